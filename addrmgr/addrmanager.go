@@ -7,6 +7,7 @@
 package addrmgr
 
 import (
+	"bytes"
 	"container/list"
 	crand "crypto/rand" // for seeding
 	"encoding/base32"
@@ -26,6 +27,7 @@ import (
 
 	"github.com/nbit99/hcd/chaincfg/chainhash"
 	"github.com/nbit99/hcd/wire"
+	"golang.org/x/crypto/sha3"
 )
 
 // AddrManager provides a concurrency safe address manager for caching potential
@@ -47,6 +49,7 @@ type AddrManager struct {
 	nNew           int
 	lamtx          sync.Mutex
 	localAddresses map[string]*localAddress
+	asmap          *ASMap
 }
 
 type serializedKnownAddress struct {
@@ -65,6 +68,10 @@ type serializedAddrManager struct {
 	Addresses    []*serializedKnownAddress
 	NewBuckets   [newBucketCount][]string // string is NetAddressKey
 	TriedBuckets [triedBucketCount][]string
+
+	// ASMapChecksum identifies the asmap the buckets were computed with.
+	// It is empty when no asmap was used.
+	ASMapChecksum string `json:",omitempty"`
 }
 
 type localAddress struct {
@@ -157,6 +164,13 @@ const (
 
 	// serialisationVersion is the current version of the on-disk format.
 	serialisationVersion = 1
+
+	// torV3KeySize is the size of the public key of a Tor v3 onion
+	// address.
+	torV3KeySize = 32
+
+	// torV3Version is the version byte of Tor v3 onion addresses.
+	torV3Version = 3
 )
 
 // updateAddress is a helper function to either update an address already known
@@ -301,8 +315,8 @@ func (a *AddrManager) getNewBucket(netAddr, srcAddr *wire.NetAddress) int {
 
 	data1 := []byte{}
 	data1 = append(data1, a.key[:]...)
-	data1 = append(data1, []byte(GroupKey(netAddr))...)
-	data1 = append(data1, []byte(GroupKey(srcAddr))...)
+	data1 = append(data1, []byte(a.GroupKey(netAddr))...)
+	data1 = append(data1, []byte(a.GroupKey(srcAddr))...)
 	hash1 := chainhash.HashB(data1)
 	hash64 := binary.LittleEndian.Uint64(hash1)
 	hash64 %= newBucketsPerGroup
@@ -310,7 +324,7 @@ func (a *AddrManager) getNewBucket(netAddr, srcAddr *wire.NetAddress) int {
 	binary.LittleEndian.PutUint64(hashbuf[:], hash64)
 	data2 := []byte{}
 	data2 = append(data2, a.key[:]...)
-	data2 = append(data2, GroupKey(srcAddr)...)
+	data2 = append(data2, a.GroupKey(srcAddr)...)
 	data2 = append(data2, hashbuf[:]...)
 
 	hash2 := chainhash.HashB(data2)
@@ -331,7 +345,7 @@ func (a *AddrManager) getTriedBucket(netAddr *wire.NetAddress) int {
 	binary.LittleEndian.PutUint64(hashbuf[:], hash64)
 	data2 := []byte{}
 	data2 = append(data2, a.key[:]...)
-	data2 = append(data2, GroupKey(netAddr)...)
+	data2 = append(data2, a.GroupKey(netAddr)...)
 	data2 = append(data2, hashbuf[:]...)
 
 	hash2 := chainhash.HashB(data2)
@@ -369,6 +383,7 @@ func (a *AddrManager) savePeers() {
 	sam := new(serializedAddrManager)
 	sam.Version = serialisationVersion
 	copy(sam.Key[:], a.key[:])
+	sam.ASMapChecksum = a.asmapChecksum()
 
 	sam.Addresses = make([]*serializedKnownAddress, len(a.addrIndex))
 	i := 0
@@ -462,6 +477,11 @@ func (a *AddrManager) deserializePeers(filePath string) error {
 	}
 	copy(a.key[:], sam.Key[:])
 
+	// The buckets depend on the asmap, so the addresses are bucketed anew
+	// when the asmap changed since they were saved.  Addresses which no
+	// longer fit into their buckets are dropped.
+	rebucket := sam.ASMapChecksum != a.asmapChecksum()
+
 	for _, v := range sam.Addresses {
 		ka := new(KnownAddress)
 		ka.na, err = a.DeserializeNetAddress(v.Addr)
@@ -488,11 +508,20 @@ func (a *AddrManager) deserializePeers(filePath string) error {
 					"none in address list", val)
 			}
 
+			bucket := i
+			if rebucket {
+				bucket = a.getNewBucket(ka.na, ka.srcAddr)
+				_, exists := a.addrNew[bucket][val]
+				if exists || len(a.addrNew[bucket]) >= newBucketSize {
+					continue
+				}
+			}
+
 			if ka.refs == 0 {
 				a.nNew++
 			}
 			ka.refs++
-			a.addrNew[i][val] = ka
+			a.addrNew[bucket][val] = ka
 		}
 	}
 	for i := range sam.TriedBuckets {
@@ -503,10 +532,27 @@ func (a *AddrManager) deserializePeers(filePath string) error {
 					"none in address list", val)
 			}
 
+			bucket := i
+			if rebucket {
+				bucket = a.getTriedBucket(ka.na)
+				if a.addrTried[bucket].Len() >= triedBucketSize {
+					continue
+				}
+			}
+
 			ka.tried = true
 			a.nTried++
-			a.addrTried[i].PushBack(ka)
+			a.addrTried[bucket].PushBack(ka)
+		}
+	}
+
+	if rebucket {
+		for k, v := range a.addrIndex {
+			if v.refs == 0 && !v.tried {
+				delete(a.addrIndex, k)
+			}
 		}
+		log.Infof("Bucketed addresses anew since the asmap changed")
 	}
 
 	// Sanity checking.
@@ -711,6 +757,16 @@ func (a *AddrManager) reset() {
 // a tor .onion address this will be taken care of. else if the host is not an
 // IP address it will be resolved (via tor if required).
 func (a *AddrManager) HostToNetAddress(host string, port uint16, services wire.ServiceFlag) (*wire.NetAddress, error) {
+	// tor v3 address is 56 char base32 + ".onion"
+	if len(host) == 62 && host[56:] == ".onion" {
+		pubKey, err := decodeTorV3(host[:56])
+		if err != nil {
+			return nil, err
+		}
+		return wire.NewNetAddressNetwork(time.Now(), services,
+			wire.AddrNetworkTorV3, pubKey, port), nil
+	}
+
 	// tor address is 16 char base32 + ".onion"
 	var ip net.IP
 	if len(host) == 22 && host[16:] == ".onion" {
@@ -738,10 +794,55 @@ func (a *AddrManager) HostToNetAddress(host string, port uint16, services wire.S
 	return wire.NewNetAddressIPPort(ip, port, services), nil
 }
 
+// torV3Checksum returns the checksum of the Tor v3 onion address of the passed
+// public key.
+func torV3Checksum(pubKey []byte) []byte {
+	h := sha3.New256()
+	h.Write([]byte(".onion checksum"))
+	h.Write(pubKey)
+	h.Write([]byte{torV3Version})
+	return h.Sum(nil)[:2]
+}
+
+// decodeTorV3 returns the public key of the passed Tor v3 onion address without
+// the ".onion" suffix.  The address is the base32 encoding of the public key
+// followed by its checksum and the version.
+func decodeTorV3(onion string) ([]byte, error) {
+	data, err := base32.StdEncoding.DecodeString(strings.ToUpper(onion))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != torV3KeySize+3 {
+		return nil, fmt.Errorf("invalid tor v3 address %s.onion", onion)
+	}
+	pubKey := data[:torV3KeySize]
+	if data[torV3KeySize+2] != torV3Version ||
+		!bytes.Equal(data[torV3KeySize:torV3KeySize+2],
+			torV3Checksum(pubKey)) {
+
+		return nil, fmt.Errorf("invalid tor v3 address %s.onion", onion)
+	}
+	return pubKey, nil
+}
+
+// encodeTorV3 returns the Tor v3 onion address of the passed public key.
+func encodeTorV3(pubKey []byte) string {
+	data := make([]byte, 0, torV3KeySize+3)
+	data = append(data, pubKey...)
+	data = append(data, torV3Checksum(pubKey)...)
+	data = append(data, torV3Version)
+	return strings.ToLower(base32.StdEncoding.EncodeToString(data)) +
+		".onion"
+}
+
 // ipString returns a string for the ip from the provided NetAddress. If the
 // ip is in the range used for tor addresses then it will be transformed into
-// the relevant .onion address.
+// the relevant .onion address.  Tor v3 addresses are transformed into their
+// .onion address as well.
 func ipString(na *wire.NetAddress) string {
+	if IsTorV3(na) {
+		return encodeTorV3(na.Addr)
+	}
 	if IsOnionCatTor(na) {
 		// We know now that na.IP is long enogh.
 		base32 := base32.StdEncoding.EncodeToString(na.IP[6:])
@@ -799,35 +900,117 @@ func (a *AddrManager) GetAddress() *KnownAddress {
 			}
 			factor *= 1.2
 		}
-	} else {
-		// new node.
-		// XXX use a closure/function to avoid repeating this.
-		large := 1 << 30
-		factor := 1.0
-		for {
-			// Pick a random bucket.
-			bucket := a.rand.Intn(len(a.addrNew))
-			if len(a.addrNew[bucket]) == 0 {
-				continue
-			}
-			// Then, a random entry in it.
-			var ka *KnownAddress
-			nth := a.rand.Intn(len(a.addrNew[bucket]))
-			for _, value := range a.addrNew[bucket] {
-				if nth == 0 {
-					ka = value
-				}
-				nth--
-			}
-			randval := a.rand.Intn(large)
-			if float64(randval) < (factor * ka.chance() * float64(large)) {
-				log.Tracef("Selected %v from new bucket",
-					NetAddressKey(ka.na))
-				return ka
+	}
+
+	// new node.
+	return a.pickNew()
+}
+
+// pickNew returns a random address from the new table with preference given to
+// ones that have not been used recently.  There must be at least one address in
+// the new table.
+//
+// This function MUST be called with the address manager lock held (for writes).
+func (a *AddrManager) pickNew() *KnownAddress {
+	large := 1 << 30
+	factor := 1.0
+	for {
+		// Pick a random bucket.
+		bucket := a.rand.Intn(len(a.addrNew))
+		if len(a.addrNew[bucket]) == 0 {
+			continue
+		}
+		// Then, a random entry in it.
+		var ka *KnownAddress
+		nth := a.rand.Intn(len(a.addrNew[bucket]))
+		for _, value := range a.addrNew[bucket] {
+			if nth == 0 {
+				ka = value
 			}
-			factor *= 1.2
+			nth--
+		}
+		randval := a.rand.Intn(large)
+		if float64(randval) < (factor * ka.chance() * float64(large)) {
+			log.Tracef("Selected %v from new bucket",
+				NetAddressKey(ka.na))
+			return ka
+		}
+		factor *= 1.2
+	}
+}
+
+// GetNewTableAddress returns a single address from the new table, which holds
+// the addresses that were never connected to successfully, or nil when the new
+// table is empty.  It is used to pick the addresses feeler connections test, so
+// addresses which turn out to be reachable are moved to the tried table.
+func (a *AddrManager) GetNewTableAddress() *KnownAddress {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if a.nNew == 0 {
+		return nil
+	}
+	return a.pickNew()
+}
+
+// Network returns the name of the network of the passed address, which is one
+// of "ipv4", "ipv6" or "onion".
+func Network(na *wire.NetAddress) string {
+	switch {
+	case isOnion(na):
+		return "onion"
+	case IsIPv4(na):
+		return "ipv4"
+	}
+	return "ipv6"
+}
+
+// TableCounts houses the number of addresses of a network in the new and tried
+// tables of the address manager.
+type TableCounts struct {
+	New   int
+	Tried int
+
+	// NewBad is the number of addresses in the new table which are
+	// considered bad, for example since connecting to them failed
+	// repeatedly.  They are removed once their buckets are full.
+	NewBad int
+}
+
+// TableCounts returns the number of addresses in the new and tried tables by the
+// names of their networks as returned by Network.
+func (a *AddrManager) TableCounts() map[string]*TableCounts {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	counts := make(map[string]*TableCounts)
+	for _, ka := range a.addrIndex {
+		network := Network(ka.na)
+		c, ok := counts[network]
+		if !ok {
+			c = new(TableCounts)
+			counts[network] = c
+		}
+		if ka.tried {
+			c.Tried++
+			continue
+		}
+		c.New++
+		if ka.isBad() {
+			c.NewBad++
 		}
 	}
+	return counts
+}
+
+// Addresses returns all addresses known to the address manager in random
+// order.
+func (a *AddrManager) Addresses() []*wire.NetAddress {
+	addrs := a.getAddresses()
+	rand.Shuffle(len(addrs), func(i, j int) {
+		addrs[i], addrs[j] = addrs[j], addrs[i]
+	})
+	return addrs
 }
 
 func (a *AddrManager) find(addr *wire.NetAddress) *KnownAddress {
@@ -971,7 +1154,7 @@ func (a *AddrManager) Good(addr *wire.NetAddress) {
 // with the given priority.
 func (a *AddrManager) AddLocalAddress(na *wire.NetAddress, priority AddressPriority) error {
 	if !IsRoutable(na) {
-		return fmt.Errorf("address %s is not routable", na.IP)
+		return fmt.Errorf("address %s is not routable", ipString(na))
 	}
 
 	a.lamtx.Lock()
@@ -1009,8 +1192,8 @@ func getReachabilityFrom(localAddr, remoteAddr *wire.NetAddress) int {
 		return Unreachable
 	}
 
-	if IsOnionCatTor(remoteAddr) {
-		if IsOnionCatTor(localAddr) {
+	if isOnion(remoteAddr) {
+		if isOnion(localAddr) {
 			return Private
 		}
 
@@ -1090,15 +1273,14 @@ func (a *AddrManager) GetBestLocalAddress(remoteAddr *wire.NetAddress) *wire.Net
 		}
 	}
 	if bestAddress != nil {
-		log.Debugf("Suggesting address %s:%d for %s:%d", bestAddress.IP,
-			bestAddress.Port, remoteAddr.IP, remoteAddr.Port)
+		log.Debugf("Suggesting address %s for %s",
+			NetAddressKey(bestAddress), NetAddressKey(remoteAddr))
 	} else {
-		log.Debugf("No worthy address for %s:%d", remoteAddr.IP,
-			remoteAddr.Port)
+		log.Debugf("No worthy address for %s", NetAddressKey(remoteAddr))
 
 		// Send something unroutable if nothing suitable.
 		var ip net.IP
-		if !IsIPv4(remoteAddr) && !IsOnionCatTor(remoteAddr) {
+		if !IsIPv4(remoteAddr) && !isOnion(remoteAddr) {
 			ip = net.IPv6zero
 		} else {
 			ip = net.IPv4zero
@@ -1109,6 +1291,45 @@ func (a *AddrManager) GetBestLocalAddress(remoteAddr *wire.NetAddress) *wire.Net
 	return bestAddress
 }
 
+// SetASMap sets the asmap which maps addresses to the autonomous systems they
+// are announced by.  The network groups of mapped addresses are their autonomous
+// systems instead of their network prefixes.  It must be called before Start.
+func (a *AddrManager) SetASMap(asmap *ASMap) {
+	a.asmap = asmap
+}
+
+// asmapChecksum returns the checksum of the asmap as a string, or an empty
+// string when no asmap is set.
+func (a *AddrManager) asmapChecksum() string {
+	if a.asmap == nil {
+		return ""
+	}
+	return a.asmap.Checksum().String()
+}
+
+// ASN returns the number of the autonomous system the passed address is
+// announced by according to the asmap, or 0 when no asmap is set or the
+// address is not mapped.
+func (a *AddrManager) ASN(na *wire.NetAddress) uint32 {
+	if a.asmap == nil {
+		return 0
+	}
+	return a.asmap.Lookup(na)
+}
+
+// GroupKey returns a string representing the network group an address is part
+// of.  This is the string "as:asn" where asn is the number of the autonomous
+// system of routable addresses mapped by the asmap and the network group
+// returned by the GroupKey function otherwise.
+func (a *AddrManager) GroupKey(na *wire.NetAddress) string {
+	if IsRoutable(na) {
+		if asn := a.ASN(na); asn != 0 {
+			return fmt.Sprintf("as:%d", asn)
+		}
+	}
+	return GroupKey(na)
+}
+
 // New returns a new hcd address manager.
 // Use Start to begin processing asynchronous address updates.
 func New(dataDir string, lookupFunc func(string) ([]net.IP, error)) *AddrManager {
@@ -1144,3 +1365,20 @@ func (a *AddrManager) SetServices(addr *wire.NetAddress, services wire.ServiceFl
 		ka.mtx.Unlock()
 	}
 }
+
+// LookupServices returns the services last known to be supported by the given
+// address along with whether or not the address is known to AddrManager.
+func (a *AddrManager) LookupServices(addr *wire.NetAddress) (wire.ServiceFlag, bool) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	ka := a.find(addr)
+	if ka == nil {
+		return 0, false
+	}
+
+	ka.mtx.Lock()
+	services := ka.na.Services
+	ka.mtx.Unlock()
+	return services, true
+}
@@ -256,6 +256,74 @@ func TestConnected(t *testing.T) {
 	}
 }
 
+func TestLookupServices(t *testing.T) {
+	n := addrmgr.New("testlookupservices", lookupFunc)
+
+	// Unknown addresses have no services.
+	na, err := n.DeserializeNetAddress(someIP + ":8333")
+	if err != nil {
+		t.Fatalf("DeserializeNetAddress failed: %v", err)
+	}
+	if _, ok := n.LookupServices(na); ok {
+		t.Fatalf("LookupServices: unexpected services of unknown address")
+	}
+
+	err = n.AddAddressByIP(someIP + ":8333")
+	if err != nil {
+		t.Fatalf("Adding address failed: %v", err)
+	}
+	services := wire.SFNodeNetwork | wire.SFNodeEncrypted
+	n.SetServices(na, services)
+	got, ok := n.LookupServices(na)
+	if !ok || got != services {
+		t.Fatalf("LookupServices: got %v (known %v), want %v", got, ok,
+			services)
+	}
+}
+
+func TestTorV3Address(t *testing.T) {
+	n := addrmgr.New("testtorv3address", lookupFunc)
+
+	const onion = "duckduckgogg42xjoc72x3sjasowoarfbgcmvfimaftt6twagswzczad.onion"
+	na, err := n.DeserializeNetAddress(onion + ":9108")
+	if err != nil {
+		t.Fatalf("DeserializeNetAddress failed: %v", err)
+	}
+	if !addrmgr.IsTorV3(na) || na.IP != nil || len(na.Addr) != 32 {
+		t.Fatalf("DeserializeNetAddress: %s is not a tor v3 address",
+			onion)
+	}
+	if !addrmgr.IsRoutable(na) {
+		t.Fatalf("IsRoutable: tor v3 address is not routable")
+	}
+	if key := addrmgr.NetAddressKey(na); key != onion+":9108" {
+		t.Fatalf("NetAddressKey: got %s, want %s", key, onion+":9108")
+	}
+	want := fmt.Sprintf("tor:%d", na.Addr[0]&0xf)
+	if key := addrmgr.GroupKey(na); key != want {
+		t.Fatalf("GroupKey: got %s, want %s", key, want)
+	}
+
+	// Addresses with an invalid checksum are rejected.
+	_, err = n.DeserializeNetAddress("e" + onion[1:] + ":9108")
+	if err == nil {
+		t.Fatalf("DeserializeNetAddress: invalid tor v3 address was " +
+			"accepted")
+	}
+
+	// Addresses of networks which can't be connected to are ignored.
+	i2p := wire.NewNetAddressNetwork(time.Now(), wire.SFNodeNetwork,
+		wire.AddrNetworkI2P, make([]byte, 32), 9108)
+	n.AddAddresses([]*wire.NetAddress{na, i2p}, na)
+	if n.NumAddresses() != 1 {
+		t.Fatalf("NumAddresses: got %d, want 1", n.NumAddresses())
+	}
+	ka := n.GetAddress()
+	if ka == nil || addrmgr.NetAddressKey(ka.NetAddress()) != onion+":9108" {
+		t.Fatalf("GetAddress: did not return the tor v3 address")
+	}
+}
+
 func TestNeedMoreAddresses(t *testing.T) {
 	n := addrmgr.New("testneedmoreaddresses", lookupFunc)
 	addrsToAdd := 1500
@@ -357,6 +425,41 @@ func TestGetAddress(t *testing.T) {
 	}
 }
 
+// TestGetNewTableAddress ensures feeler addresses are only picked from the new
+// table and the table counts reflect addresses moving to the tried table.
+func TestGetNewTableAddress(t *testing.T) {
+	n := addrmgr.New("testgetnewtableaddress", lookupFunc)
+	if ka := n.GetNewTableAddress(); ka != nil {
+		t.Fatalf("GetNewTableAddress: got %v from an empty new table", ka)
+	}
+
+	err := n.AddAddressByIP(someIP + ":8333")
+	if err != nil {
+		t.Fatalf("Adding address failed: %v", err)
+	}
+	ka := n.GetNewTableAddress()
+	if ka == nil {
+		t.Fatalf("Did not get an address where there is one in the new table")
+	}
+	counts := n.TableCounts()["ipv4"]
+	if counts == nil || counts.New != 1 || counts.Tried != 0 {
+		t.Fatalf("Wrong table counts: got %+v, want 1 new address", counts)
+	}
+
+	// Addresses which are moved to the tried table are no longer picked.
+	n.Good(ka.NetAddress())
+	if ka := n.GetNewTableAddress(); ka != nil {
+		t.Errorf("GetNewTableAddress: got %v from an empty new table", ka)
+	}
+	counts = n.TableCounts()["ipv4"]
+	if counts == nil || counts.New != 0 || counts.Tried != 1 {
+		t.Errorf("Wrong table counts: got %+v, want 1 tried address", counts)
+	}
+	if network := addrmgr.Network(ka.NetAddress()); network != "ipv4" {
+		t.Errorf("Wrong network: got %q, want %q", network, "ipv4")
+	}
+}
+
 // TestGetBestLocalAddress tests get best local address
 func TestGetBestLocalAddress(t *testing.T) {
 	localAddrs := []wire.NetAddress{
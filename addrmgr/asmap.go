@@ -0,0 +1,366 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package addrmgr
+
+import (
+	"errors"
+	"io/ioutil"
+	"math/bits"
+	"net"
+
+	"github.com/nbit99/hcd/chaincfg/chainhash"
+	"github.com/nbit99/hcd/wire"
+)
+
+// ASMap maps IP addresses to the numbers of the autonomous systems (AS) they
+// are announced by.  It is loaded from a compact map file in the format used by
+// Bitcoin Core, which encodes a binary trie over the bits of IPv6 addresses as
+// a program that is interpreted for each lookup.  IPv4 addresses are looked up
+// as IPv4-mapped IPv6 addresses.
+type ASMap struct {
+	data     []byte
+	checksum chainhash.Hash
+}
+
+// asmapInvalid is returned by decodeBits when the bits straddle the end of the
+// map.
+const asmapInvalid = 0xffffffff
+
+// asmapInstruction is an instruction of the program a map is encoded as.
+type asmapInstruction uint32
+
+const (
+	// asmapReturn returns the AS number which follows it.
+	asmapReturn asmapInstruction = 0
+
+	// asmapJump consumes the next bit of the address and skips the
+	// number of bits of the map which follows it when the bit is set.
+	asmapJump asmapInstruction = 1
+
+	// asmapMatch compares the next bits of the address to the bits which
+	// follow it and returns the default AS number when they differ.
+	asmapMatch asmapInstruction = 2
+
+	// asmapDefault sets the default AS number to the one which follows
+	// it.
+	asmapDefault asmapInstruction = 3
+)
+
+// The sizes of the classes of the variable length integers of the map.  Each
+// integer is encoded as a unary class number followed by the offset within its
+// class.
+var (
+	asmapTypeBitSizes  = []uint8{0, 0, 1}
+	asmapASNBitSizes   = []uint8{15, 16, 17, 18, 19, 20, 21, 22, 23, 24}
+	asmapMatchBitSizes = []uint8{1, 2, 3, 4, 5, 6, 7, 8}
+	asmapJumpBitSizes  = []uint8{5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16,
+		17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30}
+)
+
+// asmapIPBits is the number of bits of the addresses which are looked up.
+const asmapIPBits = 128
+
+// asmapReader reads the bits of a map starting with the least significant
+// bit of each byte.
+type asmapReader struct {
+	data []byte
+	pos  int
+	end  int
+}
+
+// bit returns the next bit of the map.  The caller must ensure the end of the
+// map is not reached.
+func (r *asmapReader) bit() uint32 {
+	b := uint32(r.data[r.pos/8]>>uint(r.pos%8)) & 1
+	r.pos++
+	return b
+}
+
+// decodeBits decodes a variable length integer with the passed minimum value
+// and class sizes.  It returns asmapInvalid when the integer straddles the end
+// of the map.
+func (r *asmapReader) decodeBits(minVal uint32, bitSizes []uint8) uint32 {
+	val := minVal
+	for i, size := range bitSizes {
+		// The class of the last size is implicit.
+		var bit uint32
+		if i != len(bitSizes)-1 {
+			if r.pos == r.end {
+				break
+			}
+			bit = r.bit()
+		}
+		if bit == 1 {
+			val += 1 << size
+			continue
+		}
+
+		for b := uint8(0); b < size; b++ {
+			if r.pos == r.end {
+				return asmapInvalid
+			}
+			val += r.bit() << (size - 1 - b)
+		}
+		return val
+	}
+	return asmapInvalid
+}
+
+func (r *asmapReader) decodeType() asmapInstruction {
+	return asmapInstruction(r.decodeBits(0, asmapTypeBitSizes))
+}
+
+func (r *asmapReader) decodeASN() uint32 {
+	return r.decodeBits(1, asmapASNBitSizes)
+}
+
+func (r *asmapReader) decodeMatch() uint32 {
+	return r.decodeBits(2, asmapMatchBitSizes)
+}
+
+func (r *asmapReader) decodeJump() uint32 {
+	return r.decodeBits(17, asmapJumpBitSizes)
+}
+
+// ipBit returns the passed bit of the IPv6 address starting with the most
+// significant bit of the first byte.
+func ipBit(ip net.IP, n int) uint32 {
+	return uint32(ip[n/8]>>uint(7-n%8)) & 1
+}
+
+// interpret runs the program of the map for the passed IPv6 address and
+// returns its AS number, or 0 when it is not mapped.
+func (m *ASMap) interpret(ip net.IP) uint32 {
+	r := asmapReader{data: m.data, end: len(m.data) * 8}
+	bitsLeft := asmapIPBits
+	var defaultASN uint32
+	for r.pos != r.end {
+		switch r.decodeType() {
+		case asmapReturn:
+			asn := r.decodeASN()
+			if asn == asmapInvalid {
+				return 0
+			}
+			return asn
+
+		case asmapJump:
+			jump := r.decodeJump()
+			if jump == asmapInvalid || bitsLeft == 0 ||
+				int64(jump) >= int64(r.end-r.pos) {
+
+				return 0
+			}
+			if ipBit(ip, asmapIPBits-bitsLeft) == 1 {
+				r.pos += int(jump)
+			}
+			bitsLeft--
+
+		case asmapMatch:
+			match := r.decodeMatch()
+			if match == asmapInvalid {
+				return 0
+			}
+			matchLen := bits.Len32(match) - 1
+			if bitsLeft < matchLen {
+				return 0
+			}
+			for b := 0; b < matchLen; b++ {
+				want := (match >> uint(matchLen-1-b)) & 1
+				if ipBit(ip, asmapIPBits-bitsLeft) != want {
+					return defaultASN
+				}
+				bitsLeft--
+			}
+
+		case asmapDefault:
+			defaultASN = r.decodeASN()
+			if defaultASN == asmapInvalid {
+				return 0
+			}
+
+		default:
+			return 0
+		}
+	}
+	return 0
+}
+
+// asmapJumpTarget is a position of the map a jump may continue at along with
+// the number of bits of the address left at that position.
+type asmapJumpTarget struct {
+	pos      int
+	bitsLeft int
+}
+
+// sanityCheck returns whether or not the program of the map is well formed,
+// which is the case when each path through it consumes at most all bits of an
+// address, ends in a return, and the map contains no unreachable code or
+// excessive padding.
+func (m *ASMap) sanityCheck() bool {
+	r := asmapReader{data: m.data, end: len(m.data) * 8}
+	bitsLeft := asmapIPBits
+	var jumps []asmapJumpTarget
+	prevOpcode := asmapJump
+	hadIncompleteMatch := false
+	for r.pos != r.end {
+		// Jumps must not continue in the middle of an instruction.
+		if len(jumps) > 0 && r.pos >= jumps[len(jumps)-1].pos {
+			return false
+		}
+
+		switch r.decodeType() {
+		case asmapReturn:
+			// A default directly followed by a return is redundant.
+			if prevOpcode == asmapDefault {
+				return false
+			}
+			if r.decodeASN() == asmapInvalid {
+				return false
+			}
+			if len(jumps) == 0 {
+				// Nothing is left to execute, so only up to 7 zero
+				// bits of padding may follow.
+				if r.end-r.pos > 7 {
+					return false
+				}
+				for r.pos != r.end {
+					if r.bit() != 0 {
+						return false
+					}
+				}
+				return true
+			}
+
+			// Continue as if the last jump was taken, which must
+			// continue right after the return.
+			last := jumps[len(jumps)-1]
+			if r.pos != last.pos {
+				return false
+			}
+			bitsLeft = last.bitsLeft
+			jumps = jumps[:len(jumps)-1]
+			prevOpcode = asmapJump
+
+		case asmapJump:
+			jump := r.decodeJump()
+			if jump == asmapInvalid || int64(jump) > int64(r.end-r.pos) ||
+				bitsLeft == 0 {
+
+				return false
+			}
+			bitsLeft--
+
+			// Jumps must not intersect.
+			target := r.pos + int(jump)
+			if len(jumps) > 0 && target >= jumps[len(jumps)-1].pos {
+				return false
+			}
+			jumps = append(jumps, asmapJumpTarget{target, bitsLeft})
+			prevOpcode = asmapJump
+
+		case asmapMatch:
+			match := r.decodeMatch()
+			if match == asmapInvalid {
+				return false
+			}
+
+			// At most one match of a sequence of matches may compare
+			// fewer than 8 bits.
+			matchLen := bits.Len32(match) - 1
+			if prevOpcode != asmapMatch {
+				hadIncompleteMatch = false
+			}
+			if matchLen < 8 && hadIncompleteMatch {
+				return false
+			}
+			hadIncompleteMatch = matchLen < 8
+			if bitsLeft < matchLen {
+				return false
+			}
+			bitsLeft -= matchLen
+			prevOpcode = asmapMatch
+
+		case asmapDefault:
+			// Successive defaults are redundant.
+			if prevOpcode == asmapDefault {
+				return false
+			}
+			if r.decodeASN() == asmapInvalid {
+				return false
+			}
+			prevOpcode = asmapDefault
+
+		default:
+			// The type straddles the end of the map.
+			return false
+		}
+	}
+
+	// The end of the map was reached without a return.
+	return false
+}
+
+// DecodeASMap returns the map encoded by the passed data.  An error is returned
+// when the map is not well formed.
+func DecodeASMap(data []byte) (*ASMap, error) {
+	m := &ASMap{
+		data:     data,
+		checksum: chainhash.HashH(data),
+	}
+	if !m.sanityCheck() {
+		return nil, errors.New("malformed asmap")
+	}
+	return m, nil
+}
+
+// LoadASMap loads the map from the passed file.
+func LoadASMap(path string) (*ASMap, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeASMap(data)
+}
+
+// Checksum returns the checksum of the map which identifies it.
+func (m *ASMap) Checksum() chainhash.Hash {
+	return m.checksum
+}
+
+// linkedIPv4 returns the IPv4 address of the passed address for IPv4 addresses
+// and IPv6 addresses which embed an IPv4 address, or nil otherwise.
+func linkedIPv4(na *wire.NetAddress) net.IP {
+	switch {
+	case IsIPv4(na):
+		return na.IP.To4()
+	case IsRFC6145(na) || IsRFC6052(na):
+		return net.IP(na.IP[12:16])
+	case IsRFC3964(na):
+		return net.IP(na.IP[2:6])
+	case IsRFC4380(na):
+		// teredo tunnels have the last 4 bytes as the v4 address XOR
+		// 0xff.
+		ip := net.IP(make([]byte, 4))
+		for i, b := range na.IP[12:16] {
+			ip[i] = b ^ 0xff
+		}
+		return ip
+	}
+	return nil
+}
+
+// Lookup returns the number of the autonomous system the passed address is
+// announced by, or 0 when it is not mapped.  Only IPv4 and IPv6 addresses are
+// mapped.
+func (m *ASMap) Lookup(na *wire.NetAddress) uint32 {
+	ip := na.IP.To16()
+	if isOnion(na) || ip == nil {
+		return 0
+	}
+	if ipv4 := linkedIPv4(na); ipv4 != nil {
+		ip = ipv4.To16()
+	}
+	return m.interpret(ip)
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package addrmgr_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/nbit99/hcd/addrmgr"
+	"github.com/nbit99/hcd/wire"
+)
+
+// asmapWriter encodes maps for the tests starting with the least significant
+// bit of each byte.
+type asmapWriter struct {
+	data []byte
+	n    uint
+}
+
+func (w *asmapWriter) bit(b uint32) {
+	if w.n%8 == 0 {
+		w.data = append(w.data, 0)
+	}
+	w.data[w.n/8] |= byte(b&1) << (w.n % 8)
+	w.n++
+}
+
+func (w *asmapWriter) encode(val, minVal uint32, bitSizes []uint8) {
+	val -= minVal
+	for i, size := range bitSizes {
+		if i != len(bitSizes)-1 {
+			if val >= 1<<size {
+				w.bit(1)
+				val -= 1 << size
+				continue
+			}
+			w.bit(0)
+		}
+		for b := int(size) - 1; b >= 0; b-- {
+			w.bit(val >> uint(b))
+		}
+		return
+	}
+}
+
+var asnBitSizes = []uint8{15, 16, 17, 18, 19, 20, 21, 22, 23, 24}
+
+func (w *asmapWriter) ret(asn uint32) {
+	w.encode(0, 0, []uint8{0, 0, 1})
+	w.encode(asn, 1, asnBitSizes)
+}
+
+func (w *asmapWriter) jump(n uint32) {
+	w.encode(1, 0, []uint8{0, 0, 1})
+	w.encode(n, 17, []uint8{5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17,
+		18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30})
+}
+
+// match encodes matches of the passed number of equal bits in chunks of 8.
+func (w *asmapWriter) match(bit uint32, n int) {
+	for ; n > 0; n -= 8 {
+		val := uint32(1 << 8)
+		if bit == 1 {
+			val |= 0xff
+		}
+		w.encode(0x2, 0, []uint8{0, 0, 1})
+		w.encode(val, 2, []uint8{1, 2, 3, 4, 5, 6, 7, 8})
+	}
+}
+
+// testASMap returns the data of a map which maps IPv4 addresses with the most
+// significant bit unset to AS 100 and the others to AS 200.
+func testASMap() []byte {
+	var w asmapWriter
+	w.match(0, 80)
+	w.match(1, 16)
+	w.jump(17) // Skips the encoded return of AS 100.
+	w.ret(100)
+	w.ret(200)
+	return w.data
+}
+
+// TestASMap ensures addresses are mapped to their autonomous systems as
+// expected and malformed maps are rejected.
+func TestASMap(t *testing.T) {
+	data := testASMap()
+	asmap, err := addrmgr.DecodeASMap(data)
+	if err != nil {
+		t.Fatalf("DecodeASMap: unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		ip      string
+		wantASN uint32
+		wantKey string
+	}{
+		{"1.2.3.4", 100, "as:100"},
+		{"127.255.0.1", 100, "local"},
+		{"200.1.2.3", 200, "as:200"},
+		{"2002:c801:0203::", 200, "as:200"},
+		{"2001:470::1", 0, "2001:470::"},
+		{"fd87:d87e:eb43:edb1:8e4:3588:e546:35ca", 0, "tor:13"},
+	}
+
+	amgr := addrmgr.New("asmap", nil)
+	amgr.SetASMap(asmap)
+	for _, test := range tests {
+		na := wire.NewNetAddressIPPort(net.ParseIP(test.ip), 9108,
+			wire.SFNodeNetwork)
+		if asn := asmap.Lookup(na); asn != test.wantASN {
+			t.Errorf("Lookup(%s): got %d, want %d", test.ip, asn,
+				test.wantASN)
+		}
+		if key := amgr.GroupKey(na); key != test.wantKey {
+			t.Errorf("GroupKey(%s): got %q, want %q", test.ip, key,
+				test.wantKey)
+		}
+	}
+
+	// The network group of unmapped address managers is unchanged.
+	na := wire.NewNetAddressIPPort(net.ParseIP("1.2.3.4"), 9108,
+		wire.SFNodeNetwork)
+	if key := addrmgr.New("asmap", nil).GroupKey(na); key != "1.2.0.0" {
+		t.Errorf("GroupKey without asmap: got %q, want %q", key,
+			"1.2.0.0")
+	}
+
+	malformed := map[string][]byte{
+		"empty":     nil,
+		"truncated": data[:len(data)-1],
+		"padding":   append(append([]byte{}, data...), 0),
+	}
+	for name, data := range malformed {
+		if _, err := addrmgr.DecodeASMap(data); err == nil {
+			t.Errorf("DecodeASMap(%s): malformed map accepted", name)
+		}
+	}
+}
@@ -119,6 +119,17 @@ func IsOnionCatTor(na *wire.NetAddress) bool {
 	return onionCatNet.Contains(na.IP)
 }
 
+// IsTorV3 returns whether or not the passed address is a Tor v3 onion address.
+func IsTorV3(na *wire.NetAddress) bool {
+	return na.Network == wire.AddrNetworkTorV3
+}
+
+// isOnion returns whether or not the passed address is a Tor onion address of
+// either version.
+func isOnion(na *wire.NetAddress) bool {
+	return IsOnionCatTor(na) || IsTorV3(na)
+}
+
 // IsRFC1918 returns whether or not the passed address is part of the IPv4
 // private network address space as defined by RFC1918 (10.0.0.0/8,
 // 172.16.0.0/12, or 192.168.0.0/16).
@@ -214,7 +225,14 @@ func IsRFC6598(na *wire.NetAddress) bool {
 // considered invalid under the following circumstances:
 // IPv4: It is either a zero or all bits set address.
 // IPv6: It is either a zero or RFC3849 documentation address.
+// Other: It is not a Tor v3 onion address.  Addresses of other networks which
+// can't be represented by an IP address, such as I2P and CJDNS, can't be
+// connected to.
 func IsValid(na *wire.NetAddress) bool {
+	if na.Network != 0 {
+		return IsTorV3(na) && len(na.Addr) == torV3KeySize
+	}
+
 	// IsUnspecified returns if address is 0, so only all bits set, and
 	// RFC3849 need to be explicitly checked.
 	return na.IP != nil && !(na.IP.IsUnspecified() ||
@@ -243,6 +261,10 @@ func GroupKey(na *wire.NetAddress) string {
 	if !IsRoutable(na) {
 		return "unroutable"
 	}
+	if IsTorV3(na) {
+		// group is keyed off the first 4 bits of the onion key.
+		return fmt.Sprintf("tor:%d", na.Addr[0]&((1<<4)-1))
+	}
 	if IsIPv4(na) {
 		return na.IP.Mask(net.CIDRMask(16, 32)).String()
 	}
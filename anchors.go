@@ -0,0 +1,56 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// anchorsFileName is the name of the file in the data directory the addresses
+// of the block-relay-only peers are saved to on shutdown and loaded from on
+// start up.
+const anchorsFileName = "anchors.json"
+
+// anchorsFilePath returns the path of the file the anchors are saved to.
+func anchorsFilePath() string {
+	return filepath.Join(cfg.DataDir, anchorsFileName)
+}
+
+// saveAnchors saves the passed addresses of the block-relay-only peers to the
+// passed file so they are connected to first on the next start.
+func saveAnchors(path string, addrs []string) error {
+	serialized, err := json.Marshal(addrs)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, serialized, 0600)
+}
+
+// loadAnchors loads the addresses of the block-relay-only peers of the previous
+// run from the passed file.  The file is removed once it is read so the anchors
+// are not reused after an unclean shutdown, which would allow an attacker which
+// managed to become an anchor to keep the node eclipsed across restarts.  No
+// anchors and no error are returned when the file does not exist.
+func loadAnchors(path string) ([]string, error) {
+	serialized, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil {
+		return nil, err
+	}
+
+	var addrs []string
+	if err := json.Unmarshal(serialized, &addrs); err != nil {
+		return nil, err
+	}
+	return addrs, nil
+}
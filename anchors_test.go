@@ -0,0 +1,60 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestAnchors ensures anchors are saved and loaded as expected and are only
+// loaded once.
+func TestAnchors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anchors")
+	if err != nil {
+		t.Fatalf("TempDir: unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, anchorsFileName)
+
+	// No anchors are loaded when the file does not exist.
+	anchors, err := loadAnchors(path)
+	if err != nil || anchors != nil {
+		t.Fatalf("loadAnchors: got %v, %v, want no anchors", anchors,
+			err)
+	}
+
+	want := []string{"127.0.0.1:9108",
+		"duckduckgogg42xjoc72x3sjasowoarfbgcmvfimaftt6twagswzczad.onion:9108"}
+	if err := saveAnchors(path, want); err != nil {
+		t.Fatalf("saveAnchors: unexpected error: %v", err)
+	}
+	anchors, err = loadAnchors(path)
+	if err != nil {
+		t.Fatalf("loadAnchors: unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(anchors, want) {
+		t.Fatalf("loadAnchors: got %v, want %v", anchors, want)
+	}
+
+	// The anchors are removed once they are loaded.
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("loadAnchors: anchors file was not removed")
+	}
+
+	// Corrupt anchors files are reported and removed.
+	if err := ioutil.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatalf("WriteFile: unexpected error: %v", err)
+	}
+	if _, err := loadAnchors(path); err == nil {
+		t.Fatalf("loadAnchors: corrupt anchors file was loaded")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("loadAnchors: corrupt anchors file was not removed")
+	}
+}
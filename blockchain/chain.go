@@ -2329,8 +2329,7 @@ func New(config *Config) (*BlockChain, error) {
 		return nil, err
 	}
 
-	// Initialize and catch up all of the currently active optional indexes
-	// as needed.
+	// Initialize all of the currently active optional indexes as needed.
 	if config.IndexManager != nil {
 		if err := config.IndexManager.Init(&b); err != nil {
 			return nil, err
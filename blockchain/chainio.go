@@ -73,6 +73,11 @@ func isNotInMainChainErr(err error) bool {
 	return ok
 }
 
+// IsNotInMainChainErr is the exported version of isNotInMainChainErr.
+func IsNotInMainChainErr(err error) bool {
+	return isNotInMainChainErr(err)
+}
+
 // errDeserialize signifies that a problem was encountered when deserializing
 // data.
 type errDeserialize string
@@ -9,6 +9,9 @@ package indexers
 import (
 	"bytes"
 	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/nbit99/hcd/blockchain"
 	"github.com/nbit99/hcd/blockchain/internal/progresslog"
@@ -20,6 +23,14 @@ import (
 	"github.com/nbit99/hcd/hcutil"
 )
 
+const (
+	// catchUpRetryMin and catchUpRetryMax are the bounds of the delay
+	// before catching up the indexes is retried after a failure.  The delay
+	// doubles with each consecutive failure.
+	catchUpRetryMin = time.Second
+	catchUpRetryMax = 5 * time.Minute
+)
+
 var (
 	// indexTipsBucketName is the name of the db bucket used to house the
 	// current tip of each index.
@@ -131,9 +142,22 @@ func dbIndexDisconnectBlock(dbTx database.Tx, indexer Indexer, block, parent *hc
 // implements the blockchain.IndexManager interface so it can be seamlessly
 // plugged into normal chain processing.
 type Manager struct {
+	started  int32 // atomic
+	shutdown int32 // atomic
+
 	params         *chaincfg.Params
 	db             database.DB
 	enabledIndexes []Indexer
+
+	// heights and synced track the current tip height of each enabled
+	// index and whether or not it is caught up to the main chain.  They
+	// are protected by mtx.
+	mtx     sync.RWMutex
+	heights []int32
+	synced  []bool
+
+	quit chan struct{}
+	wg   sync.WaitGroup
 }
 
 // Ensure the Manager type implements the blockchain.IndexManager interface.
@@ -225,11 +249,11 @@ func (m *Manager) maybeCreateIndexes(dbTx database.Tx) error {
 }
 
 // Init initializes the enabled indexes.  This is called during chain
-// initialization and primarily consists of catching up all indexes to the
-// current best chain tip.  This is necessary since each index can be disabled
-// and re-enabled at any time and attempting to catch-up indexes at the same
-// time new blocks are being downloaded would lead to an overall longer time to
-// catch up due to the I/O contention.
+// initialization and consists of creating the indexes as needed and rolling
+// back any indexes whose tip is on an orphaned fork.  Indexes which are behind
+// the current best chain tip, which can happen since each index can be disabled
+// and re-enabled at any time, are caught up in the background once the manager
+// is started so that doing so does not block startup.
 //
 // This is part of the blockchain.IndexManager interface.
 func (m *Manager) Init(chain *blockchain.BlockChain) error {
@@ -357,13 +381,10 @@ func (m *Manager) Init(chain *blockchain.BlockChain) error {
 		}
 	}
 
-	// Fetch the current tip heights for each index along with tracking the
-	// lowest one so the catchup code only needs to start at the earliest
-	// block and is able to skip connecting the block for the indexes that
-	// don't need it.
+	// Fetch the current tip heights for each index and note which of them
+	// still need to be caught up to the current best chain tip.
 	bestHeight := int32(chain.BestSnapshot().Height)
 	lowestHeight := bestHeight
-	indexerHeights := make([]int32, len(m.enabledIndexes))
 	err = m.db.View(func(dbTx database.Tx) error {
 		for i, indexer := range m.enabledIndexes {
 			idxKey := indexer.Key()
@@ -374,7 +395,8 @@ func (m *Manager) Init(chain *blockchain.BlockChain) error {
 
 			log.Debugf("Current %s tip (height %d, hash %v)",
 				indexer.Name(), height, hash)
-			indexerHeights[i] = height
+			m.heights[i] = height
+			m.synced[i] = height == bestHeight
 			if height < lowestHeight {
 				lowestHeight = height
 			}
@@ -385,83 +407,240 @@ func (m *Manager) Init(chain *blockchain.BlockChain) error {
 		return err
 	}
 
-	// Nothing to index if all of the indexes are caught up.
-	if lowestHeight == bestHeight {
-		return nil
+	// The indexes that are behind are caught up in the background once the
+	// manager is started so the node is usable in the mean time.
+	if lowestHeight != bestHeight {
+		log.Infof("Indexes will be caught up from height %d to %d in "+
+			"the background", lowestHeight, bestHeight)
+	}
+	return nil
+}
+
+// Start begins catching up any indexes that are behind the current best chain
+// tip in a background goroutine.  Blocks connected to the main chain while an
+// index is catching up are picked up by the background process, so the index
+// remains consistent throughout.
+func (m *Manager) Start() {
+	// Already started?
+	if atomic.AddInt32(&m.started, 1) != 1 {
+		return
+	}
+
+	m.wg.Add(1)
+	go m.catchUpHandler()
+}
+
+// Stop signals the background index catch up to stop and waits for it to
+// finish processing the block it is currently indexing.
+func (m *Manager) Stop() {
+	if atomic.AddInt32(&m.shutdown, 1) != 1 {
+		return
 	}
 
+	close(m.quit)
+	m.wg.Wait()
+}
+
+// catchUpHandler connects blocks to the indexes that are behind the current
+// best chain tip until they are all caught up.  Failures are retried with an
+// increasing delay so the indexes do not stay behind until a restart.  It must
+// be run as a goroutine.
+func (m *Manager) catchUpHandler() {
+	defer m.wg.Done()
+
 	// Create a progress logger for the indexing process below.
 	progressLogger := progresslog.NewBlockProgressLogger("Indexed", log)
 
-	// At this point, one or more indexes are behind the current best chain
-	// tip and need to be caught up, so log the details and loop through
-	// each block that needs to be indexed.
-	log.Infof("Catching up indexes from height %d to %d", lowestHeight,
-		bestHeight)
-
 	var cachedParent *hcutil.Block
-	for height := lowestHeight + 1; height <= bestHeight; height++ {
-		var block, parent *hcutil.Block
-		err = m.db.Update(func(dbTx database.Tx) error {
-			// Get the parent of the block, unless it's already cached.
-			if cachedParent == nil && height > 0 {
-				parent, err = blockchain.DBFetchBlockByHeight(
-					dbTx, int64(height-1))
-				if err != nil {
-					return err
-				}
-			} else {
-				parent = cachedParent
+	retryDelay := catchUpRetryMin
+	for {
+		select {
+		case <-m.quit:
+			return
+		default:
+		}
+
+		block, parent, err := m.catchUpNextBlock(cachedParent)
+		if err != nil {
+			log.Errorf("Unable to catch up indexes (retrying in %v): %v",
+				retryDelay, err)
+			cachedParent = nil
+			select {
+			case <-m.quit:
+				return
+			case <-time.After(retryDelay):
+			}
+			retryDelay *= 2
+			if retryDelay > catchUpRetryMax {
+				retryDelay = catchUpRetryMax
+			}
+			continue
+		}
+		retryDelay = catchUpRetryMin
+		if block == nil {
+			return
+		}
+		cachedParent = block
+		progressLogger.LogBlockHeight(block.MsgBlock(), parent.MsgBlock())
+	}
+}
+
+// catchUpNextBlock connects the main chain block after the lowest tip of the
+// indexes which are still catching up to every index at that tip.  The indexes
+// are marked as synced and a nil block is returned when there are no further
+// blocks to connect.
+//
+// The passed parent is used in place of loading the parent block from the
+// database when it is the parent of the block being connected.
+func (m *Manager) catchUpNextBlock(cachedParent *hcutil.Block) (*hcutil.Block, *hcutil.Block, error) {
+	var block, parent *hcutil.Block
+	err := m.db.Update(func(dbTx database.Tx) error {
+		// Since blocks are connected to the main chain in database
+		// transactions as well, the index tips and the main chain can't
+		// change while this transaction is running.
+		m.mtx.RLock()
+		synced := make([]bool, len(m.synced))
+		copy(synced, m.synced)
+		m.mtx.RUnlock()
+
+		lowestHeight := int32(-1)
+		tipHashes := make([]*chainhash.Hash, len(m.enabledIndexes))
+		tipHeights := make([]int32, len(m.enabledIndexes))
+		for i, indexer := range m.enabledIndexes {
+			if synced[i] {
+				continue
 			}
 
-			// Load the block for the height since it is required to index
-			// it.
-			block, err = blockchain.DBFetchBlockByHeight(dbTx,
-				int64(height))
+			hash, height, err := dbFetchIndexerTip(dbTx, indexer.Key())
 			if err != nil {
 				return err
 			}
-			cachedParent = block
-
-			// Connect the block for all indexes that need it.
-			var view *blockchain.UtxoViewpoint
-			for i, indexer := range m.enabledIndexes {
-				// Skip indexes that don't need to be updated with this
-				// block.
-				if indexerHeights[i] >= height {
-					continue
-				}
+			tipHashes[i], tipHeights[i] = hash, height
+			if lowestHeight == -1 || height < lowestHeight {
+				lowestHeight = height
+			}
+		}
 
-				// When the index requires all of the referenced
-				// txouts and they haven't been loaded yet, they
-				// need to be retrieved from the transaction
-				// index.
-				if view == nil && indexNeedsInputs(indexer) {
-					var errMakeView error
-					view, errMakeView = makeUtxoView(dbTx, block, parent)
-					if errMakeView != nil {
-						return errMakeView
-					}
-				}
-				err = dbIndexConnectBlock(dbTx, indexer, block,
-					parent, view)
-				if err != nil {
-					return err
-				}
+		// Nothing to do when all of the indexes are already synced.
+		if lowestHeight == -1 {
+			return nil
+		}
 
-				indexerHeights[i] = height
+		// Load the block after the lowest index tip.  All of the indexes
+		// are caught up when there is no such block in the main chain.
+		var err error
+		block, err = blockchain.DBFetchBlockByHeight(dbTx,
+			int64(lowestHeight+1))
+		if blockchain.IsNotInMainChainErr(err) {
+			m.mtx.Lock()
+			for i := range m.synced {
+				m.synced[i] = true
 			}
+			m.mtx.Unlock()
+			block = nil
 
+			log.Infof("Indexes caught up to height %d", lowestHeight)
 			return nil
-		})
+		}
 		if err != nil {
 			return err
 		}
-		progressLogger.LogBlockHeight(block.MsgBlock(), parent.MsgBlock())
+
+		// Get the parent of the block, unless it's already cached.
+		prevHash := &block.MsgBlock().Header.PrevBlock
+		if cachedParent != nil && cachedParent.Hash().IsEqual(prevHash) {
+			parent = cachedParent
+		} else {
+			parent, err = blockchain.DBFetchBlockByHeight(dbTx,
+				int64(lowestHeight))
+			if err != nil {
+				return err
+			}
+		}
+
+		// Connect the block for all indexes that need it.
+		var view *blockchain.UtxoViewpoint
+		for i, indexer := range m.enabledIndexes {
+			// Skip indexes that are synced or don't need to be
+			// updated with this block.
+			if synced[i] || tipHeights[i] != lowestHeight {
+				continue
+			}
+
+			// When the index requires all of the referenced txouts
+			// and they haven't been loaded yet, they need to be
+			// retrieved from the transaction index.
+			if view == nil && indexNeedsInputs(indexer) {
+				view, err = makeUtxoView(dbTx, block, parent)
+				if err != nil {
+					return err
+				}
+			}
+			err = dbIndexConnectBlock(dbTx, indexer, block, parent,
+				view)
+			if err != nil {
+				return err
+			}
+
+			m.mtx.Lock()
+			m.heights[i] = lowestHeight + 1
+			m.mtx.Unlock()
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
 	}
 
-	log.Infof("Indexes caught up to height %d", bestHeight)
-	return nil
+	return block, parent, nil
+}
+
+// IndexStatus describes how far an index managed by the index manager has been
+// caught up to the main chain.
+type IndexStatus struct {
+	// Name is the human-readable name of the index.
+	Name string
+
+	// Height is the height of the current tip of the index.
+	Height int32
+
+	// Synced is whether or not the index is caught up to the main chain.
+	Synced bool
+}
+
+// IndexStatuses returns the current status of each of the enabled indexes in
+// the order they are managed.
+//
+// This function is safe for concurrent access.
+func (m *Manager) IndexStatuses() []IndexStatus {
+	m.mtx.RLock()
+	statuses := make([]IndexStatus, 0, len(m.enabledIndexes))
+	for i, indexer := range m.enabledIndexes {
+		statuses = append(statuses, IndexStatus{
+			Name:   indexer.Name(),
+			Height: m.heights[i],
+			Synced: m.synced[i],
+		})
+	}
+	m.mtx.RUnlock()
+	return statuses
+}
+
+// SyncState returns the height of the current tip of the passed index and
+// whether or not it is caught up to the main chain.  False is returned for an
+// index that is not managed by the index manager.
+//
+// This function is safe for concurrent access.
+func (m *Manager) SyncState(indexer Indexer) (int32, bool) {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	for i, idx := range m.enabledIndexes {
+		if bytes.Equal(idx.Key(), indexer.Key()) {
+			return m.heights[i], m.synced[i]
+		}
+	}
+	return 0, false
 }
 
 // indexNeedsInputs returns whether or not the index needs access to the txouts
@@ -580,11 +759,32 @@ func makeUtxoView(dbTx database.Tx, block, parent *hcutil.Block) (*blockchain.Ut
 func (m *Manager) ConnectBlock(dbTx database.Tx, block, parent *hcutil.Block, view *blockchain.UtxoViewpoint) error {
 	// Call each of the currently active optional indexes with the block
 	// being connected so they can update accordingly.
-	for _, index := range m.enabledIndexes {
+	for i, index := range m.enabledIndexes {
+		// Indexes that are still catching up in the background only
+		// need the block when it extends their current tip.  The
+		// background process picks it up otherwise.
+		m.mtx.RLock()
+		synced := m.synced[i]
+		m.mtx.RUnlock()
+		if !synced {
+			tipHash, _, err := dbFetchIndexerTip(dbTx, index.Key())
+			if err != nil {
+				return err
+			}
+			if !tipHash.IsEqual(&block.MsgBlock().Header.PrevBlock) {
+				continue
+			}
+		}
+
 		err := dbIndexConnectBlock(dbTx, index, block, parent, view)
 		if err != nil {
 			return err
 		}
+
+		m.mtx.Lock()
+		m.heights[i] = int32(block.Height())
+		m.synced[i] = true
+		m.mtx.Unlock()
 	}
 	return nil
 }
@@ -598,11 +798,30 @@ func (m *Manager) ConnectBlock(dbTx database.Tx, block, parent *hcutil.Block, vi
 func (m *Manager) DisconnectBlock(dbTx database.Tx, block, parent *hcutil.Block, view *blockchain.UtxoViewpoint) error {
 	// Call each of the currently active optional indexes with the block
 	// being disconnected so they can update accordingly.
-	for _, index := range m.enabledIndexes {
+	for i, index := range m.enabledIndexes {
+		// Indexes that are still catching up in the background only
+		// have entries for the block when it is their current tip.
+		m.mtx.RLock()
+		synced := m.synced[i]
+		m.mtx.RUnlock()
+		if !synced {
+			tipHash, _, err := dbFetchIndexerTip(dbTx, index.Key())
+			if err != nil {
+				return err
+			}
+			if !tipHash.IsEqual(block.Hash()) {
+				continue
+			}
+		}
+
 		err := dbIndexDisconnectBlock(dbTx, index, block, parent, view)
 		if err != nil {
 			return err
 		}
+
+		m.mtx.Lock()
+		m.heights[i] = int32(block.Height() - 1)
+		m.mtx.Unlock()
 	}
 	return nil
 }
@@ -616,6 +835,9 @@ func NewManager(db database.DB, enabledIndexes []Indexer, params *chaincfg.Param
 		db:             db,
 		enabledIndexes: enabledIndexes,
 		params:         params,
+		heights:        make([]int32, len(enabledIndexes)),
+		synced:         make([]bool, len(enabledIndexes)),
+		quit:           make(chan struct{}),
 	}
 }
 
@@ -404,6 +404,14 @@ func CheckProofOfWork(block *hcutil.Block, powLimit *big.Int) error {
 	return checkProofOfWork(&block.MsgBlock().Header, powLimit, BFNone)
 }
 
+// CheckHeaderProofOfWork ensures the block header bits which indicate the
+// target difficulty is in min/max range and that the header hash is less than
+// the target difficulty as claimed.  This allows the proof of work of headers
+// to be checked before the blocks they describe are downloaded.
+func CheckHeaderProofOfWork(header *wire.BlockHeader, powLimit *big.Int) error {
+	return checkProofOfWork(header, powLimit, BFNone)
+}
+
 // checkBlockHeaderSanity performs some preliminary checks on a block header to
 // ensure it is sane before continuing with processing.  These checks are
 // context free.
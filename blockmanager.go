@@ -42,10 +42,6 @@ const (
 	// block or transaction before it is dropped.
 	maxResendLimit = 3
 
-	// maxRejectedTxns is the maximum number of rejected transactions
-	// hashes to store in memory.
-	maxRejectedTxns = 1000
-
 	// maxRequestedBlocks is the maximum number of requested block
 	// hashes to store in memory.
 	maxRequestedBlocks = wire.MaxInvPerMsg
@@ -77,6 +73,10 @@ type newPeerMsg struct {
 type blockMsg struct {
 	block *hcutil.Block
 	peer  *serverPeer
+
+	// cmpct is whether or not the block was reconstructed from a compact
+	// block.
+	cmpct bool
 }
 
 // invMsg packages a hcd inv message and the peer it came from together
@@ -122,6 +122,14 @@ type requestFromPeerMsg struct {
 	reply  chan requestFromPeerResponse
 }
 
+// requestFullBlockMsg is a message type to be sent across the message channel
+// for requesting a block in full from a peer when it could not be
+// reconstructed from the compact block sent by the peer.
+type requestFullBlockMsg struct {
+	peer *serverPeer
+	hash chainhash.Hash
+}
+
 // requestFromPeerResponse is a response sent to the reply channel of a
 // requestFromPeerMsg query.
 type requestFromPeerResponse struct {
@@ -256,6 +264,7 @@ type processTransactionMsg struct {
 	allowOrphans  bool
 	rateLimit     bool
 	allowHighFees bool
+	tag           mempool.Tag
 	reply         chan processTransactionResponse
 }
 
@@ -415,7 +424,6 @@ type blockManager struct {
 	started             int32
 	shutdown            int32
 	chain               *blockchain.BlockChain
-	rejectedTxns        map[chainhash.Hash]struct{}
 	requestedTxns       map[chainhash.Hash]struct{}
 	requestedEverTxns   map[chainhash.Hash]uint8
 	requestedBlocks     map[chainhash.Hash]struct{}
@@ -433,6 +441,13 @@ type blockManager struct {
 	startHeader      *list.Element
 	nextCheckpoint   *chaincfg.Checkpoint
 
+	// headersPresync is the pre-sync of the headers of the sync peer while
+	// it is being done or the headers are downloaded again after it.
+	// presyncTarget is set when nextCheckpoint is the header the pre-sync
+	// reached the minimum chain work at instead of a checkpoint.
+	headersPresync *headersPresync
+	presyncTarget  bool
+
 	// lotteryDataBroadcastMutex is a mutex protecting the map
 	// that checks if block lottery data has been broadcasted
 	// yet for any given block, so notifications are never
@@ -443,6 +458,12 @@ type blockManager struct {
 	cachedCurrentTemplate *BlockTemplate
 	cachedParentTemplate  *BlockTemplate
 	AggressiveMining      bool
+
+	// cmpctPeers houses the peers which were asked to announce new blocks
+	// by sending compact blocks directly, ordered from the peer which least
+	// recently relayed a new block first to the one which most recently
+	// did.
+	cmpctPeers []*serverPeer
 }
 
 // resetHeaderState sets the headers-first mode state to values appropriate for
@@ -451,6 +472,11 @@ func (b *blockManager) resetHeaderState(newestHash *chainhash.Hash, newestHeight
 	b.headersFirstMode = false
 	b.headerList.Init()
 	b.startHeader = nil
+	b.headersPresync = nil
+	if b.presyncTarget {
+		b.nextCheckpoint = b.findNextHeaderCheckpoint(newestHeight)
+		b.presyncTarget = false
+	}
 
 	// When there is a next checkpoint, add an entry for the latest known
 	// block into the header pool.  This allows the next downloaded header
@@ -587,11 +613,27 @@ func (b *blockManager) startSync(peers *list.List) {
 		// and fully validate them.  Finally, regression test mode does
 		// not support the headers-first approach so do normal block
 		// downloads when in regression test mode.
+		//
+		// When the best chain does not have the minimum chain work of
+		// the network yet, the headers are pre-synced first regardless
+		// of the checkpoints to ensure the chain of the peer has at
+		// least that much work before they are stored.
+		presync, err := b.startHeadersPresync(bestPeer, locator)
+		if err != nil {
+			bmgrLog.Errorf("Failed to start headers pre-sync: %v", err)
+			return
+		}
+		if presync {
+			b.headersFirstMode = true
+			b.syncPeer = bestPeer
+			return
+		}
+
 		if b.nextCheckpoint != nil &&
 			best.Height < b.nextCheckpoint.Height &&
 			!cfg.DisableCheckpoints {
 
-			err := bestPeer.PushGetHeadersMsg(locator, b.nextCheckpoint.Hash)
+			err = bestPeer.PushGetHeadersMsg(locator, b.nextCheckpoint.Hash)
 			if err != nil {
 				bmgrLog.Errorf("Failed to push getheadermsg for the "+
 					"latest blocks: %v", err)
@@ -615,6 +657,38 @@ func (b *blockManager) startSync(peers *list.List) {
 	}
 }
 
+// startHeadersPresync starts the pre-sync of the headers of the passed peer
+// from the passed block locator when the best chain does not have the minimum
+// chain work of the network.  It returns whether or not the pre-sync was
+// started.
+func (b *blockManager) startHeadersPresync(sp *serverPeer, locator blockchain.BlockLocator) (bool, error) {
+	best := b.chain.BestSnapshot()
+	work, err := b.chain.ChainWork(best.Hash)
+	if err != nil {
+		return false, err
+	}
+	if !needsHeadersPresync(b.server.chainParams, work) {
+		return false, nil
+	}
+
+	err = sp.PushGetHeadersMsg(locator, &zeroHash)
+	if err != nil {
+		return false, err
+	}
+
+	// The headers downloaded again after the pre-sync must link to the
+	// best block even when there is no checkpoint to download them up to.
+	if b.headerList.Len() == 0 {
+		node := headerNode{height: best.Height, hash: best.Hash}
+		b.headerList.PushBack(&node)
+	}
+	b.headersPresync = newHeadersPresync(b.server.chainParams, locator,
+		best.Hash, best.Height, work)
+	bmgrLog.Infof("Pre-syncing headers from peer %s to verify its chain "+
+		"has the minimum chain work", sp.Addr())
+	return true, nil
+}
+
 // isSyncCandidate returns whether or not the peer is a candidate to consider
 // syncing from.
 func (b *blockManager) isSyncCandidate(sp *serverPeer) bool {
@@ -690,6 +764,13 @@ func (b *blockManager) handleDonePeerMsg(peers *list.List, sp *serverPeer) {
 		delete(b.requestedTxns, k)
 	}
 
+	// Evict any remaining orphans that were sent by the peer.
+	numEvicted := b.server.txMemPool.RemoveOrphansByTag(mempool.Tag(sp.ID()))
+	if numEvicted > 0 {
+		bmgrLog.Debugf("Evicted %d orphan(s) from peer %s", numEvicted,
+			sp)
+	}
+
 	// Remove requested blocks from the global map so that they will be
 	// fetched from elsewhere next time we get an inv.
 	// TODO(oga) we could possibly here check which peers have these blocks
@@ -698,6 +779,14 @@ func (b *blockManager) handleDonePeerMsg(peers *list.List, sp *serverPeer) {
 		delete(b.requestedBlocks, k)
 	}
 
+	// Remove the peer from the high-bandwidth compact block peers.
+	for i, cp := range b.cmpctPeers {
+		if cp == sp {
+			b.cmpctPeers = append(b.cmpctPeers[:i], b.cmpctPeers[i+1:]...)
+			break
+		}
+	}
+
 	// Attempt to find a new peer to sync from if the quitting peer is the
 	// sync peer.  Also, reset the headers-first state if in headers-first
 	// mode so
@@ -726,7 +815,7 @@ func (b *blockManager) handleTxMsg(tmsg *txMsg) {
 	// Ignore transactions that we have already rejected.  Do not
 	// send a reject message here because if the transaction was already
 	// rejected, the transaction was unsolicited.
-	if _, exists := b.rejectedTxns[*txHash]; exists {
+	if b.server.txMemPool.IsRecentlyRejected(txHash) {
 		bmgrLog.Debugf("Ignoring unsolicited previously rejected "+
 			"transaction %v from %s", txHash, tmsg.peer)
 		return
@@ -736,7 +825,7 @@ func (b *blockManager) handleTxMsg(tmsg *txMsg) {
 	// memory pool, orphan handling, etc.
 	allowOrphans := cfg.MaxOrphanTxs > 0
 	acceptedTxs, err := b.server.txMemPool.ProcessTransaction(tmsg.tx,
-		allowOrphans, true, true)
+		allowOrphans, true, true, mempool.Tag(tmsg.peer.ID()))
 
 	// Remove transaction from request maps. Either the mempool/chain
 	// already knows about it and as such we shouldn't have any more
@@ -746,11 +835,6 @@ func (b *blockManager) handleTxMsg(tmsg *txMsg) {
 	delete(b.requestedTxns, *txHash)
 
 	if err != nil {
-		// Do not request this transaction again until a new block
-		// has been processed.
-		b.rejectedTxns[*txHash] = struct{}{}
-		b.limitMap(b.rejectedTxns, maxRejectedTxns)
-
 		// When the error is a rule error, it means the transaction was
 		// simply rejected as opposed to something actually going wrong,
 		// so log it as such.  Otherwise, something really did go wrong,
@@ -763,6 +847,17 @@ func (b *blockManager) handleTxMsg(tmsg *txMsg) {
 				txHash, err)
 		}
 
+		// Relay transactions of peers with the forcerelay permission
+		// even when they are already in the memory pool.
+		if tmsg.peer.permissions.has(permForceRelay) &&
+			b.server.txMemPool.HaveTransaction(txHash) {
+
+			bmgrLog.Debugf("Force relaying transaction %v from %s",
+				txHash, tmsg.peer)
+			iv := wire.NewInvVect(wire.InvTypeTx, txHash)
+			b.server.RelayInventory(iv, tmsg.tx)
+		}
+
 		// Convert the error into an appropriate reject message and
 		// send it.
 		code, reason := mempool.ErrToRejectErr(err)
@@ -771,6 +866,9 @@ func (b *blockManager) handleTxMsg(tmsg *txMsg) {
 		return
 	}
 
+	if len(acceptedTxs) > 0 {
+		atomic.StoreInt64(&tmsg.peer.lastTxUnix, time.Now().Unix())
+	}
 	b.server.AnnounceNewTransactions(acceptedTxs)
 }
 
@@ -977,9 +1075,11 @@ func (b *blockManager) checkBlockForHiddenVotes(block *hcutil.Block) {
 
 // handleBlockMsg handles block messages from all peers.
 func (b *blockManager) handleBlockMsg(bmsg *blockMsg) {
-	// If we didn't ask for this block then the peer is misbehaving.
+	// If we didn't ask for this block then the peer is misbehaving unless
+	// it was asked to announce new blocks by sending compact blocks.
 	blockHash := bmsg.block.Hash()
-	if _, exists := bmsg.peer.requestedBlocks[*blockHash]; !exists {
+	_, requested := bmsg.peer.requestedBlocks[*blockHash]
+	if !requested && !(bmsg.cmpct && b.isCmpctPeer(bmsg.peer)) {
 		// Check to see if we ever requested this block, since it may
 		// have been accidentally sent in duplicate. If it was,
 		// increment the counter in the ever requested map and make
@@ -1008,15 +1108,19 @@ func (b *blockManager) handleBlockMsg(bmsg *blockMsg) {
 	// verified to link together and are valid up to the next checkpoint.
 	// Also, remove the list entry for all blocks except the checkpoint
 	// since it is needed to verify the next round of headers links
-	// properly.
+	// properly.  The headers up to the end of a headers pre-sync are only
+	// shown to have the minimum chain work, so their blocks are fully
+	// validated.
 	isCheckpointBlock := false
 	behaviorFlags := blockchain.BFNone
-	if b.headersFirstMode {
+	if b.headersFirstMode && b.nextCheckpoint != nil {
 		firstNodeEl := b.headerList.Front()
 		if firstNodeEl != nil {
 			firstNode := firstNodeEl.Value.(*headerNode)
 			if blockHash.IsEqual(firstNode.hash) {
-				behaviorFlags |= blockchain.BFFastAdd
+				if !b.presyncTarget {
+					behaviorFlags |= blockchain.BFFastAdd
+				}
 				if firstNode.hash.IsEqual(b.nextCheckpoint.Hash) {
 					isCheckpointBlock = true
 				} else {
@@ -1101,6 +1205,7 @@ func (b *blockManager) handleBlockMsg(bmsg *blockMsg) {
 		// When the block is not an orphan, log information about it and
 		// update the chain state.
 		b.progressLogger.logBlockHeight(bmsg.block)
+		atomic.StoreInt64(&bmsg.peer.lastBlockUnix, time.Now().Unix())
 		r := b.server.rpcServer
 
 		// Determine if this block is recent enough that we need to calculate
@@ -1206,15 +1311,19 @@ func (b *blockManager) handleBlockMsg(bmsg *blockMsg) {
 			heightUpdate = best.Height
 			blkHashUpdate = best.Hash
 
-			// Clear the rejected transactions.
-			b.rejectedTxns = make(map[chainhash.Hash]struct{})
-
 			// Allow any clients performing long polling via the
-			// getblocktemplate RPC to be notified when the new block causes
-			// their old block template to become stale.
+			// getblocktemplate and getwork RPCs to be notified when the
+			// new block causes their old work to become stale.
 			rpcServer := b.server.rpcServer
 			if rpcServer != nil {
 				rpcServer.gbtWorkState.NotifyBlockConnected(blockHash)
+				rpcServer.workState.NotifyBlockConnected()
+			}
+
+			// Ask the peer to announce new blocks by sending compact
+			// blocks since it was the first to relay this one.
+			if b.current() && bmsg.peer.supportsCmpctBlocks() {
+				b.updateCmpctPeers(bmsg.peer)
 			}
 		}
 	}
@@ -1250,10 +1359,18 @@ func (b *blockManager) handleBlockMsg(bmsg *blockMsg) {
 	// This is headers-first mode and the block is a checkpoint.  When
 	// there is a next checkpoint, get the next round of headers by asking
 	// for headers starting from the block after this one up to the next
-	// checkpoint.
+	// checkpoint.  After the final checkpoint, the headers are downloaded
+	// up to the end of the headers pre-sync in the same way.
 	prevHeight := b.nextCheckpoint.Height
 	prevHash := b.nextCheckpoint.Hash
 	b.nextCheckpoint = b.findNextHeaderCheckpoint(prevHeight)
+	b.presyncTarget = false
+	if b.nextCheckpoint == nil && b.headersPresync != nil &&
+		prevHeight < b.headersPresync.endHeight {
+
+		b.nextCheckpoint = b.headersPresync.target()
+		b.presyncTarget = true
+	}
 	if b.nextCheckpoint != nil {
 		locator := blockchain.BlockLocator([]*chainhash.Hash{prevHash})
 		err := bmsg.peer.PushGetHeadersMsg(locator, b.nextCheckpoint.Hash)
@@ -1273,6 +1390,7 @@ func (b *blockManager) handleBlockMsg(bmsg *blockMsg) {
 	// from the block after this one up to the end of the chain (zero hash).
 	b.headersFirstMode = false
 	b.headerList.Init()
+	b.headersPresync = nil
 	bmgrLog.Infof("Reached the final checkpoint -- switching to normal mode")
 	locator := blockchain.BlockLocator([]*chainhash.Hash{blockHash})
 	err = bmsg.peer.PushGetBlocksMsg(locator, &zeroHash)
@@ -1345,6 +1463,12 @@ func (b *blockManager) handleHeadersMsg(hmsg *headersMsg) {
 		return
 	}
 
+	// Headers are not stored while they are pre-synced.
+	if b.headersPresync != nil && !b.headersPresync.complete {
+		b.handlePresyncHeadersMsg(hmsg)
+		return
+	}
+
 	// Nothing to do for an empty headers message.
 	if numHeaders == 0 {
 		return
@@ -1385,6 +1509,18 @@ func (b *blockManager) handleHeadersMsg(hmsg *headersMsg) {
 			return
 		}
 
+		// Ensure the header matches the commitments of the pre-sync
+		// when the headers are downloaded again after it.
+		if b.headersPresync != nil &&
+			!b.headersPresync.verifyHeader(node.hash, node.height) {
+
+			bmgrLog.Warnf("Received block header that does not "+
+				"match the pre-synced headers from peer %s "+
+				"-- disconnecting", hmsg.peer.Addr())
+			hmsg.peer.Disconnect()
+			return
+		}
+
 		// Verify the header at the next checkpoint height matches.
 		if node.height == b.nextCheckpoint.Height {
 			if node.hash.IsEqual(b.nextCheckpoint.Hash) {
@@ -1433,6 +1569,59 @@ func (b *blockManager) handleHeadersMsg(hmsg *headersMsg) {
 	}
 }
 
+// handlePresyncHeadersMsg handles headers messages from the sync peer while its
+// headers are pre-synced.  Once the headers have the minimum chain work, they
+// are requested again to be stored.
+func (b *blockManager) handlePresyncHeadersMsg(hmsg *headersMsg) {
+	presync := b.headersPresync
+	err := presync.processHeaders(hmsg.headers.Headers)
+	if err != nil {
+		bmgrLog.Warnf("Received invalid block header while pre-syncing "+
+			"headers from peer %s: %v -- disconnecting",
+			hmsg.peer.Addr(), err)
+		hmsg.peer.Disconnect()
+		return
+	}
+
+	if presync.complete {
+		bmgrLog.Infof("Pre-synced headers up to height %d with the "+
+			"minimum chain work from peer %s -- downloading them "+
+			"again", presync.endHeight, hmsg.peer.Addr())
+
+		// Without a checkpoint to download the headers up to, they are
+		// downloaded up to the header the minimum chain work was
+		// reached at instead.
+		if b.nextCheckpoint == nil {
+			b.nextCheckpoint = presync.target()
+			b.presyncTarget = true
+		}
+		err := hmsg.peer.PushGetHeadersMsg(presync.locator,
+			b.nextCheckpoint.Hash)
+		if err != nil {
+			bmgrLog.Warnf("Failed to send getheaders message to "+
+				"peer %s: %v", hmsg.peer.Addr(), err)
+		}
+		return
+	}
+
+	// A peer which has no more headers before the minimum chain work is
+	// reached does not have a chain worth syncing from.
+	if len(hmsg.headers.Headers) < wire.MaxBlockHeadersPerMsg {
+		bmgrLog.Warnf("Chain of peer %s ends at height %d without the "+
+			"minimum chain work -- disconnecting", hmsg.peer.Addr(),
+			presync.height)
+		hmsg.peer.Disconnect()
+		return
+	}
+
+	locator := blockchain.BlockLocator([]*chainhash.Hash{&presync.hash})
+	err = hmsg.peer.PushGetHeadersMsg(locator, &zeroHash)
+	if err != nil {
+		bmgrLog.Warnf("Failed to send getheaders message to "+
+			"peer %s: %v", hmsg.peer.Addr(), err)
+	}
+}
+
 // haveInventory returns whether or not the inventory represented by the passed
 // inventory vector is known.  This includes checking all of the various places
 // inventory can be when it is in different states such as blocks that are part
@@ -1535,7 +1724,7 @@ func (b *blockManager) handleInvMsg(imsg *invMsg) {
 			if iv.Type == wire.InvTypeTx {
 				// Skip the transaction if it has already been
 				// rejected.
-				if _, exists := b.rejectedTxns[iv.Hash]; exists {
+				if b.server.txMemPool.IsRecentlyRejected(&iv.Hash) {
 					continue
 				}
 			}
@@ -1594,6 +1783,12 @@ func (b *blockManager) handleInvMsg(imsg *invMsg) {
 		}
 	}
 
+	// Request new blocks as compact blocks when the chain is current and the
+	// peer supports them, since the transactions of new blocks are most
+	// likely already in the memory pool.
+	requestCmpct := b.current() && !b.headersFirstMode &&
+		imsg.peer.supportsCmpctBlocks()
+
 	// Request as much as possible at once.  Anything that won't fit into
 	// the request will be requested on the next inv message.
 	numRequested := 0
@@ -1613,6 +1808,10 @@ func (b *blockManager) handleInvMsg(imsg *invMsg) {
 				b.requestedEverBlocks[iv.Hash] = 0
 				b.limitMap(b.requestedBlocks, maxRequestedBlocks)
 				imsg.peer.requestedBlocks[iv.Hash] = struct{}{}
+				if requestCmpct {
+					iv = wire.NewInvVect(wire.InvTypeCmpctBlock,
+						&iv.Hash)
+				}
 				gdmsg.AddInvVect(iv)
 				numRequested++
 			}
@@ -1640,6 +1839,64 @@ func (b *blockManager) handleInvMsg(imsg *invMsg) {
 	}
 }
 
+// isCmpctPeer returns whether or not the passed peer was asked to announce new
+// blocks by sending compact blocks.
+func (b *blockManager) isCmpctPeer(sp *serverPeer) bool {
+	for _, cp := range b.cmpctPeers {
+		if cp == sp {
+			return true
+		}
+	}
+	return false
+}
+
+// updateCmpctPeers marks the passed peer as the one which most recently relayed
+// a new block first.  Peers which are newly added to the high-bandwidth compact
+// block peers are asked to announce new blocks by sending compact blocks, while
+// the peer which least recently relayed a new block is asked to stop doing so
+// when there are too many of them.
+func (b *blockManager) updateCmpctPeers(sp *serverPeer) {
+	for i, cp := range b.cmpctPeers {
+		if cp == sp {
+			b.cmpctPeers = append(b.cmpctPeers[:i], b.cmpctPeers[i+1:]...)
+			b.cmpctPeers = append(b.cmpctPeers, sp)
+			return
+		}
+	}
+
+	if len(b.cmpctPeers) >= maxHighBandwidthPeers {
+		evicted := b.cmpctPeers[0]
+		b.cmpctPeers = b.cmpctPeers[1:]
+		evicted.QueueMessage(wire.NewMsgSendCmpct(false,
+			wire.CmpctBlockVersion), nil)
+	}
+	b.cmpctPeers = append(b.cmpctPeers, sp)
+	sp.QueueMessage(wire.NewMsgSendCmpct(true, wire.CmpctBlockVersion), nil)
+	bmgrLog.Debugf("Requested high-bandwidth compact blocks from %s", sp)
+}
+
+// handleRequestFullBlockMsg requests the block of the passed message in full
+// from the peer of the message unless it is already known.  The request is
+// recorded in the request maps since blocks which are sent as compact blocks
+// by high-bandwidth peers are not requested.
+func (b *blockManager) handleRequestFullBlockMsg(msg requestFullBlockMsg) {
+	exists, err := b.chain.HaveBlock(&msg.hash)
+	if err != nil || exists {
+		return
+	}
+
+	if _, ok := msg.peer.requestedBlocks[msg.hash]; !ok {
+		msg.peer.requestedBlocks[msg.hash] = struct{}{}
+		b.requestedBlocks[msg.hash] = struct{}{}
+		b.requestedEverBlocks[msg.hash] = 0
+		b.limitMap(b.requestedBlocks, maxRequestedBlocks)
+	}
+
+	gdmsg := wire.NewMsgGetData()
+	gdmsg.AddInvVect(wire.NewInvVect(wire.InvTypeBlock, &msg.hash))
+	msg.peer.QueueMessage(gdmsg, nil)
+}
+
 // limitMap is a helper function for maps that require a maximum limit by
 // evicting a random transaction if adding a new value would cause it to
 // overflow the maximum allowed.
@@ -1682,6 +1939,9 @@ out:
 				b.handleBlockMsg(msg)
 				msg.peer.blockProcessed <- struct{}{}
 
+			case requestFullBlockMsg:
+				b.handleRequestFullBlockMsg(msg)
+
 			case *invMsg:
 				b.handleInvMsg(msg)
 
@@ -1915,11 +2175,12 @@ out:
 				}
 
 				// Allow any clients performing long polling via the
-				// getblocktemplate RPC to be notified when the new block causes
-				// their old block template to become stale.
+				// getblocktemplate and getwork RPCs to be notified when the
+				// new block causes their old work to become stale.
 				rpcServer := b.server.rpcServer
 				if rpcServer != nil {
 					rpcServer.gbtWorkState.NotifyBlockConnected(msg.block.Hash())
+					rpcServer.workState.NotifyBlockConnected()
 				}
 
 				msg.reply <- processBlockResponse{
@@ -1929,7 +2190,8 @@ out:
 
 			case processTransactionMsg:
 				acceptedTxs, err := b.server.txMemPool.ProcessTransaction(msg.tx,
-					msg.allowOrphans, msg.rateLimit, msg.allowHighFees)
+					msg.allowOrphans, msg.rateLimit, msg.allowHighFees,
+					msg.tag)
 				msg.reply <- processTransactionResponse{
 					acceptedTxs: acceptedTxs,
 					err:         err,
@@ -2045,7 +2307,7 @@ func (b *blockManager) handleNotifyMsg(notification *blockchain.Notification) {
 
 		// Generate the inventory vector and relay it.
 		iv := wire.NewInvVect(wire.InvTypeBlock, block.Hash())
-		b.server.RelayInventory(iv, block.MsgBlock().Header)
+		b.server.RelayInventory(iv, block)
 
 	// A block has been connected to the main block chain.
 	case blockchain.NTBlockConnected:
@@ -2265,6 +2527,29 @@ func (b *blockManager) QueueBlock(block *hcutil.Block, sp *serverPeer) {
 	b.msgChan <- &blockMsg{block: block, peer: sp}
 }
 
+// QueueCmpctBlock adds the passed block which was reconstructed from a compact
+// block and peer to the block handling queue.
+func (b *blockManager) QueueCmpctBlock(block *hcutil.Block, sp *serverPeer) {
+	// Don't accept more blocks if we're shutting down.
+	if atomic.LoadInt32(&b.shutdown) != 0 {
+		sp.blockProcessed <- struct{}{}
+		return
+	}
+
+	b.msgChan <- &blockMsg{block: block, peer: sp, cmpct: true}
+}
+
+// RequestFullBlock requests the block with the passed hash in full from the
+// peer when it could not be reconstructed from the compact block sent by the
+// peer.
+func (b *blockManager) RequestFullBlock(sp *serverPeer, hash *chainhash.Hash) {
+	if atomic.LoadInt32(&b.shutdown) != 0 {
+		return
+	}
+
+	b.msgChan <- requestFullBlockMsg{peer: sp, hash: *hash}
+}
+
 // QueueInv adds the passed inv message and peer to the block handling queue.
 func (b *blockManager) QueueInv(inv *wire.MsgInv, sp *serverPeer) {
 	// No channel handling here because peers do not need to block on inv
@@ -2520,10 +2805,10 @@ func (b *blockManager) ProcessBlock(block *hcutil.Block, flags blockchain.Behavi
 // a block chain.  It is funneled through the block manager since blockchain is
 // not safe for concurrent access.
 func (b *blockManager) ProcessTransaction(tx *hcutil.Tx, allowOrphans bool,
-	rateLimit bool, allowHighFees bool) ([]*hcutil.Tx, error) {
+	rateLimit bool, allowHighFees bool, tag mempool.Tag) ([]*hcutil.Tx, error) {
 	reply := make(chan processTransactionResponse, 1)
 	b.msgChan <- processTransactionMsg{tx, allowOrphans, rateLimit,
-		allowHighFees, reply}
+		allowHighFees, tag, reply}
 	response := <-reply
 	return response.acceptedTxs, response.err
 }
@@ -2589,7 +2874,6 @@ func (b *blockManager) SetParentTemplate(bt *BlockTemplate) {
 func newBlockManager(s *server, indexManager blockchain.IndexManager) (*blockManager, error) {
 	bm := blockManager{
 		server:              s,
-		rejectedTxns:        make(map[chainhash.Hash]struct{}),
 		requestedTxns:       make(map[chainhash.Hash]struct{}),
 		requestedEverTxns:   make(map[chainhash.Hash]uint8),
 		requestedBlocks:     make(map[chainhash.Hash]struct{}),
@@ -0,0 +1,176 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package chainhash
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// MidstateSize is the size of a serialized midstate in bytes.
+const MidstateSize = 32
+
+// blakeIV is the initial chain value of BLAKE-256.
+var blakeIV = [8]uint32{
+	0x6a09e667, 0xbb67ae85, 0x3c6ef372, 0xa54ff53a,
+	0x510e527f, 0x9b05688c, 0x1f83d9ab, 0x5be0cd19,
+}
+
+// blakeConst holds the constants of BLAKE-256.
+var blakeConst = [16]uint32{
+	0x243f6a88, 0x85a308d3, 0x13198a2e, 0x03707344,
+	0xa4093822, 0x299f31d0, 0x082efa98, 0xec4e6c89,
+	0x452821e6, 0x38d01377, 0xbe5466cf, 0x34e90c6c,
+	0xc0ac29b7, 0xc97c50dd, 0x3f84d5b5, 0xb5470917,
+}
+
+// blakeSigma holds the message word permutations used by the rounds of
+// BLAKE-256.  Rounds 10 through 13 reuse the first four permutations.
+var blakeSigma = [10][16]uint8{
+	{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+	{14, 10, 4, 8, 9, 15, 13, 6, 1, 12, 0, 2, 11, 7, 5, 3},
+	{11, 8, 12, 0, 5, 2, 15, 13, 10, 14, 3, 6, 7, 1, 9, 4},
+	{7, 9, 3, 1, 13, 12, 11, 14, 2, 6, 5, 10, 4, 0, 15, 8},
+	{9, 0, 5, 7, 2, 4, 10, 15, 14, 1, 11, 12, 6, 8, 3, 13},
+	{2, 12, 6, 10, 0, 11, 8, 3, 4, 13, 7, 5, 15, 14, 1, 9},
+	{12, 5, 1, 15, 14, 13, 4, 10, 0, 7, 6, 3, 9, 2, 8, 11},
+	{13, 11, 7, 14, 12, 1, 3, 9, 5, 0, 15, 4, 8, 6, 2, 10},
+	{6, 15, 14, 9, 11, 3, 0, 8, 12, 2, 13, 7, 1, 4, 10, 5},
+	{10, 2, 8, 4, 7, 6, 1, 5, 15, 11, 9, 14, 3, 12, 13, 0},
+}
+
+// blakeG is the G function of BLAKE-256 which mixes the passed words of the
+// state with the passed message words, each already combined with the
+// corresponding constant.
+func blakeG(a, b, c, d *uint32, mc0, mc1 uint32) {
+	*a += *b + mc0
+	*d = bits.RotateLeft32(*d^*a, -16)
+	*c += *d
+	*b = bits.RotateLeft32(*b^*c, -12)
+	*a += *b + mc1
+	*d = bits.RotateLeft32(*d^*a, -8)
+	*c += *d
+	*b = bits.RotateLeft32(*b^*c, -7)
+}
+
+// blakeCompress updates the passed BLAKE-256 chain value by compressing the
+// passed 64-byte block.  The counter is the number of message bits hashed up
+// to and including the block, or zero when the block only holds padding.
+func blakeCompress(h *[8]uint32, block []byte, counter uint64) {
+	var m [16]uint32
+	for i := range m {
+		m[i] = binary.BigEndian.Uint32(block[i*4:])
+	}
+
+	v0, v1, v2, v3, v4, v5, v6, v7 := h[0], h[1], h[2], h[3], h[4], h[5],
+		h[6], h[7]
+	v8, v9, v10, v11 := blakeConst[0], blakeConst[1], blakeConst[2],
+		blakeConst[3]
+	v12 := blakeConst[4] ^ uint32(counter)
+	v13 := blakeConst[5] ^ uint32(counter)
+	v14 := blakeConst[6] ^ uint32(counter>>32)
+	v15 := blakeConst[7] ^ uint32(counter>>32)
+
+	c := &blakeConst
+	for round := 0; round < 14; round++ {
+		s := &blakeSigma[round%10]
+		blakeG(&v0, &v4, &v8, &v12, m[s[0]]^c[s[1]], m[s[1]]^c[s[0]])
+		blakeG(&v1, &v5, &v9, &v13, m[s[2]]^c[s[3]], m[s[3]]^c[s[2]])
+		blakeG(&v2, &v6, &v10, &v14, m[s[4]]^c[s[5]], m[s[5]]^c[s[4]])
+		blakeG(&v3, &v7, &v11, &v15, m[s[6]]^c[s[7]], m[s[7]]^c[s[6]])
+		blakeG(&v0, &v5, &v10, &v15, m[s[8]]^c[s[9]], m[s[9]]^c[s[8]])
+		blakeG(&v1, &v6, &v11, &v12, m[s[10]]^c[s[11]], m[s[11]]^c[s[10]])
+		blakeG(&v2, &v7, &v8, &v13, m[s[12]]^c[s[13]], m[s[13]]^c[s[12]])
+		blakeG(&v3, &v4, &v9, &v14, m[s[14]]^c[s[15]], m[s[15]]^c[s[14]])
+	}
+
+	h[0] ^= v0 ^ v8
+	h[1] ^= v1 ^ v9
+	h[2] ^= v2 ^ v10
+	h[3] ^= v3 ^ v11
+	h[4] ^= v4 ^ v12
+	h[5] ^= v5 ^ v13
+	h[6] ^= v6 ^ v14
+	h[7] ^= v7 ^ v15
+}
+
+// Midstate is the internal state of BLAKE-256 after hashing a prefix of a
+// message which is a multiple of the hash block size.  It allows the hashes of
+// many messages which only differ after the prefix, such as block headers
+// which only differ in their nonces, to be calculated by only hashing the
+// remaining data of each message.
+type Midstate struct {
+	h [8]uint32
+
+	// n is the number of message bits hashed.
+	n uint64
+}
+
+// NewMidstate returns the midstate after hashing the passed prefix.  It panics
+// when the length of the prefix is not a multiple of HashBlockSize.
+func NewMidstate(prefix []byte) *Midstate {
+	if len(prefix)%HashBlockSize != 0 {
+		panic("midstate prefix is not a multiple of the block size")
+	}
+
+	m := &Midstate{h: blakeIV}
+	for ; len(prefix) > 0; prefix = prefix[HashBlockSize:] {
+		m.n += HashBlockSize * 8
+		blakeCompress(&m.h, prefix[:HashBlockSize], m.n)
+	}
+	return m
+}
+
+// HashH returns the hash of the message made up of the prefix the midstate was
+// created from followed by the passed suffix.  It does not modify the midstate
+// and is safe for concurrent access.
+func (m *Midstate) HashH(suffix []byte) Hash {
+	h, n := m.h, m.n
+	for ; len(suffix) >= HashBlockSize; suffix = suffix[HashBlockSize:] {
+		n += HashBlockSize * 8
+		blakeCompress(&h, suffix[:HashBlockSize], n)
+	}
+
+	// Pad the remaining data with a one bit, zero bits and a final one bit
+	// followed by the big-endian message length in bits.  An extra block
+	// is needed when the remaining data does not leave room for the
+	// padding.  Blocks which only hold padding are compressed with a zero
+	// counter.
+	var final [2 * HashBlockSize]byte
+	rem := copy(final[:], suffix)
+	n += uint64(rem) * 8
+	end := HashBlockSize
+	if rem > HashBlockSize-9 {
+		end = 2 * HashBlockSize
+	}
+	final[rem] = 0x80
+	final[end-9] |= 0x01
+	binary.BigEndian.PutUint64(final[end-8:end], n)
+	counter := n
+	if rem == 0 {
+		counter = 0
+	}
+	blakeCompress(&h, final[:HashBlockSize], counter)
+	if end != HashBlockSize {
+		blakeCompress(&h, final[HashBlockSize:], 0)
+	}
+
+	var hash Hash
+	for i, word := range h {
+		binary.BigEndian.PutUint32(hash[i*4:], word)
+	}
+	return hash
+}
+
+// Bytes returns the serialized chain value of the midstate which external
+// hashers continue from.  It consists of the eight 32-bit words of the chain
+// value in big endian.
+func (m *Midstate) Bytes() [MidstateSize]byte {
+	var b [MidstateSize]byte
+	for i, word := range m.h {
+		binary.BigEndian.PutUint32(b[i*4:], word)
+	}
+	return b
+}
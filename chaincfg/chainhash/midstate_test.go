@@ -0,0 +1,76 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package chainhash
+
+import (
+	"testing"
+)
+
+// headerSize is the size of a serialized block header, which is hashed by
+// miners with a midstate over the first two blocks.
+const headerSize = 180
+
+// TestMidstate ensures hashing a suffix with the midstate of a prefix produces
+// the same hash as hashing the whole message for all of the suffix lengths
+// which need a different padding.
+func TestMidstate(t *testing.T) {
+	msg := make([]byte, 4*HashBlockSize)
+	for i := range msg {
+		msg[i] = byte(i * 7)
+	}
+
+	for _, prefixLen := range []int{0, HashBlockSize, 2 * HashBlockSize} {
+		midstate := NewMidstate(msg[:prefixLen])
+		for end := prefixLen; end <= len(msg); end++ {
+			got := midstate.HashH(msg[prefixLen:end])
+			want := HashH(msg[:end])
+			if got != want {
+				t.Fatalf("HashH (prefix %d, length %d): got %v, "+
+					"want %v", prefixLen, end, got, want)
+			}
+		}
+	}
+
+	// The serialized midstate of an empty prefix is the initial value.
+	b := NewMidstate(nil).Bytes()
+	if b[0] != 0x6a || b[1] != 0x09 || b[31] != 0x19 {
+		t.Fatalf("Bytes: unexpected initial value %x", b)
+	}
+}
+
+// TestMidstatePanic ensures creating a midstate from a prefix which is not a
+// multiple of the block size panics.
+func TestMidstatePanic(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("NewMidstate: did not panic")
+		}
+	}()
+	NewMidstate(make([]byte, HashBlockSize+1))
+}
+
+// BenchmarkHashHeader benchmarks hashing a whole block header.
+func BenchmarkHashHeader(b *testing.B) {
+	header := make([]byte, headerSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		header[140] = byte(i)
+		HashH(header)
+	}
+}
+
+// BenchmarkHashHeaderMidstate benchmarks hashing a block header with the
+// midstate of the part which does not contain the nonce.
+func BenchmarkHashHeaderMidstate(b *testing.B) {
+	header := make([]byte, headerSize)
+	midstate := NewMidstate(header[:2*HashBlockSize])
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		header[140] = byte(i)
+		midstate.HashH(header[2*HashBlockSize:])
+	}
+}
@@ -311,6 +311,13 @@ type Params struct {
 	// Checkpoints ordered from oldest to newest.
 	Checkpoints []Checkpoint
 
+	// MinimumChainWork is the minimum amount of work the best chain of the
+	// network is known to have.  During the initial sync, the headers of
+	// the sync peer are pre-synced without being stored until they are
+	// shown to have at least this much work.  It is nil when the work is
+	// not known, which disables the pre-sync.
+	MinimumChainWork *big.Int
+
 	// These fields are related to voting on consensus rule changes as
 	// defined by BIP0009.
 	//
@@ -493,6 +500,12 @@ var MainNetParams = Params{
 	// Checkpoints ordered from oldest to newest.
 	Checkpoints: []Checkpoint{},
 
+	// The minimum chain work is the chainwork reported by getblockchaininfo
+	// for the best chain at a recent height.  It is not set until it was
+	// measured on a synced node, since a bound derived from the
+	// proof-of-work limit is cheap to reach for an attacker.
+	MinimumChainWork: nil,
+
 	// The miner confirmation window is defined as:
 	//   target proof of work timespan / target proof of work spacing
 	RuleChangeActivationQuorum:     4032, // 10 % of RuleChangeActivationInterval * TicketsPerBlock
@@ -604,6 +617,12 @@ var TestNet2Params = Params{
 	// Checkpoints ordered from oldest to newest.
 	Checkpoints: []Checkpoint{},
 
+	// The minimum chain work is the chainwork reported by getblockchaininfo
+	// for the best chain at a recent height.  It is not set until it was
+	// measured on a synced node, since a bound derived from the
+	// proof-of-work limit is cheap to reach for an attacker.
+	MinimumChainWork: nil,
+
 	// Consensus rule change deployments.
 	//
 	// The miner confirmation window is defined as:
@@ -746,6 +765,9 @@ var SimNetParams = Params{
 	// Checkpoints ordered from oldest to newest.
 	Checkpoints: nil,
 
+	// The minimum chain work is not needed on the simulation test network.
+	MinimumChainWork: nil,
+
 	// Consensus rule change deployments.
 	//
 	// The miner confirmation window is defined as:
@@ -0,0 +1,193 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// hccapture decodes and filters the peer messages captured by hcd when it is
+// run with --capturemessages.
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/davecgh/go-spew/spew"
+	flags "github.com/jessevdk/go-flags"
+	"github.com/nbit99/hcd/peer"
+	"github.com/nbit99/hcd/wire"
+)
+
+type config struct {
+	Commands  []string `short:"c" long:"command" description:"Only show messages with the given command (may be specified multiple times)"`
+	Addrs     []string `short:"a" long:"addr" description:"Only show messages of peers with the given address (may be specified multiple times)"`
+	Direction string   `short:"d" long:"direction" description:"Only show messages in the given direction {sent, received}"`
+	Decode    bool     `short:"v" long:"decode" description:"Decode and dump the messages"`
+	Hex       bool     `short:"x" long:"hex" description:"Show a hex dump of the raw payloads"`
+}
+
+// readCaptures reads all captured messages from the passed files or the files
+// in the passed directories.
+func readCaptures(paths []string) ([]*peer.CapturedMessage, error) {
+	var files []string
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(path, "*.dat"))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+
+	var msgs []*peer.CapturedMessage
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		r := bufio.NewReader(f)
+		for {
+			msg, err := peer.ReadCapturedMessage(r)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				f.Close()
+				return nil, fmt.Errorf("%s: %v", file, err)
+			}
+			msgs = append(msgs, msg)
+		}
+		f.Close()
+	}
+
+	// Show the messages of all files in the order they were captured.
+	sort.SliceStable(msgs, func(i, j int) bool {
+		return msgs[i].Timestamp.Before(msgs[j].Timestamp)
+	})
+	return msgs, nil
+}
+
+// contains returns whether or not s is in the passed list.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// protocolVersions tracks the protocol version negotiated with each peer from
+// the version messages in the captures so the messages are decoded the same
+// way they were by hcd.
+type protocolVersions map[string]uint32
+
+// update updates the protocol version of the peer of the passed message when it
+// is a version message.
+func (pv protocolVersions) update(msg *peer.CapturedMessage) {
+	if msg.Command != wire.CmdVersion {
+		return
+	}
+	decoded, err := wire.DecodeMessagePayload(msg.Command, msg.Payload,
+		wire.ProtocolVersion)
+	if err != nil {
+		return
+	}
+	pver := uint32(decoded.(*wire.MsgVersion).ProtocolVersion)
+	if cur, ok := pv[msg.Addr]; !ok || pver < cur {
+		pv[msg.Addr] = pver
+	}
+}
+
+// get returns the protocol version of the peer with the passed address.
+func (pv protocolVersions) get(addr string) uint32 {
+	if pver, ok := pv[addr]; ok && pver < wire.ProtocolVersion {
+		return pver
+	}
+	return wire.ProtocolVersion
+}
+
+func main() {
+	var cfg config
+	parser := flags.NewParser(&cfg, flags.Default)
+	parser.Usage = "[OPTIONS] <capture file or directory>..."
+	paths, err := parser.Parse()
+	if err != nil {
+		if e, ok := err.(*flags.Error); !ok || e.Type != flags.ErrHelp {
+			parser.WriteHelp(os.Stderr)
+		}
+		return
+	}
+	if len(paths) == 0 {
+		parser.WriteHelp(os.Stderr)
+		os.Exit(1)
+	}
+	switch cfg.Direction {
+	case "", "sent", "received":
+	default:
+		fmt.Fprintf(os.Stderr, "invalid direction %q\n", cfg.Direction)
+		os.Exit(1)
+	}
+
+	msgs, err := readCaptures(paths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot read captures: %v\n", err)
+		os.Exit(1)
+	}
+
+	pvers := make(protocolVersions)
+	for _, msg := range msgs {
+		pvers.update(msg)
+
+		direction := "received"
+		arrow := "<-"
+		if msg.Sent {
+			direction = "sent"
+			arrow = "->"
+		}
+		if len(cfg.Commands) > 0 && !contains(cfg.Commands, msg.Command) ||
+			len(cfg.Addrs) > 0 && !contains(cfg.Addrs, msg.Addr) ||
+			cfg.Direction != "" && cfg.Direction != direction {
+
+			continue
+		}
+
+		fmt.Printf("%s %s %s %s (%d bytes)\n",
+			msg.Timestamp.Format("2006-01-02 15:04:05.000000"), arrow,
+			msg.Addr, msg.Command, len(msg.Payload))
+		if cfg.Decode {
+			decoded, err := wire.DecodeMessagePayload(msg.Command,
+				msg.Payload, pvers.get(msg.Addr))
+			if err != nil {
+				fmt.Printf("  cannot decode message: %v\n", err)
+			} else {
+				fmt.Print(indent(spew.Sdump(decoded)))
+			}
+		}
+		if cfg.Hex && len(msg.Payload) > 0 {
+			fmt.Print(indent(hex.Dump(msg.Payload)))
+		}
+	}
+}
+
+// indent indents each line of the passed text.
+func indent(text string) string {
+	lines := strings.SplitAfter(text, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = "  " + line
+		}
+	}
+	return strings.Join(lines, "")
+}
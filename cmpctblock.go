@@ -0,0 +1,265 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nbit99/hcd/blockchain"
+	"github.com/nbit99/hcd/blockchain/stake"
+	"github.com/nbit99/hcd/hcutil"
+	"github.com/nbit99/hcd/wire"
+)
+
+const (
+	// maxCmpctBlockDepth is the maximum depth of the blocks which are sent
+	// as compact blocks.  Peers are unlikely to have the transactions of
+	// older blocks in their memory pools, so they are sent in full.
+	maxCmpctBlockDepth = 10
+
+	// maxBlockTxnDepth is the maximum depth of the blocks whose transactions
+	// are sent in response to getblocktxn messages.  Older blocks are sent
+	// in full instead, so the requests are subject to the same limits as
+	// any other request for historical blocks.
+	maxBlockTxnDepth = 10
+
+	// maxHighBandwidthPeers is the maximum number of peers which are asked
+	// to announce new blocks by sending compact blocks directly.
+	maxHighBandwidthPeers = 3
+)
+
+var (
+	// errShortIDCollision indicates the short ids of a compact block are
+	// ambiguous, so the block can't be reconstructed and has to be
+	// requested in full.
+	errShortIDCollision = errors.New("duplicate short transaction ids")
+
+	// errMerkleRootMismatch indicates a reconstructed block does not match
+	// the merkle roots of its header, which happens when a transaction of
+	// the memory pool collides with the short id of a transaction of the
+	// block.  The block has to be requested in full.
+	errMerkleRootMismatch = errors.New("reconstructed block does not " +
+		"match the merkle roots of its header")
+)
+
+// newCmpctBlock returns the compact block for the passed block.  The coinbase
+// and the votes are prefilled since peers are unlikely to have them in their
+// memory pools, while all other transactions of both trees are included as
+// short ids.
+func newCmpctBlock(block *hcutil.Block) (*wire.MsgCmpctBlock, error) {
+	nonce, err := wire.RandomUint64()
+	if err != nil {
+		return nil, err
+	}
+	msgBlock := block.MsgBlock()
+	msg := wire.NewMsgCmpctBlock(&msgBlock.Header, nonce)
+	key := msg.ShortIDKey()
+
+	for i, tx := range block.Transactions() {
+		if i == 0 {
+			msg.PrefilledTxs = append(msg.PrefilledTxs,
+				wire.PrefilledTx{Index: 0, Tx: tx.MsgTx()})
+			continue
+		}
+		msg.ShortIDs = append(msg.ShortIDs, key.ShortID(tx.Hash()))
+	}
+	for i, tx := range block.STransactions() {
+		if stake.DetermineTxType(tx.MsgTx()) == stake.TxTypeSSGen {
+			msg.SPrefilledTxs = append(msg.SPrefilledTxs,
+				wire.PrefilledTx{Index: uint32(i), Tx: tx.MsgTx()})
+			continue
+		}
+		msg.SShortIDs = append(msg.SShortIDs, key.ShortID(tx.Hash()))
+	}
+	return msg, nil
+}
+
+// partialTree houses the transactions of one transaction tree of a compact
+// block which is being reconstructed.
+type partialTree struct {
+	txns    []*wire.MsgTx
+	missing []uint32
+
+	// shortIDs maps the short ids of the transactions which are not
+	// prefilled to their indexes in the tree.
+	shortIDs map[uint64]uint32
+}
+
+// newPartialTree returns the transaction tree made up of the passed prefilled
+// transactions and short ids.  An error is returned when the prefilled
+// transactions do not fit into the tree.
+func newPartialTree(shortIDs []uint64, prefilled []wire.PrefilledTx) (*partialTree, error) {
+	t := &partialTree{
+		txns:     make([]*wire.MsgTx, len(shortIDs)+len(prefilled)),
+		shortIDs: make(map[uint64]uint32, len(shortIDs)),
+	}
+	for _, ptx := range prefilled {
+		if int(ptx.Index) >= len(t.txns) {
+			return nil, fmt.Errorf("prefilled transaction index %d "+
+				"exceeds the number of transactions %d",
+				ptx.Index, len(t.txns))
+		}
+		t.txns[ptx.Index] = ptx.Tx
+	}
+
+	// The short ids fill the indexes which are not prefilled in order.
+	var next int
+	for i := range t.txns {
+		if t.txns[i] != nil {
+			continue
+		}
+		if _, ok := t.shortIDs[shortIDs[next]]; ok {
+			return nil, errShortIDCollision
+		}
+		t.shortIDs[shortIDs[next]] = uint32(i)
+		next++
+	}
+	return t, nil
+}
+
+// partialBlock houses the state of a block which is being reconstructed from
+// a compact block.
+type partialBlock struct {
+	header  wire.BlockHeader
+	regular *partialTree
+	stake   *partialTree
+}
+
+// newPartialBlock returns the partially reconstructed block for the passed
+// compact block by filling in the prefilled transactions and the passed
+// transactions of the memory pool which match its short ids.  Transactions of
+// the memory pool which share a short id are left missing.
+func newPartialBlock(msg *wire.MsgCmpctBlock, poolTxns []*hcutil.Tx) (*partialBlock, error) {
+	regular, err := newPartialTree(msg.ShortIDs, msg.PrefilledTxs)
+	if err != nil {
+		return nil, err
+	}
+	stakeTree, err := newPartialTree(msg.SShortIDs, msg.SPrefilledTxs)
+	if err != nil {
+		return nil, err
+	}
+
+	key := msg.ShortIDKey()
+	collisions := make(map[*wire.MsgTx]struct{})
+	for _, tx := range poolTxns {
+		shortID := key.ShortID(tx.Hash())
+		for _, t := range []*partialTree{regular, stakeTree} {
+			index, ok := t.shortIDs[shortID]
+			if !ok {
+				continue
+			}
+			if t.txns[index] != nil {
+				collisions[t.txns[index]] = struct{}{}
+				continue
+			}
+			t.txns[index] = tx.MsgTx()
+		}
+	}
+
+	for _, t := range []*partialTree{regular, stakeTree} {
+		for _, index := range t.shortIDs {
+			if _, ok := collisions[t.txns[index]]; ok {
+				t.txns[index] = nil
+			}
+		}
+		for i, tx := range t.txns {
+			if tx == nil {
+				t.missing = append(t.missing, uint32(i))
+			}
+		}
+	}
+
+	return &partialBlock{
+		header:  msg.Header,
+		regular: regular,
+		stake:   stakeTree,
+	}, nil
+}
+
+// complete returns whether or not all transactions of the block are known.
+func (b *partialBlock) complete() bool {
+	return len(b.regular.missing) == 0 && len(b.stake.missing) == 0
+}
+
+// getBlockTxnMsg returns the message which requests the missing transactions
+// of the block.
+func (b *partialBlock) getBlockTxnMsg() *wire.MsgGetBlockTxn {
+	blockHash := b.header.BlockHash()
+	msg := wire.NewMsgGetBlockTxn(&blockHash)
+	msg.Indexes = b.regular.missing
+	msg.SIndexes = b.stake.missing
+	return msg
+}
+
+// fill fills in the missing transactions of the block with the transactions
+// of the passed blocktxn message.
+func (b *partialBlock) fill(msg *wire.MsgBlockTxn) error {
+	if len(msg.Transactions) != len(b.regular.missing) ||
+		len(msg.STransactions) != len(b.stake.missing) {
+
+		return fmt.Errorf("blocktxn message has %d transactions and "+
+			"%d stake transactions instead of the missing %d and %d",
+			len(msg.Transactions), len(msg.STransactions),
+			len(b.regular.missing), len(b.stake.missing))
+	}
+
+	for i, index := range b.regular.missing {
+		b.regular.txns[index] = msg.Transactions[i]
+	}
+	for i, index := range b.stake.missing {
+		b.stake.txns[index] = msg.STransactions[i]
+	}
+	b.regular.missing = nil
+	b.stake.missing = nil
+	return nil
+}
+
+// block returns the reconstructed block once all of its transactions are
+// known.  It returns errMerkleRootMismatch when the transactions do not match
+// the merkle roots of the header.
+func (b *partialBlock) block() (*hcutil.Block, error) {
+	msgBlock := &wire.MsgBlock{
+		Header:        b.header,
+		Transactions:  b.regular.txns,
+		STransactions: b.stake.txns,
+	}
+	block := hcutil.NewBlock(msgBlock)
+
+	merkles := blockchain.BuildMerkleTreeStore(block.Transactions())
+	if *merkles[len(merkles)-1] != b.header.MerkleRoot {
+		return nil, errMerkleRootMismatch
+	}
+	merkles = blockchain.BuildMerkleTreeStore(block.STransactions())
+	if *merkles[len(merkles)-1] != b.header.StakeRoot {
+		return nil, errMerkleRootMismatch
+	}
+	return block, nil
+}
+
+// blockTxnMsg returns the blocktxn message with the transactions of the passed
+// block which were requested by the getblocktxn message.  An error is returned
+// when the requested indexes exceed the transactions of the block.
+func blockTxnMsg(block *hcutil.Block, msg *wire.MsgGetBlockTxn) (*wire.MsgBlockTxn, error) {
+	msgBlock := block.MsgBlock()
+	resp := wire.NewMsgBlockTxn(&msg.BlockHash)
+	for _, index := range msg.Indexes {
+		if int(index) >= len(msgBlock.Transactions) {
+			return nil, fmt.Errorf("transaction index %d exceeds the "+
+				"number of transactions %d", index,
+				len(msgBlock.Transactions))
+		}
+		resp.AddTransaction(msgBlock.Transactions[index])
+	}
+	for _, index := range msg.SIndexes {
+		if int(index) >= len(msgBlock.STransactions) {
+			return nil, fmt.Errorf("stake transaction index %d "+
+				"exceeds the number of stake transactions %d",
+				index, len(msgBlock.STransactions))
+		}
+		resp.AddSTransaction(msgBlock.STransactions[index])
+	}
+	return resp, nil
+}
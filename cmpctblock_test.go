@@ -0,0 +1,190 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/nbit99/hcd/blockchain"
+	"github.com/nbit99/hcd/chaincfg/chainhash"
+	"github.com/nbit99/hcd/hcutil"
+	"github.com/nbit99/hcd/wire"
+)
+
+// cmpctTestTx returns a unique transaction for the passed seed.
+func cmpctTestTx(seed byte) *wire.MsgTx {
+	tx := wire.NewMsgTx()
+	prevHash := chainhash.HashH([]byte{seed})
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&prevHash, 0,
+		wire.TxTreeRegular), nil))
+	tx.AddTxOut(wire.NewTxOut(int64(seed)*1e8, []byte{0x51}))
+	return tx
+}
+
+// cmpctTestBlock returns a block with the passed number of regular and stake
+// transactions and a header which commits to them.
+func cmpctTestBlock(numTxns, numSTxns int) *hcutil.Block {
+	msgBlock := &wire.MsgBlock{}
+	msgBlock.Header.Height = 100
+	for i := 0; i < numTxns; i++ {
+		msgBlock.AddTransaction(cmpctTestTx(byte(i)))
+	}
+	for i := 0; i < numSTxns; i++ {
+		msgBlock.AddSTransaction(cmpctTestTx(byte(100 + i)))
+	}
+
+	block := hcutil.NewBlock(msgBlock)
+	merkles := blockchain.BuildMerkleTreeStore(block.Transactions())
+	msgBlock.Header.MerkleRoot = *merkles[len(merkles)-1]
+	merkles = blockchain.BuildMerkleTreeStore(block.STransactions())
+	msgBlock.Header.StakeRoot = *merkles[len(merkles)-1]
+	return hcutil.NewBlock(msgBlock)
+}
+
+// TestCmpctBlockReconstruction ensures blocks are reconstructed from compact
+// blocks, the transactions of the memory pool and the missing transactions
+// requested from the peer.
+func TestCmpctBlockReconstruction(t *testing.T) {
+	block := cmpctTestBlock(5, 2)
+	msg, err := newCmpctBlock(block)
+	if err != nil {
+		t.Fatalf("newCmpctBlock: unexpected error: %v", err)
+	}
+	if len(msg.PrefilledTxs) != 1 || msg.PrefilledTxs[0].Index != 0 {
+		t.Fatalf("newCmpctBlock: coinbase is not prefilled")
+	}
+	if len(msg.ShortIDs) != 4 || len(msg.SShortIDs) != 2 {
+		t.Fatalf("newCmpctBlock: got %d short ids and %d stake short "+
+			"ids, want 4 and 2", len(msg.ShortIDs), len(msg.SShortIDs))
+	}
+
+	// Send the compact block over the wire.
+	var buf bytes.Buffer
+	if err := msg.BtcEncode(&buf, wire.ProtocolVersion); err != nil {
+		t.Fatalf("BtcEncode: unexpected error: %v", err)
+	}
+	var recvMsg wire.MsgCmpctBlock
+	err = recvMsg.BtcDecode(&buf, wire.ProtocolVersion)
+	if err != nil {
+		t.Fatalf("BtcDecode: unexpected error: %v", err)
+	}
+
+	// The memory pool is missing two regular and one stake transaction and
+	// contains an unrelated one.
+	txns := block.Transactions()
+	stxns := block.STransactions()
+	poolTxns := []*hcutil.Tx{txns[1], txns[3], stxns[0],
+		hcutil.NewTx(cmpctTestTx(200))}
+	pb, err := newPartialBlock(&recvMsg, poolTxns)
+	if err != nil {
+		t.Fatalf("newPartialBlock: unexpected error: %v", err)
+	}
+	if pb.complete() {
+		t.Fatalf("complete: block with missing transactions is complete")
+	}
+
+	getBlockTxn := pb.getBlockTxnMsg()
+	if getBlockTxn.BlockHash != *block.Hash() {
+		t.Fatalf("getBlockTxnMsg: got block hash %v, want %v",
+			getBlockTxn.BlockHash, block.Hash())
+	}
+	if !reflect.DeepEqual(getBlockTxn.Indexes, []uint32{2, 4}) ||
+		!reflect.DeepEqual(getBlockTxn.SIndexes, []uint32{1}) {
+
+		t.Fatalf("getBlockTxnMsg: got indexes %v and stake indexes "+
+			"%v, want [2 4] and [1]", getBlockTxn.Indexes,
+			getBlockTxn.SIndexes)
+	}
+
+	blockTxn, err := blockTxnMsg(block, getBlockTxn)
+	if err != nil {
+		t.Fatalf("blockTxnMsg: unexpected error: %v", err)
+	}
+	if err := pb.fill(blockTxn); err != nil {
+		t.Fatalf("fill: unexpected error: %v", err)
+	}
+	if !pb.complete() {
+		t.Fatalf("complete: filled block is not complete")
+	}
+	reconstructed, err := pb.block()
+	if err != nil {
+		t.Fatalf("block: unexpected error: %v", err)
+	}
+	gotBytes, err := reconstructed.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes: unexpected error: %v", err)
+	}
+	wantBytes, err := block.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes: unexpected error: %v", err)
+	}
+	if !bytes.Equal(gotBytes, wantBytes) {
+		t.Fatalf("block: reconstructed block does not match")
+	}
+
+	// Blocktxn messages with the wrong number of transactions are rejected.
+	pb, err = newPartialBlock(&recvMsg, nil)
+	if err != nil {
+		t.Fatalf("newPartialBlock: unexpected error: %v", err)
+	}
+	if err := pb.fill(blockTxn); err == nil {
+		t.Fatalf("fill: blocktxn with the wrong number of " +
+			"transactions was accepted")
+	}
+}
+
+// TestCmpctBlockErrors ensures compact blocks which can't be reconstructed are
+// detected.
+func TestCmpctBlockErrors(t *testing.T) {
+	block := cmpctTestBlock(3, 1)
+	msg, err := newCmpctBlock(block)
+	if err != nil {
+		t.Fatalf("newCmpctBlock: unexpected error: %v", err)
+	}
+
+	// Duplicate short ids can't be told apart.
+	dupMsg := *msg
+	dupMsg.ShortIDs = []uint64{msg.ShortIDs[0], msg.ShortIDs[0]}
+	_, err = newPartialBlock(&dupMsg, nil)
+	if err != errShortIDCollision {
+		t.Fatalf("newPartialBlock: got error %v, want %v", err,
+			errShortIDCollision)
+	}
+
+	// Prefilled transactions must fit into the transaction tree.
+	badMsg := *msg
+	badMsg.PrefilledTxs = []wire.PrefilledTx{{Index: 3,
+		Tx: block.MsgBlock().Transactions[0]}}
+	if _, err := newPartialBlock(&badMsg, nil); err == nil {
+		t.Fatalf("newPartialBlock: out of range prefilled " +
+			"transaction was accepted")
+	}
+
+	// Transactions which don't match the header are detected.
+	pb, err := newPartialBlock(msg, block.Transactions()[1:])
+	if err != nil {
+		t.Fatalf("newPartialBlock: unexpected error: %v", err)
+	}
+	if len(pb.regular.missing) != 0 || len(pb.stake.missing) != 1 {
+		t.Fatalf("newPartialBlock: got %d missing transactions and "+
+			"%d missing stake transactions, want 0 and 1",
+			len(pb.regular.missing), len(pb.stake.missing))
+	}
+	pb.stake.txns[0] = cmpctTestTx(201)
+	pb.stake.missing = nil
+	if _, err := pb.block(); err != errMerkleRootMismatch {
+		t.Fatalf("block: got error %v, want %v", err,
+			errMerkleRootMismatch)
+	}
+
+	// Requests for transactions which are not in the block are rejected.
+	getBlockTxn := wire.NewMsgGetBlockTxn(block.Hash())
+	getBlockTxn.SIndexes = []uint32{1}
+	if _, err := blockTxnMsg(block, getBlockTxn); err == nil {
+		t.Fatalf("blockTxnMsg: out of range index was accepted")
+	}
+}
@@ -40,6 +40,7 @@ const (
 	defaultLogDirname            = "logs"
 	defaultLogFilename           = "hcd.log"
 	defaultMaxPeers              = 125
+	defaultBlockRelayConns       = 2
 	defaultBanDuration           = time.Hour * 24
 	defaultBanThreshold          = 100
 	defaultMaxRPCClients         = 10
@@ -55,9 +56,14 @@ const (
 	defaultNoMiningStateSync     = false
 	defaultAllowOldVotes         = false
 	defaultMaxOrphanTransactions = 1000
+	defaultMaxOrphanTxsPerPeer   = 100
+	defaultOrphanTxExpiry        = time.Minute * 15
 	defaultMaxOrphanTxSize       = 5000
+	defaultMaxMempool            = 300
+	defaultMempoolExpiry         = time.Hour * 24 * 14
 	defaultSigCacheMaxSize       = 100000
 	defaultTxIndex               = false
+	defaultStratumDifficulty     = 1.0
 	defaultNoExistsAddrIndex     = false
 )
 
@@ -98,10 +104,15 @@ type config struct {
 	DisableListen        bool          `long:"nolisten" description:"Disable listening for incoming connections -- NOTE: Listening is automatically disabled if the --connect or --proxy options are used without also specifying listen interfaces via --listen"`
 	Listeners            []string      `long:"listen" description:"Add an interface/port to listen for connections (default all interfaces port: 9108, testnet: 19108)"`
 	MaxPeers             int           `long:"maxpeers" description:"Max number of inbound and outbound peers"`
+	BlockRelayConns      int           `long:"blockrelayconns" description:"Number of outbound connections which only relay blocks and never transactions or addresses"`
+	ASMap                string        `long:"asmap" description:"Path to an IP to ASN map file which groups peers by their autonomous systems instead of their network prefixes to diversify outbound connections"`
 	DisableBanning       bool          `long:"nobanning" description:"Disable banning of misbehaving peers"`
 	BanDuration          time.Duration `long:"banduration" description:"How long to ban misbehaving peers.  Valid time units are {s, m, h}.  Minimum 1 second"`
 	BanThreshold         uint32        `long:"banthreshold" description:"Maximum allowed ban score before disconnecting and banning misbehaving peers."`
-	Whitelists           []string      `long:"whitelist" description:"Add an IP network or IP that will not be banned. (eg. 192.168.1.0/24 or ::1)"`
+	Whitelists           []string      `long:"whitelist" description:"Add an IP network or IP whose peers are granted permissions in the form of [permissions@]IP or network -- Permissions are a comma separated list of noban, relay, forcerelay, mempool, download, addr or all and default to noban,relay,mempool,download (eg. 192.168.1.0/24, ::1 or relay,mempool@10.0.0.0/8)"`
+	WhiteBinds           []string      `long:"whitebind" description:"Add an interface/port to listen for connections whose peers are granted permissions in the form of [permissions@]interface:port -- See --whitelist for the permissions"`
+	MaxUploadTarget      uint64        `long:"maxuploadtarget" description:"Try to keep the data sent to peers below the given target in MiB per 24h -- Historical blocks are no longer served to peers without the download permission once the target is approached (0 = unlimited)"`
+	MaxPeerSendRate      uint64        `long:"maxpeersendrate" description:"Limit the rate at which data is sent to each peer without the download permission in KiB/s (0 = unlimited)"`
 	RPCUser              string        `short:"u" long:"rpcuser" description:"Username for RPC connections"`
 	RPCPass              string        `short:"P" long:"rpcpass" default-mask:"-" description:"Password for RPC connections"`
 	RPCLimitUser         string        `long:"rpclimituser" description:"Username for limited RPC connections"`
@@ -132,6 +143,7 @@ type config struct {
 	CPUProfile           string        `long:"cpuprofile" description:"Write CPU profile to the specified file"`
 	MemProfile           string        `long:"memprofile" description:"Write mem profile to the specified file"`
 	DumpBlockchain       string        `long:"dumpblockchain" description:"Write blockchain as a flat file of blocks for use with addblock, to the specified filename"`
+	CaptureMessages      bool          `long:"capturemessages" description:"Record every message read from or written to peers to a file per peer in the msgcapture directory of the data directory -- Use hccapture to decode them"`
 	MiningTimeOffset     int           `long:"miningtimeoffset" description:"Offset the mining timestamp of a block by this many seconds (positive values are in the past)"`
 	DebugLevel           string        `short:"d" long:"debuglevel" description:"Logging level for all subsystems {trace, debug, info, warn, error, critical} -- You may also specify <subsystem>=<level>,<subsystem2>=<level>,... to set the log level for individual subsystems -- Use show to list available subsystems"`
 	Upnp                 bool          `long:"upnp" description:"Use UPnP to map our listening port outside of NAT"`
@@ -139,20 +151,30 @@ type config struct {
 	FreeTxRelayLimit     float64       `long:"limitfreerelay" description:"Limit relay of transactions with no transaction fee to the given amount in thousands of bytes per minute"`
 	NoRelayPriority      bool          `long:"norelaypriority" description:"Do not require free or low-fee transactions to have high priority for relaying"`
 	MaxOrphanTxs         int           `long:"maxorphantx" description:"Max number of orphan transactions to keep in memory"`
+	MaxOrphanTxsPerPeer  int           `long:"maxorphantxperpeer" description:"Max number of orphan transactions received from a single peer to keep in memory (0 for no per-peer limit)"`
+	OrphanTxExpiry       time.Duration `long:"orphantxexpiry" description:"How long orphan transactions are kept in memory before they expire.  Valid time units are {s, m, h} (0 to disable)"`
+	MaxMempool           int64         `long:"maxmempool" description:"Max size of the transaction memory pool in megabytes -- The lowest fee rate transactions are evicted once it is exceeded (0 to disable)"`
+	MempoolExpiry        time.Duration `long:"mempoolexpiry" description:"How long regular transactions are kept in the memory pool before they are evicted.  Valid time units are {s, m, h} (0 to disable)"`
 	Generate             bool          `long:"generate" description:"Generate (mine) coins using the CPU"`
 	MiningAddrs          []string      `long:"miningaddr" description:"Add the specified payment address to the list of addresses to use for generated blocks -- At least one address is required if the generate option is set"`
 	BlockMinSize         uint32        `long:"blockminsize" description:"Mininum block size in bytes to be used when creating a block"`
 	BlockMaxSize         uint32        `long:"blockmaxsize" description:"Maximum block size in bytes to be used when creating a block"`
 	BlockPrioritySize    uint32        `long:"blockprioritysize" description:"Size in bytes for high-priority/low-fee transactions when creating a block"`
+	StratumListeners     []string      `long:"stratumlisten" description:"Add an interface/port to listen for Stratum mining connections -- Enables the built-in Stratum server (default port: 14011, testnet: 12011, simnet: 13011)"`
+	StratumDifficulty    float64       `long:"stratumdifficulty" description:"The initial share difficulty of Stratum workers, where 1 is the proof of work limit of the network -- It is adjusted to the hash rate of each worker"`
 	GetWorkKeys          []string      `long:"getworkkey" description:"DEPRECATED -- Use the --miningaddr option instead"`
 	NoPeerBloomFilters   bool          `long:"nopeerbloomfilters" description:"Disable bloom filtering support"`
+	NoEncryption         bool          `long:"noencryption" description:"Disable the encrypted transport for connections with peers which support it"`
 	SigCacheMaxSize      uint          `long:"sigcachemaxsize" description:"The maximum number of entries in the signature verification cache"`
 	NonAggressive        bool          `long:"nonaggressive" description:"Disable mining off of the parent block of the blockchain if there aren't enough voters"`
 	NoMiningStateSync    bool          `long:"nominingstatesync" description:"Disable synchronizing the mining state with other nodes"`
 	AllowOldVotes        bool          `long:"allowoldvotes" description:"Enable the addition of very old votes to the mempool"`
 	BlocksOnly           bool          `long:"blocksonly" description:"Do not accept transactions from remote peers."`
+	Dandelion            bool          `long:"dandelion" description:"Relay transactions of peers and those submitted with sendrawtransaction along a Dandelion++ stem before diffusing them to the network"`
 	RelayNonStd          bool          `long:"relaynonstd" description:"Relay non-standard transactions regardless of the default settings for the active network."`
 	RejectNonStd         bool          `long:"rejectnonstd" description:"Reject non-standard transactions regardless of the default settings for the active network."`
+	RejectReplacement    bool          `long:"rejectreplacement" description:"Reject transactions that attempt to replace existing transactions within the mempool through the Replace-By-Fee (RBF) signaling policy."`
+	NoPersistMempool     bool          `long:"nopersistmempool" description:"Do not save the transaction memory pool on shutdown and load it again on start up"`
 	TxIndex              bool          `long:"txindex" description:"Maintain a full hash-based transaction index which makes all transactions available via the getrawtransaction RPC"`
 	DropTxIndex          bool          `long:"droptxindex" description:"Deletes the hash-based transaction index from the database on start up and then exits."`
 	AddrIndex            bool          `long:"addrindex" description:"Maintain a full address-based transaction index which makes the searchrawtransactions RPC available"`
@@ -168,7 +190,8 @@ type config struct {
 	dial                 func(string, string) (net.Conn, error)
 	miningAddrs          []hcutil.Address
 	minRelayTxFee        hcutil.Amount
-	whitelists           []*net.IPNet
+	whitelists           []whitelist
+	whitebinds           []whitebind
 }
 
 // serviceOptions defines the configuration options for the daemon as a service on
@@ -424,6 +447,7 @@ func loadConfig() (*config, []string, error) {
 		ConfigFile:           defaultConfigFile,
 		DebugLevel:           defaultLogLevel,
 		MaxPeers:             defaultMaxPeers,
+		BlockRelayConns:      defaultBlockRelayConns,
 		BanDuration:          defaultBanDuration,
 		BanThreshold:         defaultBanThreshold,
 		RPCMaxClients:        defaultMaxRPCClients,
@@ -440,8 +464,13 @@ func loadConfig() (*config, []string, error) {
 		BlockMaxSize:         defaultBlockMaxSize,
 		BlockPrioritySize:    mempool.DefaultBlockPrioritySize,
 		MaxOrphanTxs:         defaultMaxOrphanTransactions,
+		MaxOrphanTxsPerPeer:  defaultMaxOrphanTxsPerPeer,
+		OrphanTxExpiry:       defaultOrphanTxExpiry,
+		MaxMempool:           defaultMaxMempool,
+		MempoolExpiry:        defaultMempoolExpiry,
 		SigCacheMaxSize:      defaultSigCacheMaxSize,
 		Generate:             defaultGenerate,
+		StratumDifficulty:    defaultStratumDifficulty,
 		NoMiningStateSync:    defaultNoMiningStateSync,
 		TxIndex:              defaultTxIndex,
 		AddrIndex:            defaultAddrIndex,
@@ -647,6 +676,10 @@ func loadConfig() (*config, []string, error) {
 	cfg.LogDir = cleanAndExpandPath(cfg.LogDir)
 	cfg.LogDir = filepath.Join(cfg.LogDir, netName(activeNetParams))
 
+	if cfg.ASMap != "" {
+		cfg.ASMap = cleanAndExpandPath(cfg.ASMap)
+	}
+
 	// Special show command to list supported subsystems and exit.
 	if cfg.DebugLevel == "show" {
 		fmt.Println("Supported subsystems", supportedSubsystems())
@@ -711,12 +744,31 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
+	// Don't allow a negative number of block-relay-only connections.
+	if cfg.BlockRelayConns < 0 {
+		str := "%s: the blockrelayconns option may not be less than 0 " +
+			"-- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.BlockRelayConns)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
 	// Validate any given whitelisted IP addresses and networks.
 	if len(cfg.Whitelists) > 0 {
 		var ip net.IP
-		cfg.whitelists = make([]*net.IPNet, 0, len(cfg.Whitelists))
+		cfg.whitelists = make([]whitelist, 0, len(cfg.Whitelists))
 
-		for _, addr := range cfg.Whitelists {
+		for _, entry := range cfg.Whitelists {
+			perms, addr, err := parsePermissions(entry)
+			if err != nil {
+				str := "%s: the whitelist value of '%s' is " +
+					"invalid: %v"
+				err := fmt.Errorf(str, funcName, entry, err)
+				fmt.Fprintln(os.Stderr, err)
+				fmt.Fprintln(os.Stderr, usageMessage)
+				return nil, nil, err
+			}
 			_, ipnet, err := net.ParseCIDR(addr)
 			if err != nil {
 				ip = net.ParseIP(addr)
@@ -739,10 +791,27 @@ func loadConfig() (*config, []string, error) {
 					Mask: net.CIDRMask(bits, bits),
 				}
 			}
-			cfg.whitelists = append(cfg.whitelists, ipnet)
+			cfg.whitelists = append(cfg.whitelists,
+				whitelist{ipnet: ipnet, perms: perms})
 		}
 	}
 
+	// Validate any given whitebind listeners.
+	for _, entry := range cfg.WhiteBinds {
+		perms, addr, err := parsePermissions(entry)
+		if err != nil {
+			str := "%s: the whitebind value of '%s' is invalid: %v"
+			err := fmt.Errorf(str, funcName, entry, err)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+		cfg.whitebinds = append(cfg.whitebinds, whitebind{
+			addr:  normalizeAddress(addr, activeNetParams.DefaultPort),
+			perms: perms,
+		})
+	}
+
 	// --addPeer and --connect do not mix.
 	if len(cfg.AddPeers) > 0 && len(cfg.ConnectPeers) > 0 {
 		str := "%s: the --addpeer and --connect options can not be " +
@@ -753,9 +822,10 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
-	// --proxy or --connect without --listen disables listening.
+	// --proxy or --connect without --listen or --whitebind disables
+	// listening.
 	if (cfg.Proxy != "" || len(cfg.ConnectPeers) > 0) &&
-		len(cfg.Listeners) == 0 {
+		len(cfg.Listeners) == 0 && len(cfg.WhiteBinds) == 0 {
 		cfg.DisableListen = true
 	}
 
@@ -766,8 +836,9 @@ func loadConfig() (*config, []string, error) {
 
 	// Add the default listener if none were specified. The default
 	// listener is all addresses on the listen port for the network
-	// we are to connect to.
-	if len(cfg.Listeners) == 0 {
+	// we are to connect to.  Specifying whitebind listeners also
+	// disables the default listener.
+	if len(cfg.Listeners) == 0 && len(cfg.WhiteBinds) == 0 {
 		cfg.Listeners = []string{
 			net.JoinHostPort("", activeNetParams.DefaultPort),
 		}
@@ -855,6 +926,50 @@ func loadConfig() (*config, []string, error) {
 		fmt.Fprintln(os.Stderr, usageMessage)
 		return nil, nil, err
 	}
+	if cfg.MaxOrphanTxsPerPeer < 0 {
+		str := "%s: the maxorphantxperpeer option may not be less " +
+			"than 0 -- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.MaxOrphanTxsPerPeer)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+	if cfg.OrphanTxExpiry < 0 {
+		str := "%s: the orphantxexpiry option may not be negative " +
+			"-- parsed [%v]"
+		err := fmt.Errorf(str, funcName, cfg.OrphanTxExpiry)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// Limit the max mempool size to a sane value.
+	if cfg.MaxMempool < 0 {
+		str := "%s: the maxmempool option may not be less than 0 " +
+			"-- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.MaxMempool)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+	if cfg.MempoolExpiry < 0 {
+		str := "%s: the mempoolexpiry option may not be negative " +
+			"-- parsed [%v]"
+		err := fmt.Errorf(str, funcName, cfg.MempoolExpiry)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// --dandelion and --blocksonly do not mix.
+	if cfg.Dandelion && cfg.BlocksOnly {
+		err := fmt.Errorf("%s: the --dandelion and --blocksonly "+
+			"options may not be activated at the same time",
+			funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
 
 	// Limit the block priority and minimum block sizes to max block size.
 	cfg.BlockPrioritySize = minUint32(cfg.BlockPrioritySize, cfg.BlockMaxSize)
@@ -954,6 +1069,26 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
+	// Ensure there is at least one mining address when the Stratum server
+	// is enabled and the initial share difficulty is sane.
+	if len(cfg.StratumListeners) > 0 && len(cfg.miningAddrs) == 0 {
+		str := "%s: the stratumlisten option is set, but there are no " +
+			"mining addresses specified"
+		err := fmt.Errorf(str, funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+	if cfg.StratumDifficulty < stratumMinDifficulty {
+		str := "%s: the stratumdifficulty option may not be less than " +
+			"%v -- parsed [%v]"
+		err := fmt.Errorf(str, funcName, stratumMinDifficulty,
+			cfg.StratumDifficulty)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
 	// Add default port to all listener addresses if needed and remove
 	// duplicate addresses.
 	cfg.Listeners = normalizeAddresses(cfg.Listeners,
@@ -964,6 +1099,11 @@ func loadConfig() (*config, []string, error) {
 	cfg.RPCListeners = normalizeAddresses(cfg.RPCListeners,
 		activeNetParams.rpcPort)
 
+	// Add default port to all Stratum listener addresses if needed and
+	// remove duplicate addresses.
+	cfg.StratumListeners = normalizeAddresses(cfg.StratumListeners,
+		activeNetParams.stratumPort)
+
 	// Only allow TLS to be disabled if the RPC is bound to localhost
 	// addresses.
 	if !cfg.DisableRPC && cfg.DisableTLS {
@@ -62,6 +62,18 @@ type ConnReq struct {
 	Addr      net.Addr
 	Permanent bool
 
+	// BlockRelayOnly indicates the connection only relays blocks, so
+	// transactions and addresses are neither requested from nor announced
+	// to the remote peer.  This makes it harder for observers to infer
+	// the network topology from the relay of transactions and addresses.
+	BlockRelayOnly bool
+
+	// Feeler indicates the connection is a short-lived feeler connection
+	// which only tests whether the address is reachable.  Feeler
+	// connections are never retried and do not count towards the target
+	// number of outbound connections.
+	Feeler bool
+
 	conn       net.Conn
 	state      ConnState
 	stateMtx   sync.RWMutex
@@ -125,6 +137,17 @@ type Config struct {
 	// maintain. Defaults to 8.
 	TargetOutbound uint32
 
+	// TargetBlockRelayOnly is the number of block-relay-only outbound
+	// network connections to maintain in addition to TargetOutbound.
+	TargetBlockRelayOnly uint32
+
+	// Anchors are the addresses of the block-relay-only connections of
+	// the previous run.  They are connected to before any addresses
+	// returned by GetNewAddress in order to make it harder to eclipse the
+	// node after a restart.  Only up to TargetBlockRelayOnly anchors are
+	// used.
+	Anchors []net.Addr
+
 	// RetryDuration is the duration to wait before retrying connection
 	// requests. Defaults to 5s.
 	RetryDuration time.Duration
@@ -141,6 +164,15 @@ type Config struct {
 	// to.  If nil, no new connections will be made automatically.
 	GetNewAddress func() (net.Addr, error)
 
+	// FeelerInterval is the interval at which feeler connections are made
+	// while the target number of outbound connections is maintained.  No
+	// feeler connections are made when it is zero.
+	FeelerInterval time.Duration
+
+	// GetFeelerAddress is a way to get an address to make a feeler
+	// connection to.  If nil, no feeler connections will be made.
+	GetFeelerAddress func() (net.Addr, error)
+
 	// Dial connects to the address on the named network. It cannot be nil.
 	Dial func(net.Addr) (net.Conn, error)
 }
@@ -187,6 +219,9 @@ func (cm *ConnManager) handleFailedConn(c *ConnReq) {
 		return
 	}
 	// TODO Check for canceled connection before connect
+	if c.Feeler {
+		return
+	}
 	if c.Permanent {
 		c.retryCount++
 		d := time.Duration(c.retryCount) * cm.cfg.RetryDuration
@@ -204,10 +239,10 @@ func (cm *ConnManager) handleFailedConn(c *ConnReq) {
 				"-- retrying connection in: %v", maxFailedAttempts,
 				cm.cfg.RetryDuration)
 			time.AfterFunc(cm.cfg.RetryDuration, func() {
-				cm.NewConnReq()
+				cm.newConnReq(c.BlockRelayOnly)
 			})
 		} else {
-			go cm.NewConnReq()
+			go cm.newConnReq(c.BlockRelayOnly)
 		}
 	}
 }
@@ -220,9 +255,29 @@ func (cm *ConnManager) handleFailedConn(c *ConnReq) {
 // are processed and mapped by their assigned ids.
 func (cm *ConnManager) connHandler() {
 	conns := make(map[uint64]*ConnReq, cm.cfg.TargetOutbound)
+	target := cm.cfg.TargetOutbound + cm.cfg.TargetBlockRelayOnly
+
+	// Feeler connections are made one at a time while the target number
+	// of outbound connections is maintained.
+	var feelerTicker <-chan time.Time
+	if cm.cfg.FeelerInterval > 0 && cm.cfg.GetFeelerAddress != nil {
+		ticker := time.NewTicker(cm.cfg.FeelerInterval)
+		defer ticker.Stop()
+		feelerTicker = ticker.C
+	}
+	var numFeelers uint32
+	feelerPending := false
+
 out:
 	for {
 		select {
+		case <-feelerTicker:
+			if feelerPending || uint32(len(conns))-numFeelers < target {
+				continue
+			}
+			feelerPending = true
+			go cm.newFeelerConnReq()
+
 		case req := <-cm.requests:
 			switch msg := req.(type) {
 
@@ -231,6 +286,9 @@ out:
 				connReq.updateState(ConnEstablished)
 				connReq.conn = msg.conn
 				conns[connReq.id] = connReq
+				if connReq.Feeler {
+					numFeelers++
+				}
 				log.Debugf("Connected to %v", connReq)
 				connReq.retryCount = 0
 				cm.failedAttempts = 0
@@ -247,12 +305,16 @@ out:
 					}
 					log.Debugf("Disconnected from %v", connReq)
 					delete(conns, msg.id)
+					if connReq.Feeler {
+						numFeelers--
+						feelerPending = false
+					}
 
 					if cm.cfg.OnDisconnection != nil {
 						go cm.cfg.OnDisconnection(connReq)
 					}
 
-					if uint32(len(conns)) < cm.cfg.TargetOutbound && msg.retry {
+					if uint32(len(conns))-numFeelers < target && msg.retry {
 						cm.handleFailedConn(connReq)
 					}
 				} else {
@@ -263,6 +325,9 @@ out:
 				connReq := msg.c
 				connReq.updateState(ConnFailed)
 				log.Debugf("Failed to connect to %v: %v", connReq, msg.err)
+				if connReq.Feeler {
+					feelerPending = false
+				}
 				cm.handleFailedConn(connReq)
 			}
 
@@ -278,6 +343,12 @@ out:
 // NewConnReq creates a new connection request and connects to the
 // corresponding address.
 func (cm *ConnManager) NewConnReq() {
+	cm.newConnReq(false)
+}
+
+// newConnReq creates a new connection request of the passed class and connects
+// to the corresponding address.
+func (cm *ConnManager) newConnReq(blockRelayOnly bool) {
 	if atomic.LoadInt32(&cm.stop) != 0 {
 		return
 	}
@@ -285,7 +356,7 @@ func (cm *ConnManager) NewConnReq() {
 		return
 	}
 
-	c := &ConnReq{}
+	c := &ConnReq{BlockRelayOnly: blockRelayOnly}
 	atomic.StoreUint64(&c.id, atomic.AddUint64(&cm.connReqCount, 1))
 
 	addr, err := cm.cfg.GetNewAddress()
@@ -299,6 +370,27 @@ func (cm *ConnManager) NewConnReq() {
 	cm.Connect(c)
 }
 
+// newFeelerConnReq creates a new feeler connection request and connects to the
+// corresponding address.
+func (cm *ConnManager) newFeelerConnReq() {
+	if atomic.LoadInt32(&cm.stop) != 0 {
+		return
+	}
+
+	c := &ConnReq{Feeler: true}
+	atomic.StoreUint64(&c.id, atomic.AddUint64(&cm.connReqCount, 1))
+
+	addr, err := cm.cfg.GetFeelerAddress()
+	if err != nil {
+		cm.requests <- handleFailed{c, err}
+		return
+	}
+
+	c.Addr = addr
+
+	cm.Connect(c)
+}
+
 // Connect assigns an id and dials a connection to the address of the
 // connection request.
 func (cm *ConnManager) Connect(c *ConnReq) {
@@ -379,6 +471,22 @@ func (cm *ConnManager) Start() {
 	for i := atomic.LoadUint64(&cm.connReqCount); i < uint64(cm.cfg.TargetOutbound); i++ {
 		go cm.NewConnReq()
 	}
+
+	// Connect to the anchors first when making block-relay-only
+	// connections.
+	if cm.cfg.GetNewAddress == nil {
+		return
+	}
+	for i := uint32(0); i < cm.cfg.TargetBlockRelayOnly; i++ {
+		if int(i) < len(cm.cfg.Anchors) {
+			go cm.Connect(&ConnReq{
+				Addr:           cm.cfg.Anchors[i],
+				BlockRelayOnly: true,
+			})
+			continue
+		}
+		go cm.newConnReq(true)
+	}
 }
 
 // Wait blocks until the connection manager halts gracefully.
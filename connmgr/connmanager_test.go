@@ -218,6 +218,140 @@ func TestTargetOutbound(t *testing.T) {
 	cmgr.Stop()
 }
 
+// TestTargetBlockRelayOnly tests the target number of block-relay-only
+// connections, that anchors are connected to first and that disconnected
+// block-relay-only connections are replaced by block-relay-only connections.
+func TestTargetBlockRelayOnly(t *testing.T) {
+	anchor := &net.TCPAddr{
+		IP:   net.ParseIP("127.0.0.2"),
+		Port: 18555,
+	}
+	connected := make(chan *ConnReq)
+	cmgr, err := New(&Config{
+		TargetOutbound:       2,
+		TargetBlockRelayOnly: 2,
+		Anchors:              []net.Addr{anchor},
+		Dial:                 mockDialer,
+		GetNewAddress: func() (net.Addr, error) {
+			return &net.TCPAddr{
+				IP:   net.ParseIP("127.0.0.1"),
+				Port: 18555,
+			}, nil
+		},
+		OnConnection: func(c *ConnReq, conn net.Conn) {
+			connected <- c
+		},
+	})
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	cmgr.Start()
+	var blockRelayOnly []*ConnReq
+	var anchorConnected bool
+	for i := 0; i < 4; i++ {
+		c := <-connected
+		if !c.BlockRelayOnly {
+			continue
+		}
+		blockRelayOnly = append(blockRelayOnly, c)
+		if c.Addr.String() == anchor.String() {
+			anchorConnected = true
+		}
+	}
+	if len(blockRelayOnly) != 2 {
+		t.Fatalf("target block relay only: got %d block-relay-only "+
+			"connections, want 2", len(blockRelayOnly))
+	}
+	if !anchorConnected {
+		t.Fatalf("target block relay only: anchor was not connected")
+	}
+
+	select {
+	case c := <-connected:
+		t.Fatalf("target block relay only: got unexpected connection - %v",
+			c.Addr)
+	case <-time.After(time.Millisecond):
+		break
+	}
+
+	// Disconnect the anchor and ensure it is replaced by a new
+	// block-relay-only connection.
+	for _, c := range blockRelayOnly {
+		if c.Addr.String() == anchor.String() {
+			cmgr.Disconnect(c.ID())
+		}
+	}
+	c := <-connected
+	if !c.BlockRelayOnly || c.Addr.String() == anchor.String() {
+		t.Fatalf("target block relay only: got replacement connection "+
+			"%v (block relay only %v)", c.Addr, c.BlockRelayOnly)
+	}
+	cmgr.Stop()
+}
+
+// TestFeelerConnections tests that feeler connections are made one at a time
+// once the target number of outbound connections is maintained, and that they
+// are neither retried nor replaced by regular connections.
+func TestFeelerConnections(t *testing.T) {
+	feelerAddr := &net.TCPAddr{
+		IP:   net.ParseIP("127.0.0.2"),
+		Port: 18555,
+	}
+	connected := make(chan *ConnReq)
+	cmgr, err := New(&Config{
+		TargetOutbound: 2,
+		FeelerInterval: time.Millisecond,
+		Dial:           mockDialer,
+		GetNewAddress: func() (net.Addr, error) {
+			return &net.TCPAddr{
+				IP:   net.ParseIP("127.0.0.1"),
+				Port: 18555,
+			}, nil
+		},
+		GetFeelerAddress: func() (net.Addr, error) {
+			return feelerAddr, nil
+		},
+		OnConnection: func(c *ConnReq, conn net.Conn) {
+			connected <- c
+		},
+	})
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	cmgr.Start()
+	for i := 0; i < 2; i++ {
+		c := <-connected
+		if c.Feeler {
+			t.Fatalf("feeler connections: feeler connection made " +
+				"before the target was reached")
+		}
+	}
+
+	// Only a single feeler connection is made at a time.
+	feeler := <-connected
+	if !feeler.Feeler || feeler.Addr.String() != feelerAddr.String() {
+		t.Fatalf("feeler connections: got connection %v (feeler %v), "+
+			"want feeler connection", feeler.Addr, feeler.Feeler)
+	}
+	select {
+	case c := <-connected:
+		t.Fatalf("feeler connections: got unexpected connection - %v",
+			c.Addr)
+	case <-time.After(10 * time.Millisecond):
+		break
+	}
+
+	// Disconnecting the feeler connection makes room for the next one
+	// without retrying it.
+	cmgr.Disconnect(feeler.ID())
+	c := <-connected
+	if !c.Feeler || c.ID() == feeler.ID() {
+		t.Fatalf("feeler connections: got connection %v (feeler %v), "+
+			"want new feeler connection", c.Addr, c.Feeler)
+	}
+	cmgr.Stop()
+}
+
 // TestRetryPermanent tests that permanent connection requests are retried.
 //
 // We make a permanent connection request using Connect, disconnect it using
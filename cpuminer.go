@@ -7,6 +7,7 @@
 package main
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"math/rand"
@@ -40,6 +41,16 @@ const (
 	// keep track of the hashes per second.
 	hashUpdateSecs = 15
 
+	// headerMidstateLen is the length of the prefix of a serialized block
+	// header which the CPU miner hashes into a midstate once per block
+	// template variation.  Only the rest of the header, which contains
+	// the nonce, is hashed for every nonce.
+	headerMidstateLen = 2 * chainhash.HashBlockSize
+
+	// headerNonceOffset is the offset of the nonce in a serialized block
+	// header.
+	headerNonceOffset = 140
+
 	// maxSimnetToMine is the maximum number of blocks to mine on HEAD~1
 	// for simnet so that you don't run out of memory if tickets for
 	// some reason run out during simulations.
@@ -206,6 +217,23 @@ func (m *CPUMiner) solveBlock(msgBlock *wire.MsgBlock, ticker *time.Ticker,
 	header := &msgBlock.Header
 	targetDifficulty := blockchain.CompactToBig(header.Bits)
 
+	// The header is hashed from its serialization in which only the nonce
+	// changes for every hash.  The midstate of the prefix that does not
+	// contain the nonce is calculated whenever the header is modified
+	// otherwise.
+	var midstate *chainhash.Midstate
+	var headerTail []byte
+	nonceOffset := headerNonceOffset - headerMidstateLen
+	prepareHeader := func() error {
+		headerBytes, err := header.Bytes()
+		if err != nil {
+			return err
+		}
+		midstate = chainhash.NewMidstate(headerBytes[:headerMidstateLen])
+		headerTail = headerBytes[headerMidstateLen:]
+		return nil
+	}
+
 	// Initial state.
 	lastGenerated := time.Now()
 	lastTxUpdate := m.txSource.LastUpdated()
@@ -228,6 +256,11 @@ func (m *CPUMiner) solveBlock(msgBlock *wire.MsgBlock, ticker *time.Ticker,
 				err)
 			break
 		}
+		if err := prepareHeader(); err != nil {
+			minrLog.Warnf("Unable to serialize CPU miner block header: "+
+				"%v", err)
+			break
+		}
 
 		// Search through the entire nonce range for a solution while
 		// periodically checking for early quit and stale block
@@ -258,19 +291,25 @@ func (m *CPUMiner) solveBlock(msgBlock *wire.MsgBlock, ticker *time.Ticker,
 						"time: %v", err)
 					return false
 				}
+				if err := prepareHeader(); err != nil {
+					minrLog.Warnf("Unable to serialize CPU miner "+
+						"block header: %v", err)
+					return false
+				}
 
 			default:
 				// Non-blocking select to fall through
 			}
 
 			// Update the nonce and hash the block header.
-			header.Nonce = i
-			hash := header.BlockHash()
+			binary.LittleEndian.PutUint32(headerTail[nonceOffset:], i)
+			hash := midstate.HashH(headerTail)
 			hashesCompleted++
 
 			// The block is solved when the new block hash is less
 			// than the target difficulty.  Yay!
 			if blockchain.HashToBig(&hash).Cmp(targetDifficulty) <= 0 {
+				header.Nonce = i
 				m.updateHashes <- hashesCompleted
 				return true
 			}
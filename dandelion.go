@@ -0,0 +1,367 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/nbit99/hcd/blockchain/stake"
+	"github.com/nbit99/hcd/chaincfg/chainhash"
+	"github.com/nbit99/hcd/hcutil"
+	"github.com/nbit99/hcd/mempool"
+	"github.com/nbit99/hcd/wire"
+)
+
+const (
+	// dandelionEpoch is the duration of the epochs after which the stem
+	// relays and whether or not the node diffuses the stem transactions of
+	// its peers are chosen anew.
+	dandelionEpoch = 10 * time.Minute
+
+	// dandelionFluffProbability is the probability of the node diffusing
+	// the stem transactions of its peers during an epoch instead of
+	// relaying them further along the stem.
+	dandelionFluffProbability = 0.1
+
+	// dandelionNumRelays is the number of outbound peers which are chosen
+	// as stem relays each epoch.
+	dandelionNumRelays = 2
+
+	// dandelionEmbargoMin and dandelionEmbargoAvgAdd are the minimum and
+	// the average exponentially distributed addition of the time a stem
+	// transaction is waited for to be diffused by another node before the
+	// node diffuses it itself.  This ensures transactions are diffused
+	// even when a node along the stem drops them.
+	dandelionEmbargoMin    = 10 * time.Second
+	dandelionEmbargoAvgAdd = 20 * time.Second
+
+	// dandelionCheckInterval is the interval the embargoes of the stem
+	// transactions are checked at.
+	dandelionCheckInterval = time.Second
+)
+
+// stemRelay is a peer stem transactions can be relayed to.
+type stemRelay interface {
+	Connected() bool
+	QueueMessage(msg wire.Message, doneChan chan<- struct{})
+}
+
+// stemTxPool describes the pool the transactions in the stem phase are kept in.
+type stemTxPool interface {
+	ProcessTransaction(tx *hcutil.Tx, allowOrphans, rateLimit, allowHighFees bool, tag mempool.Tag) ([]*hcutil.Tx, error)
+	FetchTransaction(txHash *chainhash.Hash, includeRecentBlock bool) (*hcutil.Tx, error)
+	RemoveTransaction(tx *hcutil.Tx, removeRedeemers bool)
+}
+
+// dandelionConfig describes the dependencies of the Dandelion++ stem relay.
+type dandelionConfig struct {
+	// StemPool is the pool the transactions in the stem phase are kept
+	// in.
+	StemPool stemTxPool
+
+	// HaveTransaction and IsRecentlyRejected query the memory pool for
+	// whether or not a transaction was diffused already or rejected.
+	HaveTransaction    func(txHash *chainhash.Hash) bool
+	IsRecentlyRejected func(txHash *chainhash.Hash) bool
+
+	// AcceptToMemPool diffuses the passed transaction by processing it
+	// through the memory pool and announcing the accepted transactions to
+	// the network.
+	AcceptToMemPool func(tx *hcutil.Tx, rateLimit, allowHighFees bool, tag mempool.Tag) error
+
+	// RelayCandidates returns the peers stem transactions may be relayed
+	// to.
+	RelayCandidates func() []stemRelay
+
+	// Rebroadcast keeps the passed transaction, which was submitted
+	// locally and diffused, announced until it is mined.
+	Rebroadcast func(tx *hcutil.Tx)
+}
+
+// dandelion relays transactions in the stem phase of Dandelion++.  Each epoch,
+// up to dandelionNumRelays outbound peers which support it are chosen as stem
+// relays, and the stem transactions of each peer as well as the ones submitted
+// locally are forwarded to one of them.  With dandelionFluffProbability, the
+// node instead diffuses the stem transactions of its peers to the network for
+// the whole epoch.
+//
+// Stem transactions are validated against the main chain and the memory pool
+// and kept in a separate stem pool.  They are only added to the memory pool
+// once they are diffused, so they are neither announced nor served to other
+// peers in response to mempool or getdata messages before.  Likewise, locally
+// submitted transactions are only added to the rebroadcast inventory, which is
+// announced to all peers, once they are diffused.
+type dandelion struct {
+	cfg dandelionConfig
+
+	mtx       sync.Mutex
+	epochEnd  time.Time
+	diffuser  bool
+	relays    []stemRelay
+	routes    map[stemRelay]stemRelay
+	embargoes map[chainhash.Hash]time.Time
+	local     map[chainhash.Hash]struct{}
+}
+
+// newDandelion returns a new instance with the passed dependencies.
+func newDandelion(cfg *dandelionConfig) *dandelion {
+	return &dandelion{
+		cfg:       *cfg,
+		routes:    make(map[stemRelay]stemRelay),
+		embargoes: make(map[chainhash.Hash]time.Time),
+		local:     make(map[chainhash.Hash]struct{}),
+	}
+}
+
+// embargoDelay returns a random duration to wait for a stem transaction to be
+// diffused by another node.
+func embargoDelay() time.Duration {
+	add := -math.Log1p(-rand.Float64()) * float64(dandelionEmbargoAvgAdd)
+	return dandelionEmbargoMin + time.Duration(add)
+}
+
+// isStemRelayCandidate returns whether or not stem transactions may be relayed
+// to the passed peer.
+func isStemRelayCandidate(sp *serverPeer) bool {
+	return !sp.Inbound() && !sp.blockRelayOnly && !sp.feeler &&
+		sp.Connected() && sp.VersionKnown() &&
+		sp.Services()&wire.SFNodeDandelion != 0 &&
+		sp.ProtocolVersion() >= wire.DandelionVersion &&
+		!sp.relayTxDisabled()
+}
+
+// stemRelayCandidates returns the connected peers of the passed server stem
+// transactions may be relayed to.
+func stemRelayCandidates(s *server) []stemRelay {
+	var candidates []stemRelay
+	for _, sp := range s.Peers() {
+		if isStemRelayCandidate(sp) {
+			candidates = append(candidates, sp)
+		}
+	}
+	return candidates
+}
+
+// selectRelays chooses the stem relays among the relay candidates.
+//
+// This function MUST be called with the lock held.
+func (d *dandelion) selectRelays() {
+	candidates := d.cfg.RelayCandidates()
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	if len(candidates) > dandelionNumRelays {
+		candidates = candidates[:dandelionNumRelays]
+	}
+	d.relays = candidates
+	d.routes = make(map[stemRelay]stemRelay)
+}
+
+// relayFor returns the stem relay of the transactions received from the passed
+// peer, or from the node itself when it is nil.  Transactions are never relayed
+// back to the peer they were received from.  It returns nil when there is no
+// stem relay.  A new epoch is started when the current one ended.
+//
+// This function MUST be called with the lock held.
+func (d *dandelion) relayFor(from stemRelay, now time.Time) stemRelay {
+	if !now.Before(d.epochEnd) {
+		d.epochEnd = now.Add(dandelionEpoch)
+		d.diffuser = rand.Float64() < dandelionFluffProbability
+		d.selectRelays()
+		srvrLog.Debugf("Started Dandelion epoch with %d stem relays "+
+			"(diffuser: %v)", len(d.relays), d.diffuser)
+	}
+
+	if relay, ok := d.routes[from]; ok && relay.Connected() {
+		return relay
+	}
+
+	// Choose new relays when one of them disconnected or there were no
+	// candidates before.
+	reselect := len(d.relays) == 0
+	for _, relay := range d.relays {
+		if !relay.Connected() {
+			reselect = true
+		}
+	}
+	if reselect {
+		d.selectRelays()
+	}
+
+	var choices []stemRelay
+	for _, relay := range d.relays {
+		if relay != from {
+			choices = append(choices, relay)
+		}
+	}
+	if len(choices) == 0 {
+		return nil
+	}
+
+	relay := choices[rand.Intn(len(choices))]
+	d.routes[from] = relay
+	return relay
+}
+
+// ProcessStemTx validates the passed transaction received from the passed peer,
+// or submitted locally when it is nil, and either relays it to a stem relay or
+// diffuses it.  Stake transactions are always diffused right away since they
+// need to propagate quickly.  The stem transactions of peers are also diffused
+// when the node is a diffuser during the current epoch, and all transactions
+// are diffused when there is no stem relay.
+//
+// This function is safe for concurrent access.
+func (d *dandelion) ProcessStemTx(tx *hcutil.Tx, from *serverPeer, allowHighFees bool) error {
+	// Avoid converting a nil peer to a non-nil relay.
+	if from == nil {
+		return d.processStemTx(tx, nil, 0, allowHighFees)
+	}
+	return d.processStemTx(tx, from, mempool.Tag(from.ID()), allowHighFees)
+}
+
+// processStemTx implements ProcessStemTx for the transactions received from the
+// passed relay, which are tagged with the passed tag.
+func (d *dandelion) processStemTx(tx *hcutil.Tx, from stemRelay, tag mempool.Tag, allowHighFees bool) error {
+	rateLimit := from != nil
+	txHash := tx.Hash()
+	if stake.DetermineTxType(tx.MsgTx()) != stake.TxTypeRegular {
+		err := d.cfg.AcceptToMemPool(tx, rateLimit, allowHighFees, tag)
+		if err == nil && from == nil {
+			d.cfg.Rebroadcast(tx)
+		}
+		return err
+	}
+
+	// Transactions which were diffused already need not be relayed along
+	// the stem.
+	if d.cfg.HaveTransaction(txHash) {
+		if from == nil {
+			d.cfg.Rebroadcast(tx)
+		}
+		return nil
+	}
+	if d.cfg.IsRecentlyRejected(txHash) {
+		return nil
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	_, err := d.cfg.StemPool.ProcessTransaction(tx, false, rateLimit,
+		allowHighFees, tag)
+	if err != nil {
+		return err
+	}
+	if from == nil {
+		d.local[*txHash] = struct{}{}
+	}
+
+	now := time.Now()
+	relay := d.relayFor(from, now)
+	if relay == nil || (from != nil && d.diffuser) {
+		d.fluff(tx)
+		return nil
+	}
+
+	srvrLog.Debugf("Relaying stem transaction %v to %s", txHash, relay)
+	d.embargoes[*txHash] = now.Add(embargoDelay())
+	relay.QueueMessage(wire.NewMsgDandelionTx(tx.MsgTx()), nil)
+	return nil
+}
+
+// diffused records that the passed stem transaction was diffused and adds it
+// to the rebroadcast inventory when it was submitted locally.
+//
+// This function MUST be called with the lock held.
+func (d *dandelion) diffused(tx *hcutil.Tx) {
+	txHash := tx.Hash()
+	delete(d.embargoes, *txHash)
+	if _, ok := d.local[*txHash]; ok {
+		delete(d.local, *txHash)
+		d.cfg.Rebroadcast(tx)
+	}
+}
+
+// fluff removes the passed transaction from the stem pool and diffuses it to
+// the network by adding it to the memory pool.  The stem transactions it spends
+// outputs of are diffused first so it is not rejected as an orphan.  The stem
+// transactions of peers are rate limited just like any other transaction
+// received from them.
+//
+// This function MUST be called with the lock held.
+func (d *dandelion) fluff(tx *hcutil.Tx) {
+	for _, txIn := range tx.MsgTx().TxIn {
+		parent, err := d.cfg.StemPool.FetchTransaction(
+			&txIn.PreviousOutPoint.Hash, false)
+		if err == nil {
+			d.fluff(parent)
+		}
+	}
+
+	_, local := d.local[*tx.Hash()]
+	d.cfg.StemPool.RemoveTransaction(tx, false)
+	err := d.cfg.AcceptToMemPool(tx, !local, true, 0)
+	if err != nil {
+		srvrLog.Debugf("Unable to diffuse stem transaction %v: %v",
+			tx.Hash(), err)
+		delete(d.embargoes, *tx.Hash())
+		delete(d.local, *tx.Hash())
+		return
+	}
+	d.diffused(tx)
+	srvrLog.Debugf("Diffused stem transaction %v", tx.Hash())
+}
+
+// checkEmbargoes diffuses the stem transactions whose embargo expired and
+// removes the ones which were diffused by other nodes from the stem pool.
+func (d *dandelion) checkEmbargoes(now time.Time) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	for txHash, embargo := range d.embargoes {
+		if now.Before(embargo) {
+			continue
+		}
+
+		hash := txHash
+		tx, err := d.cfg.StemPool.FetchTransaction(&hash, false)
+		if err != nil {
+			// The transaction was diffused along with a transaction
+			// which spends its outputs.
+			delete(d.embargoes, hash)
+			delete(d.local, hash)
+			continue
+		}
+		if d.cfg.HaveTransaction(&hash) {
+			d.cfg.StemPool.RemoveTransaction(tx, false)
+			d.diffused(tx)
+			continue
+		}
+
+		srvrLog.Debugf("Embargo of stem transaction %v expired", hash)
+		d.fluff(tx)
+	}
+}
+
+// embargoHandler periodically checks the embargoes of the stem transactions
+// until the passed channel is closed.  It must be run as a goroutine.
+func (d *dandelion) embargoHandler(quit <-chan struct{}) {
+	ticker := time.NewTicker(dandelionCheckInterval)
+	defer ticker.Stop()
+
+out:
+	for {
+		select {
+		case now := <-ticker.C:
+			d.checkEmbargoes(now)
+
+		case <-quit:
+			break out
+		}
+	}
+}
@@ -0,0 +1,375 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nbit99/hcd/chaincfg/chainhash"
+	"github.com/nbit99/hcd/hcutil"
+	"github.com/nbit99/hcd/mempool"
+	"github.com/nbit99/hcd/txscript"
+	"github.com/nbit99/hcd/wire"
+)
+
+// TestEmbargoDelay ensures the embargoes of stem transactions are never shorter
+// than the minimum and average to the minimum plus the average addition.
+func TestEmbargoDelay(t *testing.T) {
+	const n = 100000
+	var total time.Duration
+	for i := 0; i < n; i++ {
+		d := embargoDelay()
+		if d < dandelionEmbargoMin {
+			t.Fatalf("embargoDelay: got %v, want at least %v", d,
+				dandelionEmbargoMin)
+		}
+		total += d
+	}
+
+	want := dandelionEmbargoMin + dandelionEmbargoAvgAdd
+	if avg := total / n; avg < want-time.Second || avg > want+time.Second {
+		t.Fatalf("embargoDelay: got average %v, want about %v", avg, want)
+	}
+}
+
+// fakeStemRelay is a stem relay which records the transactions relayed to it.
+type fakeStemRelay struct {
+	connected bool
+	txs       []*wire.MsgTx
+}
+
+func (r *fakeStemRelay) Connected() bool {
+	return r.connected
+}
+
+func (r *fakeStemRelay) QueueMessage(msg wire.Message, doneChan chan<- struct{}) {
+	r.txs = append(r.txs, msg.(*wire.MsgDandelionTx).Tx)
+}
+
+// fakeStemPool is a stem pool which accepts all transactions.
+type fakeStemPool map[chainhash.Hash]*hcutil.Tx
+
+func (p fakeStemPool) ProcessTransaction(tx *hcutil.Tx, allowOrphans, rateLimit, allowHighFees bool, tag mempool.Tag) ([]*hcutil.Tx, error) {
+	p[*tx.Hash()] = tx
+	return []*hcutil.Tx{tx}, nil
+}
+
+func (p fakeStemPool) FetchTransaction(txHash *chainhash.Hash, includeRecentBlock bool) (*hcutil.Tx, error) {
+	if tx, ok := p[*txHash]; ok {
+		return tx, nil
+	}
+	return nil, errors.New("transaction is not in the pool")
+}
+
+func (p fakeStemPool) RemoveTransaction(tx *hcutil.Tx, removeRedeemers bool) {
+	delete(p, *tx.Hash())
+}
+
+// dandelionHarness provides a stem relay with fake dependencies which record
+// the transactions added to the memory pool and the rebroadcast inventory.
+type dandelionHarness struct {
+	d           *dandelion
+	candidates  []*fakeStemRelay
+	stemPool    fakeStemPool
+	memPool     map[chainhash.Hash]*hcutil.Tx
+	accepted    []chainhash.Hash
+	rateLimited []chainhash.Hash
+	rebroadcast []chainhash.Hash
+}
+
+// newDandelionHarness returns a new harness with the passed relay candidates.
+func newDandelionHarness(candidates ...*fakeStemRelay) *dandelionHarness {
+	h := &dandelionHarness{
+		candidates: candidates,
+		stemPool:   make(fakeStemPool),
+		memPool:    make(map[chainhash.Hash]*hcutil.Tx),
+	}
+	h.d = newDandelion(&dandelionConfig{
+		StemPool: h.stemPool,
+		HaveTransaction: func(txHash *chainhash.Hash) bool {
+			_, ok := h.memPool[*txHash]
+			return ok
+		},
+		IsRecentlyRejected: func(txHash *chainhash.Hash) bool {
+			return false
+		},
+		AcceptToMemPool: func(tx *hcutil.Tx, rateLimit, allowHighFees bool, tag mempool.Tag) error {
+			// Reject orphans like the memory pool does.
+			for _, txIn := range tx.MsgTx().TxIn {
+				parent := txIn.PreviousOutPoint.Hash
+				if _, ok := h.stemPool[parent]; ok {
+					return fmt.Errorf("orphan transaction spends "+
+						"stem transaction %v", parent)
+				}
+			}
+			h.memPool[*tx.Hash()] = tx
+			h.accepted = append(h.accepted, *tx.Hash())
+			if rateLimit {
+				h.rateLimited = append(h.rateLimited, *tx.Hash())
+			}
+			return nil
+		},
+		RelayCandidates: func() []stemRelay {
+			var relays []stemRelay
+			for _, relay := range h.candidates {
+				if relay.connected {
+					relays = append(relays, relay)
+				}
+			}
+			return relays
+		},
+		Rebroadcast: func(tx *hcutil.Tx) {
+			h.rebroadcast = append(h.rebroadcast, *tx.Hash())
+		},
+	})
+	return h
+}
+
+// dandelionTestTx returns a regular transaction which spends the passed output.
+func dandelionTestTx(prevHash chainhash.Hash, index uint32) *hcutil.Tx {
+	msgTx := wire.NewMsgTx()
+	prevOut := wire.NewOutPoint(&prevHash, index, wire.TxTreeRegular)
+	msgTx.AddTxIn(wire.NewTxIn(prevOut, nil))
+	msgTx.AddTxOut(wire.NewTxOut(1e8, []byte{txscript.OP_TRUE}))
+	return hcutil.NewTx(msgTx)
+}
+
+// TestDandelionRelayFor ensures the stem relays are chosen anew each epoch and
+// when one of them disconnects, that transactions are never relayed back to the
+// peer they were received from, and that there is no relay without candidates.
+func TestDandelionRelayFor(t *testing.T) {
+	a := &fakeStemRelay{connected: true}
+	b := &fakeStemRelay{connected: true}
+	h := newDandelionHarness(a, b)
+	d := h.d
+	now := time.Now()
+
+	// Transactions of the same origin take the same route during an
+	// epoch.
+	relay := d.relayFor(nil, now)
+	if relay != a && relay != b {
+		t.Fatalf("relayFor: got relay %v, want one of the candidates",
+			relay)
+	}
+	if want := now.Add(dandelionEpoch); !d.epochEnd.Equal(want) {
+		t.Fatalf("relayFor: got epoch end %v, want %v", d.epochEnd, want)
+	}
+	for i := 0; i < 10; i++ {
+		if got := d.relayFor(nil, now); got != relay {
+			t.Fatalf("relayFor: got relay %v, want %v", got, relay)
+		}
+	}
+
+	// Transactions are never relayed back to the peer they were received
+	// from.
+	for i := 0; i < 10; i++ {
+		d.routes = make(map[stemRelay]stemRelay)
+		if got := d.relayFor(a, now); got != b {
+			t.Fatalf("relayFor: got relay %v for transactions of a, "+
+				"want b", got)
+		}
+		d.routes = make(map[stemRelay]stemRelay)
+		if got := d.relayFor(b, now); got != a {
+			t.Fatalf("relayFor: got relay %v for transactions of b, "+
+				"want a", got)
+		}
+	}
+
+	// The relays are only chosen anew once the epoch ended.
+	c := &fakeStemRelay{connected: true}
+	h.candidates = []*fakeStemRelay{c}
+	epochEnd := d.epochEnd
+	got := d.relayFor(nil, epochEnd.Add(-time.Second))
+	if got != a && got != b {
+		t.Fatalf("relayFor: got relay %v before the end of the epoch, "+
+			"want one of the previous relays", got)
+	}
+	if got := d.relayFor(nil, epochEnd); got != c {
+		t.Fatalf("relayFor: got relay %v after the end of the epoch, "+
+			"want c", got)
+	}
+	if want := epochEnd.Add(dandelionEpoch); !d.epochEnd.Equal(want) {
+		t.Fatalf("relayFor: got epoch end %v, want %v", d.epochEnd, want)
+	}
+
+	// The relays are chosen anew when one of them disconnects.
+	h.candidates = []*fakeStemRelay{a, b, c}
+	d.epochEnd = time.Time{}
+	relay = d.relayFor(nil, now)
+	relay.(*fakeStemRelay).connected = false
+	got = d.relayFor(nil, now)
+	if got == nil || got == relay || !got.Connected() {
+		t.Fatalf("relayFor: got relay %v after %v disconnected, want "+
+			"another connected relay", got, relay)
+	}
+	if len(d.relays) != dandelionNumRelays {
+		t.Fatalf("relayFor: got %d relays, want %d", len(d.relays),
+			dandelionNumRelays)
+	}
+	for _, r := range d.relays {
+		if r == relay {
+			t.Fatalf("relayFor: disconnected relay %v is still chosen",
+				relay)
+		}
+	}
+
+	// There is no relay when the only candidate is the peer the
+	// transactions were received from or there are no candidates at all.
+	h.candidates = []*fakeStemRelay{a}
+	d.epochEnd = time.Time{}
+	if got := d.relayFor(a, now); got != nil {
+		t.Fatalf("relayFor: got relay %v for transactions of the only "+
+			"candidate, want none", got)
+	}
+	h.candidates = nil
+	d.epochEnd = time.Time{}
+	if got := d.relayFor(nil, now); got != nil {
+		t.Fatalf("relayFor: got relay %v without candidates, want none",
+			got)
+	}
+	a.connected = false
+	h.candidates = []*fakeStemRelay{a}
+	if got := d.relayFor(nil, now); got != nil {
+		t.Fatalf("relayFor: got disconnected relay %v, want none", got)
+	}
+}
+
+// TestDandelionStemTx ensures stem transactions are kept out of the memory pool
+// until their embargo expires, that the stem transactions they spend outputs of
+// are diffused first, and that locally submitted transactions are only added to
+// the rebroadcast inventory once they are diffused.
+func TestDandelionStemTx(t *testing.T) {
+	relay := &fakeStemRelay{connected: true}
+	from := &fakeStemRelay{connected: true}
+	h := newDandelionHarness(relay)
+	d := h.d
+	d.epochEnd = time.Now().Add(time.Hour)
+	d.relays = []stemRelay{relay}
+
+	inMemPool := func(tx *hcutil.Tx) bool {
+		_, ok := h.memPool[*tx.Hash()]
+		return ok
+	}
+
+	// A locally submitted transaction and a transaction of a peer which
+	// spends its output are relayed along the stem.
+	parent := dandelionTestTx(chainhash.Hash{0x01}, 0)
+	child := dandelionTestTx(*parent.Hash(), 0)
+	if err := d.processStemTx(parent, nil, 0, false); err != nil {
+		t.Fatalf("processStemTx: unexpected error: %v", err)
+	}
+	if err := d.processStemTx(child, from, 1, false); err != nil {
+		t.Fatalf("processStemTx: unexpected error: %v", err)
+	}
+	if len(relay.txs) != 2 || relay.txs[0] != parent.MsgTx() ||
+		relay.txs[1] != child.MsgTx() {
+
+		t.Fatalf("processStemTx: got %d transactions relayed, want the "+
+			"parent and the child", len(relay.txs))
+	}
+	if len(from.txs) != 0 {
+		t.Fatal("processStemTx: transaction relayed back to its sender")
+	}
+	if len(h.stemPool) != 2 || len(d.embargoes) != 2 {
+		t.Fatalf("processStemTx: got %d stem transactions and %d "+
+			"embargoes, want 2", len(h.stemPool), len(d.embargoes))
+	}
+
+	// The stem transactions stay out of the memory pool and the
+	// rebroadcast inventory until their embargo expires.
+	d.checkEmbargoes(time.Now())
+	if inMemPool(parent) || inMemPool(child) {
+		t.Fatal("checkEmbargoes: stem transaction added to the memory " +
+			"pool before its embargo expired")
+	}
+	if len(h.rebroadcast) != 0 {
+		t.Fatal("checkEmbargoes: stem transaction added to the " +
+			"rebroadcast inventory before it was diffused")
+	}
+
+	// Only let the embargo of the child expire to ensure the parent is
+	// diffused first either way.
+	d.embargoes[*parent.Hash()] = time.Now().Add(time.Hour)
+	d.embargoes[*child.Hash()] = time.Now()
+	d.checkEmbargoes(time.Now().Add(time.Minute))
+	wantAccepted := []chainhash.Hash{*parent.Hash(), *child.Hash()}
+	if !reflect.DeepEqual(h.accepted, wantAccepted) {
+		t.Fatalf("checkEmbargoes: got diffused transactions %v, want %v",
+			h.accepted, wantAccepted)
+	}
+	if len(h.stemPool) != 0 || len(d.embargoes) != 0 || len(d.local) != 0 {
+		t.Fatalf("checkEmbargoes: got %d stem transactions, %d "+
+			"embargoes and %d local transactions left, want none",
+			len(h.stemPool), len(d.embargoes), len(d.local))
+	}
+	wantRebroadcast := []chainhash.Hash{*parent.Hash()}
+	if !reflect.DeepEqual(h.rebroadcast, wantRebroadcast) {
+		t.Fatalf("checkEmbargoes: got rebroadcast transactions %v, "+
+			"want %v", h.rebroadcast, wantRebroadcast)
+	}
+
+	// Only the transaction of the peer is rate limited when diffused.
+	wantRateLimited := []chainhash.Hash{*child.Hash()}
+	if !reflect.DeepEqual(h.rateLimited, wantRateLimited) {
+		t.Fatalf("checkEmbargoes: got rate limited transactions %v, "+
+			"want %v", h.rateLimited, wantRateLimited)
+	}
+
+	// A stem transaction which was diffused by another node is removed
+	// from the stem pool and only added to the rebroadcast inventory.
+	h.accepted = nil
+	h.rebroadcast = nil
+	tx := dandelionTestTx(chainhash.Hash{0x02}, 0)
+	if err := d.processStemTx(tx, nil, 0, false); err != nil {
+		t.Fatalf("processStemTx: unexpected error: %v", err)
+	}
+	h.memPool[*tx.Hash()] = tx
+	d.checkEmbargoes(time.Now().Add(time.Hour))
+	if len(h.accepted) != 0 || len(h.stemPool) != 0 {
+		t.Fatalf("checkEmbargoes: got %d diffused and %d stem "+
+			"transactions, want none", len(h.accepted),
+			len(h.stemPool))
+	}
+	wantRebroadcast = []chainhash.Hash{*tx.Hash()}
+	if !reflect.DeepEqual(h.rebroadcast, wantRebroadcast) {
+		t.Fatalf("checkEmbargoes: got rebroadcast transactions %v, "+
+			"want %v", h.rebroadcast, wantRebroadcast)
+	}
+
+	// The transactions of peers are diffused right away by diffusers and
+	// never added to the rebroadcast inventory.
+	h.rebroadcast = nil
+	d.diffuser = true
+	tx = dandelionTestTx(chainhash.Hash{0x03}, 0)
+	if err := d.processStemTx(tx, from, 1, false); err != nil {
+		t.Fatalf("processStemTx: unexpected error: %v", err)
+	}
+	if !inMemPool(tx) || len(h.rebroadcast) != 0 {
+		t.Fatal("processStemTx: transaction of a peer not diffused " +
+			"right away by a diffuser")
+	}
+
+	// Locally submitted transactions are diffused right away when there is
+	// no stem relay.
+	d.diffuser = false
+	relay.connected = false
+	tx = dandelionTestTx(chainhash.Hash{0x04}, 0)
+	if err := d.processStemTx(tx, nil, 0, false); err != nil {
+		t.Fatalf("processStemTx: unexpected error: %v", err)
+	}
+	if !inMemPool(tx) || len(d.embargoes) != 0 {
+		t.Fatal("processStemTx: transaction not diffused without a " +
+			"stem relay")
+	}
+	wantRebroadcast = []chainhash.Hash{*tx.Hash()}
+	if !reflect.DeepEqual(h.rebroadcast, wantRebroadcast) {
+		t.Fatalf("processStemTx: got rebroadcast transactions %v, want "+
+			"%v", h.rebroadcast, wantRebroadcast)
+	}
+}
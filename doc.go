@@ -36,13 +36,35 @@ Application Options:
       --listen=             Add an interface/port to listen for connections
                             (default all interfaces port: 14008, testnet: 12008)
       --maxpeers=           Max number of inbound and outbound peers (125)
+      --blockrelayconns=    Number of outbound connections which only relay
+                            blocks and never transactions or addresses (2)
+      --asmap=              Path to an IP to ASN map file which groups peers by
+                            their autonomous systems instead of their network
+                            prefixes to diversify outbound connections
       --nobanning           Disable banning of misbehaving peers
       --banduration=        How long to ban misbehaving peers.  Valid time units
                             are {s, m, h}.  Minimum 1 second (24h0m0s)
       --banthreshold=       Maximum allowed ban score before disconnecting and
                             banning misbehaving peers.
-      --whitelist=          Add an IP network or IP that will not be banned.
-                            (eg. 192.168.1.0/24 or ::1)
+      --whitelist=          Add an IP network or IP whose peers are granted
+                            permissions in the form of [permissions@]IP or
+                            network -- Permissions are a comma separated list
+                            of noban, relay, forcerelay, mempool, download,
+                            addr or all and default to
+                            noban,relay,mempool,download (eg. 192.168.1.0/24,
+                            ::1 or relay,mempool@10.0.0.0/8)
+      --whitebind=          Add an interface/port to listen for connections
+                            whose peers are granted permissions in the form of
+                            [permissions@]interface:port -- See --whitelist for
+                            the permissions
+      --maxuploadtarget=    Try to keep the data sent to peers below the given
+                            target in MiB per 24h -- Historical blocks are no
+                            longer served to peers without the download
+                            permission once the target is approached (0 =
+                            unlimited)
+      --maxpeersendrate=    Limit the rate at which data is sent to each peer
+                            without the download permission in KiB/s (0 =
+                            unlimited)
   -u, --rpcuser=            Username for RPC connections
   -P, --rpcpass=            Password for RPC connections
       --rpclimituser=       Username for limited RPC connections
@@ -83,6 +105,9 @@ Application Options:
       --memprofile=         Write mem profile to the specified file
       --dumpblockchain=     Write blockchain as a gob-encoded map to the
                             specified file
+      --capturemessages     Record every message read from or written to peers
+                            to a file per peer in the msgcapture directory of
+                            the data directory -- Use hccapture to decode them
       --miningtimeoffset=   Offset the mining timestamp of a block by this many
                             seconds (positive values are in the past)
   -d, --debuglevel=         Logging level for all subsystems {trace, debug,
@@ -100,6 +125,18 @@ Application Options:
                             high priority for relaying
       --maxorphantx=        Max number of orphan transactions to keep in memory
                             (1000)
+      --maxorphantxperpeer= Max number of orphan transactions received from a
+                            single peer to keep in memory (0 for no per-peer
+                            limit) (100)
+      --orphantxexpiry=     How long orphan transactions are kept in memory
+                            before they expire.  Valid time units are {s, m, h}
+                            (0 to disable) (15m0s)
+      --maxmempool=         Max size of the transaction memory pool in megabytes
+                            -- The lowest fee rate transactions are evicted once
+                            it is exceeded (0 to disable) (300)
+      --mempoolexpiry=      How long regular transactions are kept in the memory
+                            pool before they are evicted.  Valid time units are
+                            {s, m, h} (0 to disable) (336h0m0s)
       --generate            Generate (mine) bitcoins using the CPU
       --miningaddr=         Add the specified payment address to the list of
                             addresses to use for generated blocks -- At least
@@ -111,6 +148,12 @@ Application Options:
                             a block (750000)
       --blockprioritysize=  Size in bytes for high-priority/low-fee transactions
                             when creating a block (50000)
+      --stratumlisten=      Add an interface/port to listen for Stratum mining
+                            connections -- Enables the built-in Stratum server
+                            (default port: 14011, testnet: 12011, simnet: 13011)
+      --stratumdifficulty=  The initial share difficulty of Stratum workers,
+                            where 1 is the proof of work limit of the network --
+                            It is adjusted to the hash rate of each worker (1)
       --getworkkey=         DEPRECATED -- Use the --miningaddr option instead
       --nonaggressive       Disable mining off of the parent block of the blockchain
                             if there aren't enough voters
@@ -118,13 +161,23 @@ Application Options:
       --allowoldvotes       Enable the addition of very old votes to the mempool
 
       --nopeerbloomfilters  Disable bloom filtering support.
+      --noencryption        Disable the encrypted transport for connections
+                            with peers which support it.
       --sigcachemaxsize=    The maximum number of entries in the signature
                             verification cache.
       --blocksonly          Do not accept transactions from remote peers.
+      --dandelion           Relay transactions of peers and those submitted with
+                            sendrawtransaction along a Dandelion++ stem before
+                            diffusing them to the network.
       --relaynonstd         Relay non-standard transactions regardless of the
                             default settings for the active network.
       --rejectnonstd        Reject non-standard transactions regardless of the
                             default settings for the active network.
+      --rejectreplacement   Reject transactions that attempt to replace existing
+                            transactions within the mempool through the
+                            Replace-By-Fee (RBF) signaling policy.
+      --nopersistmempool    Do not save the transaction memory pool on shutdown
+                            and load it again on start up
 
 Help Options:
   -h, --help           Show this help message
@@ -0,0 +1,181 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/nbit99/hcd/chaincfg/chainhash"
+)
+
+const (
+	// evictProtectNetGroups is the number of inbound peers with distinct
+	// network groups which are protected from eviction.  The protected
+	// network groups are chosen by a keyed hash which is unknown to remote
+	// peers, so they can't be targeted by an attacker.
+	evictProtectNetGroups = 4
+
+	// evictProtectPing is the number of inbound peers with the lowest ping
+	// times which are protected from eviction.
+	evictProtectPing = 8
+
+	// evictProtectTx is the number of inbound peers which most recently
+	// relayed novel transactions which are protected from eviction.
+	evictProtectTx = 4
+
+	// evictProtectBlock is the number of inbound peers which most recently
+	// relayed novel blocks which are protected from eviction.
+	evictProtectBlock = 4
+)
+
+// evictionCandidate houses the properties of an inbound peer which are used to
+// select the peer which is evicted when all connection slots are in use.
+type evictionCandidate struct {
+	sp            *serverPeer
+	connected     time.Time
+	pingMicros    int64
+	lastBlockTime time.Time
+	lastTxTime    time.Time
+	relayTxs      bool
+	netGroup      string
+	netGroupKey   uint64
+}
+
+// newEvictionCandidate returns the eviction candidate for the passed inbound
+// peer.  The seed keys the hash of the network group of the peer.
+func newEvictionCandidate(sp *serverPeer, seed uint64) *evictionCandidate {
+	netGroup := sp.server.addrManager.GroupKey(sp.NA())
+
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], seed)
+	hash := chainhash.HashH(append(buf[:], netGroup...))
+
+	pingMicros := sp.LastPingMicros()
+	if pingMicros == 0 {
+		pingMicros = math.MaxInt64
+	}
+
+	sp.relayMtx.Lock()
+	relayTxs := !sp.disableRelayTx
+	sp.relayMtx.Unlock()
+
+	return &evictionCandidate{
+		sp:            sp,
+		connected:     sp.TimeConnected(),
+		pingMicros:    pingMicros,
+		lastBlockTime: sp.lastBlockTime(),
+		lastTxTime:    sp.lastTxTime(),
+		relayTxs:      relayTxs,
+		netGroup:      netGroup,
+		netGroupKey:   binary.LittleEndian.Uint64(hash[:8]),
+	}
+}
+
+// protectCandidates sorts the passed candidates so the most deserving of
+// protection come first according to the passed less function and returns the
+// remaining candidates after removing up to n of them.
+func protectCandidates(candidates []*evictionCandidate, n int, less func(a, b *evictionCandidate) bool) []*evictionCandidate {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return less(candidates[i], candidates[j])
+	})
+	if n > len(candidates) {
+		n = len(candidates)
+	}
+	return candidates[n:]
+}
+
+// selectPeerToEvict returns the candidate which is evicted to make room for a
+// new inbound connection, or nil when all candidates are protected.
+//
+// Candidates are protected from eviction in several ways, each of which is hard
+// for an attacker to fake: a few distinct network groups selected by a keyed
+// hash, the lowest ping times, the most recent relay of novel transactions and
+// blocks, and half of the remaining candidates with the longest connection
+// times.  The youngest candidate of the network group with the most remaining
+// candidates is evicted, so an attacker controlling many addresses of a single
+// network group only ever evicts its own connections.
+func selectPeerToEvict(candidates []*evictionCandidate) *evictionCandidate {
+	candidates = protectCandidates(candidates, evictProtectNetGroups,
+		func(a, b *evictionCandidate) bool {
+			return a.netGroupKey > b.netGroupKey
+		})
+	candidates = protectCandidates(candidates, evictProtectPing,
+		func(a, b *evictionCandidate) bool {
+			return a.pingMicros < b.pingMicros
+		})
+	candidates = protectCandidates(candidates, evictProtectTx,
+		func(a, b *evictionCandidate) bool {
+			if !a.lastTxTime.Equal(b.lastTxTime) {
+				return a.lastTxTime.After(b.lastTxTime)
+			}
+			return a.relayTxs && !b.relayTxs
+		})
+	candidates = protectCandidates(candidates, evictProtectBlock,
+		func(a, b *evictionCandidate) bool {
+			return a.lastBlockTime.After(b.lastBlockTime)
+		})
+	candidates = protectCandidates(candidates, len(candidates)/2,
+		func(a, b *evictionCandidate) bool {
+			return a.connected.Before(b.connected)
+		})
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	// Group the remaining candidates by network group and keep track of
+	// the youngest candidate of each group.
+	groups := make(map[string][]*evictionCandidate)
+	youngest := make(map[string]*evictionCandidate)
+	for _, c := range candidates {
+		groups[c.netGroup] = append(groups[c.netGroup], c)
+		if y, ok := youngest[c.netGroup]; !ok || c.connected.After(y.connected) {
+			youngest[c.netGroup] = c
+		}
+	}
+
+	// Evict the youngest candidate of the largest group.  Ties are broken
+	// in favor of the group with the youngest candidate.
+	var evict *evictionCandidate
+	var evictGroupSize int
+	for netGroup, group := range groups {
+		y := youngest[netGroup]
+		if len(group) > evictGroupSize || (len(group) == evictGroupSize &&
+			y.connected.After(evict.connected)) {
+
+			evict = y
+			evictGroupSize = len(group)
+		}
+	}
+	return evict
+}
+
+// evictInboundPeer disconnects an inbound peer to make room for a new inbound
+// connection when all connection slots are in use.  Peers with the noban
+// permission are never evicted.  It returns whether or not a peer was evicted.
+// It is invoked from the peerHandler goroutine.
+func (s *server) evictInboundPeer(state *peerState) bool {
+	candidates := make([]*evictionCandidate, 0, len(state.inboundPeers))
+	for _, sp := range state.inboundPeers {
+		if sp.permissions.has(permNoBan) || !sp.Connected() {
+			continue
+		}
+		candidates = append(candidates, newEvictionCandidate(sp,
+			s.evictionSeed))
+	}
+
+	evict := selectPeerToEvict(candidates)
+	if evict == nil {
+		return false
+	}
+
+	srvrLog.Debugf("Evicting inbound peer %s to make room for a new "+
+		"connection", evict.sp)
+	delete(state.inboundPeers, evict.sp.ID())
+	evict.sp.Disconnect()
+	return true
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// evictionTestCandidates returns the passed number of candidates which are
+// connected one minute apart in order and belong to distinct network groups.
+func evictionTestCandidates(n int) []*evictionCandidate {
+	start := time.Unix(1500000000, 0)
+	candidates := make([]*evictionCandidate, 0, n)
+	for i := 0; i < n; i++ {
+		candidates = append(candidates, &evictionCandidate{
+			connected:   start.Add(time.Duration(i) * time.Minute),
+			pingMicros:  int64(1000 + i),
+			netGroup:    fmt.Sprintf("group%d", i),
+			netGroupKey: uint64(i),
+		})
+	}
+	return candidates
+}
+
+// TestSelectPeerToEvict ensures the peer which is evicted when all connection
+// slots are in use is selected as expected.
+func TestSelectPeerToEvict(t *testing.T) {
+	// No peer is evicted when all of them are protected.
+	candidates := evictionTestCandidates(evictProtectNetGroups +
+		evictProtectPing + evictProtectTx + evictProtectBlock)
+	if c := selectPeerToEvict(candidates); c != nil {
+		t.Fatalf("selectPeerToEvict: evicted protected peer %s",
+			c.netGroup)
+	}
+
+	// The youngest peer of the largest network group is evicted.  The
+	// attacking peers have the worst ping times and never relay anything.
+	candidates = evictionTestCandidates(40)
+	attackStart := candidates[len(candidates)-1].connected
+	for i := 0; i < 20; i++ {
+		candidates = append(candidates, &evictionCandidate{
+			connected:  attackStart.Add(time.Duration(i+1) * time.Second),
+			pingMicros: 1e6,
+			netGroup:   "attacker",
+		})
+	}
+	want := candidates[len(candidates)-1]
+	if c := selectPeerToEvict(candidates); c != want {
+		t.Fatalf("selectPeerToEvict: got %v, want youngest attacker",
+			c)
+	}
+
+	// Peers which recently relayed novel blocks and transactions are
+	// protected even when they have the worst ping times and are the
+	// youngest members of the largest network group.
+	candidates = evictionTestCandidates(40)
+	now := time.Now()
+	var relayers []*evictionCandidate
+	for i := 0; i < 20; i++ {
+		c := &evictionCandidate{
+			connected:  attackStart.Add(time.Duration(i+1) * time.Second),
+			pingMicros: 1e6,
+			netGroup:   "busy",
+		}
+		if i >= 20-evictProtectTx-evictProtectBlock {
+			if i%2 == 0 {
+				c.lastTxTime = now
+			} else {
+				c.lastBlockTime = now
+			}
+			relayers = append(relayers, c)
+		}
+		candidates = append(candidates, c)
+	}
+	c := selectPeerToEvict(candidates)
+	if c == nil || c.netGroup != "busy" {
+		t.Fatalf("selectPeerToEvict: got %v, want a peer of the largest "+
+			"network group", c)
+	}
+	for _, relayer := range relayers {
+		if c == relayer {
+			t.Fatalf("selectPeerToEvict: evicted peer which recently " +
+				"relayed novel data")
+		}
+	}
+
+	// Ties between the largest network groups are broken in favor of the
+	// group with the youngest peer.
+	candidates = evictionTestCandidates(40)
+	for i := 0; i < 6; i++ {
+		netGroup := "a"
+		if i%2 == 1 {
+			netGroup = "b"
+		}
+		candidates = append(candidates, &evictionCandidate{
+			connected:  attackStart.Add(time.Duration(i+1) * time.Second),
+			pingMicros: 1e6,
+			netGroup:   netGroup,
+		})
+	}
+	want = candidates[len(candidates)-1]
+	if c := selectPeerToEvict(candidates); c != want {
+		t.Fatalf("selectPeerToEvict: got %v, want youngest peer of "+
+			"group b", c)
+	}
+}
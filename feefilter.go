@@ -0,0 +1,87 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/nbit99/hcd/hcutil"
+)
+
+const (
+	// avgFeeFilterInterval is the average interval between the feefilter
+	// messages sent to a peer.  The actual intervals are exponentially
+	// distributed around it so they can't be used to link the connections
+	// of a node.
+	avgFeeFilterInterval = 10 * time.Minute
+
+	// maxFeeFilterChangeDelay is the maximum time it takes to send a new
+	// feefilter message to a peer once the minimum fee rate of the memory
+	// pool changed substantially.
+	maxFeeFilterChangeDelay = 5 * time.Minute
+
+	// feeFilterCheckInterval is the interval at which peers are checked for
+	// whether they are due a new feefilter message.
+	feeFilterCheckInterval = 30 * time.Second
+
+	// maxFeeFilterRate is the highest fee rate in atoms/kB that fee filters
+	// sent to peers are rounded to.
+	maxFeeFilterRate = 1e7
+
+	// feeFilterSpacing is the factor between two consecutive fee rates that
+	// fee filters sent to peers are rounded to.
+	feeFilterSpacing = 1.1
+)
+
+// feeFilterRounder rounds the fee rates sent to peers in feefilter messages to
+// a fixed set of values with some randomness so the exact minimum fee rate of
+// the memory pool, which can be used to fingerprint a node, is not revealed.
+type feeFilterRounder struct {
+	feeRates []int64
+}
+
+// newFeeFilterRounder returns a new fee filter rounder which rounds to fee
+// rates spaced feeFilterSpacing apart, starting at half of the passed minimum
+// relay fee.
+func newFeeFilterRounder(minRelayTxFee hcutil.Amount) *feeFilterRounder {
+	feeRate := float64(minRelayTxFee) / 2
+	if feeRate < 1 {
+		feeRate = 1
+	}
+
+	var feeRates []int64
+	for ; feeRate <= maxFeeFilterRate; feeRate *= feeFilterSpacing {
+		feeRates = append(feeRates, int64(feeRate))
+	}
+	return &feeFilterRounder{feeRates: feeRates}
+}
+
+// round returns the fee rate to announce in place of the passed one.  It is
+// usually the next lower fee rate of the rounder and otherwise the next higher
+// one.
+func (r *feeFilterRounder) round(feeRate int64) int64 {
+	i := sort.Search(len(r.feeRates), func(i int) bool {
+		return r.feeRates[i] >= feeRate
+	})
+	if i == len(r.feeRates) || (i > 0 && rand.Intn(3) != 0) {
+		i--
+	}
+	return r.feeRates[i]
+}
+
+// feeFilterDelay returns a random delay until the next feefilter message is
+// sent to a peer.
+func feeFilterDelay() time.Duration {
+	return time.Duration(rand.ExpFloat64() * float64(avgFeeFilterInterval))
+}
+
+// feeFilterChangeDelay returns a random delay of at most
+// maxFeeFilterChangeDelay until a new feefilter message is sent to a peer after
+// the minimum fee rate of the memory pool changed substantially.
+func feeFilterChangeDelay() time.Duration {
+	return time.Duration(rand.Int63n(int64(maxFeeFilterChangeDelay)))
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+)
+
+// TestFeeFilterRounder ensures fee rates are rounded to one of the two closest
+// fee rates of the rounder and stay within its range.
+func TestFeeFilterRounder(t *testing.T) {
+	r := newFeeFilterRounder(1000)
+	if r.feeRates[0] != 500 {
+		t.Fatalf("lowest fee rate %d, want 500", r.feeRates[0])
+	}
+
+	tests := []int64{0, 499, 500, 501, 1000, 12345, 999999, maxFeeFilterRate,
+		maxFeeFilterRate * 10}
+	for _, feeRate := range tests {
+		seen := make(map[int64]struct{})
+		for i := 0; i < 100; i++ {
+			rounded := r.round(feeRate)
+			seen[rounded] = struct{}{}
+
+			// The rounded fee rate must be one of the rates of the
+			// rounder adjacent to the passed fee rate.
+			idx := -1
+			for j, rate := range r.feeRates {
+				if rate == rounded {
+					idx = j
+					break
+				}
+			}
+			if idx == -1 {
+				t.Fatalf("round(%d): %d is not a rounder fee rate",
+					feeRate, rounded)
+			}
+			if rounded > feeRate && idx > 0 &&
+				r.feeRates[idx-1] >= feeRate {
+
+				t.Fatalf("round(%d): %d is not the next higher "+
+					"fee rate", feeRate, rounded)
+			}
+			if rounded < feeRate && idx < len(r.feeRates)-1 &&
+				r.feeRates[idx+1] < feeRate {
+
+				t.Fatalf("round(%d): %d is not the next lower "+
+					"fee rate", feeRate, rounded)
+			}
+		}
+		if len(seen) > 2 {
+			t.Fatalf("round(%d): got %d distinct fee rates, want at "+
+				"most 2", feeRate, len(seen))
+		}
+	}
+}
@@ -229,6 +229,17 @@ func TestChainSvrCmds(t *testing.T) {
 				Node: hcjson.String("127.0.0.1"),
 			},
 		},
+		{
+			name: "getaddrmaninfo",
+			newCmd: func() (interface{}, error) {
+				return hcjson.NewCmd("getaddrmaninfo")
+			},
+			staticCmd: func() interface{} {
+				return hcjson.NewGetAddrManInfoCmd()
+			},
+			marshalled:   `{"jsonrpc":"1.0","method":"getaddrmaninfo","params":[],"id":1}`,
+			unmarshalled: &hcjson.GetAddrManInfoCmd{},
+		},
 		{
 			name: "getbestblockhash",
 			newCmd: func() (interface{}, error) {
@@ -543,6 +554,32 @@ func TestChainSvrCmds(t *testing.T) {
 			marshalled:   `{"jsonrpc":"1.0","method":"getnettotals","params":[],"id":1}`,
 			unmarshalled: &hcjson.GetNetTotalsCmd{},
 		},
+		{
+			name: "getnodeaddresses",
+			newCmd: func() (interface{}, error) {
+				return hcjson.NewCmd("getnodeaddresses")
+			},
+			staticCmd: func() interface{} {
+				return hcjson.NewGetNodeAddressesCmd(nil)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"getnodeaddresses","params":[],"id":1}`,
+			unmarshalled: &hcjson.GetNodeAddressesCmd{
+				Count: hcjson.Int32(1),
+			},
+		},
+		{
+			name: "getnodeaddresses optional",
+			newCmd: func() (interface{}, error) {
+				return hcjson.NewCmd("getnodeaddresses", 10)
+			},
+			staticCmd: func() interface{} {
+				return hcjson.NewGetNodeAddressesCmd(hcjson.Int32(10))
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"getnodeaddresses","params":[10],"id":1}`,
+			unmarshalled: &hcjson.GetNodeAddressesCmd{
+				Count: hcjson.Int32(10),
+			},
+		},
 		{
 			name: "getnetworkhashps",
 			newCmd: func() (interface{}, error) {
@@ -731,6 +768,21 @@ func TestChainSvrCmds(t *testing.T) {
 				Data: hcjson.String("00112233"),
 			},
 		},
+		{
+			name: "getwork longpollid",
+			newCmd: func() (interface{}, error) {
+				return hcjson.NewCmd("getwork", "", "abc-1")
+			},
+			staticCmd: func() interface{} {
+				return hcjson.NewGetWorkLongPollCmd(hcjson.String(""),
+					hcjson.String("abc-1"))
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"getwork","params":["","abc-1"],"id":1}`,
+			unmarshalled: &hcjson.GetWorkCmd{
+				Data:       hcjson.String(""),
+				LongPollID: hcjson.String("abc-1"),
+			},
+		},
 		{
 			name: "help",
 			newCmd: func() (interface{}, error) {
@@ -768,6 +820,20 @@ func TestChainSvrCmds(t *testing.T) {
 			marshalled:   `{"jsonrpc":"1.0","method":"ping","params":[],"id":1}`,
 			unmarshalled: &hcjson.PingCmd{},
 		},
+		{
+			name: "prioritisetransaction",
+			newCmd: func() (interface{}, error) {
+				return hcjson.NewCmd("prioritisetransaction", "123", 10000)
+			},
+			staticCmd: func() interface{} {
+				return hcjson.NewPrioritiseTransactionCmd("123", 10000)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"prioritisetransaction","params":["123",10000],"id":1}`,
+			unmarshalled: &hcjson.PrioritiseTransactionCmd{
+				TxID:     "123",
+				FeeDelta: 10000,
+			},
+		},
 		{
 			name: "searchrawtransactions",
 			newCmd: func() (interface{}, error) {
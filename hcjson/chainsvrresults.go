@@ -98,6 +98,24 @@ type GetAddedNodeInfoResult struct {
 	Addresses *[]GetAddedNodeInfoResultAddr `json:"addresses,omitempty"`
 }
 
+// GetAddrManInfoResult models the data returned from the getaddrmaninfo
+// command.
+type GetAddrManInfoResult struct {
+	Networks        []AddrManNetworkResult `json:"networks"`
+	FeelerAttempts  uint64                 `json:"feelerattempts"`
+	FeelerSuccesses uint64                 `json:"feelersuccesses"`
+}
+
+// AddrManNetworkResult models the networks data from the getaddrmaninfo
+// command.
+type AddrManNetworkResult struct {
+	Network string `json:"network"`
+	New     int    `json:"new"`
+	Tried   int    `json:"tried"`
+	Total   int    `json:"total"`
+	NewBad  int    `json:"newbad"`
+}
+
 // GetBlockChainInfoResult models the data returned from the getblockchaininfo
 // command.
 type GetBlockChainInfoResult struct {
@@ -180,8 +198,11 @@ type GetBlockTemplateResult struct {
 // GetMempoolInfoResult models the data returned from the getmempoolinfo
 // command.
 type GetMempoolInfoResult struct {
-	Size  int64 `json:"size"`
-	Bytes int64 `json:"bytes"`
+	Size          int64   `json:"size"`
+	Bytes         int64   `json:"bytes"`
+	MaxMempool    int64   `json:"maxmempool"`
+	MempoolMinFee float64 `json:"mempoolminfee"`
+	MinRelayTxFee float64 `json:"minrelaytxfee"`
 }
 
 // GetNetworkInfoResult models the data returned from the getnetworkinfo
@@ -198,25 +219,30 @@ type GetNetworkInfoResult struct {
 
 // GetPeerInfoResult models the data returned from the getpeerinfo command.
 type GetPeerInfoResult struct {
-	ID             int32   `json:"id"`
-	Addr           string  `json:"addr"`
-	AddrLocal      string  `json:"addrlocal,omitempty"`
-	Services       string  `json:"services"`
-	LastSend       int64   `json:"lastsend"`
-	LastRecv       int64   `json:"lastrecv"`
-	BytesSent      uint64  `json:"bytessent"`
-	BytesRecv      uint64  `json:"bytesrecv"`
-	ConnTime       int64   `json:"conntime"`
-	TimeOffset     int64   `json:"timeoffset"`
-	PingTime       float64 `json:"pingtime"`
-	PingWait       float64 `json:"pingwait,omitempty"`
-	Version        uint32  `json:"version"`
-	SubVer         string  `json:"subver"`
-	Inbound        bool    `json:"inbound"`
-	StartingHeight int64   `json:"startingheight"`
-	CurrentHeight  int64   `json:"currentheight,omitempty"`
-	BanScore       int32   `json:"banscore"`
-	SyncNode       bool    `json:"syncnode"`
+	ID             int32    `json:"id"`
+	Addr           string   `json:"addr"`
+	AddrLocal      string   `json:"addrlocal,omitempty"`
+	Services       string   `json:"services"`
+	LastSend       int64    `json:"lastsend"`
+	LastRecv       int64    `json:"lastrecv"`
+	BytesSent      uint64   `json:"bytessent"`
+	BytesRecv      uint64   `json:"bytesrecv"`
+	ConnTime       int64    `json:"conntime"`
+	TimeOffset     int64    `json:"timeoffset"`
+	PingTime       float64  `json:"pingtime"`
+	PingWait       float64  `json:"pingwait,omitempty"`
+	Version        uint32   `json:"version"`
+	SubVer         string   `json:"subver"`
+	Inbound        bool     `json:"inbound"`
+	ConnType       string   `json:"conntype"`
+	Permissions    []string `json:"permissions,omitempty"`
+	MappedAS       uint32   `json:"mappedas,omitempty"`
+	Encrypted      bool     `json:"encrypted"`
+	StartingHeight int64    `json:"startingheight"`
+	CurrentHeight  int64    `json:"currentheight,omitempty"`
+	BanScore       int32    `json:"banscore"`
+	SyncNode       bool     `json:"syncnode"`
+	OrphanTxs      int      `json:"orphantxs"`
 }
 
 // GetRawMempoolVerboseResult models the data returned from the getrawmempool
@@ -255,9 +281,31 @@ type GetTxOutResult struct {
 
 // GetNetTotalsResult models the data returned from the getnettotals command.
 type GetNetTotalsResult struct {
-	TotalBytesRecv uint64 `json:"totalbytesrecv"`
-	TotalBytesSent uint64 `json:"totalbytessent"`
-	TimeMillis     int64  `json:"timemillis"`
+	TotalBytesRecv uint64             `json:"totalbytesrecv"`
+	TotalBytesSent uint64             `json:"totalbytessent"`
+	TimeMillis     int64              `json:"timemillis"`
+	UploadTarget   UploadTargetResult `json:"uploadtarget"`
+}
+
+// UploadTargetResult models the uploadtarget data from the getnettotals
+// command.
+type UploadTargetResult struct {
+	TimeFrame             int64  `json:"timeframe"`
+	Target                uint64 `json:"target"`
+	TargetReached         bool   `json:"targetreached"`
+	ServeHistoricalBlocks bool   `json:"servehistoricalblocks"`
+	BytesLeftInCycle      uint64 `json:"bytesleftincycle"`
+	TimeLeftInCycle       int64  `json:"timeleftincycle"`
+}
+
+// GetNodeAddressesResult models the data returned from the getnodeaddresses
+// command.
+type GetNodeAddressesResult struct {
+	Time     int64  `json:"time"`
+	Services uint64 `json:"services"`
+	Address  string `json:"address"`
+	Port     uint16 `json:"port"`
+	Network  string `json:"network"`
 }
 
 // ScriptSig models a signature script.  It is defined separately since it only
@@ -446,8 +494,10 @@ type GetMiningInfoResult struct {
 
 // GetWorkResult models the data from the getwork command.
 type GetWorkResult struct {
-	Data   string `json:"data"`
-	Target string `json:"target"`
+	Data       string `json:"data"`
+	Midstate   string `json:"midstate"`
+	Target     string `json:"target"`
+	LongPollID string `json:"longpollid,omitempty"`
 }
 
 // InfoChainResult models the data returned by the chain server getinfo command.
@@ -113,6 +113,24 @@ func NewNotifyStakeDifficultyCmd() *NotifyStakeDifficultyCmd {
 	return &NotifyStakeDifficultyCmd{}
 }
 
+// NotifyWorkCmd defines the notifywork JSON-RPC command.
+type NotifyWorkCmd struct{}
+
+// NewNotifyWorkCmd returns a new instance which can be used to issue a
+// notifywork JSON-RPC command.
+func NewNotifyWorkCmd() *NotifyWorkCmd {
+	return &NotifyWorkCmd{}
+}
+
+// StopNotifyWorkCmd defines the stopnotifywork JSON-RPC command.
+type StopNotifyWorkCmd struct{}
+
+// NewStopNotifyWorkCmd returns a new instance which can be used to issue a
+// stopnotifywork JSON-RPC command.
+func NewStopNotifyWorkCmd() *StopNotifyWorkCmd {
+	return &StopNotifyWorkCmd{}
+}
+
 // StopNotifyBlocksCmd defines the stopnotifyblocks JSON-RPC command.
 type StopNotifyBlocksCmd struct{}
 
@@ -187,8 +205,10 @@ func init() {
 		(*NotifyStakeDifficultyCmd)(nil), flags)
 	MustRegisterCmd("notifywinningtickets",
 		(*NotifyWinningTicketsCmd)(nil), flags)
+	MustRegisterCmd("notifywork", (*NotifyWorkCmd)(nil), flags)
 	MustRegisterCmd("session", (*SessionCmd)(nil), flags)
 	MustRegisterCmd("stopnotifyblocks", (*StopNotifyBlocksCmd)(nil), flags)
 	MustRegisterCmd("stopnotifynewtransactions", (*StopNotifyNewTransactionsCmd)(nil), flags)
+	MustRegisterCmd("stopnotifywork", (*StopNotifyWorkCmd)(nil), flags)
 	MustRegisterCmd("rescan", (*RescanCmd)(nil), flags)
 }
@@ -122,6 +122,28 @@ func TestChainSvrWsCmds(t *testing.T) {
 			marshalled:   `{"jsonrpc":"1.0","method":"stopnotifyblocks","params":[],"id":1}`,
 			unmarshalled: &hcjson.StopNotifyBlocksCmd{},
 		},
+		{
+			name: "notifywork",
+			newCmd: func() (interface{}, error) {
+				return hcjson.NewCmd("notifywork")
+			},
+			staticCmd: func() interface{} {
+				return hcjson.NewNotifyWorkCmd()
+			},
+			marshalled:   `{"jsonrpc":"1.0","method":"notifywork","params":[],"id":1}`,
+			unmarshalled: &hcjson.NotifyWorkCmd{},
+		},
+		{
+			name: "stopnotifywork",
+			newCmd: func() (interface{}, error) {
+				return hcjson.NewCmd("stopnotifywork")
+			},
+			staticCmd: func() interface{} {
+				return hcjson.NewStopNotifyWorkCmd()
+			},
+			marshalled:   `{"jsonrpc":"1.0","method":"stopnotifywork","params":[],"id":1}`,
+			unmarshalled: &hcjson.StopNotifyWorkCmd{},
+		},
 		{
 			name: "notifynewtransactions",
 			newCmd: func() (interface{}, error) {
@@ -36,6 +36,30 @@ const (
 	// from the chain server that inform a client that a relevant
 	// transaction was accepted by the mempool.
 	RelevantTxAcceptedNtfnMethod = "relevanttxaccepted"
+
+	// TxReplacedNtfnMethod is the method used for notifications from the
+	// chain server that a transaction in the memory pool has been replaced
+	// by another transaction.
+	TxReplacedNtfnMethod = "txreplaced"
+
+	// WorkNtfnMethod is the method used for notifications from the chain
+	// server that new work is available for mining.
+	WorkNtfnMethod = "work"
+)
+
+// These constants are the reasons for sending work notifications.
+const (
+	// WorkReasonNewParent indicates the new work builds on a different
+	// block than the previous work.
+	WorkReasonNewParent = "newparent"
+
+	// WorkReasonNewVotes indicates the new work includes more votes than
+	// the previous work.
+	WorkReasonNewVotes = "newvotes"
+
+	// WorkReasonNewTxns indicates the new work includes transactions which
+	// pay notably more fees than the previous work.
+	WorkReasonNewTxns = "newtxns"
 )
 
 // BlockConnectedNtfn defines the blockconnected JSON-RPC notification.
@@ -126,6 +150,38 @@ func NewRelevantTxAcceptedNtfn(txHex string) *RelevantTxAcceptedNtfn {
 	return &RelevantTxAcceptedNtfn{Transaction: txHex}
 }
 
+// TxReplacedNtfn defines the txreplaced JSON-RPC notification.
+type TxReplacedNtfn struct {
+	ReplacedTxID    string `json:"replacedtxid"`
+	ReplacementTxID string `json:"replacementtxid"`
+}
+
+// NewTxReplacedNtfn returns a new instance which can be used to issue a
+// txreplaced JSON-RPC notification.
+func NewTxReplacedNtfn(replacedTxID, replacementTxID string) *TxReplacedNtfn {
+	return &TxReplacedNtfn{
+		ReplacedTxID:    replacedTxID,
+		ReplacementTxID: replacementTxID,
+	}
+}
+
+// WorkNtfn defines the work JSON-RPC notification.
+type WorkNtfn struct {
+	Data   string `json:"data"`
+	Target string `json:"target"`
+	Reason string `json:"reason"`
+}
+
+// NewWorkNtfn returns a new instance which can be used to issue a work
+// JSON-RPC notification.
+func NewWorkNtfn(data, target, reason string) *WorkNtfn {
+	return &WorkNtfn{
+		Data:   data,
+		Target: target,
+		Reason: reason,
+	}
+}
+
 func init() {
 	// The commands in this file are only usable by websockets and are
 	// notifications.
@@ -137,4 +193,6 @@ func init() {
 	MustRegisterCmd(TxAcceptedNtfnMethod, (*TxAcceptedNtfn)(nil), flags)
 	MustRegisterCmd(TxAcceptedVerboseNtfnMethod, (*TxAcceptedVerboseNtfn)(nil), flags)
 	MustRegisterCmd(RelevantTxAcceptedNtfnMethod, (*RelevantTxAcceptedNtfn)(nil), flags)
+	MustRegisterCmd(TxReplacedNtfnMethod, (*TxReplacedNtfn)(nil), flags)
+	MustRegisterCmd(WorkNtfnMethod, (*WorkNtfn)(nil), flags)
 }
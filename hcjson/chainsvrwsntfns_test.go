@@ -128,6 +128,36 @@ func TestChainSvrWsNtfns(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "txreplaced",
+			newNtfn: func() (interface{}, error) {
+				return hcjson.NewCmd("txreplaced", "123", "456")
+			},
+			staticNtfn: func() interface{} {
+				return hcjson.NewTxReplacedNtfn("123", "456")
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"txreplaced","params":["123","456"],"id":null}`,
+			unmarshalled: &hcjson.TxReplacedNtfn{
+				ReplacedTxID:    "123",
+				ReplacementTxID: "456",
+			},
+		},
+		{
+			name: "work",
+			newNtfn: func() (interface{}, error) {
+				return hcjson.NewCmd("work", "00112233", "ffff", "newparent")
+			},
+			staticNtfn: func() interface{} {
+				return hcjson.NewWorkNtfn("00112233", "ffff",
+					hcjson.WorkReasonNewParent)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"work","params":["00112233","ffff","newparent"],"id":null}`,
+			unmarshalled: &hcjson.WorkNtfn{
+				Data:   "00112233",
+				Target: "ffff",
+				Reason: "newparent",
+			},
+		},
 	}
 
 	t.Logf("Running %d tests", len(tests))
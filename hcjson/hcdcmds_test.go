@@ -43,6 +43,43 @@ func TestDcrdCmds(t *testing.T) {
 				LevelSpec: "trace",
 			},
 		},
+		{
+			name: "getindexinfo",
+			newCmd: func() (interface{}, error) {
+				return hcjson.NewCmd("getindexinfo")
+			},
+			staticCmd: func() interface{} {
+				return hcjson.NewGetIndexInfoCmd()
+			},
+			marshalled:   `{"jsonrpc":"1.0","method":"getindexinfo","params":[],"id":1}`,
+			unmarshalled: &hcjson.GetIndexInfoCmd{},
+		},
+		{
+			name: "getmempoolrejects",
+			newCmd: func() (interface{}, error) {
+				return hcjson.NewCmd("getmempoolrejects")
+			},
+			staticCmd: func() interface{} {
+				return hcjson.NewGetMempoolRejectsCmd(nil)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"getmempoolrejects","params":[],"id":1}`,
+			unmarshalled: &hcjson.GetMempoolRejectsCmd{
+				TxHash: nil,
+			},
+		},
+		{
+			name: "getmempoolrejects optional",
+			newCmd: func() (interface{}, error) {
+				return hcjson.NewCmd("getmempoolrejects", "123")
+			},
+			staticCmd: func() interface{} {
+				return hcjson.NewGetMempoolRejectsCmd(hcjson.String("123"))
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"getmempoolrejects","params":["123"],"id":1}`,
+			unmarshalled: &hcjson.GetMempoolRejectsCmd{
+				TxHash: hcjson.String("123"),
+			},
+		},
 		{
 			name: "getstakeversions",
 			newCmd: func() (interface{}, error) {
@@ -70,6 +107,28 @@ func TestDcrdCmds(t *testing.T) {
 				Version: 1,
 			},
 		},
+		{
+			name: "loadmempool",
+			newCmd: func() (interface{}, error) {
+				return hcjson.NewCmd("loadmempool")
+			},
+			staticCmd: func() interface{} {
+				return hcjson.NewLoadMempoolCmd()
+			},
+			marshalled:   `{"jsonrpc":"1.0","method":"loadmempool","params":[],"id":1}`,
+			unmarshalled: &hcjson.LoadMempoolCmd{},
+		},
+		{
+			name: "savemempool",
+			newCmd: func() (interface{}, error) {
+				return hcjson.NewCmd("savemempool")
+			},
+			staticCmd: func() interface{} {
+				return hcjson.NewSaveMempoolCmd()
+			},
+			marshalled:   `{"jsonrpc":"1.0","method":"savemempool","params":[],"id":1}`,
+			unmarshalled: &hcjson.SaveMempoolCmd{},
+		},
 	}
 
 	t.Logf("Running %d tests", len(tests))
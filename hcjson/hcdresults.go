@@ -6,6 +6,36 @@
 
 package hcjson
 
+// GetIndexInfoResult models the data returned for each index from the
+// getindexinfo command.
+type GetIndexInfoResult struct {
+	Name   string `json:"name"`
+	Height int32  `json:"height"`
+	Synced bool   `json:"synced"`
+}
+
+// GetMempoolRejectsResult models the data returned for each transaction from
+// the getmempoolrejects command.
+type GetMempoolRejectsResult struct {
+	TxID   string `json:"txid"`
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+	Time   int64  `json:"time"`
+	Height int64  `json:"height"`
+}
+
+// LoadMempoolResult models the data returned from the loadmempool command.
+type LoadMempoolResult struct {
+	Accepted int `json:"accepted"`
+	Skipped  int `json:"skipped"`
+}
+
+// SaveMempoolResult models the data returned from the savemempool command.
+type SaveMempoolResult struct {
+	Filename     string `json:"filename"`
+	Transactions int    `json:"transactions"`
+}
+
 // GetStakeDifficultyResult models the data returned from the
 // getstakedifficulty command.
 type GetStakeDifficultyResult struct {
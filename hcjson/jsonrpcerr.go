@@ -82,4 +82,5 @@ const (
 const (
 	ErrRPCNoWallet      RPCErrorCode = -1
 	ErrRPCUnimplemented RPCErrorCode = -1
+	ErrRPCIndexSyncing  RPCErrorCode = -28
 )
@@ -0,0 +1,184 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	mrand "math/rand"
+
+	"github.com/nbit99/hcd/blockchain"
+	"github.com/nbit99/hcd/chaincfg"
+	"github.com/nbit99/hcd/chaincfg/chainhash"
+	"github.com/nbit99/hcd/wire"
+)
+
+// headerCommitmentPeriod is the number of headers a single bit is committed to
+// for during the headers pre-sync.  A peer which sends different headers when
+// they are downloaded again is detected with a probability of one half for each
+// period, while the memory used by the pre-sync stays small even for very long
+// chains.
+const headerCommitmentPeriod = 600
+
+// headersPresync downloads the headers of the chain of the sync peer without
+// storing them until the chain is shown to have at least the minimum chain work
+// of the network.  Only the last header, the cumulative work, and a compact
+// commitment to the headers are kept, so a peer can not make the node store a
+// long chain of cheap headers.  Once the minimum chain work is reached, the
+// headers are downloaded again and each one is verified against the
+// commitments before it is stored.
+type headersPresync struct {
+	chainParams *chaincfg.Params
+
+	// locator is the block locator the pre-sync started from.  It is used
+	// to download the headers again once the pre-sync is complete.
+	locator blockchain.BlockLocator
+
+	// hash, height, and work are the hash and height of the last header
+	// and the total work of the chain up to and including it.
+	hash   chainhash.Hash
+	height int64
+	work   *big.Int
+
+	// salt and offset randomize the commitments so a peer can not create
+	// headers which match them without knowing the headers it sent.
+	salt   [16]byte
+	offset int64
+	period int64
+
+	// commitments holds one bit per commitment period, and verified is the
+	// number of them which were verified by the headers downloaded again.
+	commitments []byte
+	numCommits  int
+	verified    int
+
+	// complete is set once the minimum chain work was reached at endHeight.
+	complete  bool
+	endHeight int64
+}
+
+// newHeadersPresync returns a new headers pre-sync which starts after the block
+// with the passed hash, height, and total chain work.
+func newHeadersPresync(params *chaincfg.Params, locator blockchain.BlockLocator,
+	hash *chainhash.Hash, height int64, work *big.Int) *headersPresync {
+
+	p := &headersPresync{
+		chainParams: params,
+		locator:     locator,
+		hash:        *hash,
+		height:      height,
+		work:        new(big.Int).Set(work),
+		period:      headerCommitmentPeriod,
+		offset:      mrand.Int63n(headerCommitmentPeriod),
+	}
+	rand.Read(p.salt[:])
+	return p
+}
+
+// needsHeadersPresync returns whether or not the headers after a best chain
+// with the passed total work must be pre-synced for the passed network.
+func needsHeadersPresync(params *chaincfg.Params, work *big.Int) bool {
+	return params.MinimumChainWork != nil &&
+		work.Cmp(params.MinimumChainWork) < 0
+}
+
+// commitmentBit returns the bit the header with the passed hash is committed
+// to with.
+func (p *headersPresync) commitmentBit(hash *chainhash.Hash) byte {
+	var data [len(p.salt) + chainhash.HashSize]byte
+	copy(data[:], p.salt[:])
+	copy(data[len(p.salt):], hash[:])
+	return chainhash.HashB(data[:])[0] & 1
+}
+
+// isCommitmentHeight returns whether or not a bit is committed to for the header
+// at the passed height.
+func (p *headersPresync) isCommitmentHeight(height int64) bool {
+	return height%p.period == p.offset
+}
+
+// processHeaders processes the passed headers, which must connect to the last
+// header processed before.  It returns an error when a header does not connect,
+// does not have a valid proof of work, or does not match a checkpoint.  The
+// headers which follow the one the minimum chain work is reached at are
+// ignored.
+func (p *headersPresync) processHeaders(headers []*wire.BlockHeader) error {
+	for _, header := range headers {
+		if p.complete {
+			break
+		}
+
+		if header.PrevBlock != p.hash {
+			return fmt.Errorf("header %v does not connect to the "+
+				"previous header %v", header.BlockHash(), p.hash)
+		}
+		height := p.height + 1
+		if int64(header.Height) != height {
+			return fmt.Errorf("header %v has height %d instead of "+
+				"%d", header.BlockHash(), header.Height, height)
+		}
+		err := blockchain.CheckHeaderProofOfWork(header,
+			p.chainParams.PowLimit)
+		if err != nil {
+			return err
+		}
+
+		hash := header.BlockHash()
+		for _, checkpoint := range p.chainParams.Checkpoints {
+			if checkpoint.Height == height &&
+				*checkpoint.Hash != hash {
+
+				return fmt.Errorf("header %v at height %d does "+
+					"not match checkpoint %v", hash, height,
+					checkpoint.Hash)
+			}
+		}
+
+		if p.isCommitmentHeight(height) {
+			if p.numCommits%8 == 0 {
+				p.commitments = append(p.commitments, 0)
+			}
+			bit := p.commitmentBit(&hash)
+			p.commitments[p.numCommits/8] |= bit << uint(p.numCommits%8)
+			p.numCommits++
+		}
+
+		p.hash = hash
+		p.height = height
+		p.work.Add(p.work, blockchain.CalcWork(header.Bits))
+		if p.work.Cmp(p.chainParams.MinimumChainWork) >= 0 {
+			p.complete = true
+			p.endHeight = height
+		}
+	}
+
+	return nil
+}
+
+// target returns the header the minimum chain work was reached at as the target
+// to download the headers up to again in place of a checkpoint.  It must only
+// be called once the pre-sync is complete.
+func (p *headersPresync) target() *chaincfg.Checkpoint {
+	hash := p.hash
+	return &chaincfg.Checkpoint{Height: p.endHeight, Hash: &hash}
+}
+
+// verifyHeader returns whether or not the passed header which was downloaded
+// again matches the commitments.  It must be called for every header in order
+// of increasing height.  Headers after the one the minimum chain work was
+// reached at are not verified.
+func (p *headersPresync) verifyHeader(hash *chainhash.Hash, height int64) bool {
+	if height > p.endHeight || !p.isCommitmentHeight(height) {
+		return true
+	}
+	if p.verified >= p.numCommits {
+		return false
+	}
+
+	want := (p.commitments[p.verified/8] >> uint(p.verified%8)) & 1
+	p.verified++
+	return p.commitmentBit(hash) == want
+}
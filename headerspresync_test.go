@@ -0,0 +1,324 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"container/list"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nbit99/hcd/blockchain"
+	"github.com/nbit99/hcd/chaincfg"
+	"github.com/nbit99/hcd/chaincfg/chainhash"
+	"github.com/nbit99/hcd/database"
+	"github.com/nbit99/hcd/peer"
+	"github.com/nbit99/hcd/txscript"
+	"github.com/nbit99/hcd/wire"
+)
+
+// solveHeader sets the nonce of the passed header so its hash is either less
+// than or more than the target difficulty depending on valid.
+func solveHeader(header *wire.BlockHeader, powLimit *big.Int, valid bool) {
+	for header.Nonce = 0; ; header.Nonce++ {
+		err := blockchain.CheckHeaderProofOfWork(header, powLimit)
+		if (err == nil) == valid {
+			return
+		}
+	}
+}
+
+// presyncTestHeaders returns a chain of num headers at the minimum difficulty
+// of the passed network which connects to the genesis block.  The headers
+// differ from the ones with another passed seed.
+func presyncTestHeaders(params *chaincfg.Params, num int, seed byte) []*wire.BlockHeader {
+	headers := make([]*wire.BlockHeader, 0, num)
+	prevHash := *params.GenesisHash
+	for i := 0; i < num; i++ {
+		header := &wire.BlockHeader{
+			Version:    1,
+			PrevBlock:  prevHash,
+			MerkleRoot: chainhash.Hash{seed},
+			Bits:       params.PowLimitBits,
+			Height:     uint32(i + 1),
+			Timestamp:  time.Unix(1500000000+int64(i), 0),
+		}
+		solveHeader(header, params.PowLimit, true)
+		headers = append(headers, header)
+		prevHash = header.BlockHash()
+	}
+	return headers
+}
+
+// TestHeadersPresync ensures the headers pre-sync is completed once the minimum
+// chain work is reached, that the headers downloaded again are verified against
+// the commitments, and that invalid headers are rejected.
+func TestHeadersPresync(t *testing.T) {
+	params := chaincfg.SimNetParams
+	blockWork := blockchain.CalcWork(params.PowLimitBits)
+	genesisWork := blockchain.CalcWork(params.GenesisBlock.Header.Bits)
+	params.MinimumChainWork = new(big.Int).Mul(blockWork, big.NewInt(10))
+	params.MinimumChainWork.Add(params.MinimumChainWork, genesisWork)
+
+	if !needsHeadersPresync(&params, genesisWork) {
+		t.Fatal("headers pre-sync not needed below the minimum chain work")
+	}
+	if needsHeadersPresync(&params, params.MinimumChainWork) {
+		t.Fatal("headers pre-sync needed at the minimum chain work")
+	}
+
+	newPresync := func() *headersPresync {
+		p := newHeadersPresync(&params, nil, params.GenesisHash, 0,
+			genesisWork)
+		p.salt = [16]byte{0x01}
+		p.period = 1
+		p.offset = 0
+		return p
+	}
+
+	headers := presyncTestHeaders(&params, 15, 0)
+	p := newPresync()
+	if err := p.processHeaders(headers[:5]); err != nil {
+		t.Fatalf("processHeaders: unexpected error: %v", err)
+	}
+	if p.complete {
+		t.Fatal("pre-sync completed before the minimum chain work")
+	}
+	if err := p.processHeaders(headers[5:]); err != nil {
+		t.Fatalf("processHeaders: unexpected error: %v", err)
+	}
+	if !p.complete || p.endHeight != 10 || p.height != 10 {
+		t.Fatalf("pre-sync not completed at height 10 - got complete "+
+			"%v, end height %d, height %d", p.complete, p.endHeight,
+			p.height)
+	}
+
+	// The same headers must match the commitments.
+	for _, header := range headers {
+		hash := header.BlockHash()
+		if !p.verifyHeader(&hash, int64(header.Height)) {
+			t.Fatalf("header at height %d does not match the "+
+				"commitments", header.Height)
+		}
+	}
+
+	// Different headers must not match all of the commitments.
+	p.verified = 0
+	matched := true
+	for _, header := range presyncTestHeaders(&params, 10, 1) {
+		hash := header.BlockHash()
+		if !p.verifyHeader(&hash, int64(header.Height)) {
+			matched = false
+			break
+		}
+	}
+	if matched {
+		t.Fatal("different headers match the commitments")
+	}
+
+	// Headers which do not connect, have the wrong height, or do not have
+	// a valid proof of work must be rejected.
+	disconnected := *headers[1]
+	wrongHeight := *headers[0]
+	wrongHeight.Height = 2
+	solveHeader(&wrongHeight, params.PowLimit, true)
+	highHash := *headers[0]
+	solveHeader(&highHash, params.PowLimit, false)
+	tests := []struct {
+		name   string
+		header *wire.BlockHeader
+	}{
+		{"does not connect", &disconnected},
+		{"wrong height", &wrongHeight},
+		{"high hash", &highHash},
+	}
+	for _, test := range tests {
+		p := newPresync()
+		err := p.processHeaders([]*wire.BlockHeader{test.header})
+		if err == nil {
+			t.Errorf("%s: header was not rejected", test.name)
+		}
+	}
+}
+
+// presyncTestManager returns a block manager with a new chain for the passed
+// network, which has no checkpoints, along with a sync candidate peer.
+func presyncTestManager(t *testing.T, params *chaincfg.Params) (*blockManager, *serverPeer, func()) {
+	dir, err := ioutil.TempDir("", "headerspresync")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	db, err := database.Create("ffldb", dir, params.Net)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("unable to create database: %v", err)
+	}
+	teardown := func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+	chain, err := blockchain.New(&blockchain.Config{
+		DB:          db,
+		ChainParams: params,
+		TimeSource:  blockchain.NewMedianTime(),
+		SigCache:    txscript.NewSigCache(1000),
+	})
+	if err != nil {
+		teardown()
+		t.Fatalf("unable to create chain: %v", err)
+	}
+
+	s := &server{chainParams: params}
+	bm := &blockManager{
+		server:              s,
+		chain:               chain,
+		requestedTxns:       make(map[chainhash.Hash]struct{}),
+		requestedEverTxns:   make(map[chainhash.Hash]uint8),
+		requestedBlocks:     make(map[chainhash.Hash]struct{}),
+		requestedEverBlocks: make(map[chainhash.Hash]uint8),
+		progressLogger:      newBlockProgressLogger("Processed", bmgrLog),
+		headerList:          list.New(),
+	}
+	sp := newServerPeer(s, false)
+	sp.Peer, err = peer.NewOutboundPeer(&peer.Config{ChainParams: params},
+		"127.0.0.1:18555")
+	if err != nil {
+		teardown()
+		t.Fatalf("unable to create peer: %v", err)
+	}
+	return bm, sp, teardown
+}
+
+// isDisconnected returns whether or not the passed peer was disconnected.
+func isDisconnected(sp *serverPeer) bool {
+	disconnected := make(chan struct{})
+	go func() {
+		sp.WaitForDisconnect()
+		close(disconnected)
+	}()
+	select {
+	case <-disconnected:
+		return true
+	case <-time.After(100 * time.Millisecond):
+		return false
+	}
+}
+
+// TestHeadersPresyncSync ensures the block manager pre-syncs the headers of the
+// sync peer without storing them when there are no checkpoints, downloads them
+// again up to the header the minimum chain work was reached at, and only then
+// fetches their blocks.  Peers with a chain without the minimum chain work and
+// peers which send different headers when they are downloaded again must be
+// disconnected.
+func TestHeadersPresyncSync(t *testing.T) {
+	// The log rotator is not initialized in tests.
+	setLogLevels("off")
+
+	params := chaincfg.SimNetParams
+	params.Checkpoints = nil
+	blockWork := blockchain.CalcWork(params.PowLimitBits)
+	genesisWork := blockchain.CalcWork(params.GenesisBlock.Header.Bits)
+	params.MinimumChainWork = new(big.Int).Mul(blockWork, big.NewInt(10))
+	params.MinimumChainWork.Add(params.MinimumChainWork, genesisWork)
+	headers := presyncTestHeaders(&params, 15, 0)
+
+	startSync := func(bm *blockManager, sp *serverPeer) {
+		peers := list.New()
+		peers.PushBack(sp)
+		bm.startSync(peers)
+		if bm.syncPeer != sp || !bm.headersFirstMode ||
+			bm.headersPresync == nil {
+
+			t.Fatal("startSync: headers pre-sync not started")
+		}
+		p := bm.headersPresync
+		p.salt = [16]byte{0x01}
+		p.period = 1
+		p.offset = 0
+	}
+	sendHeaders := func(bm *blockManager, sp *serverPeer, headers []*wire.BlockHeader) {
+		bm.handleHeadersMsg(&headersMsg{
+			headers: &wire.MsgHeaders{Headers: headers},
+			peer:    sp,
+		})
+	}
+
+	// The headers of a chain which ends without the minimum chain work are
+	// never stored.
+	bm, sp, teardown := presyncTestManager(t, &params)
+	startSync(bm, sp)
+	sendHeaders(bm, sp, headers[:9])
+	if !isDisconnected(sp) {
+		t.Fatal("peer without the minimum chain work not disconnected")
+	}
+	if bm.headerList.Len() != 1 || bm.nextCheckpoint != nil {
+		t.Fatalf("got %d headers in the header list and next "+
+			"checkpoint %v, want only the best block and none",
+			bm.headerList.Len(), bm.nextCheckpoint)
+	}
+	teardown()
+
+	// The headers are not stored while they are pre-synced.
+	bm, sp, teardown = presyncTestManager(t, &params)
+	defer teardown()
+	startSync(bm, sp)
+	sendHeaders(bm, sp, headers)
+	if isDisconnected(sp) {
+		t.Fatal("peer with the minimum chain work disconnected")
+	}
+	if !bm.headersPresync.complete || bm.headerList.Len() != 1 {
+		t.Fatalf("got pre-sync complete %v and %d headers in the "+
+			"header list, want complete and only the best block",
+			bm.headersPresync.complete, bm.headerList.Len())
+	}
+
+	// The headers are downloaded again up to the one the minimum chain
+	// work was reached at in place of a checkpoint.
+	endHash := headers[9].BlockHash()
+	if !bm.presyncTarget || bm.nextCheckpoint == nil ||
+		bm.nextCheckpoint.Height != 10 ||
+		*bm.nextCheckpoint.Hash != endHash {
+
+		t.Fatalf("got next checkpoint %v (pre-sync target %v), want "+
+			"height 10 hash %v", bm.nextCheckpoint, bm.presyncTarget,
+			endHash)
+	}
+
+	// Different headers downloaded again do not match the pre-synced ones
+	// and are not stored.
+	other := presyncTestHeaders(&params, 15, 1)
+	sendHeaders(bm, sp, other)
+	if !isDisconnected(sp) {
+		t.Fatal("peer which sent different headers not disconnected")
+	}
+	if len(bm.requestedBlocks) != 0 {
+		t.Fatalf("got %d blocks requested for different headers, want "+
+			"none", len(bm.requestedBlocks))
+	}
+
+	// The same headers downloaded again are stored up to the one the
+	// minimum chain work was reached at, and their blocks are fetched.
+	bm, sp, teardown2 := presyncTestManager(t, &params)
+	defer teardown2()
+	startSync(bm, sp)
+	sendHeaders(bm, sp, headers)
+	sendHeaders(bm, sp, headers)
+	if isDisconnected(sp) {
+		t.Fatal("peer which sent the same headers disconnected")
+	}
+	if bm.headerList.Len() != 10 || len(bm.requestedBlocks) != 10 {
+		t.Fatalf("got %d headers in the header list and %d blocks "+
+			"requested, want 10", bm.headerList.Len(),
+			len(bm.requestedBlocks))
+	}
+	for _, header := range headers[:10] {
+		if _, ok := bm.requestedBlocks[header.BlockHash()]; !ok {
+			t.Fatalf("block at height %d not requested",
+				header.Height)
+		}
+	}
+}
@@ -7,6 +7,7 @@
 package mempool
 
 import (
+	"container/heap"
 	"container/list"
 	"crypto/rand"
 	"fmt"
@@ -68,8 +69,41 @@ const (
 	// maxNullDataOutputs is the maximum number of OP_RETURN null data
 	// pushes in a transaction, after which it is considered non-standard.
 	maxNullDataOutputs = 4
+
+	// MaxRBFSequence is the maximum sequence number an input can use to
+	// signal that the transaction spending it can be replaced using the
+	// Replace-By-Fee (RBF) policy.
+	MaxRBFSequence = 0xfffffffd
+
+	// MaxReplacementEvictions is the maximum number of transactions that
+	// can be evicted from the pool when accepting a replacement
+	// transaction.
+	MaxReplacementEvictions = 100
+
+	// rollingFeeHalfLife is the half life of the dynamic minimum fee rate
+	// that is raised whenever transactions are evicted from a full pool.
+	// It decays faster while the pool is well below its size limit.
+	rollingFeeHalfLife = 12 * time.Hour
+
+	// orphanExpireScanInterval is the minimum amount of time in between
+	// scans of the orphan pool to evict expired transactions.
+	orphanExpireScanInterval = time.Minute * 5
 )
 
+// Tag represents an identifier to use for tagging orphan transactions.  The
+// caller may choose any scheme it desires, however it is common to use peer IDs
+// so that orphans can be identified by which peer first relayed them.
+type Tag uint64
+
+// orphanTx is a normal transaction that references an ancestor transaction
+// that is not yet available.  It also contains additional information related
+// to it such as an expiration time to help prevent caching the orphan forever.
+type orphanTx struct {
+	tx         *hcutil.Tx
+	tag        Tag
+	expiration time.Time
+}
+
 // VoteTx is a struct describing a block vote (SSGen).
 type VoteTx struct {
 	SsgenHash chainhash.Hash // Vote
@@ -134,6 +168,14 @@ type Config struct {
 	// to use for indexing the unconfirmed transactions in the memory pool.
 	// This can be nil if the address index is not enabled.
 	ExistsAddrIndex *indexers.ExistsAddrIndex
+
+	// OnTxReplaced defines an optional function to call for every
+	// transaction evicted from the pool by a replacement transaction,
+	// including the descendants of the transactions it conflicts with.
+	//
+	// This function is called with the mempool lock held, so it must not
+	// call back into the pool.
+	OnTxReplaced func(replaced, replacement *hcutil.Tx)
 }
 
 // Policy houses the policy (configuration parameters) which is used to
@@ -162,6 +204,23 @@ type Policy struct {
 	// that can be queued.
 	MaxOrphanTxs int
 
+	// MaxOrphanTxsPerTag is the maximum number of orphan transactions with
+	// the same tag, typically the peer they were received from, that can
+	// be queued.  The oldest orphan with the tag is evicted to make room
+	// for a new one.  Zero means only MaxOrphanTxs applies.
+	MaxOrphanTxsPerTag int
+
+	// OrphanTTL is the amount of time an orphan transaction is kept in the
+	// orphan pool before it expires and is evicted.  Zero means orphans do
+	// not expire.
+	OrphanTTL time.Duration
+
+	// MaxTxAge is the amount of time a regular transaction is kept in the
+	// pool before it is evicted along with the transactions spending it.
+	// Zero means regular transactions are kept until they are mined or
+	// expire by height.
+	MaxTxAge time.Duration
+
 	// MaxOrphanTxSize is the maximum size allowed for orphan transactions.
 	// This helps prevent memory exhaustion attacks from sending a lot of
 	// of big orphans.
@@ -176,6 +235,17 @@ type Policy struct {
 	// considered a non-zero fee.
 	MinRelayTxFee hcutil.Amount
 
+	// AcceptReplacement defines whether the pool accepts regular
+	// transactions that replace conflicting pool transactions which signal
+	// replaceability using the Replace-By-Fee (RBF) policy.
+	AcceptReplacement bool
+
+	// MaxPoolSize is the maximum total serialized size in bytes of all
+	// transactions in the pool.  Once it is exceeded, the regular
+	// transactions with the lowest fee rates are evicted along with their
+	// descendants.  A value of zero disables the limit.
+	MaxPoolSize int64
+
 	// AllowOldVotes defines whether or not votes on old blocks will be
 	// admitted and relayed.
 	AllowOldVotes bool
@@ -209,8 +279,9 @@ type TxPool struct {
 	mtx           sync.RWMutex
 	cfg           Config
 	pool          map[chainhash.Hash]*TxDesc
-	orphans       map[chainhash.Hash]*hcutil.Tx
+	orphans       map[chainhash.Hash]*orphanTx
 	orphansByPrev map[chainhash.Hash]map[chainhash.Hash]*hcutil.Tx
+	orphansByTag  map[Tag]int                            // number of orphans with each tag
 	addrindex     map[string]map[chainhash.Hash]struct{} // maps address to txs
 	outpoints     map[wire.OutPoint]*hcutil.Tx
 
@@ -220,6 +291,32 @@ type TxPool struct {
 
 	pennyTotal    float64 // exponentially decaying total for penny spends.
 	lastPennyUnix int64   // unix time of last ``penny spend''
+
+	// totalSize is the total serialized size of all transactions in the
+	// pool.
+	totalSize int64
+
+	// evictionEntries tracks the fees and sizes of the descendants of each
+	// transaction in the pool, and evictionHeap orders the regular ones by
+	// the fee rate they are evicted by when the pool is full.
+	evictionEntries map[chainhash.Hash]*evictionEntry
+	evictionHeap    evictionHeap
+
+	// rollingMinFee is the dynamic minimum fee rate in atoms/kB which is
+	// raised after evictions due to the pool size limit and decays over
+	// time.  lastRollingFeeUpdate is the last time it was decayed.
+	rollingMinFee        float64
+	lastRollingFeeUpdate time.Time
+
+	// nextExpireScan is the time after which the orphan pool will be
+	// scanned in order to evict orphans.  This is NOT a hard deadline as
+	// the scan will only run when an orphan is added to the pool as opposed
+	// to on an unconditional timer.
+	nextExpireScan time.Time
+
+	// recentRejects holds the transactions which were recently rejected
+	// from the pool.
+	recentRejects *recentRejects
 }
 
 // insertVote inserts a vote into the map of block votes.
@@ -318,10 +415,11 @@ var _ mining.TxSource = (*TxPool)(nil)
 func (mp *TxPool) removeOrphan(txHash *chainhash.Hash) {
 
 	// Nothing to do if passed tx is not an orphan.
-	tx, exists := mp.orphans[*txHash]
+	otx, exists := mp.orphans[*txHash]
 	if !exists {
 		return
 	}
+	tx := otx.tx
 	// Remove the reference from the previous orphan index.
 	for _, txIn := range tx.MsgTx().TxIn {
 		originTxHash := txIn.PreviousOutPoint.Hash
@@ -338,6 +436,10 @@ func (mp *TxPool) removeOrphan(txHash *chainhash.Hash) {
 
 	// Remove the transaction from the orphan pool.
 	delete(mp.orphans, *txHash)
+	mp.orphansByTag[otx.tag]--
+	if mp.orphansByTag[otx.tag] <= 0 {
+		delete(mp.orphansByTag, otx.tag)
+	}
 }
 
 // RemoveOrphan removes the passed orphan transaction from the orphan pool and
@@ -350,11 +452,86 @@ func (mp *TxPool) RemoveOrphan(txHash *chainhash.Hash) {
 	mp.mtx.Unlock()
 }
 
-// limitNumOrphans limits the number of orphan transactions by evicting a random
-// orphan if adding a new one would cause it to overflow the max allowed.
+// RemoveOrphansByTag removes all orphan transactions tagged with the provided
+// identifier.  It returns the number of orphans removed.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) RemoveOrphansByTag(tag Tag) int {
+	var numEvicted int
+	mp.mtx.Lock()
+	for txHash, otx := range mp.orphans {
+		if otx.tag == tag {
+			mp.removeOrphan(&txHash)
+			numEvicted++
+		}
+	}
+	mp.mtx.Unlock()
+
+	return numEvicted
+}
+
+// CountOrphansByTag returns the number of orphan transactions tagged with the
+// provided identifier.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) CountOrphansByTag(tag Tag) int {
+	mp.mtx.RLock()
+	count := mp.orphansByTag[tag]
+	mp.mtx.RUnlock()
+
+	return count
+}
+
+// limitNumOrphans limits the number of orphan transactions.  Expired orphans
+// are evicted first.  Then the oldest orphan with the passed tag is evicted if
+// adding a new one with the tag would exceed the max allowed per tag, and a
+// random orphan is evicted if adding a new one would cause the pool to
+// overflow the max allowed.
 //
 // This function MUST be called with the mempool lock held (for writes).
-func (mp *TxPool) limitNumOrphans() error {
+func (mp *TxPool) limitNumOrphans(tag Tag) error {
+	// Scan through the orphan pool and remove any expired orphans when it's
+	// time.  This is done for efficiency so the scan only happens
+	// periodically instead of on every orphan added to the pool.
+	if now := time.Now(); mp.cfg.Policy.OrphanTTL > 0 &&
+		now.After(mp.nextExpireScan) {
+
+		origNumOrphans := len(mp.orphans)
+		for txHash, otx := range mp.orphans {
+			if now.After(otx.expiration) {
+				mp.removeOrphan(&txHash)
+			}
+		}
+
+		// Set next expiration scan to occur after the scan interval.
+		mp.nextExpireScan = now.Add(orphanExpireScanInterval)
+
+		numOrphans := len(mp.orphans)
+		if numExpired := origNumOrphans - numOrphans; numExpired > 0 {
+			log.Debugf("Expired %d orphans (remaining: %d)",
+				numExpired, numOrphans)
+		}
+	}
+
+	// Evict the oldest orphan with the same tag when the tag is at its
+	// quota.
+	if mp.cfg.Policy.MaxOrphanTxsPerTag > 0 &&
+		mp.orphansByTag[tag]+1 > mp.cfg.Policy.MaxOrphanTxsPerTag {
+
+		var oldest *orphanTx
+		for _, otx := range mp.orphans {
+			if otx.tag != tag {
+				continue
+			}
+			if oldest == nil || otx.expiration.Before(oldest.expiration) {
+				oldest = otx
+			}
+		}
+		if oldest != nil {
+			mp.removeOrphan(oldest.tx.Hash())
+		}
+	}
+
 	if len(mp.orphans)+1 > mp.cfg.Policy.MaxOrphanTxs &&
 		mp.cfg.Policy.MaxOrphanTxs > 0 {
 
@@ -392,12 +569,18 @@ func (mp *TxPool) limitNumOrphans() error {
 // addOrphan adds an orphan transaction to the orphan pool.
 //
 // This function MUST be called with the mempool lock held (for writes).
-func (mp *TxPool) addOrphan(tx *hcutil.Tx) {
-	// Limit the number orphan transactions to prevent memory exhaustion.  A
-	// random orphan is evicted to make room if needed.
-	mp.limitNumOrphans()
-
-	mp.orphans[*tx.Hash()] = tx
+func (mp *TxPool) addOrphan(tx *hcutil.Tx, tag Tag) {
+	// Limit the number orphan transactions to prevent memory exhaustion.
+	// Expired orphans, the oldest orphan with the same tag, or a random
+	// orphan are evicted to make room if needed.
+	mp.limitNumOrphans(tag)
+
+	mp.orphans[*tx.Hash()] = &orphanTx{
+		tx:         tx,
+		tag:        tag,
+		expiration: time.Now().Add(mp.cfg.Policy.OrphanTTL),
+	}
+	mp.orphansByTag[tag]++
 	for _, txIn := range tx.MsgTx().TxIn {
 		originTxHash := txIn.PreviousOutPoint.Hash
 		if _, exists := mp.orphansByPrev[originTxHash]; !exists {
@@ -414,7 +597,7 @@ func (mp *TxPool) addOrphan(tx *hcutil.Tx) {
 // maybeAddOrphan potentially adds an orphan to the orphan pool.
 //
 // This function MUST be called with the mempool lock held (for writes).
-func (mp *TxPool) maybeAddOrphan(tx *hcutil.Tx) error {
+func (mp *TxPool) maybeAddOrphan(tx *hcutil.Tx, tag Tag) error {
 	// Ignore orphan transactions that are too large.  This helps avoid
 	// a memory exhaustion attack based on sending a lot of really large
 	// orphans.  In the case there is a valid transaction larger than this,
@@ -434,7 +617,7 @@ func (mp *TxPool) maybeAddOrphan(tx *hcutil.Tx) error {
 	}
 
 	// Add the orphan if the none of the above disqualified it.
-	mp.addOrphan(tx)
+	mp.addOrphan(tx, tag)
 
 	return nil
 }
@@ -571,7 +754,24 @@ func (mp *TxPool) removeTransaction(tx *hcutil.Tx, removeRedeemers bool) {
 		for _, txIn := range txDesc.Tx.MsgTx().TxIn {
 			delete(mp.outpoints, txIn.PreviousOutPoint)
 		}
+		entry := mp.evictionEntries[*txHash]
+		ancestors := mp.txAncestors(tx)
+		hasDescendants := mp.hasDescendants(entry)
+		delete(mp.evictionEntries, *txHash)
+		if entry.index >= 0 {
+			heap.Remove(&mp.evictionHeap, entry.index)
+		}
+
 		delete(mp.pool, *txHash)
+		mp.totalSize -= int64(msgTx.SerializeSize())
+
+		// The descendants of the transaction are no longer descendants
+		// of its ancestors when it is removed without them.
+		if hasDescendants {
+			mp.recalcEvictionEntries(ancestors)
+		} else {
+			mp.addToAncestors(ancestors, entry, -1)
+		}
 		atomic.StoreInt64(&mp.lastUpdated, time.Now().Unix())
 	}
 }
@@ -633,6 +833,30 @@ func (mp *TxPool) addTransaction(utxoView *blockchain.UtxoViewpoint,
 	for _, txIn := range msgTx.TxIn {
 		mp.outpoints[txIn.PreviousOutPoint] = tx
 	}
+	mp.totalSize += int64(msgTx.SerializeSize())
+
+	// Track the transaction for eviction and add it to the descendants of
+	// its ancestors.  A transaction which was added back to the pool, such
+	// as after a reorganization, might already have descendants in it.
+	entry := &evictionEntry{
+		tx:      tx,
+		stake:   txType != stake.TxTypeRegular,
+		fee:     fee,
+		size:    int64(msgTx.SerializeSize()),
+		pkgFees: fee,
+		pkgSize: int64(msgTx.SerializeSize()),
+		index:   -1,
+	}
+	mp.evictionEntries[*tx.Hash()] = entry
+	if !entry.stake {
+		heap.Push(&mp.evictionHeap, entry)
+	}
+	ancestors := mp.txAncestors(tx)
+	if mp.hasDescendants(entry) {
+		mp.recalcEvictionEntries(append(ancestors, entry))
+	} else {
+		mp.addToAncestors(ancestors, entry, 1)
+	}
 	atomic.StoreInt64(&mp.lastUpdated, time.Now().Unix())
 
 	// Add unconfirmed address index entries associated with the transaction
@@ -647,25 +871,548 @@ func (mp *TxPool) addTransaction(utxoView *blockchain.UtxoViewpoint,
 
 // checkPoolDoubleSpend checks whether or not the passed transaction is
 // attempting to spend coins already spent by other transactions in the pool.
-// Note it does not check for double spends against transactions already in the
-// main chain.
+// If it does, we'll check whether each of those transactions are signaling for
+// replacement.  If just one of them isn't, an error is returned.  Otherwise, a
+// boolean is returned signaling that the transaction is a replacement.  Note it
+// does not check for double spends against transactions already in the main
+// chain.
 //
 // This function MUST be called with the mempool lock held (for reads).
-func (mp *TxPool) checkPoolDoubleSpend(tx *hcutil.Tx, txType stake.TxType) error {
+func (mp *TxPool) checkPoolDoubleSpend(tx *hcutil.Tx, txType stake.TxType) (bool, error) {
+	var isReplacement bool
 	for i, txIn := range tx.MsgTx().TxIn {
 		// We don't care about double spends of stake bases.
 		if  i == 0 && (txType == stake.TxTypeSSGen || txType == stake.TxTypeSSRtx) {
 			continue
 		}
 
-		if txR, exists := mp.outpoints[txIn.PreviousOutPoint]; exists {
+		txR, exists := mp.outpoints[txIn.PreviousOutPoint]
+		if !exists {
+			continue
+		}
+
+		// Only regular transactions may replace other regular
+		// transactions which signal replaceability, and only when the
+		// replacement policy is enabled.
+		conflict, inPool := mp.pool[*txR.Hash()]
+		if !mp.cfg.Policy.AcceptReplacement ||
+			txType != stake.TxTypeRegular || !inPool ||
+			conflict.Type != stake.TxTypeRegular ||
+			!mp.signalsReplacement(txR, nil) {
+
 			str := fmt.Sprintf("transaction %v in the pool "+
 				"already spends the same coins", txR.Hash())
-			return txRuleError(wire.RejectDuplicate, str)
+			return false, txRuleError(wire.RejectDuplicate, str)
 		}
+
+		isReplacement = true
 	}
 
-	return nil
+	return isReplacement, nil
+}
+
+// signalsReplacement determines whether the passed transaction is signaling
+// that it can be replaced using the Replace-By-Fee (RBF) policy.  This policy
+// specifies two ways a transaction can signal that it is replaceable:
+//
+// Explicit signaling: A transaction is considered to have opted in to allowing
+// replacement of itself if any of its inputs have a sequence number less than
+// or equal to MaxRBFSequence.
+//
+// Inherited signaling: Transactions that don't explicitly signal
+// replaceability are replaceable under this policy for as long as any one of
+// their ancestors signals replaceability and remains unconfirmed.
+//
+// The cache is optional and tracks the transactions already visited which do
+// not signal replaceability.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) signalsReplacement(tx *hcutil.Tx,
+	cache map[chainhash.Hash]struct{}) bool {
+
+	if cache == nil {
+		cache = make(map[chainhash.Hash]struct{})
+	}
+
+	for _, txIn := range tx.MsgTx().TxIn {
+		if txIn.Sequence <= MaxRBFSequence {
+			return true
+		}
+
+		hash := txIn.PreviousOutPoint.Hash
+		parent, ok := mp.pool[hash]
+		if !ok {
+			continue
+		}
+		if _, visited := cache[hash]; visited {
+			continue
+		}
+		if mp.signalsReplacement(parent.Tx, cache) {
+			return true
+		}
+		cache[hash] = struct{}{}
+	}
+
+	return false
+}
+
+// validateReplacement determines whether the passed transaction, which is
+// known to conflict with transactions in the pool that signal replaceability,
+// satisfies the Replace-By-Fee (RBF) policy.  The replacement must:
+//
+//  - pay a higher fee rate than each of the transactions it directly conflicts
+//    with
+//  - not evict more than MaxReplacementEvictions transactions, counting the
+//    conflicts and all of their descendants
+//  - not evict any stake transactions
+//  - not spend outputs of any of the transactions it evicts
+//  - pay at least the total fees of the evicted transactions plus the minimum
+//    relay fee for its own size
+//
+// On success, the set of transactions that must be evicted from the pool in
+// order to accept the replacement is returned.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) validateReplacement(tx *hcutil.Tx,
+	txFee int64) (map[chainhash.Hash]*hcutil.Tx, error) {
+
+	txHash := tx.Hash()
+	txSize := int64(tx.MsgTx().SerializeSize())
+
+	evicts := make(map[chainhash.Hash]*TxDesc)
+	for _, txIn := range tx.MsgTx().TxIn {
+		txR, exists := mp.outpoints[txIn.PreviousOutPoint]
+		if !exists {
+			continue
+		}
+		conflictHash := txR.Hash()
+		conflict, exists := mp.pool[*conflictHash]
+		if !exists {
+			continue
+		}
+		if _, ok := evicts[*conflictHash]; ok {
+			continue
+		}
+
+		// The replacement must pay a strictly higher fee rate than each
+		// of the transactions it directly conflicts with.
+		conflictSize := int64(conflict.Tx.MsgTx().SerializeSize())
+		if txFee*conflictSize <= conflict.Fee*txSize {
+			str := fmt.Sprintf("replacement transaction %v has an "+
+				"insufficient fee rate: needs more than %v/kB, "+
+				"has %v/kB", txHash,
+				hcutil.Amount(conflict.Fee*1000/conflictSize),
+				hcutil.Amount(txFee*1000/txSize))
+			return nil, txRuleError(wire.RejectInsufficientFee, str)
+		}
+
+		evicts[*conflictHash] = conflict
+		for _, desc := range mp.txDescendants(conflict) {
+			evicts[*desc.Tx.Hash()] = desc
+		}
+		if len(evicts) > MaxReplacementEvictions {
+			str := fmt.Sprintf("replacement transaction %v evicts "+
+				"more transactions than permitted: max is %v",
+				txHash, MaxReplacementEvictions)
+			return nil, txRuleError(wire.RejectNonstandard, str)
+		}
+	}
+
+	// The replacement must not depend on any of the transactions it
+	// evicts since they would no longer exist.
+	for _, txIn := range tx.MsgTx().TxIn {
+		if _, ok := evicts[txIn.PreviousOutPoint.Hash]; ok {
+			str := fmt.Sprintf("replacement transaction %v spends "+
+				"output %v of a transaction it replaces", txHash,
+				txIn.PreviousOutPoint)
+			return nil, txRuleError(wire.RejectInvalid, str)
+		}
+	}
+
+	var evictedFees int64
+	replaced := make(map[chainhash.Hash]*hcutil.Tx, len(evicts))
+	for hash, desc := range evicts {
+		if desc.Type != stake.TxTypeRegular {
+			str := fmt.Sprintf("replacement transaction %v would "+
+				"evict stake transaction %v", txHash, hash)
+			return nil, txRuleError(wire.RejectNonstandard, str)
+		}
+		evictedFees += desc.Fee
+		replaced[hash] = desc.Tx
+	}
+
+	// The replacement must pay more than the transactions it evicts, and
+	// the additional fees must cover the relay of the replacement itself.
+	if txFee < evictedFees {
+		str := fmt.Sprintf("replacement transaction %v has an "+
+			"insufficient absolute fee: needs %v, has %v", txHash,
+			hcutil.Amount(evictedFees), hcutil.Amount(txFee))
+		return nil, txRuleError(wire.RejectInsufficientFee, str)
+	}
+	relayFee := calcMinRequiredTxRelayFee(txSize,
+		mp.cfg.Policy.MinRelayTxFee)
+	if txFee-evictedFees < relayFee {
+		str := fmt.Sprintf("replacement transaction %v does not pay "+
+			"for its own relay: needs %v more than the replaced "+
+			"fees of %v, has %v", txHash, hcutil.Amount(relayFee),
+			hcutil.Amount(evictedFees), hcutil.Amount(txFee))
+		return nil, txRuleError(wire.RejectInsufficientFee, str)
+	}
+
+	return replaced, nil
+}
+
+// txDescendants returns the descriptors of all transactions in the pool which
+// spend outputs of the passed transaction, either directly or by way of other
+// transactions in the pool.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) txDescendants(txDesc *TxDesc) []*TxDesc {
+	var descendants []*TxDesc
+	seen := map[chainhash.Hash]struct{}{*txDesc.Tx.Hash(): {}}
+	queue := []*TxDesc{txDesc}
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+
+		tree := wire.TxTreeRegular
+		if parent.Type != stake.TxTypeRegular {
+			tree = wire.TxTreeStake
+		}
+		parentHash := parent.Tx.Hash()
+		for i := range parent.Tx.MsgTx().TxOut {
+			outpoint := wire.OutPoint{Hash: *parentHash, Index: uint32(i),
+				Tree: tree}
+			redeemer, exists := mp.outpoints[outpoint]
+			if !exists {
+				continue
+			}
+			redeemerHash := redeemer.Hash()
+			if _, ok := seen[*redeemerHash]; ok {
+				continue
+			}
+			seen[*redeemerHash] = struct{}{}
+
+			desc, exists := mp.pool[*redeemerHash]
+			if !exists {
+				continue
+			}
+			descendants = append(descendants, desc)
+			queue = append(queue, desc)
+		}
+	}
+
+	return descendants
+}
+
+// evictionEntry tracks the fee and size of a transaction in the pool along
+// with the total fees and sizes of the package made up of it and all of its
+// descendants in the pool.  The totals are updated as transactions are added
+// to and removed from the pool so the pool can be limited to its maximum size
+// without walking the descendants of every transaction.
+type evictionEntry struct {
+	tx    *hcutil.Tx
+	stake bool
+	fee   int64
+	size  int64
+
+	// pkgFees and pkgSize are the total fees and sizes of the transaction
+	// and its descendants, and stakeDescendants is the number of stake
+	// transactions among the descendants.
+	pkgFees          int64
+	pkgSize          int64
+	stakeDescendants int
+
+	// index is the index of the entry in the eviction heap.  It is -1 for
+	// stake transactions, which are never evicted.
+	index int
+}
+
+// feeRate returns the fee rate in atoms/kB the transaction is evicted by.  It
+// is the higher of its own fee rate and the fee rate of its package, so that a
+// low fee parent is kept when a descendant pays for it.  Transactions with
+// stake descendants are never evicted, so their fee rate is infinite.
+func (e *evictionEntry) feeRate() float64 {
+	if e.stakeDescendants > 0 {
+		return math.Inf(1)
+	}
+	feeRate := float64(e.fee) * 1000 / float64(e.size)
+	pkgFeeRate := float64(e.pkgFees) * 1000 / float64(e.pkgSize)
+	if pkgFeeRate > feeRate {
+		return pkgFeeRate
+	}
+	return feeRate
+}
+
+// evictionHeap implements heap.Interface to order the regular transactions in
+// the pool by the fee rate they are evicted by, lowest first.
+type evictionHeap []*evictionEntry
+
+// Len returns the number of entries in the heap.  It is part of the
+// heap.Interface implementation.
+func (h evictionHeap) Len() int {
+	return len(h)
+}
+
+// Less returns whether the entry with index i should be evicted before the
+// entry with index j.  It is part of the heap.Interface implementation.
+func (h evictionHeap) Less(i, j int) bool {
+	return h[i].feeRate() < h[j].feeRate()
+}
+
+// Swap swaps the entries at the passed indices.  It is part of the
+// heap.Interface implementation.
+func (h evictionHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+// Push adds the passed entry to the end of the heap.  It is part of the
+// heap.Interface implementation.
+func (h *evictionHeap) Push(x interface{}) {
+	entry := x.(*evictionEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+// Pop removes the last entry of the heap.  It is part of the heap.Interface
+// implementation.
+func (h *evictionHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	entry.index = -1
+	*h = old[:len(old)-1]
+	return entry
+}
+
+// txAncestors returns the eviction entries of all transactions in the pool the
+// passed transaction spends outputs of, either directly or by way of other
+// transactions in the pool.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) txAncestors(tx *hcutil.Tx) []*evictionEntry {
+	var ancestors []*evictionEntry
+	seen := map[chainhash.Hash]struct{}{*tx.Hash(): {}}
+	queue := []*hcutil.Tx{tx}
+	for len(queue) > 0 {
+		child := queue[0]
+		queue = queue[1:]
+
+		for _, txIn := range child.MsgTx().TxIn {
+			parentHash := txIn.PreviousOutPoint.Hash
+			if _, ok := seen[parentHash]; ok {
+				continue
+			}
+			seen[parentHash] = struct{}{}
+
+			entry, exists := mp.evictionEntries[parentHash]
+			if !exists {
+				continue
+			}
+			ancestors = append(ancestors, entry)
+			queue = append(queue, entry.tx)
+		}
+	}
+
+	return ancestors
+}
+
+// hasDescendants returns whether or not any transaction in the pool spends an
+// output of the transaction with the passed entry.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) hasDescendants(entry *evictionEntry) bool {
+	tree := wire.TxTreeRegular
+	if entry.stake {
+		tree = wire.TxTreeStake
+	}
+	txHash := entry.tx.Hash()
+	for i := range entry.tx.MsgTx().TxOut {
+		outpoint := wire.OutPoint{Hash: *txHash, Index: uint32(i),
+			Tree: tree}
+		if _, exists := mp.outpoints[outpoint]; exists {
+			return true
+		}
+	}
+	return false
+}
+
+// updateEvictionEntry restores the order of the eviction heap after the totals
+// of the passed entry changed.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) updateEvictionEntry(entry *evictionEntry) {
+	if entry.index >= 0 {
+		heap.Fix(&mp.evictionHeap, entry.index)
+	}
+}
+
+// addToAncestors adds the fee and size of the transaction with the passed
+// entry, multiplied by sign, to the package totals of the passed ancestors.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) addToAncestors(ancestors []*evictionEntry, entry *evictionEntry, sign int) {
+	for _, ancestor := range ancestors {
+		ancestor.pkgFees += int64(sign) * entry.fee
+		ancestor.pkgSize += int64(sign) * entry.size
+		if entry.stake {
+			ancestor.stakeDescendants += sign
+		}
+		mp.updateEvictionEntry(ancestor)
+	}
+}
+
+// recalcEvictionEntries recalculates the package totals of the passed entries
+// from their descendants in the pool.  It is only needed when a transaction
+// with descendants is added to or removed from the pool, which is rare.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) recalcEvictionEntries(entries []*evictionEntry) {
+	for _, entry := range entries {
+		entry.pkgFees, entry.pkgSize = entry.fee, entry.size
+		entry.stakeDescendants = 0
+		for _, desc := range mp.txDescendants(mp.pool[*entry.tx.Hash()]) {
+			entry.pkgFees += desc.Fee
+			entry.pkgSize += int64(desc.Tx.MsgTx().SerializeSize())
+			if desc.Type != stake.TxTypeRegular {
+				entry.stakeDescendants++
+			}
+		}
+		mp.updateEvictionEntry(entry)
+	}
+}
+
+// limitPoolSize evicts the regular transactions with the lowest fee rates,
+// along with all of their descendants, until the total size of the pool is
+// within the configured limit.  Stake transactions, and regular transactions
+// with stake descendants, are never evicted.  The dynamic minimum fee rate is
+// raised above the fee rate of every evicted package.
+//
+// A transaction is scored by the higher of its own fee rate and the fee rate
+// of the package made up of it and its descendants, so that a low fee parent
+// is kept when a descendant pays for it.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) limitPoolSize() {
+	maxSize := mp.cfg.Policy.MaxPoolSize
+	if maxSize <= 0 || mp.totalSize <= maxSize {
+		return
+	}
+
+	var numEvicted int
+	var maxEvictedFeeRate float64
+	for mp.totalSize > maxSize && len(mp.evictionHeap) > 0 {
+		// Only transactions with stake descendants are left once the
+		// lowest fee rate is infinite.
+		entry := mp.evictionHeap[0]
+		feeRate := entry.feeRate()
+		if math.IsInf(feeRate, 1) {
+			break
+		}
+
+		log.Debugf("Evicting transaction %v with fee rate %.0f atoms/kB "+
+			"(pool size: %v bytes)", entry.tx.Hash(), feeRate,
+			mp.totalSize)
+		poolLen := len(mp.pool)
+		mp.removeTransaction(entry.tx, true)
+		numEvicted += poolLen - len(mp.pool)
+		if feeRate > maxEvictedFeeRate {
+			maxEvictedFeeRate = feeRate
+		}
+	}
+
+	if numEvicted == 0 {
+		if mp.totalSize > maxSize {
+			log.Warnf("Memory pool size of %v bytes exceeds the limit "+
+				"of %v bytes, but no transactions can be evicted",
+				mp.totalSize, maxSize)
+		}
+		return
+	}
+
+	// Raise the dynamic minimum fee rate so that new transactions must pay
+	// more than the evicted ones by at least the minimum relay fee rate.
+	newMinFee := maxEvictedFeeRate + float64(mp.cfg.Policy.MinRelayTxFee)
+	if newMinFee > mp.rollingMinFee {
+		mp.rollingMinFee = newMinFee
+		mp.lastRollingFeeUpdate = time.Now()
+	}
+	log.Infof("Evicted %d transactions to limit the memory pool size, "+
+		"minimum fee rate is now %v/kB", numEvicted,
+		hcutil.Amount(mp.rollingMinFee))
+}
+
+// dynamicMinFee returns the current dynamic minimum fee rate in atoms/kB after
+// decaying it for the time elapsed since it was last updated.  It returns zero
+// when the pool has not recently been full.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) dynamicMinFee() hcutil.Amount {
+	if mp.rollingMinFee == 0 {
+		return 0
+	}
+
+	// Decay the rate faster while the pool is well below its limit.
+	halfLife := rollingFeeHalfLife
+	maxSize := mp.cfg.Policy.MaxPoolSize
+	if mp.totalSize < maxSize/4 {
+		halfLife /= 4
+	} else if mp.totalSize < maxSize/2 {
+		halfLife /= 2
+	}
+
+	now := time.Now()
+	elapsed := now.Sub(mp.lastRollingFeeUpdate)
+	mp.rollingMinFee /= math.Pow(2, elapsed.Seconds()/halfLife.Seconds())
+	mp.lastRollingFeeUpdate = now
+
+	// Drop the dynamic rate entirely once it has decayed far enough to be
+	// irrelevant compared to the static minimum relay fee.
+	if mp.rollingMinFee < float64(mp.cfg.Policy.MinRelayTxFee)/2 {
+		mp.rollingMinFee = 0
+		return 0
+	}
+
+	return hcutil.Amount(mp.rollingMinFee)
+}
+
+// MinFeeRate returns the minimum fee rate per kB a regular transaction must
+// currently pay to be accepted into the pool.  This is the higher of the
+// minimum relay fee and the dynamic minimum fee which rises after
+// transactions are evicted due to the pool size limit.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) MinFeeRate() hcutil.Amount {
+	mp.mtx.Lock()
+	minFee := mp.dynamicMinFee()
+	mp.mtx.Unlock()
+
+	if minFee < mp.cfg.Policy.MinRelayTxFee {
+		minFee = mp.cfg.Policy.MinRelayTxFee
+	}
+	return minFee
+}
+
+// Size returns the total serialized size in bytes of all transactions in the
+// pool.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) Size() int64 {
+	mp.mtx.RLock()
+	size := mp.totalSize
+	mp.mtx.RUnlock()
+
+	return size
+}
+
+// MaxSize returns the configured maximum total serialized size in bytes of all
+// transactions in the pool.  Zero means the pool size is unlimited.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) MaxSize() int64 {
+	return mp.cfg.Policy.MaxPoolSize
 }
 
 // IsTxTreeValid checks the map of votes for a block to see if the tx
@@ -728,6 +1475,19 @@ func (mp *TxPool) fetchInputUtxos(tx *hcutil.Tx) (*blockchain.UtxoViewpoint, err
 	return utxoView, nil
 }
 
+// FetchInputUtxos loads utxo details about the input transactions referenced by
+// the passed transaction from the viewpoint of the main chain and the
+// transaction pool.  It allows transactions which are kept apart from the pool
+// to spend outputs of transactions in the pool.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) FetchInputUtxos(tx *hcutil.Tx) (*blockchain.UtxoViewpoint, error) {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	return mp.fetchInputUtxos(tx)
+}
+
 // FetchTransaction returns the requested transaction from the transaction pool.
 // This only fetches from the main transaction pool and does not include
 // orphans.
@@ -762,6 +1522,22 @@ func (mp *TxPool) FetchTransaction(txHash *chainhash.Hash, includeRecentBlock bo
 	return nil, fmt.Errorf("transaction is not in the pool")
 }
 
+// FetchTxDesc returns the descriptor of the requested transaction from the
+// main pool.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) FetchTxDesc(txHash *chainhash.Hash) (*TxDesc, error) {
+	// Protect concurrent access.
+	mp.mtx.RLock()
+	txDesc, exists := mp.pool[*txHash]
+	mp.mtx.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("transaction is not in the pool")
+	}
+	return txDesc, nil
+}
+
 // maybeAcceptTransaction is the internal function which implements the public
 // MaybeAcceptTransaction.  See the comment for MaybeAcceptTransaction for
 // more details.
@@ -867,6 +1643,7 @@ func (mp *TxPool) maybeAcceptTransaction(tx *hcutil.Tx, isNew, rateLimit, allowH
 	}
 
 	// Handle stake transaction double spending exceptions.
+	var isReplacement bool
 	if (txType == stake.TxTypeSSGen) || (txType == stake.TxTypeSSRtx) {
 		if txType == stake.TxTypeSSGen {
 			ssGenAlreadyFound := 0
@@ -909,7 +1686,11 @@ func (mp *TxPool) maybeAcceptTransaction(tx *hcutil.Tx, isNew, rateLimit, allowH
 		// at this point.  There is a more in-depth check that happens later
 		// after fetching the referenced transaction inputs from the main chain
 		// which examines the actual spend data and prevents double spends.
-		err = mp.checkPoolDoubleSpend(tx, txType)
+		//
+		// Regular transactions which signal replaceability may be
+		// replaced, in which case the replacement is validated once its
+		// fee is known.
+		isReplacement, err = mp.checkPoolDoubleSpend(tx, txType)
 		if err != nil {
 			return nil, err
 		}
@@ -1159,6 +1940,34 @@ func (mp *TxPool) maybeAcceptTransaction(tx *hcutil.Tx, isNew, rateLimit, allowH
 		}
 	}
 
+	// Don't allow regular transactions that do not pay the dynamic minimum
+	// fee rate, which is raised above the minimum relay fee after evictions
+	// due to the pool size limit.  Stake transactions are exempt since they
+	// are never evicted.
+	if isNew && txType == stake.TxTypeRegular {
+		if dynamicMinFee := mp.dynamicMinFee(); dynamicMinFee > 0 {
+			minPoolFee := calcMinRequiredTxRelayFee(serializedSize,
+				dynamicMinFee)
+			if txFee < minPoolFee {
+				str := fmt.Sprintf("transaction %v has %v fees which "+
+					"is under the mempool minimum fee of %v", txHash,
+					txFee, minPoolFee)
+				return nil, txRuleError(wire.RejectInsufficientFee, str)
+			}
+		}
+	}
+
+	// If the transaction conflicts with transactions in the pool, ensure it
+	// satisfies the replacement policy and determine which transactions it
+	// evicts.
+	var replacedTxns map[chainhash.Hash]*hcutil.Tx
+	if isReplacement {
+		replacedTxns, err = mp.validateReplacement(tx, txFee)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// Verify crypto signatures for each input and reject the transaction if
 	// any don't verify.
 	flags, err := mp.cfg.Policy.StandardVerifyFlags()
@@ -1174,6 +1983,17 @@ func (mp *TxPool) maybeAcceptTransaction(tx *hcutil.Tx, isNew, rateLimit, allowH
 		return nil, err
 	}
 
+	// Remove the transactions being replaced along with their descendants
+	// now that the replacement is known to be valid.
+	for _, replacedTx := range replacedTxns {
+		log.Debugf("Replacing transaction %v with %v", replacedTx.Hash(),
+			txHash)
+		mp.removeTransaction(replacedTx, true)
+		if mp.cfg.OnTxReplaced != nil {
+			mp.cfg.OnTxReplaced(replacedTx, tx)
+		}
+	}
+
 	// Add to transaction pool.
 	mp.addTransaction(utxoView, tx, txType, bestHeight, txFee)
 
@@ -1188,6 +2008,15 @@ func (mp *TxPool) maybeAcceptTransaction(tx *hcutil.Tx, isNew, rateLimit, allowH
 		}
 	}
 
+	// Evict the lowest fee rate transactions if the pool is now over its
+	// size limit.  This might include the transaction just added.
+	mp.limitPoolSize()
+	if !mp.isTransactionInPool(txHash) {
+		str := fmt.Sprintf("transaction %v was evicted because the "+
+			"mempool is full", txHash)
+		return nil, txRuleError(wire.RejectInsufficientFee, str)
+	}
+
 	log.Debugf("Accepted transaction %v (pool size: %v)", txHash,
 		len(mp.pool))
 
@@ -1251,6 +2080,7 @@ func (mp *TxPool) processOrphans(hash *chainhash.Hash) []*hcutil.Tx {
 			// leaving them in the orphan pool if not all parent
 			// transactions are known yet.
 			orphanHash := tx.Hash()
+			tag := mp.orphans[*orphanHash].tag
 			mp.removeOrphan(orphanHash)
 
 			// Potentially accept the transaction into the
@@ -1268,7 +2098,7 @@ func (mp *TxPool) processOrphans(hash *chainhash.Hash) []*hcutil.Tx {
 			if len(missingParents) > 0 {
 				// Transaction is still an orphan, so add it
 				// back.
-				mp.addOrphan(tx)
+				mp.addOrphan(tx, tag)
 				continue
 			}
 
@@ -1333,9 +2163,37 @@ func (mp *TxPool) PruneExpiredTx(height int64) {
 	// Protect concurrent access.
 	mp.mtx.Lock()
 	mp.pruneExpiredTx(height)
+	mp.pruneOldTx(time.Now())
 	mp.mtx.Unlock()
 }
 
+// pruneOldTx evicts the regular transactions which have been in the pool for
+// longer than the maximum transaction age along with the transactions which
+// spend them.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) pruneOldTx(now time.Time) {
+	if mp.cfg.Policy.MaxTxAge <= 0 {
+		return
+	}
+
+	cutoff := now.Add(-mp.cfg.Policy.MaxTxAge)
+	for _, txDesc := range mp.pool {
+		if txDesc.Type != stake.TxTypeRegular || !txDesc.Added.Before(cutoff) {
+			continue
+		}
+
+		// The transaction might have been removed already as a
+		// descendant of another old transaction.
+		if !mp.isTransactionInPool(txDesc.Tx.Hash()) {
+			continue
+		}
+		log.Debugf("Evicting transaction %v which has been in the "+
+			"mempool since %v", txDesc.Tx.Hash(), txDesc.Added)
+		mp.removeTransaction(txDesc.Tx, true)
+	}
+}
+
 func (mp *TxPool) pruneExpiredTx(height int64) {
 	for _, tx := range mp.pool {
 		if tx.Tx.MsgTx().Expiry != 0 {
@@ -1375,8 +2233,11 @@ func (mp *TxPool) ProcessOrphans(hash *chainhash.Hash) []*hcutil.Tx {
 // with any additional orphan transaactions that were added as a result of
 // the passed one being accepted.
 //
+// The tag is associated with the transaction when it is added to the orphan
+// pool, typically to identify the peer it was received from.
+//
 // This function is safe for concurrent access.
-func (mp *TxPool) ProcessTransaction(tx *hcutil.Tx, allowOrphan, rateLimit, allowHighFees bool) ([]*hcutil.Tx, error) {
+func (mp *TxPool) ProcessTransaction(tx *hcutil.Tx, allowOrphan, rateLimit, allowHighFees bool, tag Tag) ([]*hcutil.Tx, error) {
 	// Protect concurrent access.
 	mp.mtx.Lock()
 	defer mp.mtx.Unlock()
@@ -1385,6 +2246,15 @@ func (mp *TxPool) ProcessTransaction(tx *hcutil.Tx, allowOrphan, rateLimit, allo
 		if err != nil {
 			log.Tracef("Failed to process transaction %v: %s",
 				tx.Hash(), err.Error())
+
+			// Remember transactions which were rejected due to the
+			// rules so they are not downloaded and validated again
+			// and the reason can be looked up later.  Transactions
+			// which are already known are not rejections.
+			_, isRuleErr := err.(RuleError)
+			if isRuleErr && !mp.haveTransaction(tx.Hash()) {
+				mp.recordReject(tx.Hash(), err)
+			}
 		}
 	}()
 
@@ -1428,11 +2298,12 @@ func (mp *TxPool) ProcessTransaction(tx *hcutil.Tx, allowOrphan, rateLimit, allo
 		str := fmt.Sprintf("orphan transaction %v references "+
 			"outputs of unknown or fully-spent transaction %v",
 			tx.Hash(), missingParents[0])
-		return nil, txRuleError(wire.RejectDuplicate, str)
+		err = txRuleError(wire.RejectDuplicate, str)
+		return nil, err
 	}
 
 	// Potentially add the orphan transaction to the orphan pool.
-	err = mp.maybeAddOrphan(tx)
+	err = mp.maybeAddOrphan(tx, tag)
 	return nil, err
 }
 
@@ -1501,6 +2372,35 @@ func (mp *TxPool) MiningDescs() []*mining.TxDesc {
 	return descs
 }
 
+// PrioritiseTransaction adds the passed fee delta, which may be negative, to
+// the fee delta of the passed transaction in the pool and returns the resulting
+// fee delta.  The fee delta adjusts the fee the transaction is ranked by when
+// it is considered for inclusion in new blocks.  It does not change the fee
+// the transaction pays or how it is treated by the pool itself.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) PrioritiseTransaction(hash *chainhash.Hash, feeDelta int64) (int64, error) {
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	txDesc, exists := mp.pool[*hash]
+	if !exists {
+		return 0, fmt.Errorf("transaction is not in the pool")
+	}
+
+	// Replace the descriptor rather than modifying it since descriptors
+	// handed out by the pool are read without holding the lock.
+	newDesc := *txDesc
+	newDesc.FeeDelta += feeDelta
+	mp.pool[*hash] = &newDesc
+	atomic.StoreInt64(&mp.lastUpdated, time.Now().Unix())
+
+	log.Debugf("Prioritised transaction %v with fee delta %d (total %d)",
+		hash, feeDelta, newDesc.FeeDelta)
+
+	return newDesc.FeeDelta, nil
+}
+
 // RawMempoolVerbose returns all of the entries in the mempool filtered by the
 // provided stake type as a fully populated JSON result.  The filter type can be
 // nil in which case all transactions will be returned.
@@ -1584,11 +2484,14 @@ func (mp *TxPool) CheckIfTxsExist(hashes []chainhash.Hash) bool {
 // transactions until they are mined into a block.
 func New(cfg *Config) *TxPool {
 	return &TxPool{
-		cfg:           *cfg,
-		pool:          make(map[chainhash.Hash]*TxDesc),
-		orphans:       make(map[chainhash.Hash]*hcutil.Tx),
-		orphansByPrev: make(map[chainhash.Hash]map[chainhash.Hash]*hcutil.Tx),
-		outpoints:     make(map[wire.OutPoint]*hcutil.Tx),
-		votes:         make(map[chainhash.Hash][]VoteTx),
+		cfg:             *cfg,
+		pool:            make(map[chainhash.Hash]*TxDesc),
+		evictionEntries: make(map[chainhash.Hash]*evictionEntry),
+		orphans:         make(map[chainhash.Hash]*orphanTx),
+		orphansByPrev:   make(map[chainhash.Hash]map[chainhash.Hash]*hcutil.Tx),
+		orphansByTag:    make(map[Tag]int),
+		recentRejects:   newRecentRejects(),
+		outpoints:       make(map[wire.OutPoint]*hcutil.Tx),
+		votes:           make(map[chainhash.Hash][]VoteTx),
 	}
 }
@@ -473,7 +473,7 @@ func TestSimpleOrphanChain(t *testing.T) {
 	// none are evicted).
 	for _, tx := range chainedTxns[1 : maxOrphans+1] {
 		acceptedTxns, err := harness.txPool.ProcessTransaction(tx, true,
-			false, true)
+			false, true, 0)
 		if err != nil {
 			t.Fatalf("ProcessTransaction: failed to accept valid "+
 				"orphan %v", err)
@@ -507,7 +507,7 @@ func TestSimpleOrphanChain(t *testing.T) {
 	// to ensure it has no bearing on whether or not already existing
 	// orphans in the pool are linked.
 	acceptedTxns, err := harness.txPool.ProcessTransaction(chainedTxns[0],
-		false, false, true)
+		false, false, true, 0)
 	if err != nil {
 		t.Fatalf("ProcessTransaction: failed to accept valid "+
 			"orphan %v", err)
@@ -555,7 +555,7 @@ func TestOrphanReject(t *testing.T) {
 	// Ensure orphans are rejected when the allow orphans flag is not set.
 	for _, tx := range chainedTxns[1:] {
 		acceptedTxns, err := harness.txPool.ProcessTransaction(tx, false,
-			false, true)
+			false, true, 0)
 		if err == nil {
 			t.Fatalf("ProcessTransaction: did not fail on orphan "+
 				"%v when allow orphans flag is false", tx.Hash())
@@ -622,7 +622,7 @@ func TestOrphanEviction(t *testing.T) {
 	// all accepted.  This will cause an eviction.
 	for _, tx := range chainedTxns[1:] {
 		acceptedTxns, err := harness.txPool.ProcessTransaction(tx, true,
-			false, true)
+			false, true, 0)
 		if err != nil {
 			t.Fatalf("ProcessTransaction: failed to accept valid "+
 				"orphan %v", err)
@@ -674,6 +674,139 @@ func TestOrphanEviction(t *testing.T) {
 	}
 }
 
+// TestOrphanTags ensures the orphans with the same tag are limited to the per
+// tag quota by evicting the oldest ones, that orphans are removed by tag, and
+// that expired orphans are evicted.
+func TestOrphanTags(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	harness.txPool.cfg.Policy.MaxOrphanTxsPerTag = 2
+	harness.txPool.cfg.Policy.OrphanTTL = time.Hour
+
+	chainedTxns, err := harness.CreateTxChain(outputs[0], 6)
+	if err != nil {
+		t.Fatalf("unable to create transaction chain: %v", err)
+	}
+
+	// Add three orphans with the same tag so the oldest one is evicted.
+	for i, tx := range chainedTxns[1:4] {
+		_, err := harness.txPool.ProcessTransaction(tx, true, false,
+			true, 1)
+		if err != nil {
+			t.Fatalf("ProcessTransaction: failed to accept valid "+
+				"orphan %v", err)
+		}
+		harness.txPool.orphans[*tx.Hash()].expiration =
+			time.Now().Add(time.Duration(i) * time.Minute)
+	}
+	if harness.txPool.IsOrphanInPool(chainedTxns[1].Hash()) {
+		t.Fatal("IsOrphanInPool: true for oldest orphan over quota")
+	}
+	if count := harness.txPool.CountOrphansByTag(1); count != 2 {
+		t.Fatalf("CountOrphansByTag: got %d, want 2", count)
+	}
+
+	// Add orphans with a different tag which must not be affected by the
+	// quota of the first one.
+	for _, tx := range chainedTxns[4:] {
+		_, err := harness.txPool.ProcessTransaction(tx, true, false,
+			true, 2)
+		if err != nil {
+			t.Fatalf("ProcessTransaction: failed to accept valid "+
+				"orphan %v", err)
+		}
+	}
+	if count := harness.txPool.CountOrphansByTag(2); count != 2 {
+		t.Fatalf("CountOrphansByTag: got %d, want 2", count)
+	}
+
+	// Remove the orphans of the first tag.
+	if removed := harness.txPool.RemoveOrphansByTag(1); removed != 2 {
+		t.Fatalf("RemoveOrphansByTag: removed %d, want 2", removed)
+	}
+	for _, tx := range chainedTxns[1:4] {
+		if harness.txPool.IsOrphanInPool(tx.Hash()) {
+			t.Fatal("IsOrphanInPool: true for removed orphan")
+		}
+	}
+	if count := harness.txPool.CountOrphansByTag(1); count != 0 {
+		t.Fatalf("CountOrphansByTag: got %d, want 0", count)
+	}
+
+	// Expire the orphans of the second tag and ensure they are evicted
+	// when the next orphan is added.
+	for _, tx := range chainedTxns[4:] {
+		harness.txPool.orphans[*tx.Hash()].expiration =
+			time.Now().Add(-time.Minute)
+	}
+	harness.txPool.nextExpireScan = time.Now().Add(-time.Minute)
+	_, err = harness.txPool.ProcessTransaction(chainedTxns[2], true, false,
+		true, 3)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: failed to accept valid orphan %v",
+			err)
+	}
+	for _, tx := range chainedTxns[4:] {
+		if harness.txPool.IsOrphanInPool(tx.Hash()) {
+			t.Fatal("IsOrphanInPool: true for expired orphan")
+		}
+	}
+	if !harness.txPool.IsOrphanInPool(chainedTxns[2].Hash()) {
+		t.Fatal("IsOrphanInPool: false for accepted orphan")
+	}
+}
+
+// TestPrioritiseTransaction ensures fee deltas are accumulated on the mining
+// descriptors of transactions in the pool without changing their fees.
+func TestPrioritiseTransaction(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	tx, err := harness.CreateSignedTx(outputs, 1)
+	if err != nil {
+		t.Fatalf("unable to create transaction: %v", err)
+	}
+	_, err = harness.txPool.ProcessTransaction(tx, false, false, true, 0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: failed to accept valid transaction: "+
+			"%v", err)
+	}
+	fee := harness.txPool.pool[*tx.Hash()].Fee
+
+	for _, test := range []struct {
+		delta, want int64
+	}{
+		{10000, 10000},
+		{-2500, 7500},
+	} {
+		got, err := harness.txPool.PrioritiseTransaction(tx.Hash(),
+			test.delta)
+		if err != nil {
+			t.Fatalf("PrioritiseTransaction: unexpected error: %v", err)
+		}
+		if got != test.want {
+			t.Fatalf("PrioritiseTransaction: fee delta %d, want %d",
+				got, test.want)
+		}
+	}
+	descs := harness.txPool.MiningDescs()
+	if len(descs) != 1 || descs[0].FeeDelta != 7500 || descs[0].Fee != fee {
+		t.Fatalf("MiningDescs: unexpected descriptors %+v", descs)
+	}
+
+	unknown := chainhash.Hash{0x01}
+	if _, err := harness.txPool.PrioritiseTransaction(&unknown, 1); err == nil {
+		t.Fatal("PrioritiseTransaction: no error for unknown transaction")
+	}
+}
+
 // add test for tx lock 
 func TestTxLockPool(t *testing.T) {
 	t.Parallel()
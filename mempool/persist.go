@@ -0,0 +1,234 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/nbit99/hcd/chaincfg/chainhash"
+	"github.com/nbit99/hcd/hcutil"
+	"github.com/nbit99/hcd/wire"
+)
+
+const (
+	// poolFileVersion is the version of the serialized memory pool file
+	// format written by Save.
+	poolFileVersion = 1
+
+	// maxPoolFileEntries is the maximum number of transactions that will be
+	// read from a serialized memory pool file.  It protects against
+	// allocating huge amounts of memory due to a corrupt file.
+	maxPoolFileEntries = 10000000
+)
+
+// sortedForSave returns the descriptors of all transactions in the pool ordered
+// such that every transaction comes after the transactions in the pool it
+// spends.  This allows the transactions to be accepted back into the pool in
+// order without treating any of them as orphans.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) sortedForSave() []*TxDesc {
+	descs := make([]*TxDesc, 0, len(mp.pool))
+	visited := make(map[chainhash.Hash]struct{}, len(mp.pool))
+	var visit func(*TxDesc)
+	visit = func(txDesc *TxDesc) {
+		hash := *txDesc.Tx.Hash()
+		if _, ok := visited[hash]; ok {
+			return
+		}
+		visited[hash] = struct{}{}
+
+		for _, txIn := range txDesc.Tx.MsgTx().TxIn {
+			if parent, ok := mp.pool[txIn.PreviousOutPoint.Hash]; ok {
+				visit(parent)
+			}
+		}
+		descs = append(descs, txDesc)
+	}
+	for _, txDesc := range mp.pool {
+		visit(txDesc)
+	}
+
+	return descs
+}
+
+// Save writes all transactions in the pool along with the time they were first
+// seen and their fee deltas to the file at the passed path so they can be
+// restored with Load, for example after a restart.  The file is replaced
+// atomically.  It returns the number of transactions written.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) Save(path string) (int, error) {
+	tmpPath := path + ".new"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return 0, err
+	}
+
+	mp.mtx.RLock()
+	descs := mp.sortedForSave()
+	w := bufio.NewWriter(f)
+	err = writePoolFile(w, descs)
+	mp.mtx.RUnlock()
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return 0, err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return 0, err
+	}
+	return len(descs), nil
+}
+
+// writePoolFile serializes the passed transaction descriptors to w.  The format
+// is the file version and number of entries followed by, for each entry, the
+// serialized transaction, the unix time it was first seen, and its fee delta.
+func writePoolFile(w io.Writer, descs []*TxDesc) error {
+	err := binary.Write(w, binary.LittleEndian, uint32(poolFileVersion))
+	if err != nil {
+		return err
+	}
+	if err := wire.WriteVarInt(w, 0, uint64(len(descs))); err != nil {
+		return err
+	}
+	for _, txDesc := range descs {
+		if err := txDesc.Tx.MsgTx().Serialize(w); err != nil {
+			return err
+		}
+		entry := [2]int64{txDesc.Added.Unix(), txDesc.FeeDelta}
+		if err := binary.Write(w, binary.LittleEndian, entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Load reads the transactions written by Save from the file at the passed path
+// and submits them to the pool through the normal acceptance path, restoring
+// the time they were first seen and their fee deltas.  Transactions which have
+// expired or are no longer valid are skipped.  Loading stops early when the
+// quit channel is closed.
+//
+// It returns the transactions which were accepted, including any orphans they
+// made acceptable, and the number of transactions which were skipped.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) Load(path string, quit <-chan struct{}) ([]*hcutil.Tx, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	var version uint32
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return nil, 0, err
+	}
+	if version != poolFileVersion {
+		return nil, 0, fmt.Errorf("unsupported mempool file version %d",
+			version)
+	}
+	count, err := wire.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	if count > maxPoolFileEntries {
+		return nil, 0, fmt.Errorf("mempool file has too many entries "+
+			"(%d)", count)
+	}
+
+	var accepted []*hcutil.Tx
+	var skipped int
+	for i := uint64(0); i < count; i++ {
+		select {
+		case <-quit:
+			return accepted, skipped, nil
+		default:
+		}
+
+		var msgTx wire.MsgTx
+		if err := msgTx.Deserialize(r); err != nil {
+			return accepted, skipped, err
+		}
+		var entry [2]int64
+		err := binary.Read(r, binary.LittleEndian, &entry)
+		if err != nil {
+			return accepted, skipped, err
+		}
+		added, feeDelta := time.Unix(entry[0], 0), entry[1]
+
+		tx := hcutil.NewTx(&msgTx)
+		txs, err := mp.loadTransaction(tx, added, feeDelta)
+		if err != nil {
+			log.Debugf("Skipping saved transaction %v: %v", tx.Hash(),
+				err)
+			skipped++
+			continue
+		}
+		accepted = append(accepted, txs...)
+	}
+
+	return accepted, skipped, nil
+}
+
+// loadTransaction submits a transaction read from a saved memory pool file to
+// the pool and restores the time it was first seen and its fee delta.  It
+// returns the accepted transactions, which includes any orphans made
+// acceptable by it.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) loadTransaction(tx *hcutil.Tx, added time.Time,
+	feeDelta int64) ([]*hcutil.Tx, error) {
+
+	mp.mtx.Lock()
+	defer mp.mtx.Unlock()
+
+	// Don't bother validating transactions which would be pruned as
+	// expired right away.
+	expiry := tx.MsgTx().Expiry
+	if expiry != wire.NoExpiryValue &&
+		mp.cfg.BestHeight() >= int64(expiry) {
+
+		return nil, fmt.Errorf("transaction expired at height %d", expiry)
+	}
+
+	missingParents, err := mp.maybeAcceptTransaction(tx, true, false, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(missingParents) > 0 {
+		return nil, fmt.Errorf("transaction references outputs of "+
+			"unknown or fully-spent transaction %v", missingParents[0])
+	}
+
+	// The transaction might have been evicted right away when the pool is
+	// full.
+	txDesc, ok := mp.pool[*tx.Hash()]
+	if !ok {
+		return nil, fmt.Errorf("transaction was not added to the pool")
+	}
+	txDesc.Added = added
+	txDesc.FeeDelta = feeDelta
+
+	accepted := []*hcutil.Tx{tx}
+	return append(accepted, mp.processOrphans(tx.Hash())...), nil
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nbit99/hcd/chaincfg"
+)
+
+// TestSaveLoad ensures transactions saved from one pool are loaded into
+// another one in dependency order along with the time they were first seen and
+// their fee deltas, and that transactions which are already in the pool are
+// skipped.
+func TestSaveLoad(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	chainedTxns, err := harness.CreateTxChain(outputs[0], 4)
+	if err != nil {
+		t.Fatalf("unable to create transaction chain: %v", err)
+	}
+	for _, tx := range chainedTxns {
+		_, err := harness.txPool.ProcessTransaction(tx, false, false,
+			true, 0)
+		if err != nil {
+			t.Fatalf("ProcessTransaction: failed to accept valid "+
+				"transaction: %v", err)
+		}
+	}
+	added := time.Unix(1500000000, 0)
+	harness.txPool.pool[*chainedTxns[1].Hash()].Added = added
+	harness.txPool.pool[*chainedTxns[2].Hash()].FeeDelta = 5000
+
+	dir, err := ioutil.TempDir("", "mempooltest")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "mempool.dat")
+
+	saved, err := harness.txPool.Save(path)
+	if err != nil {
+		t.Fatalf("Save: unexpected error: %v", err)
+	}
+	if saved != len(chainedTxns) {
+		t.Fatalf("Save: saved %d transactions, want %d", saved,
+			len(chainedTxns))
+	}
+
+	// Load the transactions into a new pool backed by the same utxos.
+	loadHarness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	loadHarness.chain.utxos = harness.chain.utxos
+	accepted, skipped, err := loadHarness.txPool.Load(path, nil)
+	if err != nil {
+		t.Fatalf("Load: unexpected error: %v", err)
+	}
+	if len(accepted) != len(chainedTxns) || skipped != 0 {
+		t.Fatalf("Load: accepted %d and skipped %d transactions, want "+
+			"%d and 0", len(accepted), skipped, len(chainedTxns))
+	}
+	for _, tx := range chainedTxns {
+		if !loadHarness.txPool.IsTransactionInPool(tx.Hash()) {
+			t.Fatalf("Load: transaction %v not in pool", tx.Hash())
+		}
+	}
+	txDesc := loadHarness.txPool.pool[*chainedTxns[1].Hash()]
+	if !txDesc.Added.Equal(added) {
+		t.Fatalf("Load: added time %v, want %v", txDesc.Added, added)
+	}
+	txDesc = loadHarness.txPool.pool[*chainedTxns[2].Hash()]
+	if txDesc.FeeDelta != 5000 {
+		t.Fatalf("Load: fee delta %d, want 5000", txDesc.FeeDelta)
+	}
+
+	// Loading the same file again must skip all of the transactions since
+	// they are already in the pool.
+	accepted, skipped, err = loadHarness.txPool.Load(path, nil)
+	if err != nil {
+		t.Fatalf("Load: unexpected error: %v", err)
+	}
+	if len(accepted) != 0 || skipped != len(chainedTxns) {
+		t.Fatalf("Load: accepted %d and skipped %d transactions, want "+
+			"0 and %d", len(accepted), skipped, len(chainedTxns))
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"testing"
+
+	"github.com/nbit99/hcd/blockchain"
+	"github.com/nbit99/hcd/blockchain/stake"
+	"github.com/nbit99/hcd/chaincfg"
+	"github.com/nbit99/hcd/chaincfg/chainhash"
+	"github.com/nbit99/hcd/hcutil"
+	"github.com/nbit99/hcd/wire"
+)
+
+// poolSizeTestTx returns a regular transaction which spends the passed outputs.
+func poolSizeTestTx(prevOuts ...wire.OutPoint) *hcutil.Tx {
+	msgTx := wire.NewMsgTx()
+	for i := range prevOuts {
+		msgTx.AddTxIn(wire.NewTxIn(&prevOuts[i], nil))
+	}
+	msgTx.AddTxOut(wire.NewTxOut(1e8, make([]byte, 25)))
+	msgTx.AddTxOut(wire.NewTxOut(1e8, make([]byte, 25)))
+	return hcutil.NewTx(msgTx)
+}
+
+// outPoint returns the outpoint of the passed output of the passed transaction.
+func outPoint(tx *hcutil.Tx, index uint32) wire.OutPoint {
+	return wire.OutPoint{Hash: *tx.Hash(), Index: index,
+		Tree: wire.TxTreeRegular}
+}
+
+// checkEvictionEntries ensures the package totals which were updated as
+// transactions were added to and removed from the pool match the ones
+// calculated from scratch, and that the eviction heap holds exactly the
+// regular transactions in the pool.
+func checkEvictionEntries(t *testing.T, mp *TxPool) {
+	t.Helper()
+
+	if len(mp.evictionEntries) != len(mp.pool) {
+		t.Fatalf("got %d eviction entries for %d transactions",
+			len(mp.evictionEntries), len(mp.pool))
+	}
+	numRegular := 0
+	for hash, txDesc := range mp.pool {
+		entry, ok := mp.evictionEntries[hash]
+		if !ok {
+			t.Fatalf("no eviction entry for transaction %v", hash)
+		}
+		if txDesc.Type == stake.TxTypeRegular {
+			numRegular++
+			if entry.index < 0 || mp.evictionHeap[entry.index] != entry {
+				t.Fatalf("transaction %v is not in the eviction "+
+					"heap", hash)
+			}
+		}
+
+		want := *entry
+		mp.recalcEvictionEntries([]*evictionEntry{&want})
+		if entry.pkgFees != want.pkgFees ||
+			entry.pkgSize != want.pkgSize ||
+			entry.stakeDescendants != want.stakeDescendants {
+
+			t.Fatalf("transaction %v: got package fees %d, size %d, "+
+				"stake descendants %d, want %d, %d, %d", hash,
+				entry.pkgFees, entry.pkgSize,
+				entry.stakeDescendants, want.pkgFees,
+				want.pkgSize, want.stakeDescendants)
+		}
+	}
+	if len(mp.evictionHeap) != numRegular {
+		t.Fatalf("got %d eviction heap entries for %d regular "+
+			"transactions", len(mp.evictionHeap), numRegular)
+	}
+}
+
+// TestLimitPoolSize ensures the package totals the pool is limited by are kept
+// up to date as transactions are added and removed, and that the transactions
+// with the lowest fee rates are evicted along with their descendants while low
+// fee parents of high fee transactions are kept.
+func TestLimitPoolSize(t *testing.T) {
+	t.Parallel()
+
+	harness, _, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	mp := harness.txPool
+	view := blockchain.NewUtxoViewpoint()
+	add := func(tx *hcutil.Tx, fee int64) {
+		mp.addTransaction(view, tx, stake.TxTypeRegular, 1, fee)
+		checkEvictionEntries(t, mp)
+	}
+
+	// A chain of three transactions with low fees, the first of which has
+	// the lowest package fee rate, a low fee transaction
+	// whose child pays for it, and an unrelated transaction with a medium
+	// fee.
+	grandparent := poolSizeTestTx(wire.OutPoint{Hash: chainhash.Hash{0x01}})
+	parent := poolSizeTestTx(outPoint(grandparent, 0))
+	child := poolSizeTestTx(outPoint(parent, 0), outPoint(grandparent, 1))
+	cheap := poolSizeTestTx(wire.OutPoint{Hash: chainhash.Hash{0x02}})
+	payer := poolSizeTestTx(outPoint(cheap, 0))
+	medium := poolSizeTestTx(wire.OutPoint{Hash: chainhash.Hash{0x03}})
+	add(grandparent, 0)
+	add(parent, 100)
+	add(child, 100)
+	add(cheap, 0)
+	add(payer, 100000)
+	add(medium, 10000)
+
+	entry := mp.evictionEntries[*grandparent.Hash()]
+	if entry.pkgFees != 200 {
+		t.Fatalf("got package fees %d for the grandparent, want 200",
+			entry.pkgFees)
+	}
+
+	// Removing a transaction without its descendants, such as when it is
+	// mined, removes it from the packages of its ancestors.  The child
+	// stays in the package of the grandparent since it also spends an
+	// output of it directly.
+	mp.removeTransaction(parent, false)
+	checkEvictionEntries(t, mp)
+	if entry.pkgFees != 100 {
+		t.Fatalf("got package fees %d for the grandparent, want 100",
+			entry.pkgFees)
+	}
+	add(parent, 100)
+
+	// Limit the pool to all but one transaction so the package with the
+	// lowest fee rate is evicted in full.
+	size := func(txns ...*hcutil.Tx) int64 {
+		var size int64
+		for _, tx := range txns {
+			size += int64(tx.MsgTx().SerializeSize())
+		}
+		return size
+	}
+	mp.cfg.Policy.MaxPoolSize = mp.totalSize - 1
+	mp.limitPoolSize()
+	checkEvictionEntries(t, mp)
+	for _, tx := range []*hcutil.Tx{grandparent, parent, child} {
+		if mp.isTransactionInPool(tx.Hash()) {
+			t.Fatalf("transaction %v of the lowest fee package was "+
+				"not evicted", tx.Hash())
+		}
+	}
+	if want := size(cheap, payer, medium); mp.totalSize != want {
+		t.Fatalf("got pool size %d, want %d", mp.totalSize, want)
+	}
+	if mp.rollingMinFee == 0 {
+		t.Fatal("dynamic minimum fee not raised after the eviction")
+	}
+
+	// The unrelated transaction is evicted before the low fee transaction
+	// whose child pays for it.
+	mp.cfg.Policy.MaxPoolSize = mp.totalSize - 1
+	mp.limitPoolSize()
+	checkEvictionEntries(t, mp)
+	if mp.isTransactionInPool(medium.Hash()) {
+		t.Fatal("transaction with a medium fee was not evicted")
+	}
+	if !mp.isTransactionInPool(cheap.Hash()) ||
+		!mp.isTransactionInPool(payer.Hash()) {
+
+		t.Fatal("low fee transaction with a high fee child was evicted")
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"time"
+
+	"github.com/nbit99/hcd/chaincfg/chainhash"
+	"github.com/nbit99/hcd/wire"
+)
+
+// maxRecentRejects is the maximum number of recently rejected transactions
+// which are remembered.  The oldest rejection is forgotten to make room for a
+// new one once the limit is reached.
+const maxRecentRejects = 5000
+
+// RejectedTx describes a transaction which was recently rejected from the
+// memory pool.
+type RejectedTx struct {
+	// Hash is the hash of the rejected transaction.
+	Hash chainhash.Hash
+
+	// Code is the reject code associated with the reason for the
+	// rejection.
+	Code wire.RejectCode
+
+	// Reason is a human-readable description of why the transaction was
+	// rejected.
+	Reason string
+
+	// Time is the time the transaction was rejected.
+	Time time.Time
+
+	// Height is the height of the best chain at the time the transaction
+	// was rejected.
+	Height int64
+
+	// bestHash is the hash of the best block at the time the transaction
+	// was rejected.  A transaction rejected on top of a different best
+	// block might be valid now.
+	bestHash chainhash.Hash
+}
+
+// recentRejects is a rolling cache of transactions which were recently
+// rejected from the memory pool.
+type recentRejects struct {
+	entries []RejectedTx
+	next    int
+	index   map[chainhash.Hash]int
+}
+
+// newRecentRejects returns a new empty cache of recently rejected
+// transactions.
+func newRecentRejects() *recentRejects {
+	return &recentRejects{
+		entries: make([]RejectedTx, 0, maxRecentRejects),
+		index:   make(map[chainhash.Hash]int),
+	}
+}
+
+// add records the passed rejected transaction, replacing any previous
+// rejection of the same transaction and forgetting the oldest rejection if the
+// cache is full.
+func (r *recentRejects) add(rejected RejectedTx) {
+	if i, ok := r.index[rejected.Hash]; ok {
+		r.entries[i] = rejected
+		return
+	}
+
+	if len(r.entries) < maxRecentRejects {
+		r.index[rejected.Hash] = len(r.entries)
+		r.entries = append(r.entries, rejected)
+		return
+	}
+
+	delete(r.index, r.entries[r.next].Hash)
+	r.entries[r.next] = rejected
+	r.index[rejected.Hash] = r.next
+	r.next = (r.next + 1) % maxRecentRejects
+}
+
+// lookup returns the recorded rejection of the passed transaction if there is
+// one.
+func (r *recentRejects) lookup(hash *chainhash.Hash) (*RejectedTx, bool) {
+	i, ok := r.index[*hash]
+	if !ok {
+		return nil, false
+	}
+	return &r.entries[i], true
+}
+
+// newestFirst returns all recorded rejections ordered from the most to the
+// least recent one.
+func (r *recentRejects) newestFirst() []RejectedTx {
+	rejects := make([]RejectedTx, 0, len(r.entries))
+	for i := 1; i <= len(r.entries); i++ {
+		idx := (r.next - i + len(r.entries)) % len(r.entries)
+		rejects = append(rejects, r.entries[idx])
+	}
+	return rejects
+}
+
+// recordReject remembers that the passed transaction was rejected with the
+// passed rule error.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) recordReject(txHash *chainhash.Hash, err error) {
+	code, reason := ErrToRejectErr(err)
+	mp.recentRejects.add(RejectedTx{
+		Hash:     *txHash,
+		Code:     code,
+		Reason:   reason,
+		Time:     time.Now(),
+		Height:   mp.cfg.BestHeight(),
+		bestHash: *mp.cfg.BestHash(),
+	})
+}
+
+// IsRecentlyRejected returns whether or not the passed transaction was rejected
+// since the current best block was connected.  Transactions which were
+// rejected on top of an earlier best block are not reported since they might
+// have become valid.  Callers can use it to avoid downloading and validating
+// the same invalid transaction again.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) IsRecentlyRejected(hash *chainhash.Hash) bool {
+	mp.mtx.RLock()
+	rejected, ok := mp.recentRejects.lookup(hash)
+	rejectedNow := ok && rejected.bestHash == *mp.cfg.BestHash()
+	mp.mtx.RUnlock()
+
+	return rejectedNow
+}
+
+// RecentRejects returns the transactions which were recently rejected from the
+// pool along with the reasons, ordered from the most to the least recent one.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) RecentRejects() []RejectedTx {
+	mp.mtx.RLock()
+	rejects := mp.recentRejects.newestFirst()
+	mp.mtx.RUnlock()
+
+	return rejects
+}
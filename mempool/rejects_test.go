@@ -0,0 +1,106 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"testing"
+
+	"github.com/nbit99/hcd/chaincfg"
+	"github.com/nbit99/hcd/chaincfg/chainhash"
+	"github.com/nbit99/hcd/wire"
+)
+
+// TestRecentRejectsRolling ensures the cache of recently rejected transactions
+// forgets the oldest rejections once full and returns them most recent first.
+func TestRecentRejectsRolling(t *testing.T) {
+	t.Parallel()
+
+	r := newRecentRejects()
+	numRejects := maxRecentRejects + 10
+	for i := 0; i < numRejects; i++ {
+		r.add(RejectedTx{Hash: chainhash.Hash{byte(i), byte(i >> 8)}})
+	}
+
+	rejects := r.newestFirst()
+	if len(rejects) != maxRecentRejects {
+		t.Fatalf("got %d rejects, want %d", len(rejects),
+			maxRecentRejects)
+	}
+	for i, rejected := range rejects {
+		n := numRejects - 1 - i
+		want := chainhash.Hash{byte(n), byte(n >> 8)}
+		if rejected.Hash != want {
+			t.Fatalf("reject #%d: got %v, want %v", i, rejected.Hash,
+				want)
+		}
+	}
+	for i := 0; i < numRejects-maxRecentRejects; i++ {
+		hash := chainhash.Hash{byte(i), byte(i >> 8)}
+		if _, ok := r.lookup(&hash); ok {
+			t.Fatalf("lookup: found forgotten reject %v", hash)
+		}
+	}
+}
+
+// TestRecentlyRejected ensures transactions rejected by the pool are
+// remembered along with the reason, and that they are only reported as
+// recently rejected until the best block changes.
+func TestRecentlyRejected(t *testing.T) {
+	t.Parallel()
+
+	harness, outputs, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	chainedTxns, err := harness.CreateTxChain(outputs[0], 2)
+	if err != nil {
+		t.Fatalf("unable to create transaction chain: %v", err)
+	}
+
+	// Reject an orphan.
+	orphan := chainedTxns[1]
+	_, err = harness.txPool.ProcessTransaction(orphan, false, false, true, 0)
+	if err == nil {
+		t.Fatal("ProcessTransaction: accepted orphan")
+	}
+	if !harness.txPool.IsRecentlyRejected(orphan.Hash()) {
+		t.Fatal("IsRecentlyRejected: false for rejected transaction")
+	}
+	rejects := harness.txPool.RecentRejects()
+	if len(rejects) != 1 || rejects[0].Hash != *orphan.Hash() ||
+		rejects[0].Code != wire.RejectDuplicate {
+
+		t.Fatalf("RecentRejects: unexpected rejects %v", rejects)
+	}
+
+	// Transactions which are accepted are not rejections.
+	_, err = harness.txPool.ProcessTransaction(chainedTxns[0], false, false,
+		true, 0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: failed to accept valid "+
+			"transaction: %v", err)
+	}
+	_, err = harness.txPool.ProcessTransaction(chainedTxns[0], false, false,
+		true, 0)
+	if err == nil {
+		t.Fatal("ProcessTransaction: accepted duplicate transaction")
+	}
+	if harness.txPool.IsRecentlyRejected(chainedTxns[0].Hash()) {
+		t.Fatal("IsRecentlyRejected: true for duplicate transaction")
+	}
+
+	// The rejection must be kept but no longer reported as recent once the
+	// best block changed.
+	harness.chain.Lock()
+	harness.chain.currentHash = chainhash.Hash{0x01}
+	harness.chain.Unlock()
+	if harness.txPool.IsRecentlyRejected(orphan.Hash()) {
+		t.Fatal("IsRecentlyRejected: true after best block changed")
+	}
+	if len(harness.txPool.RecentRejects()) != 1 {
+		t.Fatal("RecentRejects: rejection forgotten after best block " +
+			"changed")
+	}
+}
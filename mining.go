@@ -55,16 +55,90 @@ const (
 // which have not been mined into a block yet.
 type txPrioItem struct {
 	tx       *hcutil.Tx
+	desc     *mining.TxDesc
 	txType   stake.TxType
-	fee      int64
+	size     int64
 	priority float64
+
+	// fee is the modified fee of the transaction as returned by the
+	// transaction selector which is used to rank it.  The fee it actually
+	// pays is kept by desc.
+	fee int64
+
+	// feePerKB is the fee per kilobyte of the package made up of the
+	// transaction and all of its ancestors in the source pool which have
+	// not been included in the block yet, as described by pkgFee and
+	// pkgSize.  Sorting by the package fee rate allows a high fee child to
+	// pay for its parents.
 	feePerKB float64
+	pkgFee   int64
+	pkgSize  int64
 
 	// dependsOn holds a map of transaction hashes which this one depends
 	// on.  It will only be set when the transaction references other
 	// transactions in the source pool and hence must come after them in
 	// a block.
 	dependsOn map[chainhash.Hash]struct{}
+
+	// included is set once the transaction has been added to the block.
+	// deferred is set while the transaction is out of the priority queue
+	// waiting for the transactions it depends on to be included.
+	included bool
+	deferred bool
+}
+
+// setPackageStats updates the package fee details of the transaction given its
+// ancestors which have not been included in the block yet.  It returns whether
+// or not the package fee rate changed.
+func (item *txPrioItem) setPackageStats(ancestors []*txPrioItem) bool {
+	fee, size := item.fee, item.size
+	for _, ancestor := range ancestors {
+		fee += ancestor.fee
+		size += ancestor.size
+	}
+	feePerKB := (float64(fee) * float64(kilobyte)) / float64(size)
+	changed := feePerKB != item.feePerKB
+
+	item.pkgFee = fee
+	item.pkgSize = size
+	item.feePerKB = feePerKB
+	return changed
+}
+
+// ancestorPackage returns the ancestors of the passed regular transaction which
+// have not been included in the block yet, ordered such that every ancestor
+// comes after all of its own ancestors.  The returned flag is false when the
+// transaction can't be included along with its ancestors because one of them
+// is not available for inclusion or is a stake transaction.
+func ancestorPackage(item *txPrioItem,
+	items map[chainhash.Hash]*txPrioItem) ([]*txPrioItem, bool) {
+
+	var ancestors []*txPrioItem
+	visited := make(map[chainhash.Hash]struct{})
+	var visit func(*txPrioItem) bool
+	visit = func(item *txPrioItem) bool {
+		for hash := range item.dependsOn {
+			if _, ok := visited[hash]; ok {
+				continue
+			}
+			visited[hash] = struct{}{}
+
+			parent, ok := items[hash]
+			if !ok || parent.txType != stake.TxTypeRegular {
+				return false
+			}
+			if !visit(parent) {
+				return false
+			}
+			ancestors = append(ancestors, parent)
+		}
+		return true
+	}
+	if !visit(item) {
+		return nil, false
+	}
+
+	return ancestors, true
 }
 
 // txPriorityQueueLessFunc describes a function that can be used as a compare
@@ -1285,6 +1359,11 @@ func NewBlockTemplate(policy *mining.Policy, server *server,
 	// in the block once each transaction has been included.
 	dependers := make(map[chainhash.Hash]map[chainhash.Hash]*txPrioItem)
 
+	// prioItems holds all of the transactions which are candidates for
+	// inclusion so the ancestors of regular transactions can be found when
+	// selecting them as a package.
+	prioItems := make(map[chainhash.Hash]*txPrioItem, len(sourceTxns))
+
 	// Create slices to hold the fees and number of signature operations
 	// for each of the selected transactions and add an entry for the
 	// coinbase.  This allows the code below to simply append details about
@@ -1297,6 +1376,10 @@ func NewBlockTemplate(policy *mining.Policy, server *server,
 	txSigOpCountsMap := make(map[chainhash.Hash]int64)
 	txFees = append(txFees, -1) // Updated once known
 
+	// The selector decides which of the transactions are candidates and
+	// ranks them by their modified fees.
+	selector := policy.NewTxSelector()
+
 	minrLog.Debugf("Considering %d transactions for inclusion to new block",
 		len(sourceTxns))
 	treeValid := mp.IsTxTreeValid(prevHash)
@@ -1317,6 +1400,12 @@ mempoolLoop:
 			minrLog.Tracef("Skipping non-finalized tx %s", tx.Hash())
 			continue
 		}
+		modifiedFee, ok := selector.Consider(txDesc)
+		if !ok {
+			minrLog.Tracef("Skipping tx %s excluded by the selection "+
+				"policy", tx.Hash())
+			continue
+		}
 
 		// Need this for a check below for stake base input, and to check
 		// the ticket number.
@@ -1351,7 +1440,8 @@ mempoolLoop:
 		// Setup dependencies for any transactions which reference
 		// other transactions in the mempool so they can be properly
 		// ordered below.
-		prioItem := &txPrioItem{tx: txDesc.Tx, txType: txDesc.Type}
+		prioItem := &txPrioItem{tx: txDesc.Tx, desc: txDesc,
+			txType: txDesc.Type}
 		for i, txIn := range tx.MsgTx().TxIn {
 			// Evaluate if this is a stakebase input or not. If it is, continue
 			// without evaluation of the input.
@@ -1398,21 +1488,15 @@ mempoolLoop:
 		prioItem.priority = mempool.CalcPriority(tx.MsgTx(), utxos,
 			nextBlockHeight)
 
-		// Calculate the fee in Atoms/KB.
+		// Record the modified fee and size used to calculate the fee in
+		// Atoms/KB once the ancestors of the transaction are known.
 		// NOTE: This is a more precise value than the one calculated
 		// during calcMinRelayFee which rounds up to the nearest full
 		// kilobyte boundary.  This is beneficial since it provides an
 		// incentive to create smaller transactions.
-		txSize := tx.MsgTx().SerializeSize()
-		prioItem.feePerKB = (float64(txDesc.Fee) * float64(kilobyte)) /
-			float64(txSize)
-		prioItem.fee = txDesc.Fee
-
-		// Add the transaction to the priority queue to mark it ready
-		// for inclusion in the block unless it has dependencies.
-		if prioItem.dependsOn == nil {
-			heap.Push(priorityQueue, prioItem)
-		}
+		prioItem.fee = modifiedFee
+		prioItem.size = int64(tx.MsgTx().SerializeSize())
+		prioItems[*tx.Hash()] = prioItem
 
 		// Merge the referenced outputs from the input transactions to
 		// this transaction into the block utxo view.  This allows the
@@ -1420,6 +1504,25 @@ mempoolLoop:
 		mergeUtxoView(blockUtxos, utxos)
 	}
 
+	// Add the transactions to the priority queue to mark them ready for
+	// inclusion in the block.  Regular transactions are added along with
+	// their package fee rate even when they have dependencies since they
+	// may be selected together with their ancestors, while stake
+	// transactions wait until their dependencies have been included.
+	for _, prioItem := range prioItems {
+		if prioItem.txType == stake.TxTypeRegular {
+			ancestors, _ := ancestorPackage(prioItem, prioItems)
+			prioItem.setPackageStats(ancestors)
+			heap.Push(priorityQueue, prioItem)
+			continue
+		}
+
+		prioItem.setPackageStats(nil)
+		if prioItem.dependsOn == nil {
+			heap.Push(priorityQueue, prioItem)
+		}
+	}
+
 	minrLog.Tracef("Priority queue len %d, dependers len %d",
 		priorityQueue.Len(), len(dependers))
 
@@ -1447,11 +1550,76 @@ mempoolLoop:
 		foundWinningTickets[ticketHash] = false
 	}
 
+	// pkgQueue holds the remaining transactions of the package currently
+	// being added to the block, in dependency order, along with the fee
+	// rate of the package.  deferredItems holds the regular transactions
+	// which were removed from the priority queue until their dependencies
+	// have been included.
+	var pkgQueue, deferredItems []*txPrioItem
+	var pkgFeePerKB float64
+
 	// Choose which transactions make it into the block.
-	for priorityQueue.Len() > 0 {
-		// Grab the highest priority (or highest fee per kilobyte
-		// depending on the sort order) transaction.
-		prioItem := heap.Pop(priorityQueue).(*txPrioItem)
+	for priorityQueue.Len() > 0 || len(pkgQueue) > 0 {
+		// Grab the next transaction of the package being added, if any.
+		// Otherwise, grab the highest priority (or highest package fee
+		// per kilobyte depending on the sort order) transaction.
+		var prioItem *txPrioItem
+		inPackage := len(pkgQueue) > 0
+		if inPackage {
+			prioItem = pkgQueue[0]
+			pkgQueue = pkgQueue[1:]
+
+			// The rest of the package can't be included when one
+			// of its transactions was skipped.
+			if len(prioItem.dependsOn) != 0 {
+				minrLog.Tracef("Skipping tx %s since one of its "+
+					"ancestors was skipped", prioItem.tx.Hash())
+				pkgQueue = nil
+				continue
+			}
+		} else {
+			prioItem = heap.Pop(priorityQueue).(*txPrioItem)
+			if prioItem.included {
+				continue
+			}
+		}
+
+		// Regular transactions which depend on other transactions that
+		// are not in the block yet are added together with those
+		// ancestors as a package once sorting by fees.  The package fee
+		// rate changes as ancestors are included, so the transaction is
+		// put back into the priority queue when its rate is stale.
+		if !inPackage && prioItem.txType == stake.TxTypeRegular {
+			ancestors, ok := ancestorPackage(prioItem, prioItems)
+			if !ok || (!sortedByFee && len(ancestors) != 0) {
+				prioItem.deferred = true
+				deferredItems = append(deferredItems, prioItem)
+				continue
+			}
+			if prioItem.setPackageStats(ancestors) {
+				heap.Push(priorityQueue, prioItem)
+				continue
+			}
+			if len(ancestors) != 0 {
+				pkgSize := uint32(prioItem.pkgSize)
+				if blockSize+pkgSize < blockSize ||
+					blockSize+pkgSize >= policy.BlockMaxSize {
+					minrLog.Tracef("Skipping tx %s (package size "+
+						"%v) because it would exceed the max "+
+						"block size; cur block size %v, cur "+
+						"num tx %v", prioItem.tx.Hash(),
+						pkgSize, blockSize, len(blockTxns))
+					continue
+				}
+
+				pkgQueue = append(ancestors, prioItem)
+				pkgFeePerKB = prioItem.feePerKB
+				continue
+			}
+		}
+		if !inPackage {
+			pkgFeePerKB = prioItem.feePerKB
+		}
 		tx := prioItem.tx
 
 		// Store if this is an SStx or not.
@@ -1555,13 +1723,13 @@ mempoolLoop:
 		// Skip free transactions once the block is larger than the
 		// minimum block size, except for stake transactions.
 		if sortedByFee &&
-			(prioItem.feePerKB < float64(policy.TxMinFreeFee)) &&
+			(pkgFeePerKB < float64(policy.TxMinFreeFee)) &&
 			(tx.Tree() != wire.TxTreeStake) &&
 			(blockPlusTxSize >= policy.BlockMinSize) {
 
 			minrLog.Tracef("Skipping tx %s with feePerKB %.2f "+
 				"< TxMinFreeFee %d and block size %d >= "+
-				"minBlockSize %d", tx.Hash(), prioItem.feePerKB,
+				"minBlockSize %d", tx.Hash(), pkgFeePerKB,
 				policy.TxMinFreeFee, blockPlusTxSize,
 				policy.BlockMinSize)
 			logSkippedDeps(tx, deps)
@@ -1583,6 +1751,17 @@ mempoolLoop:
 			sortedByFee = true
 			priorityQueue.SetLessFunc(txPQByStakeAndFee)
 
+			// Transactions which were deferred because their
+			// ancestors were not in the block yet may now be added
+			// along with them as a package.
+			for _, item := range deferredItems {
+				if item.deferred {
+					item.deferred = false
+					heap.Push(priorityQueue, item)
+				}
+			}
+			deferredItems = nil
+
 			// Put the transaction back into the priority queue and
 			// skip it so it is re-priortized by fees if it won't
 			// fit into the high-priority section or the priority is
@@ -1618,6 +1797,14 @@ mempoolLoop:
 			continue
 		}
 
+		// Finally, let the selection policy have the last word.
+		if !selector.Select(prioItem.desc, blockSize) {
+			minrLog.Tracef("Skipping tx %s rejected by the selection "+
+				"policy", tx.Hash())
+			logSkippedDeps(tx, deps)
+			continue
+		}
+
 		// Spend the transaction inputs in the block utxo view and add
 		// an entry for it to ensure any transactions which reference
 		// this one have it available as an input and can ensure they
@@ -1645,20 +1832,36 @@ mempoolLoop:
 			foundWinningTickets[tx.MsgTx().TxIn[1].PreviousOutPoint.Hash] = true
 		}
 
-		txFeesMap[*tx.Hash()] = prioItem.fee
+		txFeesMap[*tx.Hash()] = prioItem.desc.Fee
 		txSigOpCountsMap[*tx.Hash()] = numSigOps
+		prioItem.included = true
 
-		minrLog.Tracef("Adding tx %s (priority %.2f, feePerKB %.2f)",
-			prioItem.tx.Hash(), prioItem.priority, prioItem.feePerKB)
+		minrLog.Tracef("Adding tx %s (priority %.2f, modified feePerKB "+
+			"%.2f, package feePerKB %.2f)", prioItem.tx.Hash(),
+			prioItem.priority, float64(prioItem.fee)*kilobyte/
+				float64(prioItem.size), pkgFeePerKB)
 
 		// Add transactions which depend on this one (and also do not
 		// have any other unsatisified dependencies) to the priority
-		// queue.
+		// queue.  Regular transactions remain in the priority queue
+		// while waiting on their dependencies unless they were
+		// deferred, and their package fee rates are refreshed when
+		// they are next popped.  Deferred transactions are given
+		// another chance to be added as a package when sorting by fees.
 		for _, item := range deps {
 			// Add the transaction to the priority queue if there
 			// are no more dependencies after this one.
 			delete(item.dependsOn, *tx.Hash())
-			if len(item.dependsOn) == 0 {
+			if item.deferred {
+				if sortedByFee || len(item.dependsOn) == 0 {
+					item.deferred = false
+					heap.Push(priorityQueue, item)
+				}
+				continue
+			}
+			if item.txType != stake.TxTypeRegular &&
+				len(item.dependsOn) == 0 {
+
 				heap.Push(priorityQueue, item)
 			}
 		}
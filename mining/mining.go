@@ -31,6 +31,11 @@ type TxDesc struct {
 
 	// Fee is the total fee the transaction associated with the entry pays.
 	Fee int64
+
+	// FeeDelta is an adjustment applied to the fee of the transaction
+	// associated with the entry when it is considered for inclusion in new
+	// blocks.
+	FeeDelta int64
 }
 
 // TxSource represents a source of transactions to consider for inclusion in
@@ -27,4 +27,9 @@ type Policy struct {
 	// required for a transaction to be treated as free for mining purposes
 	// (block template generation).
 	TxMinFreeFee hcutil.Amount
+
+	// TxSelection is the policy which decides which transactions are
+	// included in block templates and how they are ranked.  The default
+	// selection policy is used when it is nil.
+	TxSelection SelectionPolicy
 }
@@ -0,0 +1,84 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mining
+
+// SelectionPolicy is consulted when generating block templates to decide which
+// transactions from the source pool are included and how they are ranked.
+// It allows miners to, for example, prioritize their own transactions, exclude
+// transactions with certain scripts, or limit the share of a block given to a
+// single sender.
+type SelectionPolicy interface {
+	// NewSelector returns a selector which tracks the transactions
+	// selected for a single new block template.  It is called once for
+	// every template that is generated.
+	NewSelector() TxSelector
+}
+
+// TxSelector decides which transactions are selected for a single block
+// template.
+//
+// NewBlockTemplate first calls Consider for every candidate transaction in the
+// source pool.  It then visits the accepted transactions in the order of their
+// modified fees (or priority) and calls Select for each one that passed all of
+// the consensus and size checks.  Returning true from Select adds the
+// transaction to the block.
+type TxSelector interface {
+	// Consider returns whether or not the transaction described by the
+	// passed descriptor is a candidate for inclusion in the block along
+	// with its modified fee.  The modified fee is used in place of the
+	// fee the transaction pays when ranking it by fee per kilobyte.  It
+	// does not change the fees collected by the block.
+	Consider(desc *TxDesc) (modifiedFee int64, ok bool)
+
+	// Select returns whether or not the transaction described by the
+	// passed descriptor is added to the block given the size of the block
+	// before it.  It is called in the order transactions are added to the
+	// block.
+	Select(desc *TxDesc, blockSize uint32) bool
+}
+
+// defaultSelectionPolicy is the selection policy used when none is configured.
+// It considers every transaction and ranks it by the fee it pays adjusted by
+// the fee delta of its descriptor.
+type defaultSelectionPolicy struct{}
+
+// NewSelector returns the default selector.
+//
+// This is part of the SelectionPolicy interface implementation.
+func (defaultSelectionPolicy) NewSelector() TxSelector {
+	return defaultSelectionPolicy{}
+}
+
+// Consider returns the fee of the transaction adjusted by the fee delta of the
+// descriptor.
+//
+// This is part of the TxSelector interface implementation.
+func (defaultSelectionPolicy) Consider(desc *TxDesc) (int64, bool) {
+	return desc.Fee + desc.FeeDelta, true
+}
+
+// Select always adds the transaction to the block.
+//
+// This is part of the TxSelector interface implementation.
+func (defaultSelectionPolicy) Select(desc *TxDesc, blockSize uint32) bool {
+	return true
+}
+
+// DefaultSelectionPolicy returns the selection policy used when the policy
+// does not configure one.  It considers every transaction from the source pool
+// and ranks them by the fee they pay adjusted by the fee delta of their
+// descriptors.
+func DefaultSelectionPolicy() SelectionPolicy {
+	return defaultSelectionPolicy{}
+}
+
+// NewTxSelector returns a selector for a new block template from the configured
+// selection policy, or from the default policy when none is configured.
+func (p *Policy) NewTxSelector() TxSelector {
+	if p.TxSelection == nil {
+		return DefaultSelectionPolicy().NewSelector()
+	}
+	return p.TxSelection.NewSelector()
+}
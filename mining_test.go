@@ -12,6 +12,7 @@ import (
 	"testing"
 
 	"github.com/nbit99/hcd/blockchain/stake"
+	"github.com/nbit99/hcd/chaincfg/chainhash"
 )
 
 // TestStakeTxFeePrioHeap tests the priority heaps including the stake types for
@@ -128,3 +129,57 @@ func TestStakeTxFeePrioHeap(t *testing.T) {
 		}
 	}
 }
+
+// TestAncestorPackage ensures the unmined ancestors of a transaction are found
+// in dependency order and that the package fee rate accounts for them so a high
+// fee child pays for a low fee parent.
+func TestAncestorPackage(t *testing.T) {
+	hashA := chainhash.Hash{0x01}
+	hashB := chainhash.Hash{0x02}
+	hashC := chainhash.Hash{0x03}
+	hashTicket := chainhash.Hash{0x04}
+	a := &txPrioItem{txType: stake.TxTypeRegular, fee: 0, size: 250}
+	b := &txPrioItem{txType: stake.TxTypeRegular, fee: 1000, size: 250,
+		dependsOn: map[chainhash.Hash]struct{}{hashA: {}}}
+	c := &txPrioItem{txType: stake.TxTypeRegular, fee: 20000, size: 500,
+		dependsOn: map[chainhash.Hash]struct{}{hashA: {}, hashB: {}}}
+	ticket := &txPrioItem{txType: stake.TxTypeSStx, fee: 5000, size: 300}
+	d := &txPrioItem{txType: stake.TxTypeRegular, fee: 5000, size: 250,
+		dependsOn: map[chainhash.Hash]struct{}{hashTicket: {}}}
+	items := map[chainhash.Hash]*txPrioItem{
+		hashA: a, hashB: b, hashC: c, hashTicket: ticket,
+	}
+
+	ancestors, ok := ancestorPackage(c, items)
+	if !ok || len(ancestors) != 2 || ancestors[0] != a || ancestors[1] != b {
+		t.Fatalf("unexpected ancestors of child: %v (ok %v)", ancestors, ok)
+	}
+	c.setPackageStats(ancestors)
+	if c.pkgFee != 21000 || c.pkgSize != 1000 || c.feePerKB != 21000 {
+		t.Fatalf("unexpected package stats: fee %d, size %d, feePerKB %v",
+			c.pkgFee, c.pkgSize, c.feePerKB)
+	}
+
+	// The package shrinks as ancestors are included in the block.
+	delete(c.dependsOn, hashA)
+	delete(b.dependsOn, hashA)
+	ancestors, ok = ancestorPackage(c, items)
+	if !ok || len(ancestors) != 1 || ancestors[0] != b {
+		t.Fatalf("unexpected ancestors of child: %v (ok %v)", ancestors, ok)
+	}
+	if !c.setPackageStats(ancestors) || c.feePerKB != 28000 {
+		t.Fatalf("unexpected package feePerKB %v", c.feePerKB)
+	}
+
+	// Transactions depending on stake transactions or unknown transactions
+	// can't be selected as a package.
+	if _, ok := ancestorPackage(d, items); ok {
+		t.Fatal("package with stake ancestor unexpectedly allowed")
+	}
+	delete(items, hashA)
+	a.dependsOn = nil
+	b.dependsOn = map[chainhash.Hash]struct{}{hashA: {}}
+	if _, ok := ancestorPackage(b, items); ok {
+		t.Fatal("package with unknown ancestor unexpectedly allowed")
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbit99/hcd/wire"
+)
+
+// maxCapturedAddrLen is the maximum length of the peer address of a captured
+// message.
+const maxCapturedAddrLen = 256
+
+// CapturedMessage describes a message which was read from or written to a peer
+// and recorded to a capture file.
+type CapturedMessage struct {
+	// Timestamp is the time the message was read or written at.
+	Timestamp time.Time
+
+	// Sent is whether the message was written to the peer as opposed to
+	// read from it.
+	Sent bool
+
+	// Addr is the address of the peer.
+	Addr string
+
+	// Command is the command of the message.
+	Command string
+
+	// Payload is the raw payload of the message.
+	Payload []byte
+}
+
+// WriteCapturedMessage writes the passed captured message to w.  Each record
+// consists of the timestamp in nanoseconds since the unix epoch, a direction
+// byte which is 1 for sent messages, the length-prefixed peer address, the
+// command padded to wire.CommandSize, and the length-prefixed payload, all
+// integers in little endian.
+func WriteCapturedMessage(w io.Writer, msg *CapturedMessage) error {
+	if len(msg.Addr) > maxCapturedAddrLen {
+		return fmt.Errorf("peer address is longer than %d bytes",
+			maxCapturedAddrLen)
+	}
+	if len(msg.Command) > wire.CommandSize {
+		return fmt.Errorf("command is longer than %d bytes",
+			wire.CommandSize)
+	}
+
+	var buf bytes.Buffer
+	var direction uint8
+	if msg.Sent {
+		direction = 1
+	}
+	var command [wire.CommandSize]byte
+	copy(command[:], msg.Command)
+	binary.Write(&buf, binary.LittleEndian, msg.Timestamp.UnixNano())
+	buf.WriteByte(direction)
+	binary.Write(&buf, binary.LittleEndian, uint16(len(msg.Addr)))
+	buf.WriteString(msg.Addr)
+	buf.Write(command[:])
+	binary.Write(&buf, binary.LittleEndian, uint32(len(msg.Payload)))
+	buf.Write(msg.Payload)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ReadCapturedMessage reads the next captured message written by
+// WriteCapturedMessage from r.  It returns io.EOF when there are no more
+// messages.
+func ReadCapturedMessage(r io.Reader) (*CapturedMessage, error) {
+	var header struct {
+		Timestamp int64
+		Direction uint8
+		AddrLen   uint16
+	}
+	err := binary.Read(r, binary.LittleEndian, &header)
+	if err != nil {
+		return nil, err
+	}
+	if header.AddrLen > maxCapturedAddrLen {
+		return nil, fmt.Errorf("peer address length %d is longer than "+
+			"%d bytes", header.AddrLen, maxCapturedAddrLen)
+	}
+
+	addr := make([]byte, header.AddrLen)
+	var command [wire.CommandSize]byte
+	var payloadLen uint32
+	if _, err := io.ReadFull(r, addr); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if _, err := io.ReadFull(r, command[:]); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	err = binary.Read(r, binary.LittleEndian, &payloadLen)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if payloadLen > wire.MaxMessagePayload {
+		return nil, fmt.Errorf("payload length %d is larger than the "+
+			"maximum of %d bytes", payloadLen, wire.MaxMessagePayload)
+	}
+	payload := make([]byte, payloadLen)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	return &CapturedMessage{
+		Timestamp: time.Unix(0, header.Timestamp),
+		Sent:      header.Direction != 0,
+		Addr:      string(addr),
+		Command:   string(bytes.TrimRight(command[:], "\x00")),
+		Payload:   payload,
+	}, nil
+}
+
+// unexpectedEOF converts io.EOF to io.ErrUnexpectedEOF since the end of the
+// input in the middle of a captured message means it is truncated.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// CaptureFileName returns the name of the file the messages of the peer with
+// the passed address are captured to.
+func CaptureFileName(addr string) string {
+	replacer := strings.NewReplacer(":", "_", "[", "", "]", "", "/", "_",
+		"\\", "_")
+	return replacer.Replace(addr) + ".dat"
+}
+
+// messageCapture records the messages read from and written to a peer to a
+// file in a directory.  The file is created when the first message is captured
+// and messages of later connections to the same address are appended to it.
+type messageCapture struct {
+	mtx    sync.Mutex
+	dir    string
+	file   *os.File
+	closed bool
+}
+
+// capture records the passed message of the peer with the passed address.
+// Failures are logged and stop the capture for the peer.
+//
+// This function is safe for concurrent access.
+func (c *messageCapture) capture(addr string, sent bool, command string, payload []byte) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.closed {
+		return
+	}
+	if c.file == nil {
+		err := os.MkdirAll(c.dir, 0700)
+		if err != nil {
+			log.Errorf("Unable to create message capture directory: %v",
+				err)
+			c.closed = true
+			return
+		}
+		path := filepath.Join(c.dir, CaptureFileName(addr))
+		file, err := os.OpenFile(path,
+			os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			log.Errorf("Unable to open message capture file: %v", err)
+			c.closed = true
+			return
+		}
+		c.file = file
+	}
+
+	err := WriteCapturedMessage(c.file, &CapturedMessage{
+		Timestamp: time.Now(),
+		Sent:      sent,
+		Addr:      addr,
+		Command:   command,
+		Payload:   payload,
+	})
+	if err != nil {
+		log.Errorf("Unable to capture message of peer %s: %v", addr, err)
+		c.close()
+	}
+}
+
+// close closes the capture file.  No more messages are captured afterwards.
+//
+// This function MUST be called with the capture lock held.
+func (c *messageCapture) close() {
+	c.closed = true
+	if c.file == nil {
+		return
+	}
+	if err := c.file.Close(); err != nil {
+		log.Errorf("Unable to close message capture file: %v", err)
+	}
+	c.file = nil
+}
+
+// Close closes the capture file.  No more messages are captured afterwards.
+//
+// This function is safe for concurrent access.
+func (c *messageCapture) Close() {
+	c.mtx.Lock()
+	c.close()
+	c.mtx.Unlock()
+}
+
+// captureMessage records the passed raw message, including its header, which
+// was read from or written to the peer.  Messages are recorded whether or not
+// they could be decoded, so messages with unknown commands or malformed
+// payloads are captured as well.  Messages which were cut short before the end
+// of their header are not recorded since their command is not known.
+func (p *Peer) captureMessage(sent bool, raw []byte) {
+	if len(raw) < wire.MessageHeaderSize {
+		return
+	}
+	command := raw[4 : 4+wire.CommandSize]
+	command = bytes.TrimRight(command, "\x00")
+	payload := raw[wire.MessageHeaderSize:]
+	p.capture.capture(p.addr, sent, string(command), payload)
+}
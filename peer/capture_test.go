@@ -0,0 +1,182 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nbit99/hcd/chaincfg"
+	"github.com/nbit99/hcd/wire"
+)
+
+// TestCapturedMessage ensures captured messages are read back the same way they
+// were written and that truncated captures are detected.
+func TestCapturedMessage(t *testing.T) {
+	msgs := []*CapturedMessage{{
+		Timestamp: time.Unix(1500000000, 123456789),
+		Sent:      true,
+		Addr:      "127.0.0.1:14008",
+		Command:   wire.CmdPing,
+		Payload:   []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08},
+	}, {
+		Timestamp: time.Unix(1500000001, 0),
+		Addr:      "[::1]:14008",
+		Command:   wire.CmdVerAck,
+		Payload:   []byte{},
+	}}
+
+	var buf bytes.Buffer
+	for _, msg := range msgs {
+		if err := WriteCapturedMessage(&buf, msg); err != nil {
+			t.Fatalf("WriteCapturedMessage: unexpected error: %v", err)
+		}
+	}
+	data := buf.Bytes()
+
+	r := bytes.NewReader(data)
+	for i, want := range msgs {
+		got, err := ReadCapturedMessage(r)
+		if err != nil {
+			t.Fatalf("ReadCapturedMessage #%d: unexpected error: %v",
+				i, err)
+		}
+		if !got.Timestamp.Equal(want.Timestamp) {
+			t.Fatalf("ReadCapturedMessage #%d: got timestamp %v, "+
+				"want %v", i, got.Timestamp, want.Timestamp)
+		}
+		got.Timestamp = want.Timestamp
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("ReadCapturedMessage #%d: got %+v, want %+v",
+				i, got, want)
+		}
+	}
+	if _, err := ReadCapturedMessage(r); err != io.EOF {
+		t.Fatalf("ReadCapturedMessage: got error %v, want %v", err,
+			io.EOF)
+	}
+
+	r = bytes.NewReader(data[:len(data)-1])
+	if _, err := ReadCapturedMessage(r); err != nil {
+		t.Fatalf("ReadCapturedMessage: unexpected error: %v", err)
+	}
+	if _, err := ReadCapturedMessage(r); err != io.ErrUnexpectedEOF {
+		t.Fatalf("ReadCapturedMessage: got error %v for a truncated "+
+			"capture, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+// TestMessageCapture ensures the messages of a peer are captured to a file
+// named after its address until the capture is closed.
+func TestMessageCapture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peercapture")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	const addr = "127.0.0.1:14008"
+	c := &messageCapture{dir: filepath.Join(dir, "msgcapture")}
+	c.capture(addr, true, wire.CmdPing, []byte{0x01})
+	c.capture(addr, false, wire.CmdPong, []byte{0x02})
+	c.Close()
+	c.capture(addr, true, wire.CmdPing, []byte{0x03})
+
+	f, err := os.Open(filepath.Join(c.dir, CaptureFileName(addr)))
+	if err != nil {
+		t.Fatalf("unable to open capture file: %v", err)
+	}
+	defer f.Close()
+
+	var commands []string
+	for {
+		msg, err := ReadCapturedMessage(f)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadCapturedMessage: unexpected error: %v", err)
+		}
+		commands = append(commands, msg.Command)
+	}
+	want := []string{wire.CmdPing, wire.CmdPong}
+	if !reflect.DeepEqual(commands, want) {
+		t.Fatalf("got captured commands %v, want %v", commands, want)
+	}
+}
+
+// TestPeerMessageCapture ensures the messages a peer reads are captured even
+// when they can not be decoded, and that the messages it writes are captured
+// with the payload that was sent.
+func TestPeerMessageCapture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peercapture")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Queue a message with an unknown command to be read by the peer.
+	var rw bytes.Buffer
+	payload := []byte{0x01, 0x02, 0x03}
+	header := make([]byte, wire.MessageHeaderSize)
+	binary.LittleEndian.PutUint32(header,
+		uint32(chaincfg.MainNetParams.Net))
+	copy(header[4:], "unknowncmd")
+	binary.LittleEndian.PutUint32(header[16:], uint32(len(payload)))
+	rw.Write(header)
+	rw.Write(payload)
+
+	const addr = "127.0.0.1:14008"
+	p := newPeerBase(&Config{ChainParams: &chaincfg.MainNetParams}, false)
+	p.addr = addr
+	p.rw = &rw
+	p.capture = &messageCapture{dir: dir}
+	if _, _, err := p.readMessage(); err == nil {
+		t.Fatal("readMessage: message with an unknown command was " +
+			"decoded")
+	}
+	if err := p.writeMessage(wire.NewMsgPing(1)); err != nil {
+		t.Fatalf("writeMessage: unexpected error: %v", err)
+	}
+	p.capture.Close()
+
+	var ping bytes.Buffer
+	wire.NewMsgPing(1).BtcEncode(&ping, p.ProtocolVersion())
+	want := []CapturedMessage{
+		{Sent: false, Addr: addr, Command: "unknowncmd",
+			Payload: payload},
+		{Sent: true, Addr: addr, Command: wire.CmdPing,
+			Payload: ping.Bytes()},
+	}
+
+	f, err := os.Open(filepath.Join(dir, CaptureFileName(addr)))
+	if err != nil {
+		t.Fatalf("unable to open capture file: %v", err)
+	}
+	defer f.Close()
+	for i := range want {
+		msg, err := ReadCapturedMessage(f)
+		if err != nil {
+			t.Fatalf("ReadCapturedMessage #%d: unexpected error: %v",
+				i, err)
+		}
+		msg.Timestamp = time.Time{}
+		if !reflect.DeepEqual(msg, &want[i]) {
+			t.Fatalf("captured message #%d: got %+v, want %+v", i,
+				msg, &want[i])
+		}
+	}
+	if _, err := ReadCapturedMessage(f); err != io.EOF {
+		t.Fatalf("ReadCapturedMessage: got %v after the last message, "+
+			"want %v", err, io.EOF)
+	}
+}
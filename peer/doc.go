@@ -105,7 +105,10 @@ Of special interest are inventory messages.  Rather than manually sending MsgInv
 messages via Queuemessage, the inventory vectors should be queued using the
 QueueInventory function.  It employs batching and trickling along with
 intelligent known remote peer inventory detection and avoidance through the use
-of a most-recently used algorithm.
+of a most-recently used algorithm.  Transaction inventory is announced in
+batches of random order at exponentially distributed intervals, which are
+shared by all inbound peers, to make it harder to link transactions to the node
+they originated from.
 
 Message Sending Helper Functions
 
@@ -0,0 +1,78 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/nbit99/hcd/wire"
+)
+
+const (
+	// inboundTxInvInterval is the average interval between the batches of
+	// transaction inventory announced to inbound peers.
+	inboundTxInvInterval = 5 * time.Second
+
+	// outboundTxInvInterval is the average interval between the batches of
+	// transaction inventory announced to outbound peers.  It is shorter
+	// than the inbound interval since outbound peers are chosen by us and
+	// therefore less likely to be controlled by an observer.
+	outboundTxInvInterval = 2 * time.Second
+)
+
+// poissonDelay returns a random delay which is exponentially distributed with
+// the passed average, so the send times which result from repeatedly waiting
+// for such delays form a Poisson process.
+func poissonDelay(avg time.Duration) time.Duration {
+	return time.Duration(-math.Log1p(-rand.Float64()) * float64(avg))
+}
+
+// txInvSchedule is a schedule of the times transaction inventory is announced
+// at which is shared by multiple peers.
+type txInvSchedule struct {
+	mtx  sync.Mutex
+	avg  time.Duration
+	next time.Time
+}
+
+// nextSend returns the next time transaction inventory is announced at after
+// the passed time.  It is safe for concurrent access.
+func (s *txInvSchedule) nextSend(now time.Time) time.Time {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if !now.Before(s.next) {
+		s.next = now.Add(poissonDelay(s.avg))
+	}
+	return s.next
+}
+
+// inboundTxInvSchedule is the schedule shared by all inbound peers.  Connecting
+// many times to the node does not let an observer sample more send times, which
+// would otherwise allow it to tell which transactions were announced by the
+// node first.
+var inboundTxInvSchedule = txInvSchedule{avg: inboundTxInvInterval}
+
+// txInvDelay returns the time to wait until the next batch of transaction
+// inventory is announced to the peer.  Inbound peers share a schedule while
+// outbound peers announce at independent times.
+func (p *Peer) txInvDelay(now time.Time) time.Duration {
+	if p.inbound {
+		return inboundTxInvSchedule.nextSend(now).Sub(now)
+	}
+	return poissonDelay(outboundTxInvInterval)
+}
+
+// shuffleInvVects randomizes the order of the passed inventory vectors in place
+// so the order a batch is announced in does not reveal the order the
+// transactions were received in.
+func shuffleInvVects(invVects []*wire.InvVect) {
+	rand.Shuffle(len(invVects), func(i, j int) {
+		invVects[i], invVects[j] = invVects[j], invVects[i]
+	})
+}
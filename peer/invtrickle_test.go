@@ -0,0 +1,52 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"testing"
+	"time"
+)
+
+// TestPoissonDelay ensures the random delays are non-negative and average to
+// the requested interval.
+func TestPoissonDelay(t *testing.T) {
+	const n = 100000
+	var total time.Duration
+	for i := 0; i < n; i++ {
+		d := poissonDelay(time.Second)
+		if d < 0 {
+			t.Fatalf("poissonDelay: got negative delay %v", d)
+		}
+		total += d
+	}
+	if avg := total / n; avg < 950*time.Millisecond ||
+		avg > 1050*time.Millisecond {
+
+		t.Fatalf("poissonDelay: got average %v, want about %v", avg,
+			time.Second)
+	}
+}
+
+// TestTxInvSchedule ensures peers sharing a schedule announce transaction
+// inventory at the same times and the schedule advances once a send time
+// passed.
+func TestTxInvSchedule(t *testing.T) {
+	s := txInvSchedule{avg: time.Minute}
+	now := time.Unix(1500000000, 0)
+	next := s.nextSend(now)
+	if next.Before(now) {
+		t.Fatalf("nextSend: got %v before %v", next, now)
+	}
+
+	// Peers asking before the send time passed share it.
+	if got := s.nextSend(now.Add((next.Sub(now)) / 2)); got != next {
+		t.Fatalf("nextSend: got %v, want shared %v", got, next)
+	}
+
+	// A new send time is chosen once it passed.
+	if got := s.nextSend(next); got.Before(next) {
+		t.Fatalf("nextSend: got %v before %v", got, next)
+	}
+}
@@ -29,7 +29,7 @@ import (
 
 const (
 	// MaxProtocolVersion is the max protocol version the peer supports.
-	MaxProtocolVersion = wire.FeeFilterVersion
+	MaxProtocolVersion = wire.DandelionVersion
 
 	// outputBufferSize is the number of elements the output channels use.
 	outputBufferSize = 5000
@@ -103,6 +103,9 @@ type MessageListeners struct {
 	// OnAddr is invoked when a peer receives an addr wire message.
 	OnAddr func(p *Peer, msg *wire.MsgAddr)
 
+	// OnAddrV2 is invoked when a peer receives an addrv2 wire message.
+	OnAddrV2 func(p *Peer, msg *wire.MsgAddrV2)
+
 	// OnPing is invoked when a peer receives a ping wire message.
 	OnPing func(p *Peer, msg *wire.MsgPing)
 
@@ -175,6 +178,24 @@ type MessageListeners struct {
 	// message.
 	OnSendHeaders func(p *Peer, msg *wire.MsgSendHeaders)
 
+	// OnSendCmpct is invoked when a peer receives a sendcmpct wire message.
+	OnSendCmpct func(p *Peer, msg *wire.MsgSendCmpct)
+
+	// OnCmpctBlock is invoked when a peer receives a cmpctblock wire
+	// message.
+	OnCmpctBlock func(p *Peer, msg *wire.MsgCmpctBlock)
+
+	// OnGetBlockTxn is invoked when a peer receives a getblocktxn wire
+	// message.
+	OnGetBlockTxn func(p *Peer, msg *wire.MsgGetBlockTxn)
+
+	// OnBlockTxn is invoked when a peer receives a blocktxn wire message.
+	OnBlockTxn func(p *Peer, msg *wire.MsgBlockTxn)
+
+	// OnDandelionTx is invoked when a peer receives a dandeliontx wire
+	// message.
+	OnDandelionTx func(p *Peer, msg *wire.MsgDandelionTx)
+
 	// OnRead is invoked when a peer receives a wire message.  It consists
 	// of the number of bytes read, the message, and whether or not an error
 	// in the read occurred.  Typically, callers will opt to use the
@@ -237,6 +258,28 @@ type Config struct {
 	// not send inv messages for transactions.
 	DisableRelayTx bool
 
+	// AcceptEncryption specifies whether or not inbound peers accept the
+	// encrypted transport when the remote peer requests it.  Unencrypted
+	// connections are accepted either way.
+	AcceptEncryption bool
+
+	// InitiateEncryption specifies whether or not outbound peers request
+	// the encrypted transport.  The connection fails when the remote peer
+	// does not support it, so it should only be set for peers known to
+	// support it.
+	InitiateEncryption bool
+
+	// MaxSendRate specifies the maximum rate in bytes per second at which
+	// data is sent to the remote peer.  A burst of up to one second worth
+	// of data is allowed after the peer was idle.  This field can be
+	// omitted in which case the send rate is not limited.
+	MaxSendRate uint64
+
+	// CaptureDir specifies the directory every message read from and
+	// written to the remote peer is recorded to a file in.  This field can
+	// be omitted in which case messages are not captured.
+	CaptureDir string
+
 	// Listeners houses callback functions to be invoked on receiving peer
 	// messages.
 	Listeners MessageListeners
@@ -342,6 +385,7 @@ type StatsSnap struct {
 	LastPingNonce  uint64
 	LastPingTime   time.Time
 	LastPingMicros int64
+	Encrypted      bool
 }
 
 // HashFunc is a function which returns a block hash, height and error
@@ -393,11 +437,16 @@ type Peer struct {
 
 	conn net.Conn
 
+	// rw is the transport messages are read from and written to.  It is the
+	// connection itself unless the encrypted transport was negotiated.
+	rw io.ReadWriter
+
 	// These fields are set at creation time and never modified, so they are
 	// safe to read from concurrently without a mutex.
 	addr    string
 	cfg     Config
 	inbound bool
+	capture *messageCapture
 
 	flagsMtx             sync.Mutex // protects the peer flags below
 	na                   *wire.NetAddress
@@ -410,6 +459,8 @@ type Peer struct {
 	sendHeadersPreferred bool   // peer sent a sendheaders message
 	versionSent          bool
 	verAckReceived       bool
+	encrypted            bool
+	encryptionRejected   bool
 
 	knownInventory     *mruInventoryMap
 	prevGetBlocksMtx   sync.Mutex
@@ -485,6 +536,14 @@ func (p *Peer) AddKnownInventory(invVect *wire.InvVect) {
 	p.knownInventory.Add(invVect)
 }
 
+// IsKnownInventory returns whether or not the passed inventory is in the
+// cache of known inventory for the peer.
+//
+// This function is safe for concurrent access.
+func (p *Peer) IsKnownInventory(invVect *wire.InvVect) bool {
+	return p.knownInventory.Exists(invVect)
+}
+
 // StatsSnapshot returns a snapshot of the current peer flags and statistics.
 //
 // This function is safe for concurrent access.
@@ -518,6 +577,7 @@ func (p *Peer) StatsSnapshot() *StatsSnap {
 		LastPingNonce:  p.lastPingNonce,
 		LastPingMicros: p.lastPingMicros,
 		LastPingTime:   p.lastPingTime,
+		Encrypted:      p.Encrypted(),
 	}
 
 	p.statsMtx.RUnlock()
@@ -839,32 +899,47 @@ func (p *Peer) localVersionMsg() (*wire.MsgVersion, error) {
 // are too many.  It returns the addresses that were actually sent and no
 // message will be sent if there are no entries in the provided addresses slice.
 //
+// An addrv2 message is sent instead when the negotiated protocol version
+// supports it.  Otherwise addresses which can't be represented by an IP
+// address, such as Tor v3 onion addresses, are not sent.
+//
 // This function is safe for concurrent access.
 func (p *Peer) PushAddrMsg(addresses []*wire.NetAddress) ([]*wire.NetAddress, error) {
+	addrV2 := p.ProtocolVersion() >= wire.AddrV2Version
+	addrList := make([]*wire.NetAddress, 0, len(addresses))
+	for _, na := range addresses {
+		if addrV2 || na.Network == 0 {
+			addrList = append(addrList, na)
+		}
+	}
 
 	// Nothing to send.
-	if len(addresses) == 0 {
+	if len(addrList) == 0 {
 		return nil, nil
 	}
 
-	msg := wire.NewMsgAddr()
-	msg.AddrList = make([]*wire.NetAddress, len(addresses))
-	copy(msg.AddrList, addresses)
-
 	// Randomize the addresses sent if there are more than the maximum allowed.
-	if len(msg.AddrList) > wire.MaxAddrPerMsg {
+	if len(addrList) > wire.MaxAddrPerMsg {
 		// Shuffle the address list.
-		for i := range msg.AddrList {
+		for i := range addrList {
 			j := rand.Intn(i + 1)
-			msg.AddrList[i], msg.AddrList[j] = msg.AddrList[j], msg.AddrList[i]
+			addrList[i], addrList[j] = addrList[j], addrList[i]
 		}
 
 		// Truncate it to the maximum size.
-		msg.AddrList = msg.AddrList[:wire.MaxAddrPerMsg]
+		addrList = addrList[:wire.MaxAddrPerMsg]
 	}
 
-	p.QueueMessage(msg, nil)
-	return msg.AddrList, nil
+	if addrV2 {
+		msg := wire.NewMsgAddrV2()
+		msg.AddrList = addrList
+		p.QueueMessage(msg, nil)
+	} else {
+		msg := wire.NewMsgAddr()
+		msg.AddrList = addrList
+		p.QueueMessage(msg, nil)
+	}
+	return addrList, nil
 }
 
 // PushGetBlocksMsg sends a getblocks message for the provided block locator
@@ -1081,12 +1156,23 @@ func (p *Peer) handlePongMsg(msg *wire.MsgPong) {
 
 // readMessage reads the next wire message from the peer with logging.
 func (p *Peer) readMessage() (wire.Message, []byte, error) {
-	n, msg, buf, err := wire.ReadMessageN(p.conn, p.ProtocolVersion(),
+	// Record the raw bytes of the message when message capture is enabled
+	// so it is captured even when it can not be decoded.
+	var r io.Reader = p.rw
+	var raw *bytes.Buffer
+	if p.capture != nil {
+		raw = new(bytes.Buffer)
+		r = io.TeeReader(p.rw, raw)
+	}
+	n, msg, buf, err := wire.ReadMessageN(r, p.ProtocolVersion(),
 		p.cfg.ChainParams.Net)
 	atomic.AddUint64(&p.bytesReceived, uint64(n))
 	if p.cfg.Listeners.OnRead != nil {
 		p.cfg.Listeners.OnRead(p, n, msg, err)
 	}
+	if raw != nil {
+		p.captureMessage(false, raw.Bytes())
+	}
 	if err != nil {
 		return nil, nil, err
 	}
@@ -1143,13 +1229,27 @@ func (p *Peer) writeMessage(msg wire.Message) error {
 		return spew.Sdump(buf.Bytes())
 	}))
 
-	// Write the message to the peer.
-	n, err := wire.WriteMessageN(p.conn, msg, p.ProtocolVersion(),
+	// Write the message to the peer.  Messages are serialized up front when
+	// the transport is encrypted so each one is sent in a single frame, and
+	// when message capture is enabled so the bytes written are captured.
+	var w io.Writer = p.rw
+	var buf *bytes.Buffer
+	if p.Encrypted() || p.capture != nil {
+		buf = new(bytes.Buffer)
+		w = buf
+	}
+	n, err := wire.WriteMessageN(w, msg, p.ProtocolVersion(),
 		p.cfg.ChainParams.Net)
+	if err == nil && buf != nil {
+		_, err = p.rw.Write(buf.Bytes())
+	}
 	atomic.AddUint64(&p.bytesSent, uint64(n))
 	if p.cfg.Listeners.OnWrite != nil {
 		p.cfg.Listeners.OnWrite(p, n, msg, err)
 	}
+	if err == nil && p.capture != nil {
+		p.captureMessage(true, buf.Bytes())
+	}
 	return err
 }
 
@@ -1201,11 +1301,16 @@ func (p *Peer) maybeAddDeadline(pendingResponses map[string]time.Time, msgCmd st
 		pendingResponses[wire.CmdInv] = deadline
 
 	case wire.CmdGetData:
-		// Expects a block, tx, or notfound message.
+		// Expects a block, cmpctblock, tx, or notfound message.
 		pendingResponses[wire.CmdBlock] = deadline
+		pendingResponses[wire.CmdCmpctBlock] = deadline
 		pendingResponses[wire.CmdTx] = deadline
 		pendingResponses[wire.CmdNotFound] = deadline
 
+	case wire.CmdGetBlockTxn:
+		// Expects a blocktxn message.
+		pendingResponses[wire.CmdBlockTxn] = deadline
+
 	case wire.CmdGetHeaders:
 		// Expects a headers message.  Use a longer deadline since it
 		// can take a while for the remote peer to load all of the
@@ -1262,10 +1367,13 @@ out:
 				switch msgCmd := msg.message.Command(); msgCmd {
 				case wire.CmdBlock:
 					fallthrough
+				case wire.CmdCmpctBlock:
+					fallthrough
 				case wire.CmdTx:
 					fallthrough
 				case wire.CmdNotFound:
 					delete(pendingResponses, wire.CmdBlock)
+					delete(pendingResponses, wire.CmdCmpctBlock)
 					delete(pendingResponses, wire.CmdTx)
 					delete(pendingResponses, wire.CmdNotFound)
 
@@ -1443,6 +1551,11 @@ out:
 				p.cfg.Listeners.OnAddr(p, msg)
 			}
 
+		case *wire.MsgAddrV2:
+			if p.cfg.Listeners.OnAddrV2 != nil {
+				p.cfg.Listeners.OnAddrV2(p, msg)
+			}
+
 		case *wire.MsgPing:
 			p.handlePingMsg(msg)
 			if p.cfg.Listeners.OnPing != nil {
@@ -1549,6 +1662,31 @@ out:
 				p.cfg.Listeners.OnSendHeaders(p, msg)
 			}
 
+		case *wire.MsgSendCmpct:
+			if p.cfg.Listeners.OnSendCmpct != nil {
+				p.cfg.Listeners.OnSendCmpct(p, msg)
+			}
+
+		case *wire.MsgCmpctBlock:
+			if p.cfg.Listeners.OnCmpctBlock != nil {
+				p.cfg.Listeners.OnCmpctBlock(p, msg)
+			}
+
+		case *wire.MsgGetBlockTxn:
+			if p.cfg.Listeners.OnGetBlockTxn != nil {
+				p.cfg.Listeners.OnGetBlockTxn(p, msg)
+			}
+
+		case *wire.MsgBlockTxn:
+			if p.cfg.Listeners.OnBlockTxn != nil {
+				p.cfg.Listeners.OnBlockTxn(p, msg)
+			}
+
+		case *wire.MsgDandelionTx:
+			if p.cfg.Listeners.OnDandelionTx != nil {
+				p.cfg.Listeners.OnDandelionTx(p, msg)
+			}
+
 		default:
 			log.Debugf("Received unhandled message of type %v "+
 				"from %v", rmsg.Command(), p)
@@ -1587,6 +1725,13 @@ func (p *Peer) queueHandler() {
 	trickleTicker := time.NewTicker(trickleTimeout)
 	defer trickleTicker.Stop()
 
+	// Transaction inventory is queued separately and announced in batches
+	// at exponentially distributed intervals to make it harder to infer
+	// which node a transaction originated from by the time it is announced.
+	var txInvSendQueue []*wire.InvVect
+	txInvTimer := time.NewTimer(p.txInvDelay(time.Now()))
+	defer txInvTimer.Stop()
+
 	// We keep the waiting flag so that we know if we have a message queued
 	// to the outHandler or not.  We could use the presence of a head of
 	// the list for this but then we have rather racy concerns about whether
@@ -1607,6 +1752,34 @@ func (p *Peer) queueHandler() {
 		// we are always waiting now.
 		return true
 	}
+
+	// queueInv creates and queues as many inv messages as needed to
+	// announce the passed inventory.
+	queueInv := func(invVects []*wire.InvVect) {
+		invMsg := wire.NewMsgInvSizeHint(uint(len(invVects)))
+		for _, iv := range invVects {
+			// Don't send inventory that became known after the
+			// initial check.
+			if p.knownInventory.Exists(iv) {
+				continue
+			}
+
+			invMsg.AddInvVect(iv)
+			if len(invMsg.InvList) >= maxInvTrickleSize {
+				waiting = queuePacket(outMsg{msg: invMsg},
+					&pendingMsgs, waiting)
+				invMsg = wire.NewMsgInvSizeHint(uint(len(invVects)))
+			}
+
+			// Add the inventory that is being relayed to the known
+			// inventory for the peer.
+			p.AddKnownInventory(iv)
+		}
+		if len(invMsg.InvList) > 0 {
+			waiting = queuePacket(outMsg{msg: invMsg}, &pendingMsgs,
+				waiting)
+		}
+	}
 out:
 	for {
 		select {
@@ -1637,10 +1810,15 @@ out:
 
 		case iv := <-p.outputInvChan:
 			// No handshake?  They'll find out soon enough.
-			if p.VersionKnown() {
-				//invSendQueue.PushBack(iv)
-				invSendQueue = append(invSendQueue, iv)
+			if !p.VersionKnown() {
+				continue
 			}
+			if iv.Type == wire.InvTypeTx {
+				txInvSendQueue = append(txInvSendQueue, iv)
+				continue
+			}
+			//invSendQueue.PushBack(iv)
+			invSendQueue = append(invSendQueue, iv)
 
 		case <-trickleTicker.C:
 			// Don't send anything if we're disconnecting or there
@@ -1652,38 +1830,22 @@ out:
 				continue
 			}
 
-			// Create and send as many inv messages as needed to
-			// drain the inventory send queue.
-			//invMsg := wire.NewMsgInvSizeHint(uint(invSendQueue.Len()))
-			invMsg := wire.NewMsgInvSizeHint(uint(len(invSendQueue)))
-			//for e := invSendQueue.Front(); e != nil; e = invSendQueue.Front() {
-			for _, iv := range invSendQueue {
-				//iv := invSendQueue.Remove(e).(*wire.InvVect)
-
-				// Don't send inventory that became known after
-				// the initial check.
-				if p.knownInventory.Exists(iv) {
-					continue
-				}
-
-				invMsg.AddInvVect(iv)
-				if len(invMsg.InvList) >= maxInvTrickleSize {
-					waiting = queuePacket(
-						outMsg{msg: invMsg},
-						&pendingMsgs, waiting)
-					//invMsg = wire.NewMsgInvSizeHint(uint(invSendQueue.Len()))
-					invMsg = wire.NewMsgInvSizeHint(uint(len(invSendQueue)))
-				}
+			// Drain the inventory send queue.
+			queueInv(invSendQueue)
+			invSendQueue = nil
 
-				// Add the inventory that is being relayed to
-				// the known inventory for the peer.
-				p.AddKnownInventory(iv)
-			}
-			if len(invMsg.InvList) > 0 {
-				waiting = queuePacket(outMsg{msg: invMsg},
-					&pendingMsgs, waiting)
+		case now := <-txInvTimer.C:
+			txInvTimer.Reset(p.txInvDelay(now))
+			if atomic.LoadInt32(&p.disconnect) != 0 ||
+				len(txInvSendQueue) == 0 {
+				continue
 			}
-			invSendQueue = nil
+
+			// Announce the batch in random order so it does not
+			// reveal the order the transactions were received in.
+			shuffleInvVects(txInvSendQueue)
+			queueInv(txInvSendQueue)
+			txInvSendQueue = nil
 
 		case <-p.quit:
 			break out
@@ -1745,6 +1907,13 @@ func (p *Peer) outHandler() {
 	pingTicker := time.NewTicker(pingInterval)
 	defer pingTicker.Stop()
 
+	// rateLimiter limits the rate at which data is sent to the remote peer
+	// when a maximum send rate is configured.
+	var rateLimiter *sendRateLimiter
+	if p.cfg.MaxSendRate != 0 {
+		rateLimiter = newSendRateLimiter(p.cfg.MaxSendRate)
+	}
+
 out:
 	for {
 		select {
@@ -1759,6 +1928,7 @@ out:
 			}
 
 			p.stallControl <- stallControlMsg{sccSendMessage, msg.msg}
+			bytesSent := atomic.LoadUint64(&p.bytesSent)
 			if err := p.writeMessage(msg.msg); err != nil {
 				p.Disconnect()
 				if p.shouldLogWriteError(err) {
@@ -1776,10 +1946,24 @@ out:
 			// message that it has been sent (if requested), and
 			// signal the send queue to the deliver the next queued
 			// message.
-			atomic.StoreInt64(&p.lastSend, time.Now().Unix())
+			now := time.Now()
+			atomic.StoreInt64(&p.lastSend, now.Unix())
 			if msg.doneChan != nil {
 				msg.doneChan <- struct{}{}
 			}
+
+			// Hold off on the next queued message as long as needed
+			// to stay below the maximum send rate.
+			if rateLimiter != nil {
+				n := atomic.LoadUint64(&p.bytesSent) - bytesSent
+				if delay := rateLimiter.delay(n, now); delay > 0 {
+					select {
+					case <-time.After(delay):
+					case <-p.quit:
+						break out
+					}
+				}
+			}
 			p.sendDoneQueue <- struct{}{}
 
 		case <-pingTicker.C:
@@ -1837,7 +2021,9 @@ func (p *Peer) QueueMessage(msg wire.Message, doneChan chan<- struct{}) {
 
 // QueueInventory adds the passed inventory to the inventory send queue which
 // might not be sent right away, rather it is trickled to the peer in batches.
-// Inventory that the peer is already known to have is ignored.
+// Transaction inventory is announced at random intervals with an average of
+// 2 seconds for outbound and 5 seconds for inbound peers.  Inventory that the
+// peer is already known to have is ignored.
 //
 // This function is safe for concurrent access.
 func (p *Peer) QueueInventory(invVect *wire.InvVect) {
@@ -1866,6 +2052,7 @@ func (p *Peer) AssociateConnection(conn net.Conn) {
 	}
 
 	p.conn = conn
+	p.rw = conn
 	p.timeConnected = time.Now()
 
 	if p.inbound {
@@ -1911,6 +2098,9 @@ func (p *Peer) Disconnect() {
 	if atomic.LoadInt32(&p.connected) != 0 {
 		p.conn.Close()
 	}
+	if p.capture != nil {
+		p.capture.Close()
+	}
 	close(p.quit)
 }
 
@@ -1920,6 +2110,10 @@ func (p *Peer) start() error {
 
 	negotiateErr := make(chan error)
 	go func() {
+		if err := p.negotiateTransport(); err != nil {
+			negotiateErr <- err
+			return
+		}
 		if p.inbound {
 			negotiateErr <- p.negotiateInboundProtocol()
 		} else {
@@ -1931,6 +2125,12 @@ func (p *Peer) start() error {
 	select {
 	case err := <-negotiateErr:
 		if err != nil {
+			// Frames which can not be decrypted during the version
+			// exchange show the remote peer does not speak the
+			// encrypted transport.
+			if _, ok := err.(transportError); ok && !p.inbound {
+				p.rejectEncryption()
+			}
 			return err
 		}
 	case <-time.After(negotiateTimeout):
@@ -2060,6 +2260,9 @@ func newPeerBase(cfg *Config, inbound bool) *Peer {
 		services:        cfg.Services,
 		protocolVersion: protocolVersion,
 	}
+	if cfg.CaptureDir != "" {
+		p.capture = &messageCapture{dir: cfg.CaptureDir}
+	}
 	return &p
 }
 
@@ -0,0 +1,47 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"time"
+)
+
+// sendRateBurst is the duration worth of data at the maximum send rate which
+// may be sent in a burst after the peer was idle.
+const sendRateBurst = time.Second
+
+// sendRateLimiter limits the rate at which data is sent to a peer.  It keeps
+// track of the time at which the data sent so far would have been sent at the
+// maximum rate and delays further sends until that time, less the allowed
+// burst, has come.
+//
+// It is only used by the outHandler goroutine and is therefore not safe for
+// concurrent access.
+type sendRateLimiter struct {
+	rate uint64 // bytes per second
+	next time.Time
+}
+
+// newSendRateLimiter returns a new send rate limiter which limits the rate at
+// which data is sent to the passed number of bytes per second.
+func newSendRateLimiter(rate uint64) *sendRateLimiter {
+	return &sendRateLimiter{rate: rate}
+}
+
+// delay records that the passed number of bytes were sent at the passed time
+// and returns how long to wait before sending more data in order to stay below
+// the maximum rate.
+func (l *sendRateLimiter) delay(n uint64, now time.Time) time.Duration {
+	// Idle time only counts towards the allowed burst.
+	if earliest := now.Add(-sendRateBurst); l.next.Before(earliest) {
+		l.next = earliest
+	}
+	l.next = l.next.Add(time.Duration(n) * time.Second /
+		time.Duration(l.rate))
+	if l.next.After(now) {
+		return l.next.Sub(now)
+	}
+	return 0
+}
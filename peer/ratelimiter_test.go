@@ -0,0 +1,41 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"testing"
+	"time"
+)
+
+// TestSendRateLimiter ensures the send rate limiter allows a burst of data
+// after the peer was idle and delays further sends to stay below the maximum
+// rate.
+func TestSendRateLimiter(t *testing.T) {
+	l := newSendRateLimiter(1000)
+	now := time.Unix(1500000000, 0)
+
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		n       uint64
+		want    time.Duration
+	}{
+		{"burst", 0, 1000, 0},
+		{"burst exhausted", 0, 500, 500 * time.Millisecond},
+		{"waited", 500 * time.Millisecond, 1000, time.Second},
+		{"waited less than delay", 500 * time.Millisecond, 1000,
+			1500 * time.Millisecond},
+		{"idle", time.Hour, 200, 0},
+		{"after idle", 0, 1000, 200 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		now = now.Add(test.elapsed)
+		if got := l.delay(test.n, now); got != test.want {
+			t.Errorf("%s: got delay %v, want %v", test.name, got,
+				test.want)
+		}
+	}
+}
@@ -0,0 +1,396 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"syscall"
+
+	"github.com/nbit99/hcd/wire"
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// The encrypted transport protects all messages exchanged with a peer against
+// passive observers.  It is negotiated before the version handshake as
+// follows:
+//
+// The initiating (outbound) side sends an ephemeral X25519 public key.  Since
+// the first bytes of an unencrypted connection are always the network magic,
+// the responding (inbound) side uses them to tell the two transports apart.
+// It replies with its own ephemeral public key when the encrypted transport is
+// requested.  Both sides then derive the keys for each direction from the
+// shared secret of the key exchange with HKDF-SHA256.
+//
+// Afterwards every message is sent in a frame made up of the length of the
+// message, encrypted with a ChaCha20 stream, followed by the message sealed
+// with ChaCha20-Poly1305 using the encrypted length as additional data.  The
+// message is the full message of the unencrypted transport including its
+// header, so the command name is encrypted as well.
+//
+// The transport is not authenticated, so it does not protect against active
+// attackers which intercept the connection.
+const (
+	// transportKeySize is the size of the ephemeral public keys exchanged
+	// when negotiating the encrypted transport.
+	transportKeySize = 32
+
+	// transportLengthSize is the size of the encrypted length of a frame.
+	transportLengthSize = 4
+
+	// maxTransportFrameSize is the maximum size of the message in a frame.
+	maxTransportFrameSize = wire.MessageHeaderSize + wire.MaxMessagePayload
+)
+
+// transportSalt is the salt used when deriving the keys of the encrypted
+// transport.  It is followed by the network magic so keys are never shared
+// between networks.
+var transportSalt = []byte("hcd encrypted transport")
+
+// transportError describes a failure of the encrypted transport caused by the
+// data received from the remote peer as opposed to a failure of the underlying
+// connection.
+type transportError string
+
+// Error satisfies the error interface and prints human-readable errors.
+func (e transportError) Error() string {
+	return string(e)
+}
+
+// transportDirection houses the ciphers used for the frames sent in one
+// direction of an encrypted connection.
+type transportDirection struct {
+	length  *chacha20.Cipher
+	payload cipher.AEAD
+	nonce   [chacha20poly1305.NonceSize]byte
+	counter uint64
+}
+
+// newTransportDirection returns the ciphers for one direction of an encrypted
+// connection given the keys for the lengths and payloads of the frames.
+func newTransportDirection(lengthKey, payloadKey []byte) (*transportDirection, error) {
+	var nonce [chacha20.NonceSize]byte
+	length, err := chacha20.NewUnauthenticatedCipher(lengthKey, nonce[:])
+	if err != nil {
+		return nil, err
+	}
+	payload, err := chacha20poly1305.New(payloadKey)
+	if err != nil {
+		return nil, err
+	}
+	return &transportDirection{length: length, payload: payload}, nil
+}
+
+// nextNonce returns the nonce for the next frame.  Every frame is sealed with
+// a unique nonce made up of the number of preceding frames.
+func (d *transportDirection) nextNonce() []byte {
+	binary.LittleEndian.PutUint64(d.nonce[4:], d.counter)
+	d.counter++
+	return d.nonce[:]
+}
+
+// encryptedConn implements the framing of the encrypted transport on top of
+// an underlying connection.
+type encryptedConn struct {
+	conn io.ReadWriter
+
+	sendMtx sync.Mutex
+	send    *transportDirection
+
+	recvMtx sync.Mutex
+	recv    *transportDirection
+	recvBuf []byte
+}
+
+// Write encrypts the passed data and writes it to the underlying connection in
+// a single frame, or in multiple frames when it exceeds the maximum frame size.
+//
+// This is part of the io.Writer interface implementation.
+func (c *encryptedConn) Write(b []byte) (int, error) {
+	c.sendMtx.Lock()
+	defer c.sendMtx.Unlock()
+
+	var written int
+	for len(b) > 0 {
+		n := len(b)
+		if n > maxTransportFrameSize {
+			n = maxTransportFrameSize
+		}
+
+		frame := make([]byte, transportLengthSize, transportLengthSize+n+
+			c.send.payload.Overhead())
+		binary.LittleEndian.PutUint32(frame, uint32(n))
+		c.send.length.XORKeyStream(frame, frame)
+		frame = c.send.payload.Seal(frame, c.send.nextNonce(), b[:n],
+			frame[:transportLengthSize])
+		if _, err := c.conn.Write(frame); err != nil {
+			return written, err
+		}
+		written += n
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// Read reads and decrypts data from the underlying connection.  It reads the
+// next frame when all of the data of the previous one was consumed, skipping
+// any empty frames.
+//
+// This is part of the io.Reader interface implementation.
+func (c *encryptedConn) Read(b []byte) (int, error) {
+	c.recvMtx.Lock()
+	defer c.recvMtx.Unlock()
+
+	for len(c.recvBuf) == 0 {
+		var length [transportLengthSize]byte
+		if _, err := io.ReadFull(c.conn, length[:]); err != nil {
+			return 0, err
+		}
+		var n [transportLengthSize]byte
+		c.recv.length.XORKeyStream(n[:], length[:])
+		frameLen := binary.LittleEndian.Uint32(n[:])
+		if frameLen > maxTransportFrameSize {
+			str := fmt.Sprintf("encrypted frame of %d bytes "+
+				"exceeds the maximum of %d bytes", frameLen,
+				maxTransportFrameSize)
+			return 0, transportError(str)
+		}
+
+		frame := make([]byte, int(frameLen)+
+			c.recv.payload.Overhead())
+		if _, err := io.ReadFull(c.conn, frame); err != nil {
+			return 0, err
+		}
+		plaintext, err := c.recv.payload.Open(frame[:0],
+			c.recv.nextNonce(), frame, length[:])
+		if err != nil {
+			return 0, transportError("unable to decrypt frame")
+		}
+		c.recvBuf = plaintext
+	}
+
+	n := copy(b, c.recvBuf)
+	c.recvBuf = c.recvBuf[n:]
+	return n, nil
+}
+
+// prefixedConn replays the bytes which were read from an underlying connection
+// to detect the transport before reading from the connection itself.
+type prefixedConn struct {
+	io.ReadWriter
+	prefix []byte
+}
+
+// Read reads the replayed bytes followed by the data from the underlying
+// connection.
+//
+// This is part of the io.Reader interface implementation.
+func (c *prefixedConn) Read(b []byte) (int, error) {
+	if len(c.prefix) > 0 {
+		n := copy(b, c.prefix)
+		c.prefix = c.prefix[n:]
+		return n, nil
+	}
+	return c.ReadWriter.Read(b)
+}
+
+// newTransportKey returns a new ephemeral key pair for negotiating the
+// encrypted transport.  The public key never starts with the network magic so
+// it can't be mistaken for the start of an unencrypted connection.
+func newTransportKey(net wire.CurrencyNet) (priv, pub []byte, err error) {
+	var magic [4]byte
+	binary.LittleEndian.PutUint32(magic[:], uint32(net))
+	for {
+		priv = make([]byte, curve25519.ScalarSize)
+		if _, err := rand.Read(priv); err != nil {
+			return nil, nil, err
+		}
+		pub, err = curve25519.X25519(priv, curve25519.Basepoint)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !bytes.Equal(pub[:len(magic)], magic[:]) {
+			return priv, pub, nil
+		}
+	}
+}
+
+// newEncryptedConn returns the encrypted connection on top of the passed
+// connection given the ephemeral keys exchanged by both sides.
+func newEncryptedConn(conn io.ReadWriter, net wire.CurrencyNet, inbound bool,
+	priv, initiatorPub, responderPub []byte) (*encryptedConn, error) {
+
+	remotePub := responderPub
+	if inbound {
+		remotePub = initiatorPub
+	}
+	secret, err := curve25519.X25519(priv, remotePub)
+	if err != nil {
+		return nil, err
+	}
+
+	// Derive the keys for the lengths and payloads of both directions.
+	// The public keys are bound to the keys to rule out any ambiguity of
+	// the key exchange.
+	salt := make([]byte, len(transportSalt)+4)
+	copy(salt, transportSalt)
+	binary.LittleEndian.PutUint32(salt[len(transportSalt):], uint32(net))
+	info := append(append([]byte{}, initiatorPub...), responderPub...)
+	kdf := hkdf.New(sha256.New, secret, salt, info)
+	var keys [4][chacha20poly1305.KeySize]byte
+	for i := range keys {
+		if _, err := io.ReadFull(kdf, keys[i][:]); err != nil {
+			return nil, err
+		}
+	}
+
+	initiator, err := newTransportDirection(keys[0][:], keys[1][:])
+	if err != nil {
+		return nil, err
+	}
+	responder, err := newTransportDirection(keys[2][:], keys[3][:])
+	if err != nil {
+		return nil, err
+	}
+	c := &encryptedConn{conn: conn, send: initiator, recv: responder}
+	if inbound {
+		c.send, c.recv = responder, initiator
+	}
+	return c, nil
+}
+
+// negotiateTransport negotiates the transport used for the connection with the
+// remote peer before the version handshake.  Outbound peers request the
+// encrypted transport when configured to do so, and inbound peers accept it
+// when configured to do so while they keep accepting unencrypted connections.
+func (p *Peer) negotiateTransport() error {
+	net := p.cfg.ChainParams.Net
+	if !p.inbound {
+		if !p.cfg.InitiateEncryption {
+			return nil
+		}
+
+		priv, pub, err := newTransportKey(net)
+		if err != nil {
+			return err
+		}
+		if _, err := p.conn.Write(pub); err != nil {
+			return err
+		}
+		// Peers which do not support the encrypted transport close the
+		// connection since the public key does not start with the
+		// network magic.
+		remotePub := make([]byte, transportKeySize)
+		if _, err := io.ReadFull(p.conn, remotePub); err != nil {
+			if isConnClosed(err) {
+				p.rejectEncryption()
+			}
+			return err
+		}
+		conn, err := newEncryptedConn(p.conn, net, false, priv, pub,
+			remotePub)
+		if err != nil {
+			p.rejectEncryption()
+			return err
+		}
+		p.setTransport(conn, true)
+		return nil
+	}
+
+	if !p.cfg.AcceptEncryption {
+		return nil
+	}
+
+	// Unencrypted connections start with the network magic.
+	remotePub := make([]byte, transportKeySize)
+	if _, err := io.ReadFull(p.conn, remotePub[:4]); err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint32(remotePub[:4]) == uint32(net) {
+		p.setTransport(&prefixedConn{p.conn, remotePub[:4]}, false)
+		return nil
+	}
+
+	if _, err := io.ReadFull(p.conn, remotePub[4:]); err != nil {
+		return err
+	}
+	priv, pub, err := newTransportKey(net)
+	if err != nil {
+		return err
+	}
+	if _, err := p.conn.Write(pub); err != nil {
+		return err
+	}
+	conn, err := newEncryptedConn(p.conn, net, true, priv, remotePub, pub)
+	if err != nil {
+		return err
+	}
+	p.setTransport(conn, true)
+	return nil
+}
+
+// setTransport sets the connection messages are read from and written to
+// along with whether or not it is encrypted.
+func (p *Peer) setTransport(rw io.ReadWriter, encrypted bool) {
+	p.flagsMtx.Lock()
+	p.rw = rw
+	p.encrypted = encrypted
+	p.flagsMtx.Unlock()
+}
+
+// isConnClosed returns whether or not the passed error shows the remote peer
+// closed the connection, as opposed to the connection timing out or failing
+// otherwise.
+func isConnClosed(err error) bool {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	var errno syscall.Errno
+	return errors.As(err, &errno) && errno == syscall.ECONNRESET
+}
+
+// rejectEncryption marks the encrypted transport requested from the remote
+// peer as rejected.
+func (p *Peer) rejectEncryption() {
+	p.flagsMtx.Lock()
+	p.encryptionRejected = true
+	p.flagsMtx.Unlock()
+}
+
+// EncryptionRejected returns whether or not the remote peer rejected the
+// encrypted transport requested by the local peer or failed to complete it.
+// Failures of the connection itself, such as timeouts, are not considered a
+// rejection.
+//
+// This function is safe for concurrent access.
+func (p *Peer) EncryptionRejected() bool {
+	p.flagsMtx.Lock()
+	rejected := p.encryptionRejected
+	p.flagsMtx.Unlock()
+
+	return rejected
+}
+
+// Encrypted returns whether or not the messages exchanged with the peer are
+// encrypted.
+//
+// This function is safe for concurrent access.
+func (p *Peer) Encrypted() bool {
+	p.flagsMtx.Lock()
+	encrypted := p.encrypted
+	p.flagsMtx.Unlock()
+
+	return encrypted
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer_test
+
+import (
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nbit99/hcd/chaincfg"
+	"github.com/nbit99/hcd/peer"
+	"github.com/nbit99/hcd/wire"
+	"golang.org/x/crypto/curve25519"
+)
+
+// TestEncryptedTransport tests the negotiation of the encrypted transport
+// along with the fall back to the unencrypted transport for outbound peers
+// which do not request it.
+func TestEncryptedTransport(t *testing.T) {
+	tests := []struct {
+		name          string
+		accept        bool
+		initiate      bool
+		wantEncrypted bool
+	}{
+		{"encrypted", true, true, true},
+		{"outbound unencrypted", true, false, false},
+		{"both unencrypted", false, false, false},
+	}
+
+	for _, test := range tests {
+		verack := make(chan struct{}, 2)
+		pong := make(chan struct{}, 1)
+		listeners := peer.MessageListeners{
+			OnVerAck: func(p *peer.Peer, msg *wire.MsgVerAck) {
+				verack <- struct{}{}
+			},
+			OnPong: func(p *peer.Peer, msg *wire.MsgPong) {
+				pong <- struct{}{}
+			},
+		}
+		inCfg := &peer.Config{
+			Listeners:        listeners,
+			ChainParams:      &chaincfg.MainNetParams,
+			AcceptEncryption: test.accept,
+		}
+		outCfg := &peer.Config{
+			Listeners:          listeners,
+			ChainParams:        &chaincfg.MainNetParams,
+			InitiateEncryption: test.initiate,
+		}
+
+		inConn, outConn := pipe(
+			&conn{raddr: "10.0.0.1:8333"},
+			&conn{raddr: "10.0.0.2:8333"},
+		)
+		inPeer := peer.NewInboundPeer(inCfg)
+		inPeer.AssociateConnection(inConn)
+		outPeer, err := peer.NewOutboundPeer(outCfg, "10.0.0.2:8333")
+		if err != nil {
+			t.Fatalf("%s: NewOutboundPeer: unexpected err %v",
+				test.name, err)
+		}
+		outPeer.AssociateConnection(outConn)
+
+		for i := 0; i < 2; i++ {
+			select {
+			case <-verack:
+			case <-time.After(time.Second):
+				t.Fatalf("%s: verack timeout", test.name)
+			}
+		}
+
+		for _, p := range []*peer.Peer{inPeer, outPeer} {
+			if p.Encrypted() != test.wantEncrypted {
+				t.Errorf("%s: Encrypted (inbound %v): got %v, "+
+					"want %v", test.name, p.Inbound(),
+					p.Encrypted(), test.wantEncrypted)
+			}
+			if p.StatsSnapshot().Encrypted != test.wantEncrypted {
+				t.Errorf("%s: StatsSnapshot.Encrypted (inbound "+
+					"%v): got %v, want %v", test.name,
+					p.Inbound(), !test.wantEncrypted,
+					test.wantEncrypted)
+			}
+		}
+
+		// Ensure messages keep flowing after the handshake.
+		outPeer.QueueMessage(wire.NewMsgPing(1), nil)
+		select {
+		case <-pong:
+		case <-time.After(time.Second):
+			t.Fatalf("%s: pong timeout", test.name)
+		}
+
+		inPeer.Disconnect()
+		outPeer.Disconnect()
+	}
+}
+
+// TestEncryptionRejected ensures the encrypted transport is only considered
+// rejected when the remote peer closes the connection instead of completing
+// the key exchange, and not when the connection fails afterwards.
+func TestEncryptionRejected(t *testing.T) {
+	tests := []struct {
+		name         string
+		completeKex  bool
+		wantRejected bool
+	}{
+		{"closed during key exchange", false, true},
+		{"closed after key exchange", true, false},
+	}
+
+	for _, test := range tests {
+		local, remote := net.Pipe()
+		go func(completeKex bool) {
+			defer remote.Close()
+
+			// Read the public key of the outbound peer and close the
+			// connection like peers which do not support the
+			// encrypted transport do.
+			var pub [32]byte
+			if _, err := io.ReadFull(remote, pub[:]); err != nil {
+				return
+			}
+			if !completeKex {
+				return
+			}
+
+			// Otherwise complete the key exchange and close the
+			// connection once the version message arrived.
+			priv := make([]byte, curve25519.ScalarSize)
+			rand.Read(priv)
+			remotePub, _ := curve25519.X25519(priv,
+				curve25519.Basepoint)
+			if _, err := remote.Write(remotePub); err != nil {
+				return
+			}
+			remote.Read(make([]byte, 1))
+		}(test.completeKex)
+
+		outCfg := &peer.Config{
+			ChainParams:        &chaincfg.MainNetParams,
+			InitiateEncryption: true,
+		}
+		outPeer, err := peer.NewOutboundPeer(outCfg, "10.0.0.2:8333")
+		if err != nil {
+			t.Fatalf("%s: NewOutboundPeer: unexpected err %v",
+				test.name, err)
+		}
+		outPeer.AssociateConnection(local)
+
+		select {
+		case <-disconnected(outPeer):
+		case <-time.After(time.Second):
+			t.Fatalf("%s: disconnect timeout", test.name)
+		}
+		if outPeer.EncryptionRejected() != test.wantRejected {
+			t.Errorf("%s: EncryptionRejected: got %v, want %v",
+				test.name, outPeer.EncryptionRejected(),
+				test.wantRejected)
+		}
+	}
+}
+
+// disconnected returns a channel which is closed once the passed peer
+// disconnected.
+func disconnected(p *peer.Peer) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		p.WaitForDisconnect()
+		close(done)
+	}()
+	return done
+}
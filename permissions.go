@@ -0,0 +1,172 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// permissionFlags houses the permissions which are granted to the peers of a
+// whitelisted network or of a whitebind listener.
+type permissionFlags uint32
+
+const (
+	// permNoBan allows the peer to misbehave without being banned, to
+	// connect when the maximum number of peers is reached and protects it
+	// from eviction.
+	permNoBan permissionFlags = 1 << iota
+
+	// permRelay accepts and relays the transactions of the peer even when
+	// transactions of remote peers are not accepted due to --blocksonly.
+	permRelay
+
+	// permForceRelay relays the transactions of the peer even when they are
+	// already in the memory pool.  It implies permRelay.
+	permForceRelay
+
+	// permMempool allows the peer to request the contents of the memory
+	// pool with the mempool message regardless of bloom filter support
+	// and without increasing its ban score.
+	permMempool
+
+	// permDownload allows the peer to request data without increasing its
+	// ban score and exempts it from the upload target and the send rate
+	// limit.
+	permDownload
+
+	// permAddr answers requests for addresses of the peer even when it is
+	// an outbound peer.
+	permAddr
+
+	// permImplicit are the permissions granted when a whitelist or
+	// whitebind entry does not name any.
+	permImplicit = permNoBan | permRelay | permMempool | permDownload
+
+	// permAll are all known permissions.
+	permAll = permNoBan | permRelay | permForceRelay | permMempool |
+		permDownload | permAddr
+)
+
+// permissionNames maps the permissions to the names they are configured with.
+var permissionNames = []struct {
+	flag permissionFlags
+	name string
+}{
+	{permNoBan, "noban"},
+	{permRelay, "relay"},
+	{permForceRelay, "forcerelay"},
+	{permMempool, "mempool"},
+	{permDownload, "download"},
+	{permAddr, "addr"},
+}
+
+// has returns whether or not all of the passed permissions are granted.
+func (f permissionFlags) has(perms permissionFlags) bool {
+	return f&perms == perms
+}
+
+// names returns the names of the granted permissions.
+func (f permissionFlags) names() []string {
+	var names []string
+	for _, p := range permissionNames {
+		if f.has(p.flag) {
+			names = append(names, p.name)
+		}
+	}
+	return names
+}
+
+// String returns the granted permissions as a comma separated list of their
+// names.
+func (f permissionFlags) String() string {
+	return strings.Join(f.names(), ",")
+}
+
+// parsePermissions parses a whitelist or whitebind entry in the form of
+// '[permissions@]value', where permissions is a comma separated list of
+// permission names, and returns the permissions along with the value.  The
+// implicit permissions are returned when the entry does not name any.
+func parsePermissions(entry string) (permissionFlags, string, error) {
+	at := strings.Index(entry, "@")
+	if at == -1 {
+		return permImplicit, entry, nil
+	}
+
+	var perms permissionFlags
+	for _, name := range strings.Split(entry[:at], ",") {
+		name = strings.TrimSpace(name)
+		if name == "all" {
+			perms |= permAll
+			continue
+		}
+		var known bool
+		for _, p := range permissionNames {
+			if p.name == name {
+				perms |= p.flag
+				known = true
+				break
+			}
+		}
+		if !known {
+			return 0, "", fmt.Errorf("unknown permission %q", name)
+		}
+	}
+	if perms.has(permForceRelay) {
+		perms |= permRelay
+	}
+	return perms, entry[at+1:], nil
+}
+
+// whitelist houses a whitelisted network along with the permissions granted to
+// its peers.
+type whitelist struct {
+	ipnet *net.IPNet
+	perms permissionFlags
+}
+
+// whitebind houses the address of a whitebind listener along with the
+// permissions granted to the peers connecting to it.
+type whitebind struct {
+	addr  string
+	perms permissionFlags
+}
+
+// permListener wraps a listener so the connections it accepts are granted the
+// permissions of the listener.
+type permListener struct {
+	net.Listener
+	perms permissionFlags
+}
+
+// permConn is a connection which was accepted by a permListener.
+type permConn struct {
+	net.Conn
+	perms permissionFlags
+}
+
+// Accept waits for and returns the next connection to the listener along with
+// the permissions of the listener.
+//
+// This is part of the net.Listener interface.
+func (l *permListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &permConn{Conn: conn, perms: l.perms}, nil
+}
+
+// connPermissions returns the permissions granted to the peer of the passed
+// inbound connection by the whitelisted networks and the whitebind listener it
+// was accepted by.
+func connPermissions(conn net.Conn) permissionFlags {
+	perms := whitelistPermissions(conn.RemoteAddr())
+	if pc, ok := conn.(*permConn); ok {
+		perms |= pc.perms
+	}
+	return perms
+}
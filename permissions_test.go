@@ -0,0 +1,85 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+)
+
+// TestParsePermissions ensures whitelist and whitebind entries are parsed into
+// their permissions and values as expected.
+func TestParsePermissions(t *testing.T) {
+	tests := []struct {
+		entry     string
+		wantPerms permissionFlags
+		wantValue string
+		wantErr   bool
+	}{
+		{
+			entry:     "192.168.1.0/24",
+			wantPerms: permImplicit,
+			wantValue: "192.168.1.0/24",
+		},
+		{
+			entry:     "noban@::1",
+			wantPerms: permNoBan,
+			wantValue: "::1",
+		},
+		{
+			entry:     "relay, mempool@[::1]:9108",
+			wantPerms: permRelay | permMempool,
+			wantValue: "[::1]:9108",
+		},
+		{
+			entry:     "forcerelay@10.0.0.0/8",
+			wantPerms: permForceRelay | permRelay,
+			wantValue: "10.0.0.0/8",
+		},
+		{
+			entry:     "all@127.0.0.1",
+			wantPerms: permAll,
+			wantValue: "127.0.0.1",
+		},
+		{
+			entry:     "download,addr@127.0.0.1",
+			wantPerms: permDownload | permAddr,
+			wantValue: "127.0.0.1",
+		},
+		{
+			entry:   "noban,bogus@127.0.0.1",
+			wantErr: true,
+		},
+		{
+			entry:   "@127.0.0.1",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		perms, value, err := parsePermissions(test.entry)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("parsePermissions(%q): expected error",
+					test.entry)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parsePermissions(%q): unexpected error: %v",
+				test.entry, err)
+			continue
+		}
+		if perms != test.wantPerms || value != test.wantValue {
+			t.Errorf("parsePermissions(%q): got %v and %q, want %v "+
+				"and %q", test.entry, perms, value,
+				test.wantPerms, test.wantValue)
+		}
+	}
+
+	// The names of the permissions are listed in a fixed order.
+	if got := (permMempool | permNoBan).String(); got != "noban,mempool" {
+		t.Errorf("String: got %q, want %q", got, "noban,mempool")
+	}
+}
@@ -36,7 +36,9 @@ import (
 	"github.com/btcsuite/websocket"
 
 	"github.com/HcashOrg/bitset"
+	"github.com/nbit99/hcd/addrmgr"
 	"github.com/nbit99/hcd/blockchain"
+	"github.com/nbit99/hcd/blockchain/indexers"
 	"github.com/nbit99/hcd/blockchain/stake"
 	"github.com/nbit99/hcd/chaincfg"
 	"github.com/nbit99/hcd/chaincfg/chainec"
@@ -83,11 +85,32 @@ const (
 	getworkDataLen = (1 + ((wire.MaxBlockHeaderPayload*8 + 65) /
 		(chainhash.HashBlockSize * 8))) * chainhash.HashBlockSize
 
+	// getworkMidstateLen is the length of the prefix of the data field of
+	// the getwork RPC which the returned midstate is calculated from.  It
+	// covers all of the hash blocks except the final one, which contains
+	// the timestamp and nonce.
+	getworkMidstateLen = getworkDataLen - chainhash.HashBlockSize
+
 	// getworkExpirationDiff is the number of blocks below the current
 	// best block in height to begin pruning out old block work from
 	// the template pool.
 	getworkExpirationDiff = 3
 
+	// getworkCheckInterval is the interval at which the best block, the
+	// votes for it and the memory pool are checked for whether the work
+	// handed out by the getwork RPC has to be regenerated.
+	getworkCheckInterval = time.Second
+
+	// getworkRegenerateInterval is the minimum interval between two
+	// regenerations of the getwork block template which are only caused by
+	// changes to the transactions in the memory pool.
+	getworkRegenerateInterval = time.Second * 10
+
+	// getworkFeeJumpPercent is the percentage the total fees of a new
+	// getwork block template must exceed the fees of the work callers were
+	// last notified about for them to be notified again.
+	getworkFeeJumpPercent = 10
+
 	// gbtNonceRange is two 32-bit big-endian hexadecimal integers which
 	// represent the valid ranges of nonces returned by the getblocktemplate
 	// RPC.
@@ -196,6 +219,7 @@ var rpcHandlersBeforeInit = map[string]commandHandler{
 	"existsmempooltxs":      handleExistsMempoolTxs,
 	"generate":              handleGenerate,
 	"getaddednodeinfo":      handleGetAddedNodeInfo,
+	"getaddrmaninfo":        handleGetAddrManInfo,
 	"getbestblock":          handleGetBestBlock,
 	"getbestblockhash":      handleGetBestBlockHash,
 	"getblock":              handleGetBlock,
@@ -210,12 +234,15 @@ var rpcHandlersBeforeInit = map[string]commandHandler{
 	"getgenerate":           handleGetGenerate,
 	"gethashespersec":       handleGetHashesPerSec,
 	"getheaders":            handleGetHeaders,
+	"getindexinfo":          handleGetIndexInfo,
 	"getinfo":               handleGetInfo,
 	"getblockchaininfo":     handleGetBlockchainInfo,
 	"getmempoolinfo":        handleGetMempoolInfo,
+	"getmempoolrejects":     handleGetMempoolRejects,
 	"getmininginfo":         handleGetMiningInfo,
 	"getnettotals":          handleGetNetTotals,
 	"getnetworkhashps":      handleGetNetworkHashPS,
+	"getnodeaddresses":      handleGetNodeAddresses,
 	"getpeerinfo":           handleGetPeerInfo,
 	"getrawmempool":         handleGetRawMempool,
 	"getrawtransaction":     handleGetRawTransaction,
@@ -228,12 +255,15 @@ var rpcHandlersBeforeInit = map[string]commandHandler{
 	"getwork":               handleGetWork,
 	"help":                  handleHelp,
 	"livetickets":           handleLiveTickets,
+	"loadmempool":           handleLoadMempool,
 	"missedtickets":         handleMissedTickets,
 	"node":                  handleNode,
 	"ping":                  handlePing,
+	"prioritisetransaction": handlePrioritiseTransaction,
 	"searchrawtransactions": handleSearchRawTransactions,
 	"rebroadcastmissed":     handleRebroadcastMissed,
 	"rebroadcastwinners":    handleRebroadcastWinners,
+	"savemempool":           handleSaveMempool,
 	"sendrawtransaction":    handleSendRawTransaction,
 	"setgenerate":           handleSetGenerate,
 	"stop":                  handleStop,
@@ -422,6 +452,14 @@ func rpcNoTxInfoError(txHash *chainhash.Hash) *hcjson.RPCError {
 			txHash))
 }
 
+// rpcIndexSyncingError is a convenience function for returning a nicely
+// formatted RPC error which indicates the passed index is still being caught
+// up to the main chain.
+func rpcIndexSyncingError(name string, height int32) *hcjson.RPCError {
+	return hcjson.NewRPCError(hcjson.ErrRPCIndexSyncing,
+		fmt.Sprintf("%s syncing, at height %d", name, height))
+}
+
 // rpcMiscError is a convenience function for returning a nicely formatted RPC
 // error which indicates there is a unquantifiable error.  Use this sparingly;
 // misc return codes are a cop out.
@@ -443,14 +481,50 @@ type workState struct {
 	lastTxUpdate  time.Time
 	lastGenerated time.Time
 	prevHash      *chainhash.Hash
+	numVotes      int
 	msgBlock      *wire.MsgBlock
 	extraNonce    uint64
+
+	// The following fields describe the work long polling getwork callers
+	// and notifywork websocket clients were last notified about.  The work
+	// changed channel is closed and replaced whenever the work changes
+	// materially.
+	workID         int64
+	workChanged    chan struct{}
+	notifiedParent chainhash.Hash
+	notifiedVoters uint16
+	notifiedFees   int64
+
+	// blockConnected is used to wake up the work handler as soon as a new
+	// block is connected to the main chain.
+	blockConnected chan struct{}
 }
 
 // newWorkState returns a new instance of a workState with all internal fields
 // initialized and ready to use.
 func newWorkState() *workState {
-	return &workState{}
+	return &workState{
+		workChanged:    make(chan struct{}),
+		blockConnected: make(chan struct{}, 1),
+	}
+}
+
+// NotifyBlockConnected signals that a new block was connected to the main
+// chain so fresh work is generated and pushed to the callers waiting for it
+// right away.
+func (state *workState) NotifyBlockConnected() {
+	select {
+	case state.blockConnected <- struct{}{}:
+	default:
+	}
+}
+
+// longPollID returns the ID of the current work which callers pass to getwork
+// in order to wait for the work to change.
+//
+// This function MUST be called with the state locked.
+func (state *workState) longPollID() string {
+	return fmt.Sprintf("%s-%d", state.notifiedParent, state.workID)
 }
 
 // gbtWorkState houses state that is used in between multiple RPC invocations to
@@ -1557,6 +1631,9 @@ func handleExistsAddress(s *rpcServer, cmd interface{}, closeChan <-chan struct{
 		return nil, rpcInternalError("Exists address index disabled",
 			"Configuration")
 	}
+	if err := s.checkIndexSynced(existsAddrIndex); err != nil {
+		return nil, err
+	}
 
 	c := cmd.(*hcjson.ExistsAddressCmd)
 
@@ -1582,6 +1659,9 @@ func handleExistsAddresses(s *rpcServer, cmd interface{}, closeChan <-chan struc
 		return nil, rpcInternalError("Exists address index disabled",
 			"Configuration")
 	}
+	if err := s.checkIndexSynced(existsAddrIndex); err != nil {
+		return nil, err
+	}
 
 	c := cmd.(*hcjson.ExistsAddressesCmd)
 	addresses := make([]hcutil.Address, len(c.Addresses))
@@ -1857,6 +1937,32 @@ func handleGetAddedNodeInfo(s *rpcServer, cmd interface{}, closeChan <-chan stru
 	return results, nil
 }
 
+// handleGetAddrManInfo implements the getaddrmaninfo command.
+func handleGetAddrManInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	counts := s.server.addrManager.TableCounts()
+	networks := make([]hcjson.AddrManNetworkResult, 0, 3)
+	for _, network := range []string{"ipv4", "ipv6", "onion"} {
+		c, ok := counts[network]
+		if !ok {
+			c = new(addrmgr.TableCounts)
+		}
+		networks = append(networks, hcjson.AddrManNetworkResult{
+			Network: network,
+			New:     c.New,
+			Tried:   c.Tried,
+			Total:   c.New + c.Tried,
+			NewBad:  c.NewBad,
+		})
+	}
+
+	attempts, successes := s.server.FeelerStats()
+	return &hcjson.GetAddrManInfoResult{
+		Networks:        networks,
+		FeelerAttempts:  attempts,
+		FeelerSuccesses: successes,
+	}, nil
+}
+
 // handleGetBestBlock implements the getbestblock command.
 func handleGetBestBlock(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	// All other "get block" commands give either the height, the hash, or
@@ -3254,6 +3360,36 @@ func handleGetHeaders(s *rpcServer, cmd interface{}, closeChan <-chan struct{})
 	return &hcjson.GetHeadersResult{Headers: hexBlockHeaders}, nil
 }
 
+// handleGetIndexInfo implements the getindexinfo command.
+func handleGetIndexInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	// No indexes are enabled when there is no index manager.
+	if s.server.indexManager == nil {
+		return []hcjson.GetIndexInfoResult{}, nil
+	}
+
+	statuses := s.server.indexManager.IndexStatuses()
+	infos := make([]hcjson.GetIndexInfoResult, 0, len(statuses))
+	for _, status := range statuses {
+		infos = append(infos, hcjson.GetIndexInfoResult{
+			Name:   status.Name,
+			Height: status.Height,
+			Synced: status.Synced,
+		})
+	}
+	return infos, nil
+}
+
+// checkIndexSynced returns an RPC error when the passed index is still being
+// caught up to the main chain in the background and therefore can't be relied
+// on to answer queries yet.
+func (s *rpcServer) checkIndexSynced(indexer indexers.Indexer) error {
+	height, synced := s.server.indexManager.SyncState(indexer)
+	if !synced {
+		return rpcIndexSyncingError(indexer.Name(), height)
+	}
+	return nil
+}
+
 // handleGetInfo implements the getinfo command. We only return the fields
 // that are not related to wallet functionality.
 func handleGetInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
@@ -3276,19 +3412,48 @@ func handleGetInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (in
 
 // handleGetMempoolInfo implements the getmempoolinfo command.
 func handleGetMempoolInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
-	mempoolTxns := s.server.txMemPool.TxDescs()
+	mp := s.server.txMemPool
+	ret := &hcjson.GetMempoolInfoResult{
+		Size:          int64(mp.Count()),
+		Bytes:         mp.Size(),
+		MaxMempool:    mp.MaxSize(),
+		MempoolMinFee: mp.MinFeeRate().ToCoin(),
+		MinRelayTxFee: cfg.minRelayTxFee.ToCoin(),
+	}
+
+	return ret, nil
+}
 
-	var numBytes int64
-	for _, txD := range mempoolTxns {
-		numBytes += int64(txD.Tx.MsgTx().SerializeSize())
+// handleGetMempoolRejects implements the getmempoolrejects command.
+func handleGetMempoolRejects(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*hcjson.GetMempoolRejectsCmd)
+
+	var txHash *chainhash.Hash
+	if c.TxHash != nil {
+		var err error
+		txHash, err = chainhash.NewHashFromStr(*c.TxHash)
+		if err != nil {
+			return nil, rpcDecodeHexError(*c.TxHash)
+		}
 	}
 
-	ret := &hcjson.GetMempoolInfoResult{
-		Size:  int64(len(mempoolTxns)),
-		Bytes: numBytes,
+	rejects := s.server.txMemPool.RecentRejects()
+	result := make([]hcjson.GetMempoolRejectsResult, 0, len(rejects))
+	for i := range rejects {
+		rejected := &rejects[i]
+		if txHash != nil && rejected.Hash != *txHash {
+			continue
+		}
+		result = append(result, hcjson.GetMempoolRejectsResult{
+			TxID:   rejected.Hash.String(),
+			Code:   rejected.Code.String(),
+			Reason: rejected.Reason,
+			Time:   rejected.Time.Unix(),
+			Height: rejected.Height,
+		})
 	}
 
-	return ret, nil
+	return result, nil
 }
 
 // handleGetMiningInfo implements the getmininginfo command. We only return the
@@ -3335,14 +3500,55 @@ func handleGetMiningInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{
 // handleGetNetTotals implements the getnettotals command.
 func handleGetNetTotals(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	totalBytesRecv, totalBytesSent := s.server.NetTotals()
+	now := time.Now()
+	target := s.server.uploadTarget.state(now)
 	reply := &hcjson.GetNetTotalsResult{
 		TotalBytesRecv: totalBytesRecv,
 		TotalBytesSent: totalBytesSent,
-		TimeMillis:     time.Now().UTC().UnixNano() / int64(time.Millisecond),
+		TimeMillis:     now.UTC().UnixNano() / int64(time.Millisecond),
+		UploadTarget: hcjson.UploadTargetResult{
+			TimeFrame:             int64(uploadTargetTimeframe / time.Second),
+			Target:                target.target,
+			TargetReached:         target.reached,
+			ServeHistoricalBlocks: target.serveHistoricalBlocks,
+			BytesLeftInCycle:      target.bytesLeftInCycle,
+			TimeLeftInCycle:       int64(target.timeLeftInCycle / time.Second),
+		},
 	}
 	return reply, nil
 }
 
+// handleGetNodeAddresses implements the getnodeaddresses command.
+func handleGetNodeAddresses(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*hcjson.GetNodeAddressesCmd)
+
+	// A count of zero returns all known addresses.
+	count := int(*c.Count)
+	if count < 0 {
+		return nil, rpcInvalidError("Address count out of range")
+	}
+	addrs := s.server.addrManager.Addresses()
+	if count > 0 && count < len(addrs) {
+		addrs = addrs[:count]
+	}
+
+	results := make([]hcjson.GetNodeAddressesResult, 0, len(addrs))
+	for _, na := range addrs {
+		host, _, err := net.SplitHostPort(addrmgr.NetAddressKey(na))
+		if err != nil {
+			return nil, rpcInternalError(err.Error(), "Address key")
+		}
+		results = append(results, hcjson.GetNodeAddressesResult{
+			Time:     na.Timestamp.Unix(),
+			Services: uint64(na.Services),
+			Address:  host,
+			Port:     na.Port,
+			Network:  addrmgr.Network(na),
+		})
+	}
+	return results, nil
+}
+
 // handleGetNetworkHashPS implements the getnetworkhashps command.
 func handleGetNetworkHashPS(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	// Note: All valid error return paths should return an int64.  Literal
@@ -3473,10 +3679,15 @@ func handleGetPeerInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{})
 			Version:        statsSnap.Version,
 			SubVer:         statsSnap.UserAgent,
 			Inbound:        statsSnap.Inbound,
+			ConnType:       p.connType(),
+			Permissions:    p.permissions.names(),
+			MappedAS:       s.server.addrManager.ASN(p.NA()),
+			Encrypted:      statsSnap.Encrypted,
 			StartingHeight: statsSnap.StartingHeight,
 			CurrentHeight:  statsSnap.LastBlock,
 			BanScore:       int32(p.banScore.Int()),
 			SyncNode:       p == syncPeer,
+			OrphanTxs:      s.server.txMemPool.CountOrphansByTag(mempool.Tag(statsSnap.ID)),
 		}
 		if p.LastPingNonce() != 0 {
 			wait := float64(time.Since(statsSnap.LastPingTime).Nanoseconds())
@@ -3564,6 +3775,9 @@ func handleGetRawTransaction(s *rpcServer, cmd interface{}, closeChan <-chan str
 				"must be enabled to query the blockchain "+
 				"(specify --txindex)", "Configuration")
 		}
+		if err := s.checkIndexSynced(txIndex); err != nil {
+			return nil, err
+		}
 
 		// Look up the location of the transaction.
 		blockRegion, err := txIndex.TxBlockRegion(*txHash)
@@ -4171,116 +4385,202 @@ func pruneOldBlockTemplates(s *rpcServer, bestHeight int64) {
 	}
 }
 
-// handleGetWorkRequest is a helper for handleGetWork which deals with
-// generating and returning work to the caller.
+// workNeedsUpdate returns whether or not a new getwork block template has to
+// be generated.  This is the case when the best block or the votes for it
+// changed, or when the transactions in the memory pool have been updated and
+// at least the passed duration has passed since the last template was
+// generated.
 //
 // This function MUST be called with the RPC workstate locked.
-func handleGetWorkRequest(s *rpcServer) (interface{}, error) {
+func workNeedsUpdate(s *rpcServer, regenerateInterval time.Duration) bool {
 	state := s.workState
+	if state.msgBlock == nil || state.prevHash == nil {
+		return true
+	}
+	latestHash, _ := s.server.blockManager.chainState.Best()
+	if !state.prevHash.IsEqual(latestHash) {
+		return true
+	}
+	numVotes := len(s.server.txMemPool.VoteHashesForBlock(*latestHash))
+	if numVotes != state.numVotes {
+		return true
+	}
+	lastTxUpdate := s.server.txMemPool.LastUpdated()
+	return state.lastTxUpdate != lastTxUpdate &&
+		time.Now().After(state.lastGenerated.Add(regenerateInterval))
+}
 
-	// Generate a new block template when the current best block has
-	// changed or the transactions in the memory pool have been updated and
-	// it has been at least one minute since the last template was
-	// generated.
+// updateWorkTemplate generates a new getwork block template and notifies any
+// long polling getwork callers and notifywork websocket clients when it
+// differs materially from the work they were last notified about.
+//
+// This function MUST be called with the RPC workstate locked.
+func updateWorkTemplate(s *rpcServer) error {
+	state := s.workState
 	lastTxUpdate := s.server.txMemPool.LastUpdated()
 	latestHash, latestHeight := s.server.blockManager.chainState.Best()
-	msgBlock := state.msgBlock
-
-	// The current code pulls down a new template every second, however
-	// with a large mempool this will be pretty excruciating sometimes. It
-	// should examine whether or not a new template needs to be created
-	// based on the votes present every second or so, and then, if needed,
-	// generate a new block template. TODO cj
-	if msgBlock == nil || state.prevHash == nil ||
-		!state.prevHash.IsEqual(latestHash) ||
-		(state.lastTxUpdate != lastTxUpdate &&
-			time.Now().After(state.lastGenerated.Add(time.Second))) {
-		// Reset the extra nonce and clear all expired cached template
-		// variations if the best block changed.
-		if state.prevHash != nil && !state.prevHash.IsEqual(latestHash) {
-			state.extraNonce = 0
-			pruneOldBlockTemplates(s, latestHeight)
-		}
+	numVotes := len(s.server.txMemPool.VoteHashesForBlock(*latestHash))
 
-		// Reset the previous best hash the block template was
-		// generated against so any errors below cause the next
-		// invocation to try again.
-		state.prevHash = nil
+	// Reset the extra nonce and clear all expired cached template
+	// variations if the best block changed.
+	if state.prevHash != nil && !state.prevHash.IsEqual(latestHash) {
+		state.extraNonce = 0
+		pruneOldBlockTemplates(s, latestHeight)
+	}
+
+	// Reset the previous best hash the block template was generated
+	// against so any errors below cause the next invocation to try again.
+	state.prevHash = nil
 
-		// Choose a payment address at random.
-		payToAddr := cfg.miningAddrs[rand.Intn(len(cfg.miningAddrs))]
+	// Choose a payment address at random.
+	payToAddr := cfg.miningAddrs[rand.Intn(len(cfg.miningAddrs))]
+
+	template, err := NewBlockTemplate(s.policy, s.server, payToAddr)
+	if err != nil {
+		context := "Failed to create new block template"
+		return rpcInternalError(err.Error(), context)
+	}
+	if template == nil {
+		// This happens if the template is returned nil because there
+		// are not enough voters on HEAD and there is currently an
+		// unsuitable parent cached template to try building off of.
+		context := "Failed to create new block template: not " +
+			"enough voters and failed to find a suitable " +
+			"parent template to build from"
+		return rpcInternalError("internal error", context)
+	}
+	templateCopy := deepCopyBlockTemplate(template)
+	msgBlock := templateCopy.Block
+
+	// Update work state to ensure another block template isn't generated
+	// until needed.
+	state.msgBlock = msgBlock
+	state.lastGenerated = time.Now()
+	state.lastTxUpdate = lastTxUpdate
+	state.prevHash = latestHash
+	state.numVotes = numVotes
+
+	rpcsLog.Debugf("Generated block template (timestamp %v, extra "+
+		"nonce %d, target %064x, merkle root %s)",
+		msgBlock.Header.Timestamp, state.extraNonce,
+		blockchain.CompactToBig(msgBlock.Header.Bits),
+		msgBlock.Header.MerkleRoot)
+
+	// Notify the callers waiting for new work when the template builds on
+	// a different block, includes more votes or pays notably more fees
+	// than the work they were last notified about.
+	var fees int64
+	if len(templateCopy.Fees) > 0 {
+		fees = -templateCopy.Fees[0]
+	}
+	minFeeJump := state.notifiedFees * getworkFeeJumpPercent / 100
+	if minFeeJump < int64(cfg.minRelayTxFee) {
+		minFeeJump = int64(cfg.minRelayTxFee)
+	}
+	var reason string
+	switch {
+	case msgBlock.Header.PrevBlock != state.notifiedParent:
+		reason = hcjson.WorkReasonNewParent
+	case msgBlock.Header.Voters > state.notifiedVoters:
+		reason = hcjson.WorkReasonNewVotes
+	case fees >= state.notifiedFees+minFeeJump:
+		reason = hcjson.WorkReasonNewTxns
+	default:
+		return nil
+	}
+	state.workID++
+	state.notifiedParent = msgBlock.Header.PrevBlock
+	state.notifiedVoters = msgBlock.Header.Voters
+	state.notifiedFees = fees
+	close(state.workChanged)
+	state.workChanged = make(chan struct{})
+	s.ntfnMgr.NotifyWork(reason)
 
-		template, err := NewBlockTemplate(s.policy, s.server, payToAddr)
+	rpcsLog.Debugf("Notifying getwork callers about new work (%s)", reason)
+	return nil
+}
+
+// newWorkVariation returns a new variation of the current getwork block
+// template with the time updated and, beyond the first blocks, the extra nonce
+// incremented so every caller is handed distinct work.
+//
+// This function MUST be called with the RPC workstate locked.
+func newWorkVariation(s *rpcServer) (*wire.MsgBlock, error) {
+	state := s.workState
+
+	// Update the existing block template and track the variations so each
+	// variation can be regenerated if a caller finds an answer and makes a
+	// submission against it.
+	templateCopy := deepCopyBlockTemplate(&BlockTemplate{
+		Block: state.msgBlock,
+	})
+	msgBlock := templateCopy.Block
+
+	// Update the time of the block template to the current time while
+	// accounting for the median time of the past several blocks per the
+	// chain consensus rules.
+	UpdateBlockTime(msgBlock, s.server.blockManager)
+
+	if templateCopy.Height > 1 {
+		// Increment the extra nonce and update the block template with
+		// the new value by regenerating the coinbase script and setting
+		// the merkle root to the new value.
+		_, latestHeight := s.server.blockManager.chainState.Best()
+		ens := getCoinbaseExtranonces(msgBlock)
+		state.extraNonce++
+		ens[0]++
+		err := UpdateExtraNonce(msgBlock, latestHeight+1, ens)
 		if err != nil {
-			context := "Failed to create new block template"
-			return nil, rpcInternalError(err.Error(), context)
-		}
-		if template == nil {
-			// This happens if the template is returned nil because
-			// there are not enough voters on HEAD and there is
-			// currently an unsuitable parent cached template to
-			// try building off of.
-			context := "Failed to create new block template: not " +
-				"enough voters and failed to find a suitable " +
-				"parent template to build from"
-			return nil, rpcInternalError("internal error", context)
+			errStr := fmt.Sprintf("Failed to update extra nonce: %v",
+				err)
+			return nil, rpcInternalError(errStr, "")
 		}
-		templateCopy := deepCopyBlockTemplate(template)
-		msgBlock = templateCopy.Block
+	}
 
-		// Update work state to ensure another block template isn't
-		// generated until needed.
-		state.msgBlock = msgBlock
-		state.lastGenerated = time.Now()
-		state.lastTxUpdate = lastTxUpdate
-		state.prevHash = latestHash
+	rpcsLog.Debugf("Updated block template (timestamp %v, extra "+
+		"nonce %d, target %064x, merkle root %s)",
+		msgBlock.Header.Timestamp,
+		state.extraNonce,
+		blockchain.CompactToBig(msgBlock.Header.Bits),
+		msgBlock.Header.MerkleRoot)
 
-		rpcsLog.Debugf("Generated block template (timestamp %v, extra "+
-			"nonce %d, target %064x, merkle root %s)",
-			msgBlock.Header.Timestamp, state.extraNonce,
-			blockchain.CompactToBig(msgBlock.Header.Bits),
-			msgBlock.Header.MerkleRoot)
-	} else {
-		// At this point, there is a saved block template and a new
-		// request for work was made, but either the available
-		// transactions haven't change or it hasn't been long enough to
-		// trigger a new block template to be generated.  So, update the
-		// existing block template and track the variations so each
-		// variation can be regenerated if a caller finds an answer and
-		// makes a submission against it.
-		templateCopy := deepCopyBlockTemplate(&BlockTemplate{
-			Block: msgBlock,
-		})
-		msgBlock = templateCopy.Block
+	return msgBlock, nil
+}
 
-		// Update the time of the block template to the current time
-		// while accounting for the median time of the past several
-		// blocks per the chain consensus rules.
-		UpdateBlockTime(msgBlock, s.server.blockManager)
-
-		if templateCopy.Height > 1 {
-			// Increment the extra nonce and update the block template
-			// with the new value by regenerating the coinbase script and
-			// setting the merkle root to the new value.
-			ens := getCoinbaseExtranonces(msgBlock)
-			state.extraNonce++
-			ens[0]++
-			err := UpdateExtraNonce(msgBlock, latestHeight+1, ens)
-			if err != nil {
-				errStr := fmt.Sprintf("Failed to update extra nonce: "+
-					"%v", err)
-				return nil, rpcInternalError(errStr, "")
-			}
+// handleGetWorkRequest is a helper for handleGetWork which deals with
+// generating and returning work to the caller.
+//
+// This function MUST be called with the RPC workstate locked.
+func handleGetWorkRequest(s *rpcServer) (interface{}, error) {
+	// Generate a new block template when the current best block or the
+	// votes for it have changed, or the transactions in the memory pool
+	// have been updated and it has been at least one second since the last
+	// template was generated.  Otherwise, hand out a new variation of the
+	// existing block template.
+	var msgBlock *wire.MsgBlock
+	if workNeedsUpdate(s, time.Second) {
+		if err := updateWorkTemplate(s); err != nil {
+			return nil, err
+		}
+		msgBlock = s.workState.msgBlock
+	} else {
+		var err error
+		msgBlock, err = newWorkVariation(s)
+		if err != nil {
+			return nil, err
 		}
-
-		rpcsLog.Debugf("Updated block template (timestamp %v, extra "+
-			"nonce %d, target %064x, merkle root %s)",
-			msgBlock.Header.Timestamp,
-			state.extraNonce,
-			blockchain.CompactToBig(msgBlock.Header.Bits),
-			msgBlock.Header.MerkleRoot)
 	}
 
+	return workResult(s, msgBlock)
+}
+
+// workResult records the passed variation of the getwork block template so
+// submissions against it can be checked and returns the work for it.
+//
+// This function MUST be called with the RPC workstate locked.
+func workResult(s *rpcServer, msgBlock *wire.MsgBlock) (*hcjson.GetWorkResult, error) {
+	state := s.workState
+
 	// In order to efficiently store the variations of block templates that
 	// have been provided to callers, save a pointer to the block as well
 	// as the modified signature script keyed by the merkle root.  This
@@ -4337,13 +4637,98 @@ func handleGetWorkRequest(s *rpcServer) (interface{}, error) {
 	// an artifact of some legacy internal state in the reference
 	// implementation, but it is required for compatibility.
 	target := bigToLEUint256(blockchain.CompactToBig(msgBlock.Header.Bits))
+
+	// Provide the midstate of the part of the data which does not contain
+	// the timestamp and nonce so callers only need to hash the final chunk
+	// for every nonce.
+	midstate := chainhash.NewMidstate(data[:getworkMidstateLen]).Bytes()
 	reply := &hcjson.GetWorkResult{
-		Data:   hex.EncodeToString(data),
-		Target: hex.EncodeToString(target[:]),
+		Data:       hex.EncodeToString(data),
+		Midstate:   hex.EncodeToString(midstate[:]),
+		Target:     hex.EncodeToString(target[:]),
+		LongPollID: state.longPollID(),
 	}
 	return reply, nil
 }
 
+// handleGetWorkLongPoll is a helper for handleGetWork which deals with long
+// polling for work.  When a caller sends a request with a long poll ID that
+// was previously returned, a response is not sent until the work changed
+// materially, which is the case when a new block was connected, more votes
+// for the parent block are available or the fees the work pays jumped.
+func handleGetWorkLongPoll(s *rpcServer, longPollID string, closeChan <-chan struct{}) (interface{}, error) {
+	state := s.workState
+	state.Lock()
+	// The state unlock is intentionally not deferred here since it needs to
+	// be manually unlocked before waiting for the work to change.
+
+	// Return new work right away if the long poll ID provided by the caller
+	// is invalid or identifies stale work.
+	if longPollID == state.longPollID() {
+		workChanged := state.workChanged
+		state.Unlock()
+
+		select {
+		// When the client closes before it's time to send a reply, just
+		// return now so the goroutine doesn't hang around.
+		case <-closeChan:
+			return nil, ErrClientQuit
+
+		// Wait until the work changed to send the reply.
+		case <-workChanged:
+		}
+
+		state.Lock()
+	}
+	defer state.Unlock()
+
+	return handleGetWorkRequest(s)
+}
+
+// workHandler regenerates the work handed out by the getwork RPC as the best
+// block, the votes for it and the memory pool change so long polling getwork
+// callers and notifywork websocket clients are notified as soon as the work
+// changes materially.  It must be run as a goroutine.
+func (s *rpcServer) workHandler() {
+	ticker := time.NewTicker(getworkCheckInterval)
+	defer ticker.Stop()
+
+out:
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.workState.blockConnected:
+		case <-s.quit:
+			break out
+		}
+
+		// There is no point in generating work while getwork is
+		// disallowed or before the chain is synced.
+		if s.server.cpuMiner.IsMining() {
+			continue
+		}
+		if !cfg.SimNet && s.server.ConnectedCount() == 0 {
+			continue
+		}
+		_, currentHeight := s.server.blockManager.chainState.Best()
+		if currentHeight != 0 && !s.server.blockManager.IsCurrent() {
+			continue
+		}
+
+		s.workState.Lock()
+		if workNeedsUpdate(s, getworkRegenerateInterval) {
+			if err := updateWorkTemplate(s); err != nil {
+				rpcsLog.Debugf("Unable to update getwork block "+
+					"template: %v", err)
+			}
+		}
+		s.workState.Unlock()
+	}
+
+	s.wg.Done()
+	rpcsLog.Tracef("Getwork handler done")
+}
+
 // handleGetWorkSubmission is a helper for handleGetWork which deals with
 // the calling submitting work to be verified and processed.
 //
@@ -4478,6 +4863,13 @@ func handleGetWork(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (in
 
 	c := cmd.(*hcjson.GetWorkCmd)
 
+	// When the caller provides a long poll ID without data, it waits for
+	// the work to change.
+	hasData := c.Data != nil && *c.Data != ""
+	if !hasData && c.LongPollID != nil && *c.LongPollID != "" {
+		return handleGetWorkLongPoll(s, *c.LongPollID, closeChan)
+	}
+
 	// Protect concurrent access from multiple RPC invocations for work
 	// requests and submission.
 	s.workState.Lock()
@@ -4486,7 +4878,7 @@ func handleGetWork(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (in
 	// When the caller provides data, it is a submission of a supposedly
 	// solved block that needs to be checked and submitted to the network
 	// if valid.
-	if c.Data != nil && *c.Data != "" {
+	if hasData {
 		return handleGetWorkSubmission(s, *c.Data)
 	}
 
@@ -4546,6 +4938,26 @@ func handleLiveTickets(s *rpcServer, cmd interface{}, closeChan <-chan struct{})
 	return hcjson.LiveTicketsResult{Tickets: ltString}, nil
 }
 
+// handleLoadMempool implements the loadmempool command.
+func handleLoadMempool(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	if atomic.LoadInt32(&s.server.mempoolLoaded) == 0 {
+		return nil, rpcMiscError("Mempool is still being loaded")
+	}
+
+	path := mempoolFilePath()
+	txns, skipped, err := s.server.txMemPool.Load(path, closeChan)
+	if err != nil {
+		return nil, rpcMiscError(fmt.Sprintf("Unable to load mempool "+
+			"from %s: %v", path, err))
+	}
+	s.server.AnnounceNewTransactions(txns)
+
+	return hcjson.LoadMempoolResult{
+		Accepted: len(txns),
+		Skipped:  skipped,
+	}, nil
+}
+
 // handleMissedTickets implements the missedtickets command.
 func handleMissedTickets(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	mt, err := s.server.blockManager.chain.MissedTickets()
@@ -4575,6 +4987,25 @@ func handlePing(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (inter
 	return nil, nil
 }
 
+// handlePrioritiseTransaction implements the prioritisetransaction command.
+func handlePrioritiseTransaction(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*hcjson.PrioritiseTransactionCmd)
+
+	txHash, err := chainhash.NewHashFromStr(c.TxID)
+	if err != nil {
+		return nil, rpcDecodeHexError(c.TxID)
+	}
+
+	// The fee delta only applies to transactions in the pool and affects
+	// the block templates generated from now on.
+	_, err = s.server.txMemPool.PrioritiseTransaction(txHash, c.FeeDelta)
+	if err != nil {
+		return nil, rpcNoTxInfoError(txHash)
+	}
+
+	return true, nil
+}
+
 // handleRebroadcastMissed implements the rebroadcastmissed command.
 func handleRebroadcastMissed(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	hash, height := s.server.blockManager.chainState.Best()
@@ -4632,6 +5063,26 @@ func handleRebroadcastWinners(s *rpcServer, cmd interface{}, closeChan <-chan st
 	return nil, nil
 }
 
+// handleSaveMempool implements the savemempool command.
+func handleSaveMempool(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	// Saving a partially loaded mempool would lose the transactions which
+	// were not loaded yet.
+	if atomic.LoadInt32(&s.server.mempoolLoaded) == 0 {
+		return nil, rpcMiscError("Mempool is still being loaded")
+	}
+
+	path := mempoolFilePath()
+	count, err := s.server.txMemPool.Save(path)
+	if err != nil {
+		return nil, rpcInternalError(err.Error(), "Unable to save mempool")
+	}
+
+	return hcjson.SaveMempoolResult{
+		Filename:     path,
+		Transactions: count,
+	}, nil
+}
+
 // retrievedTx represents a transaction that was either loaded from the
 // transaction memory pool or from the database.  When a transaction is loaded
 // from the database, it is loaded with the raw serialized bytes while the
@@ -4675,7 +5126,11 @@ func fetchInputTxos(s *rpcServer, tx *wire.MsgTx) (map[wire.OutPoint]wire.TxOut,
 			continue
 		}
 
-		// Look up the location of the transaction.
+		// Look up the location of the transaction.  The index must be
+		// caught up for the lookup to be reliable.
+		if err := s.checkIndexSynced(s.server.txIndex); err != nil {
+			return nil, err
+		}
 		blockRegion, err := s.server.txIndex.TxBlockRegion(origin.Hash)
 		if err != nil {
 			context := "Failed to retrieve transaction location"
@@ -4886,6 +5341,9 @@ func handleSearchRawTransactions(s *rpcServer, cmd interface{}, closeChan <-chan
 		return nil, rpcInternalError("Address index must be "+
 			"enabled (--addrindex)", "Configuration")
 	}
+	if err := s.checkIndexSynced(addrIndex); err != nil {
+		return nil, err
+	}
 
 	// Override the flag for including extra previous output information in
 	// each input if needed.
@@ -5168,9 +5626,16 @@ func handleSendRawTransaction(s *rpcServer, cmd interface{}, closeChan <-chan st
 			err)
 	}
 
+	// Relay the transaction along a Dandelion++ stem when enabled, in which
+	// case it is only added to the memory pool once it is diffused.
 	tx := hcutil.NewTx(msgtx)
-	acceptedTxs, err := s.server.blockManager.ProcessTransaction(tx, false,
-		false, allowHighFees)
+	var acceptedTxs []*hcutil.Tx
+	if s.server.dandelion != nil {
+		err = s.server.dandelion.ProcessStemTx(tx, nil, allowHighFees)
+	} else {
+		acceptedTxs, err = s.server.blockManager.ProcessTransaction(tx,
+			false, false, allowHighFees, 0)
+	}
 	if err != nil {
 		// When the error is a rule error, it means the transaction was
 		// simply rejected as opposed to something actually going
@@ -5194,9 +5659,13 @@ func handleSendRawTransaction(s *rpcServer, cmd interface{}, closeChan <-chan st
 	s.server.AnnounceNewTransactions(acceptedTxs)
 
 	// Keep track of all the sendrawtransaction request txns so that they
-	// can be rebroadcast if they don't make their way into a block.
-	iv := wire.NewInvVect(wire.InvTypeTx, tx.Hash())
-	s.server.AddRebroadcastInventory(iv, tx)
+	// can be rebroadcast if they don't make their way into a block.  Stem
+	// transactions are only added once they are diffused so they are not
+	// announced to all peers before.
+	if s.server.dandelion == nil {
+		iv := wire.NewInvVect(wire.InvTypeTx, tx.Hash())
+		s.server.AddRebroadcastInventory(iv, tx)
+	}
 
 	return tx.Hash().String(), nil
 }
@@ -6387,6 +6856,14 @@ func (s *rpcServer) Start() {
 		}(listener)
 	}
 
+	// Keep the getwork block template up to date for long polling getwork
+	// callers and notifywork websocket clients when there are addresses to
+	// pay the created blocks to.
+	if len(cfg.miningAddrs) != 0 {
+		s.wg.Add(1)
+		go s.workHandler()
+	}
+
 	s.ntfnMgr.Start()
 }
 
@@ -220,6 +220,21 @@ var helpDescsEnUS = map[string]string{
 	"getaddednodeinfo--condition1": "dns=true",
 	"getaddednodeinfo--result0":    "List of added peers",
 
+	// GetAddrManInfoCmd help.
+	"getaddrmaninfo--synopsis": "Returns the number of addresses in the new and tried tables of the address manager by network and the statistics of the feeler connections which test addresses of the new table.",
+
+	// GetAddrManInfoResult help.
+	"getaddrmaninforesult-networks":        "The number of addresses in the tables by network",
+	"getaddrmaninforesult-feelerattempts":  "Number of feeler connections attempted since the server was started",
+	"getaddrmaninforesult-feelersuccesses": "Number of feeler connections which completed the version handshake",
+
+	// AddrManNetworkResult help.
+	"addrmannetworkresult-network": "The network of the addresses (ipv4, ipv6 or onion)",
+	"addrmannetworkresult-new":     "Number of addresses in the new table",
+	"addrmannetworkresult-tried":   "Number of addresses in the tried table",
+	"addrmannetworkresult-total":   "Total number of addresses in both tables",
+	"addrmannetworkresult-newbad":  "Number of addresses in the new table which are considered bad",
+
 	// GetBestBlockResult help.
 	"getbestblockresult-hash":   "Hex-encoded bytes of the best block hash",
 	"getbestblockresult-height": "Height of the best block",
@@ -529,8 +544,22 @@ var helpDescsEnUS = map[string]string{
 	"getmempoolinfo--synopsis": "Returns memory pool information",
 
 	// GetMempoolInfoResult help.
-	"getmempoolinforesult-bytes": "Size in bytes of the mempool",
-	"getmempoolinforesult-size":  "Number of transactions in the mempool",
+	"getmempoolinforesult-bytes":         "Size in bytes of the mempool",
+	"getmempoolinforesult-size":          "Number of transactions in the mempool",
+	"getmempoolinforesult-maxmempool":    "Maximum size in bytes of the mempool (0 when unlimited)",
+	"getmempoolinforesult-mempoolminfee": "Minimum fee rate in HC/kB for regular transactions to be accepted into the mempool",
+	"getmempoolinforesult-minrelaytxfee": "Minimum relay fee rate in HC/kB for transactions to be considered a non-zero fee",
+
+	// GetMempoolRejectsCmd help.
+	"getmempoolrejects--synopsis": "Returns the transactions which were recently rejected from the memory pool along with the reasons, most recent first.",
+	"getmempoolrejects-txhash":    "Only return the rejection of the transaction with this hash",
+
+	// GetMempoolRejectsResult help.
+	"getmempoolrejectsresult-txid":   "The hash of the rejected transaction",
+	"getmempoolrejectsresult-code":   "The reject code",
+	"getmempoolrejectsresult-reason": "The reason the transaction was rejected",
+	"getmempoolrejectsresult-time":   "The time the transaction was rejected in seconds since 1 Jan 1970 GMT",
+	"getmempoolrejectsresult-height": "The height of the best chain when the transaction was rejected",
 
 	// GetMiningInfoResult help.
 	"getmininginforesult-blocks":           "Height of the latest best block",
@@ -555,6 +584,17 @@ var helpDescsEnUS = map[string]string{
 	"getnetworkhashps-height":    "Perform estimate ending with this height or -1 for current best chain block height",
 	"getnetworkhashps--result0":  "Estimated hashes per second",
 
+	// GetNodeAddressesCmd help.
+	"getnodeaddresses--synopsis": "Returns addresses known to the address manager in random order.  They may be used to find peers outside of the network.",
+	"getnodeaddresses-count":     "The maximum number of addresses to return (0 to return all known addresses)",
+
+	// GetNodeAddressesResult help.
+	"getnodeaddressesresult-time":     "Time the address was last seen in seconds since 1 Jan 1970 GMT",
+	"getnodeaddressesresult-services": "Services bitmask which represents the services supported by the node",
+	"getnodeaddressesresult-address":  "The ip address or onion host of the node",
+	"getnodeaddressesresult-port":     "The port of the node",
+	"getnodeaddressesresult-network":  "The network of the address (ipv4, ipv6 or onion)",
+
 	// GetNetTotalsCmd help.
 	"getnettotals--synopsis": "Returns a JSON object containing network traffic statistics.",
 
@@ -562,6 +602,15 @@ var helpDescsEnUS = map[string]string{
 	"getnettotalsresult-totalbytesrecv": "Total bytes received",
 	"getnettotalsresult-totalbytessent": "Total bytes sent",
 	"getnettotalsresult-timemillis":     "Number of milliseconds since 1 Jan 1970 GMT",
+	"getnettotalsresult-uploadtarget":   "The state of the upload target set by --maxuploadtarget",
+
+	// UploadTargetResult help.
+	"uploadtargetresult-timeframe":             "Length of the cycles the upload target applies to in seconds",
+	"uploadtargetresult-target":                "Number of bytes which may be sent per cycle (0 when the upload target is disabled)",
+	"uploadtargetresult-targetreached":         "Whether or not the bytes sent during the current cycle reached the target",
+	"uploadtargetresult-servehistoricalblocks": "Whether or not historical blocks are served to peers without the download permission",
+	"uploadtargetresult-bytesleftincycle":      "Number of bytes left in the current cycle",
+	"uploadtargetresult-timeleftincycle":       "Number of seconds left in the current cycle",
 
 	// GetPeerInfoResult help.
 	"getpeerinforesult-id":             "A unique node ID",
@@ -579,10 +628,15 @@ var helpDescsEnUS = map[string]string{
 	"getpeerinforesult-version":        "The protocol version of the peer",
 	"getpeerinforesult-subver":         "The user agent of the peer",
 	"getpeerinforesult-inbound":        "Whether or not the peer is an inbound connection",
+	"getpeerinforesult-conntype":       "The type of the connection (inbound, outbound-full-relay, block-relay-only, manual or feeler)",
+	"getpeerinforesult-permissions":    "The permissions granted to the peer by whitelists and whitebind listeners",
+	"getpeerinforesult-mappedas":       "The number of the autonomous system the peer is announced by according to the asmap (omitted when unknown)",
+	"getpeerinforesult-encrypted":      "Whether or not the messages exchanged with the peer are encrypted",
 	"getpeerinforesult-startingheight": "The latest block height the peer knew about when the connection was established",
 	"getpeerinforesult-currentheight":  "The current height of the peer",
 	"getpeerinforesult-banscore":       "The ban score",
 	"getpeerinforesult-syncnode":       "Whether or not the peer is the sync peer",
+	"getpeerinforesult-orphantxs":      "The number of orphan transactions received from the peer in the orphan pool",
 
 	// GetPeerInfoCmd help.
 	"getpeerinfo--synopsis": "Returns data about each connected network peer as an array of json objects.",
@@ -631,18 +685,26 @@ var helpDescsEnUS = map[string]string{
 	"gettxout-includemempool": "Include the mempool when true",
 
 	// GetWorkResult help.
-	"getworkresult-data":     "Hex-encoded block data",
-	"getworkresult-hash1":    "(DEPRECATED) Hex-encoded formatted hash buffer",
-	"getworkresult-midstate": "(DEPRECATED) Hex-encoded precomputed hash state after hashing first half of the data",
-	"getworkresult-target":   "Hex-encoded little-endian hash target",
+	"getworkresult-data":       "Hex-encoded block data",
+	"getworkresult-hash1":      "(DEPRECATED) Hex-encoded formatted hash buffer",
+	"getworkresult-midstate":   "Hex-encoded BLAKE-256 chain value after hashing the first 128 bytes of the data as eight big-endian 32-bit words",
+	"getworkresult-target":     "Hex-encoded little-endian hash target",
+	"getworkresult-longpollid": "Identifier of the work which can be passed back to wait for the next material change of the work",
 
 	// GetWorkCmd help.
 	"getwork--synopsis":   "(DEPRECATED - Use getblocktemplate instead) Returns formatted hash data to work on or checks and submits solved data.",
 	"getwork-data":        "Hex-encoded data to check",
+	"getwork-longpollid":  "Delay the reply until the work identified by the passed long poll identifier was replaced (data must be empty)",
 	"getwork--condition0": "no data provided",
 	"getwork--condition1": "data provided",
 	"getwork--result1":    "Whether or not the solved data is valid and was added to the chain",
 
+	// PrioritiseTransactionCmd help.
+	"prioritisetransaction--synopsis": "Adjusts the fee a transaction in the memory pool is ranked by when it is considered for inclusion in new blocks.",
+	"prioritisetransaction-txid":      "The hash of the transaction",
+	"prioritisetransaction-feedelta":  "The fee in atoms to add to (or subtract from, if negative) the fee of the transaction when ranking it; the fee the transaction actually pays is unchanged",
+	"prioritisetransaction--result0":  "Always true",
+
 	// HelpCmd help.
 	"help--synopsis":   "Returns a list of all commands or help for a specified command.",
 	"help-command":     "The command to retrieve help for",
@@ -679,7 +741,7 @@ var helpDescsEnUS = map[string]string{
 	"searchrawtransactions--result0":    "Hex-encoded serialized transaction",
 
 	// SendRawTransactionCmd help.
-	"sendrawtransaction--synopsis":     "Submits the serialized, hex-encoded transaction to the local peer and relays it to the network.",
+	"sendrawtransaction--synopsis":     "Submits the serialized, hex-encoded transaction to the local peer and relays it to the network.\nWith --dandelion, regular transactions are relayed along a Dandelion++ stem first and only added to the memory pool once they are diffused.",
 	"sendrawtransaction-hextx":         "Serialized, hex-encoded signed transaction",
 	"sendrawtransaction-allowhighfees": "Whether or not to allow insanely high fees (hcd does not yet implement this parameter, so it has no effect)",
 	"sendrawtransaction--result0":      "The hash of the transaction",
@@ -760,6 +822,12 @@ var helpDescsEnUS = map[string]string{
 	// StopNotifyBlocksCmd help.
 	"stopnotifyblocks--synopsis": "Cancel registered notifications for whenever a block is connected or disconnected from the main (best) chain.",
 
+	// NotifyWorkCmd help.
+	"notifywork--synopsis": "Send a work notification with fresh work whenever the block template changes materially due to a new best block, new votes, or a fee jump.",
+
+	// StopNotifyWorkCmd help.
+	"stopnotifywork--synopsis": "Cancel registered work notifications.",
+
 	// NotifyNewTransactionsCmd help.
 	"notifynewtransactions--synopsis": "Send either a txaccepted or a txacceptedverbose notification when a new transaction is accepted into the mempool.",
 	"notifynewtransactions-verbose":   "Specifies which type of notification to receive. If verbose is true, then the caller receives txacceptedverbose, otherwise the caller receives txaccepted",
@@ -801,14 +869,30 @@ var helpDescsEnUS = map[string]string{
 	"getcoinsupply--synopsis": "Returns current total coin supply in atoms",
 	"getcoinsupply--result0":  "Current coin supply in atoms",
 
+	// GetIndexInfo help.
+	"getindexinfo--synopsis":    "Returns the status of each of the enabled optional indexes.",
+	"getindexinforesult-name":   "The name of the index",
+	"getindexinforesult-height": "The height of the most recent block added to the index",
+	"getindexinforesult-synced": "Whether or not the index is caught up to the main chain",
+
 	// LiveTickets help.
 	"livetickets--synopsis":     "Request tickets the live ticket hashes from the ticket database",
 	"liveticketsresult-tickets": "List of live tickets",
 
+	// LoadMempool help.
+	"loadmempool--synopsis":      "Loads the transactions saved by savemempool or on the last shutdown back into the memory pool.\nTransactions which have expired or are no longer valid are skipped.",
+	"loadmempoolresult-accepted": "The number of transactions accepted to the memory pool",
+	"loadmempoolresult-skipped":  "The number of saved transactions which were skipped",
+
 	// MissedTickets help.
 	"missedtickets--synopsis":     "Request tickets the client missed",
 	"missedticketsresult-tickets": "List of missed tickets",
 
+	// SaveMempool help.
+	"savemempool--synopsis":          "Saves the transactions in the memory pool to the data directory so they can be loaded again with loadmempool or on the next start.",
+	"savemempoolresult-filename":     "The path of the file the memory pool was saved to",
+	"savemempoolresult-transactions": "The number of transactions saved",
+
 	// TicketBuckets help.
 	"ticketbuckets--synopsis": "Request for the number of tickets currently in each bucket of the ticket database.",
 	"ticketbucket-tickets":    "Number of tickets in bucket.",
@@ -909,6 +993,7 @@ var rpcResultTypes = map[string][]interface{}{
 	"existslivetickets":     {(*string)(nil)},
 	"existsmempooltxs":      {(*string)(nil)},
 	"getaddednodeinfo":      {(*[]string)(nil), (*[]hcjson.GetAddedNodeInfoResult)(nil)},
+	"getaddrmaninfo":        {(*hcjson.GetAddrManInfoResult)(nil)},
 	"getbestblock":          {(*hcjson.GetBestBlockResult)(nil)},
 	"generate":              {(*[]string)(nil)},
 	"getbestblockhash":      {(*string)(nil)},
@@ -928,11 +1013,14 @@ var rpcResultTypes = map[string][]interface{}{
 	"getgenerate":           {(*bool)(nil)},
 	"gethashespersec":       {(*float64)(nil)},
 	"getheaders":            {(*hcjson.GetHeadersResult)(nil)},
+	"getindexinfo":          {(*[]hcjson.GetIndexInfoResult)(nil)},
 	"getinfo":               {(*hcjson.InfoChainResult)(nil)},
 	"getmempoolinfo":        {(*hcjson.GetMempoolInfoResult)(nil)},
+	"getmempoolrejects":     {(*[]hcjson.GetMempoolRejectsResult)(nil)},
 	"getmininginfo":         {(*hcjson.GetMiningInfoResult)(nil)},
 	"getnettotals":          {(*hcjson.GetNetTotalsResult)(nil)},
 	"getnetworkhashps":      {(*int64)(nil)},
+	"getnodeaddresses":      {(*[]hcjson.GetNodeAddressesResult)(nil)},
 	"getpeerinfo":           {(*[]hcjson.GetPeerInfoResult)(nil)},
 	"getrawmempool":         {(*[]string)(nil), (*hcjson.GetRawMempoolVerboseResult)(nil)},
 	"getrawtransaction":     {(*string)(nil), (*hcjson.TxRawResult)(nil)},
@@ -943,11 +1031,14 @@ var rpcResultTypes = map[string][]interface{}{
 	"getcoinsupply":         {(*int64)(nil)},
 	"help":                  {(*string)(nil), (*string)(nil)},
 	"livetickets":           {(*hcjson.LiveTicketsResult)(nil)},
+	"loadmempool":           {(*hcjson.LoadMempoolResult)(nil)},
 	"missedtickets":         {(*hcjson.MissedTicketsResult)(nil)},
 	"node":                  nil,
 	"ping":                  nil,
+	"prioritisetransaction": {(*bool)(nil)},
 	"rebroadcastmissed":     nil,
 	"rebroadcastwinners":    nil,
+	"savemempool":           {(*hcjson.SaveMempoolResult)(nil)},
 	"searchrawtransactions": {(*string)(nil), (*[]hcjson.SearchRawTransactionsResult)(nil)},
 	"sendrawtransaction":    {(*string)(nil)},
 	"setgenerate":           nil,
@@ -974,11 +1065,13 @@ var rpcResultTypes = map[string][]interface{}{
 	"notifynewtransactions":       nil,
 	"notifyreceived":              nil,
 	"notifyspent":                 nil,
+	"notifywork":                  nil,
 	"rescan":                      nil,
 	"stopnotifyblocks":            nil,
 	"stopnotifynewtransactions":   nil,
 	"stopnotifyreceived":          nil,
 	"stopnotifyspent":             nil,
+	"stopnotifywork":              nil,
 }
 
 // helpCacher provides a concurrent safe type that provides help and usage for
@@ -68,6 +68,7 @@ var wsHandlersBeforeInit = map[string]wsCommandHandler{
 	"loadtxfilter":                handleLoadTxFilter,
 	"notifyblocks":                handleNotifyBlocks,
 	"notifywinningtickets":        handleWinningTickets,
+	"notifywork":                  handleNotifyWork,
 	"notifyspentandmissedtickets": handleSpentAndMissedTickets,
 	"notifynewtickets":            handleNewTickets,
 	"notifystakedifficulty":       handleStakeDifficulty,
@@ -77,6 +78,7 @@ var wsHandlersBeforeInit = map[string]wsCommandHandler{
 	"rescan":                      handleRescan,
 	"stopnotifyblocks":            handleStopNotifyBlocks,
 	"stopnotifynewtransactions":   handleStopNotifyNewTransactions,
+	"stopnotifywork":              handleStopNotifyWork,
 }
 
 // WebsocketHandler handles a new websocket client by creating a new wsClient,
@@ -317,6 +319,38 @@ func (m *wsNotificationManager) NotifyMempoolTx(tx *hcutil.Tx, isNew bool) {
 	}
 }
 
+// NotifyTxReplaced passes a transaction evicted from the mempool by a
+// replacement transaction to the notification manager for transaction
+// notification processing.
+func (m *wsNotificationManager) NotifyTxReplaced(replaced, replacement *hcutil.Tx) {
+	n := &notificationTxReplaced{
+		replaced:    replaced,
+		replacement: replacement,
+	}
+
+	// As NotifyTxReplaced will be called by mempool and the RPC server
+	// may no longer be running, use a select statement to unblock
+	// enqueuing the notification once the RPC server has begun
+	// shutting down.
+	select {
+	case m.queueNotification <- n:
+	case <-m.quit:
+	}
+}
+
+// NotifyWork passes the reason new work is available for mining to the
+// notification manager for work notification processing.
+func (m *wsNotificationManager) NotifyWork(reason string) {
+	// As NotifyWork will be called by the RPC server and the block manager
+	// and the RPC server may no longer be running, use a select statement
+	// to unblock enqueuing the notification once the RPC server has begun
+	// shutting down.
+	select {
+	case m.queueNotification <- notificationWork(reason):
+	case <-m.quit:
+	}
+}
+
 // WinningTicketsNtfnData is the data that is used to generate
 // winning ticket notifications (which indicate a block and
 // the tickets eligible to vote on it).
@@ -505,6 +539,11 @@ type notificationTxAcceptedByMempool struct {
 	isNew bool
 	tx    *hcutil.Tx
 }
+type notificationTxReplaced struct {
+	replaced    *hcutil.Tx
+	replacement *hcutil.Tx
+}
+type notificationWork string
 
 // Notification control requests
 type notificationRegisterClient wsClient
@@ -521,6 +560,8 @@ type notificationRegisterStakeDifficulty wsClient
 type notificationUnregisterStakeDifficulty wsClient
 type notificationRegisterNewMempoolTxs wsClient
 type notificationUnregisterNewMempoolTxs wsClient
+type notificationRegisterWork wsClient
+type notificationUnregisterWork wsClient
 
 // notificationHandler reads notifications and control messages from the queue
 // handler and processes one at a time.
@@ -541,6 +582,7 @@ func (m *wsNotificationManager) notificationHandler() {
 	ticketNewNotifications := make(map[chan struct{}]*wsClient)
 	stakeDifficultyNotifications := make(map[chan struct{}]*wsClient)
 	txNotifications := make(map[chan struct{}]*wsClient)
+	workNotifications := make(map[chan struct{}]*wsClient)
 
 out:
 	for {
@@ -592,6 +634,17 @@ out:
 				}
 				m.notifyRelevantTxAccepted(n.tx, clients)
 
+			case *notificationTxReplaced:
+				if len(txNotifications) != 0 {
+					m.notifyTxReplaced(txNotifications,
+						n.replaced, n.replacement)
+				}
+
+			case notificationWork:
+				if len(workNotifications) != 0 {
+					m.notifyWork(workNotifications, string(n))
+				}
+
 			case *notificationRegisterBlocks:
 				wsc := (*wsClient)(n)
 				blockNotifications[wsc.quit] = wsc
@@ -642,6 +695,7 @@ out:
 				// the client itself.
 				delete(blockNotifications, wsc.quit)
 				delete(txNotifications, wsc.quit)
+				delete(workNotifications, wsc.quit)
 				delete(clients, wsc.quit)
 
 			case *notificationRegisterNewMempoolTxs:
@@ -652,6 +706,14 @@ out:
 				wsc := (*wsClient)(n)
 				delete(txNotifications, wsc.quit)
 
+			case *notificationRegisterWork:
+				wsc := (*wsClient)(n)
+				workNotifications[wsc.quit] = wsc
+
+			case *notificationUnregisterWork:
+				wsc := (*wsClient)(n)
+				delete(workNotifications, wsc.quit)
+
 			default:
 				rpcsLog.Warn("Unhandled notification type")
 			}
@@ -1086,6 +1148,79 @@ func (m *wsNotificationManager) notifyForNewTx(clients map[chan struct{}]*wsClie
 	}
 }
 
+// RegisterWorkUpdates requests work notifications to the passed websocket
+// client.
+func (m *wsNotificationManager) RegisterWorkUpdates(wsc *wsClient) {
+	m.queueNotification <- (*notificationRegisterWork)(wsc)
+}
+
+// UnregisterWorkUpdates removes work notifications for the passed websocket
+// client.
+func (m *wsNotificationManager) UnregisterWorkUpdates(wsc *wsClient) {
+	m.queueNotification <- (*notificationUnregisterWork)(wsc)
+}
+
+// notifyWork notifies websocket clients that have registered for work updates
+// that new work is available.  The block template for the work was generated
+// before the notification was queued, so each client is only sent its own
+// variation of it, just like callers of the getwork RPC.
+func (m *wsNotificationManager) notifyWork(clients map[chan struct{}]*wsClient,
+	reason string) {
+
+	marshalled := make(map[*wsClient][]byte, len(clients))
+	state := m.server.workState
+	state.Lock()
+	if state.msgBlock == nil {
+		state.Unlock()
+		return
+	}
+	for _, wsc := range clients {
+		msgBlock, err := newWorkVariation(m.server)
+		if err != nil {
+			rpcsLog.Errorf("Failed to create work notification: %v", err)
+			continue
+		}
+		work, err := workResult(m.server, msgBlock)
+		if err != nil {
+			rpcsLog.Errorf("Failed to create work notification: %v", err)
+			continue
+		}
+
+		ntfn := hcjson.NewWorkNtfn(work.Data, work.Target, reason)
+		marshalledJSON, err := hcjson.MarshalCmd(nil, ntfn)
+		if err != nil {
+			rpcsLog.Errorf("Failed to marshal work notification: %v",
+				err)
+			continue
+		}
+		marshalled[wsc] = marshalledJSON
+	}
+	state.Unlock()
+
+	for wsc, marshalledJSON := range marshalled {
+		wsc.QueueNotification(marshalledJSON)
+	}
+}
+
+// notifyTxReplaced notifies websocket clients that have registered for
+// updates when a transaction in the memory pool is replaced by another one.
+func (m *wsNotificationManager) notifyTxReplaced(clients map[chan struct{}]*wsClient,
+	replaced, replacement *hcutil.Tx) {
+
+	ntfn := hcjson.NewTxReplacedNtfn(replaced.Hash().String(),
+		replacement.Hash().String())
+	marshalledJSON, err := hcjson.MarshalCmd(nil, ntfn)
+	if err != nil {
+		rpcsLog.Errorf("Failed to marshal tx replaced notification: %v",
+			err)
+		return
+	}
+
+	for _, wsc := range clients {
+		wsc.QueueNotification(marshalledJSON)
+	}
+}
+
 // txHexString returns the serialized transaction encoded in hexadecimal.
 func txHexString(tx *wire.MsgTx) string {
 	buf := bytes.NewBuffer(make([]byte, 0, tx.SerializeSize()))
@@ -1841,6 +1976,20 @@ func handleStopNotifyBlocks(wsc *wsClient, icmd interface{}) (interface{}, error
 	return nil, nil
 }
 
+// handleNotifyWork implements the notifywork command extension for websocket
+// connections.
+func handleNotifyWork(wsc *wsClient, icmd interface{}) (interface{}, error) {
+	wsc.server.ntfnMgr.RegisterWorkUpdates(wsc)
+	return nil, nil
+}
+
+// handleStopNotifyWork implements the stopnotifywork command extension for
+// websocket connections.
+func handleStopNotifyWork(wsc *wsClient, icmd interface{}) (interface{}, error) {
+	wsc.server.ntfnMgr.UnregisterWorkUpdates(wsc)
+	return nil, nil
+}
+
 // handleNotifyNewTransations implements the notifynewtransactions command
 // extension for websocket connections.
 func handleNotifyNewTransactions(wsc *wsClient, icmd interface{}) (interface{}, error) {
@@ -114,6 +114,17 @@ const FileContents = `[Application Options]
 ; Maximum number of inbound and outbound peers.
 ; maxpeers=8
 
+; Number of outbound connections which only relay blocks and never transactions
+; or addresses.  Their addresses are saved as anchors on shutdown and connected
+; to first on the next start.
+; blockrelayconns=2
+
+; Path to an IP to ASN map file in the format used by Bitcoin Core.  Peers are
+; grouped by the autonomous systems they are announced by instead of their /16
+; (IPv4) or /32 (IPv6) network prefixes, so outbound connections are spread
+; over more network operators.  Changing the map rebuckets the known addresses.
+; asmap=~/.hcd/ip_asn.map
+
 ; Disable banning of misbehaving peers.
 ; nobanning=1
 
@@ -126,11 +137,40 @@ const FileContents = `[Application Options]
 ; banduration=11h30m15s
 
 ; Add whitelisted IP networks and IPs. Connected peers whose IP matches a
-; whitelist will not have their ban score increased.
+; whitelist are granted its permissions.  Permissions are named in front of the
+; IP network or IP, separated by an '@':
+;   noban:      never ban the peer, allow it to connect when the maximum number
+;               of peers is reached and never evict it
+;   relay:      accept and relay transactions of the peer even with blocksonly
+;   forcerelay: relay transactions of the peer even when they are already in
+;               the memory pool (implies relay)
+;   mempool:    allow the peer to request the contents of the memory pool
+;   download:   allow the peer to request data without increasing its ban score
+;               and exempt it from maxuploadtarget and maxpeersendrate
+;   addr:       answer requests for addresses of the peer even when it is an
+;               outbound peer
+;   all:        all of the above
+; Whitelists which don't name any permissions are granted
+; noban,relay,mempool,download.
 ; whitelist=127.0.0.1
 ; whitelist=::1
 ; whitelist=192.168.0.0/24
 ; whitelist=fd00::/16
+; whitelist=forcerelay@10.0.0.0/8
+
+; Add interfaces/ports to listen for connections whose peers are granted the
+; named permissions.  See whitelist for the permissions.
+; whitebind=noban,download@127.0.0.1:9108
+
+; Try to keep the data sent to peers below the given target in MiB per 24h.
+; Once the target is approached, historical blocks are no longer served to
+; peers without the download permission, while new blocks and transactions are
+; still relayed.  The default of 0 disables the target.
+; maxuploadtarget=5000
+
+; Limit the rate at which data is sent to each peer without the download
+; permission in KiB/s.  The default of 0 disables the limit.
+; maxpeersendrate=500
 
 ; Disable DNS seeding for peers.  By default, when hcd starts, it will use
 ; DNS to query for available peers to connect with.
@@ -171,6 +211,10 @@ const FileContents = `[Application Options]
 ; Disable peer bloom filtering.  See BIP0111.
 ; nopeerbloomfilters=1
 
+; Disable the encrypted transport.  By default connections with peers which
+; support it are encrypted while unencrypted connections are still accepted.
+; noencryption=1
+
 
 ; ------------------------------------------------------------------------------
 ; RPC server options - The following options control the built-in RPC server
@@ -245,15 +289,43 @@ const FileContents = `[Application Options]
 ; Limit orphan transaction pool to 1000 transactions.
 ; maxorphantx=1000
 
+; Limit the orphan transactions received from a single peer to 100.
+; maxorphantxperpeer=100
+
+; Expire orphan transactions after 15 minutes.
+; orphantxexpiry=15m
+
+; Limit the transaction memory pool to 300 megabytes.  The transactions with
+; the lowest fee rates are evicted once the limit is exceeded.
+; maxmempool=300
+
+; Evict regular transactions which have not been mined after two weeks.
+; mempoolexpiry=336h
+
 ; Do not accept transactions from remote peers.
 ; blocksonly=1
 
+; Relay transactions along a Dandelion++ stem of randomly selected outbound
+; peers which support it before they are diffused to the network.  This makes it
+; harder to link transactions submitted with sendrawtransaction to this node.
+; Stem transactions are kept out of the memory pool until they are diffused.
+; dandelion=1
+
 ; Relay non-standard transactions regardless of default network settings.
 ; relaynonstd=1
 
 ; Reject non-standard transactions regardless of default network settings.
 ; rejectnonstd=1
 
+; Reject regular transactions that attempt to replace conflicting mempool
+; transactions which signal replaceability through the Replace-By-Fee (RBF)
+; policy.
+; rejectreplacement=1
+
+; Do not save the transaction memory pool to mempool.dat in the data directory
+; on shutdown and load it again on start up.
+; nopersistmempool=1
+
 
 ; ------------------------------------------------------------------------------
 ; Optional Transaction Indexes
@@ -322,6 +394,21 @@ const FileContents = `[Application Options]
 ; by the blockmaxsize option and will be limited as needed.
 ; blockprioritysize=50000
 
+; Enable the built-in Stratum server and specify the interfaces it listens on
+; for connections from miners.  Work is handed out to the miners and pushed to
+; them whenever the best block or the votes for it change.  The mining addresses
+; above are used to pay the mined blocks to.  The default port is 14011 for
+; mainnet, 12011 for testnet and 13011 for simnet.
+;
+; NOTE: The Stratum protocol is neither authenticated nor encrypted, so only
+; listen on interfaces which are reachable by trusted miners.
+; stratumlisten=:14011
+
+; Specify the initial share difficulty of Stratum workers, where 1 is the proof
+; of work limit of the network.  It is adjusted to the hash rate of each worker
+; so that each of them submits a share every few seconds.
+; stratumdifficulty=1
+
 
 ; ------------------------------------------------------------------------------
 ; Debug
@@ -334,6 +421,11 @@ const FileContents = `[Application Options]
 ; available subsystems.
 ; debuglevel=info
 
+; Record every message read from or written to peers to a file per peer in the
+; msgcapture directory of the data directory.  The captures can be decoded and
+; filtered with the hccapture utility.
+; capturemessages=1
+
 ; ------------------------------------------------------------------------------
 ; Profile - enable the HTTP profiler
 ; ------------------------------------------------------------------------------
@@ -14,6 +14,8 @@ import (
 	"fmt"
 	"math"
 	"net"
+	"os"
+	"path/filepath"
 	"regexp"
 	"runtime"
 	"strconv"
@@ -25,6 +27,7 @@ import (
 	"github.com/nbit99/hcd/addrmgr"
 	"github.com/nbit99/hcd/blockchain"
 	"github.com/nbit99/hcd/blockchain/indexers"
+	"github.com/nbit99/hcd/blockchain/stake"
 	"github.com/nbit99/hcd/chaincfg"
 	"github.com/nbit99/hcd/chaincfg/chainhash"
 	"github.com/nbit99/hcd/connmgr"
@@ -41,7 +44,8 @@ import (
 const (
 	// defaultServices describes the default services that are supported by
 	// the server.
-	defaultServices = wire.SFNodeNetwork | wire.SFNodeBloom
+	defaultServices = wire.SFNodeNetwork | wire.SFNodeBloom |
+		wire.SFNodeEncrypted
 
 	// defaultRequiredServices describes the default services that are
 	// required to be supported by outbound peers.
@@ -56,8 +60,26 @@ const (
 	// number of retries such that there is a retry backoff.
 	connectionRetryInterval = time.Second * 5
 
+	// feelerInterval is the interval at which feeler connections are made
+	// to addresses of the new table of the address manager in order to
+	// test whether they are reachable.
+	feelerInterval = time.Minute * 2
+
 	// maxProtocolVersion is the max protocol version the server supports.
-	maxProtocolVersion = wire.MaxBlockSizeVersion
+	maxProtocolVersion = wire.DandelionVersion
+
+	// mempoolFileName is the name of the file in the data directory the
+	// transaction memory pool is saved to on shutdown and loaded from on
+	// start up.
+	mempoolFileName = "mempool.dat"
+
+	// msgCaptureDirName is the name of the directory in the data directory
+	// the messages of peers are captured to when --capturemessages is set.
+	msgCaptureDirName = "msgcapture"
+
+	// maxUnencryptedAddrs is the maximum number of addresses which failed
+	// to negotiate the encrypted transport that are remembered.
+	maxUnencryptedAddrs = 1000
 )
 
 var (
@@ -147,9 +169,16 @@ type server struct {
 	// Putting the uint64s first makes them 64-bit aligned for 32-bit systems.
 	bytesReceived uint64 // Total bytes received from all peers since start.
 	bytesSent     uint64 // Total bytes sent by all peers since start.
+
+	// feelerAttempts and feelerSuccesses count the feeler connections
+	// attempted and the ones which completed the version handshake.
+	feelerAttempts  uint64
+	feelerSuccesses uint64
+
 	started       int32
 	shutdown      int32
 	shutdownSched int32
+	mempoolLoaded int32 // Set once the saved mempool has been loaded.
 
 	chainParams          *chaincfg.Params
 	addrManager          *addrmgr.AddrManager
@@ -158,7 +187,9 @@ type server struct {
 	rpcServer            *rpcServer
 	blockManager         *blockManager
 	txMemPool            *mempool.TxPool
+	dandelion            *dandelion
 	cpuMiner             *CPUMiner
+	stratumServer        *stratumServer
 	modifyRebroadcastInv chan interface{}
 	newPeers             chan *serverPeer
 	donePeers            chan *serverPeer
@@ -173,6 +204,21 @@ type server struct {
 	db                   database.DB
 	timeSource           blockchain.MedianTimeSource
 	services             wire.ServiceFlag
+	feeFilterRounder     *feeFilterRounder
+
+	// unencryptedAddrs houses the addresses of outbound peers which failed
+	// to negotiate the encrypted transport.  Later connections to them use
+	// the unencrypted transport.
+	unencryptedMtx   sync.Mutex
+	unencryptedAddrs map[string]struct{}
+
+	// evictionSeed keys the hash of the network groups of inbound peers
+	// which decides which of them are protected from eviction.
+	evictionSeed uint64
+
+	// uploadTarget keeps track of the bytes sent to peers in order to stop
+	// serving historical blocks once the --maxuploadtarget is approached.
+	uploadTarget *uploadTarget
 
 	// The following fields are used for optional indexes.  They will be nil
 	// if the associated index is not enabled.  These fields are set during
@@ -181,11 +227,17 @@ type server struct {
 	txIndex         *indexers.TxIndex
 	addrIndex       *indexers.AddrIndex
 	existsAddrIndex *indexers.ExistsAddrIndex
+	indexManager    *indexers.Manager
 }
 
 // serverPeer extends the peer to maintain state shared by the server and
 // the blockmanager.
 type serverPeer struct {
+	// The following variables must only be used atomically.
+	feeFilter     int64 // Minimum fee rate in atoms/kB announced by the peer.
+	lastBlockUnix int64 // Time the peer last relayed a novel block.
+	lastTxUnix    int64 // Time the peer last relayed a novel transaction.
+
 	*peer.Peer
 
 	connReq         *connmgr.ConnReq
@@ -194,7 +246,7 @@ type serverPeer struct {
 	continueHash    *chainhash.Hash
 	relayMtx        sync.Mutex
 	disableRelayTx  bool
-	isWhitelisted   bool
+	permissions     permissionFlags
 	requestQueue    []*wire.InvVect
 	requestedTxns   map[chainhash.Hash]struct{}
 	requestedBlocks map[chainhash.Hash]struct{}
@@ -208,9 +260,32 @@ type serverPeer struct {
 	// has already sent the respective request.  It is used to prevent more
 	// than one response per connection.
 	getMiningStateSent bool
+	// The following fields track the feefilter messages sent to the peer.
+	feeFilterMtx      sync.Mutex
+	sentFeeFilter     int64
+	nextFeeFilterTime time.Time
 	// The following chans are used to sync blockmanager and server.
 	txProcessed    chan struct{}
 	blockProcessed chan struct{}
+	// initiatedEncryption is whether or not the encrypted transport was
+	// requested from the remote peer of the outbound connection.
+	initiatedEncryption bool
+	// blockRelayOnly is whether or not the peer is a block-relay-only
+	// outbound connection, which never relays transactions or addresses.
+	blockRelayOnly bool
+	// feeler is whether or not the peer is a short-lived feeler connection
+	// which only tests whether the address is reachable.
+	feeler bool
+	// The following fields track whether or not the peer supports compact
+	// blocks and whether or not it wants new blocks to be announced by
+	// sending compact blocks directly.
+	cmpctMtx       sync.Mutex
+	cmpctSupported bool
+	cmpctAnnounce  bool
+	// pendingCmpct is the compact block of the peer which is waiting for
+	// its missing transactions.  It is only accessed from the input handler
+	// of the peer.
+	pendingCmpct *partialBlock
 }
 // Only respond with addresses once per connection
 //if sp.addrsSent {
@@ -243,6 +318,36 @@ func (sp *serverPeer) newestBlock() (*chainhash.Hash, int64, error) {
 	return best.Hash, best.Height, nil
 }
 
+// lastBlockTime returns the time the peer last relayed a novel block.
+//
+// This function is safe for concurrent access.
+func (sp *serverPeer) lastBlockTime() time.Time {
+	return time.Unix(atomic.LoadInt64(&sp.lastBlockUnix), 0)
+}
+
+// lastTxTime returns the time the peer last relayed a novel transaction.
+//
+// This function is safe for concurrent access.
+func (sp *serverPeer) lastTxTime() time.Time {
+	return time.Unix(atomic.LoadInt64(&sp.lastTxUnix), 0)
+}
+
+// connType returns a description of the type of the connection to the peer.
+func (sp *serverPeer) connType() string {
+	switch {
+	case sp.Inbound():
+		return "inbound"
+	case sp.persistent:
+		return "manual"
+	case sp.blockRelayOnly:
+		return "block-relay-only"
+	case sp.feeler:
+		return "feeler"
+	default:
+		return "outbound-full-relay"
+	}
+}
+
 // addKnownAddresses adds the given addresses to the set of known addreses to
 // the peer to prevent sending duplicate addresses.
 func (sp *serverPeer) addKnownAddresses(addresses []*wire.NetAddress) {
@@ -305,8 +410,9 @@ func (sp *serverPeer) addBanScore(persistent, transient uint32, reason string) {
 	if cfg.DisableBanning {
 		return
 	}
-	if sp.isWhitelisted {
-		peerLog.Debugf("Misbehaving whitelisted peer %s: %s", sp, reason)
+	if sp.permissions.has(permNoBan) {
+		peerLog.Debugf("Misbehaving peer %s with noban permission: %s",
+			sp, reason)
 		return
 	}
 
@@ -424,16 +530,31 @@ func (sp *serverPeer) OnVersion(p *peer.Peer, msg *wire.MsgVersion) {
 		return
 	}
 
+	// Feeler connections only test whether the address is reachable, so
+	// the address is marked as good and the peer is disconnected once the
+	// version handshake completed.
+	if sp.feeler {
+		peerLog.Debugf("Feeler connection to %s succeeded", sp)
+		atomic.AddUint64(&sp.server.feelerSuccesses, 1)
+		addrManager.Good(remoteAddr)
+		sp.Disconnect()
+		return
+	}
+
 	// Choose whether or not to relay transactions before a filter command
-	// is received.
-	sp.setDisableRelayTx(msg.DisableRelayTx)
+	// is received.  Transactions are never relayed to block-relay-only
+	// peers.
+	sp.setDisableRelayTx(msg.DisableRelayTx || sp.blockRelayOnly)
 
 	// Update the address manager and request known addresses from the
 	// remote peer for outbound connections.  This is skipped when running
 	// on the simulation test network since it is only intended to connect
 	// to specified peers and actively avoids advertising and connecting to
-	// discovered peers.
-	if !cfg.SimNet {
+	// discovered peers.  Addresses are never relayed to block-relay-only
+	// peers, but they are still marked as good.
+	if !cfg.SimNet && sp.blockRelayOnly {
+		addrManager.Good(remoteAddr)
+	} else if !cfg.SimNet {
 		//addrManager := sp.server.addrManager
 		// Outbound connections.
 		if !p.Inbound() {
@@ -465,43 +586,127 @@ func (sp *serverPeer) OnVersion(p *peer.Peer, msg *wire.MsgVersion) {
 	sp.server.AddPeer(sp)
 }
 
+// OnVerAck is invoked when a peer receives a verack wire message.  It is used
+// to advertise the minimum fee rate of transactions the server is willing to
+// accept into its memory pool, so the peer does not announce transactions that
+// would be rejected anyway.
+func (sp *serverPeer) OnVerAck(p *peer.Peer, msg *wire.MsgVerAck) {
+	sp.pushFeeFilterMsg(time.Now())
+
+	// Signal support for compact blocks.  New blocks are announced in
+	// low-bandwidth mode until the peer is asked to send them directly by
+	// the block manager.
+	if sp.ProtocolVersion() >= wire.CompactBlocksVersion {
+		sp.QueueMessage(wire.NewMsgSendCmpct(false,
+			wire.CmpctBlockVersion), nil)
+	}
+}
+
+// pushFeeFilterMsg sends a feefilter message with the current minimum fee rate
+// of the memory pool to the connected peer when the negotiated protocol version
+// supports it and the peer is due a new filter.  Filters are sent at random
+// intervals and the fee rate is rounded in order to make it harder to link the
+// connections of the server.  A new filter is sent sooner when the minimum fee
+// rate changed substantially since the last one.  No filter is sent when
+// transaction relay is disabled.
+func (sp *serverPeer) pushFeeFilterMsg(now time.Time) {
+	if cfg.BlocksOnly || sp.blockRelayOnly ||
+		sp.ProtocolVersion() < wire.FeeFilterVersion {
+
+		return
+	}
+
+	minFee := int64(sp.server.txMemPool.MinFeeRate())
+
+	sp.feeFilterMtx.Lock()
+	defer sp.feeFilterMtx.Unlock()
+
+	if now.Before(sp.nextFeeFilterTime) {
+		changed := minFee < sp.sentFeeFilter*3/4 ||
+			minFee > sp.sentFeeFilter*4/3
+		if changed && sp.nextFeeFilterTime.Sub(now) > maxFeeFilterChangeDelay {
+			sp.nextFeeFilterTime = now.Add(feeFilterChangeDelay())
+		}
+		return
+	}
+
+	feeFilter := sp.server.feeFilterRounder.round(minFee)
+	if feeFilter < int64(cfg.minRelayTxFee) {
+		feeFilter = int64(cfg.minRelayTxFee)
+	}
+	if feeFilter != sp.sentFeeFilter {
+		sp.QueueMessage(wire.NewMsgFeeFilter(feeFilter), nil)
+		sp.sentFeeFilter = feeFilter
+	}
+	sp.nextFeeFilterTime = now.Add(feeFilterDelay())
+}
+
+// OnFeeFilter is invoked when a peer receives a feefilter wire message and it
+// is used to stop announcing transactions to the peer which pay a lower fee
+// rate than the one it requested.
+func (sp *serverPeer) OnFeeFilter(p *peer.Peer, msg *wire.MsgFeeFilter) {
+	// Disconnect peers which send an invalid fee rate.
+	if msg.MinFee < 0 || msg.MinFee > hcutil.MaxAmount {
+		peerLog.Debugf("Peer %v sent an invalid feefilter '%v' -- "+
+			"disconnecting", sp, hcutil.Amount(msg.MinFee))
+		sp.Disconnect()
+		return
+	}
+
+	atomic.StoreInt64(&sp.feeFilter, msg.MinFee)
+}
+
 // OnMemPool is invoked when a peer receives a mempool wire message.  It creates
 // and sends an inventory message with the contents of the memory pool up to the
 // maximum inventory allowed per message.  When the peer has a bloom filter
 // loaded, the contents are filtered accordingly.
 func (sp *serverPeer) OnMemPool(p *peer.Peer, msg *wire.MsgMemPool) {
+	// Transactions are never relayed to block-relay-only peers.
+	if sp.blockRelayOnly {
+		peerLog.Debugf("Ignoring mempool request from block-relay-only "+
+			"peer %v", p)
+		return
+	}
+
+	// Only peers with the mempool permission may request the contents of
+	// the memory pool when bloom filters are not supported.
+	hasMempoolPerm := sp.permissions.has(permMempool)
+	if !hasMempoolPerm && sp.server.services&wire.SFNodeBloom == 0 {
+		peerLog.Debugf("Peer %v sent mempool request without the "+
+			"mempool permission -- disconnecting", p)
+		sp.Disconnect()
+		return
+	}
+
 	// A decaying ban score increase is applied to prevent flooding.
 	// The ban score accumulates and passes the ban threshold if a burst of
 	// mempool messages comes from a peer. The score decays each minute to
-	// half of its value.
-	sp.addBanScore(0, 33, "mempool")
-
-	// Generate inventory message with the available transactions in the
-	// transaction memory pool.  Limit it to the max allowed inventory
-	// per message.  The NewMsgInvSizeHint function automatically limits
-	// the passed hint to the maximum allowed, so it's safe to pass it
-	// without double checking it here.
-	txMemPool := sp.server.txMemPool
-	txDescs := txMemPool.TxDescs()
-	invMsg := wire.NewMsgInvSizeHint(uint(len(txDescs)))
-
-	for i, txDesc := range txDescs {
+	// half of its value.  Peers with the mempool permission are exempt.
+	if !hasMempoolPerm {
+		sp.addBanScore(0, 33, "mempool")
+	}
+
+	// Queue the inventory of the available transactions in the transaction
+	// memory pool, limited to the max allowed inventory per message.  The
+	// inventory is announced along with the other transaction inventory
+	// of the peer at the next of its randomized announcement times, so the
+	// request can not be used to learn about transactions before they are
+	// announced, and transactions the peer already knows are skipped.
+	txDescs := sp.server.txMemPool.TxDescs()
+	numQueued := 0
+	for _, txDesc := range txDescs {
 		// Either add all transactions when there is no bloom filter,
 		// or only the transactions that match the filter when there is
 		// one.
 		if !sp.filter.IsLoaded() || sp.filter.MatchTxAndUpdate(txDesc.Tx) {
 			iv := wire.NewInvVect(wire.InvTypeTx, txDesc.Tx.Hash())
-			invMsg.AddInvVect(iv)
-			if i+1 >= wire.MaxInvPerMsg {
+			p.QueueInventory(iv)
+			numQueued++
+			if numQueued >= wire.MaxInvPerMsg {
 				break
 			}
 		}
 	}
-
-	// Send the inventory message if there is anything to send.
-	if len(invMsg.InvList) > 0 {
-		p.QueueMessage(invMsg, nil)
-	}
 }
 
 // pushMiningStateMsg pushes a mining state message to the queue for a
@@ -621,11 +826,16 @@ func (sp *serverPeer) OnMiningState(p *peer.Peer, msg *wire.MsgMiningState) {
 // serialize all transactions through a single thread transactions don't rely on
 // the previous one in a linear fashion like blocks.
 func (sp *serverPeer) OnTx(p *peer.Peer, msg *wire.MsgTx) {
-	if cfg.BlocksOnly {
+	if cfg.BlocksOnly && !sp.permissions.has(permRelay) {
 		peerLog.Tracef("Ignoring tx %v from %v - blocksonly enabled",
 			msg.TxHash(), p)
 		return
 	}
+	if sp.blockRelayOnly {
+		peerLog.Tracef("Ignoring tx %v from block-relay-only peer %v",
+			msg.TxHash(), p)
+		return
+	}
 
 	// Add the transaction to the known inventory for the peer.
 	// Convert the raw MsgTx to a hcutil.Tx which provides some convenience
@@ -667,12 +877,221 @@ func (sp *serverPeer) OnBlock(p *peer.Peer, msg *wire.MsgBlock, buf []byte) {
 	<-sp.blockProcessed
 }
 
+// supportsCmpctBlocks returns whether or not the peer signalled support for
+// compact blocks.
+func (sp *serverPeer) supportsCmpctBlocks() bool {
+	sp.cmpctMtx.Lock()
+	supported := sp.cmpctSupported
+	sp.cmpctMtx.Unlock()
+	return supported
+}
+
+// wantsCmpctBlocks returns whether or not the peer wants new blocks to be
+// announced by sending compact blocks directly.
+func (sp *serverPeer) wantsCmpctBlocks() bool {
+	sp.cmpctMtx.Lock()
+	announce := sp.cmpctSupported && sp.cmpctAnnounce
+	sp.cmpctMtx.Unlock()
+	return announce
+}
+
+// OnSendCmpct is invoked when a peer receives a sendcmpct wire message.  It is
+// used to track whether or not the peer supports compact blocks and whether or
+// not it wants new blocks to be announced by sending compact blocks directly.
+func (sp *serverPeer) OnSendCmpct(p *peer.Peer, msg *wire.MsgSendCmpct) {
+	// Ignore unsupported versions of compact blocks.
+	if msg.CmpctBlockVersion != wire.CmpctBlockVersion {
+		return
+	}
+
+	sp.cmpctMtx.Lock()
+	sp.cmpctSupported = true
+	sp.cmpctAnnounce = msg.AnnounceUsingCmpctBlock
+	sp.cmpctMtx.Unlock()
+}
+
+// OnCmpctBlock is invoked when a peer receives a cmpctblock wire message.  The
+// block is reconstructed from the transactions of the memory pool and the
+// missing transactions are requested from the peer with a getblocktxn message.
+// It blocks until the reconstructed block has been fully processed.
+func (sp *serverPeer) OnCmpctBlock(p *peer.Peer, msg *wire.MsgCmpctBlock) {
+	blockHash := msg.Header.BlockHash()
+	iv := wire.NewInvVect(wire.InvTypeBlock, &blockHash)
+	p.AddKnownInventory(iv)
+
+	// Ignore blocks which are already known.
+	exists, err := sp.server.blockManager.chain.HaveBlock(&blockHash)
+	if err != nil || exists {
+		return
+	}
+
+	// Disconnect peers which send compact blocks with an invalid proof of
+	// work before spending any effort on reconstructing them.
+	header := hcutil.NewBlock(&wire.MsgBlock{Header: msg.Header})
+	err = blockchain.CheckProofOfWork(header, sp.server.chainParams.PowLimit)
+	if err != nil {
+		peerLog.Debugf("Compact block %v from %s has an invalid proof "+
+			"of work: %v -- disconnecting", blockHash, sp, err)
+		sp.Disconnect()
+		return
+	}
+
+	// Request the missing blocks the normal way when the block does not
+	// connect to a known block rather than trying to reconstruct it.
+	chain := sp.server.blockManager.chain
+	exists, err = chain.HaveBlock(&msg.Header.PrevBlock)
+	if err != nil {
+		return
+	}
+	if !exists {
+		peerLog.Debugf("Compact block %v from %s does not connect to a "+
+			"known block -- requesting the missing blocks",
+			blockHash, sp)
+		locator, err := chain.LatestBlockLocator()
+		if err != nil {
+			peerLog.Warnf("Failed to get block locator for the "+
+				"latest block: %v", err)
+			return
+		}
+		if err := p.PushGetBlocksMsg(locator, &blockHash); err != nil {
+			peerLog.Warnf("Failed to push getblocksmsg for compact "+
+				"block %v: %v", blockHash, err)
+		}
+		return
+	}
+
+	txDescs := sp.server.txMemPool.TxDescs()
+	poolTxns := make([]*hcutil.Tx, 0, len(txDescs))
+	for _, txDesc := range txDescs {
+		poolTxns = append(poolTxns, txDesc.Tx)
+	}
+	pb, err := newPartialBlock(msg, poolTxns)
+	if err == errShortIDCollision {
+		peerLog.Debugf("Unable to reconstruct compact block %v from "+
+			"%s: %v -- requesting the full block", blockHash, sp, err)
+		sp.server.blockManager.RequestFullBlock(sp, &blockHash)
+		return
+	}
+	if err != nil {
+		peerLog.Debugf("Invalid compact block %v from %s: %v -- "+
+			"disconnecting", blockHash, sp, err)
+		sp.Disconnect()
+		return
+	}
+
+	if !pb.complete() {
+		sp.pendingCmpct = pb
+		getBlockTxn := pb.getBlockTxnMsg()
+		peerLog.Debugf("Requesting %d transactions and %d stake "+
+			"transactions of compact block %v from %s",
+			len(getBlockTxn.Indexes), len(getBlockTxn.SIndexes),
+			blockHash, sp)
+		sp.QueueMessage(getBlockTxn, nil)
+		return
+	}
+	sp.pendingCmpct = nil
+	sp.processPartialBlock(pb)
+}
+
+// OnBlockTxn is invoked when a peer receives a blocktxn wire message.  The
+// transactions fill in the missing transactions of the compact block which is
+// pending for the peer.  It blocks until the reconstructed block has been
+// fully processed.
+func (sp *serverPeer) OnBlockTxn(p *peer.Peer, msg *wire.MsgBlockTxn) {
+	pb := sp.pendingCmpct
+	if pb == nil || pb.header.BlockHash() != msg.BlockHash {
+		peerLog.Debugf("Ignoring unrequested blocktxn for block %v "+
+			"from %s", msg.BlockHash, sp)
+		return
+	}
+	sp.pendingCmpct = nil
+
+	if err := pb.fill(msg); err != nil {
+		peerLog.Debugf("Invalid blocktxn for block %v from %s: %v -- "+
+			"disconnecting", msg.BlockHash, sp, err)
+		sp.Disconnect()
+		return
+	}
+	sp.processPartialBlock(pb)
+}
+
+// processPartialBlock queues the block reconstructed from a compact block of
+// the peer to be processed by the block manager and blocks until it has been
+// fully processed.  The block is requested in full when the reconstructed
+// transactions do not match the header, which happens when short ids collide.
+func (sp *serverPeer) processPartialBlock(pb *partialBlock) {
+	block, err := pb.block()
+	if err != nil {
+		blockHash := pb.header.BlockHash()
+		peerLog.Debugf("Unable to reconstruct compact block %v from "+
+			"%s: %v -- requesting the full block", blockHash, sp, err)
+		sp.server.blockManager.RequestFullBlock(sp, &blockHash)
+		return
+	}
+
+	sp.server.blockManager.QueueCmpctBlock(block, sp)
+	<-sp.blockProcessed
+}
+
+// OnGetBlockTxn is invoked when a peer receives a getblocktxn wire message.  It
+// responds with the requested transactions of the block in a blocktxn message.
+// Blocks which are too deep in the chain are sent in full instead.
+func (sp *serverPeer) OnGetBlockTxn(p *peer.Peer, msg *wire.MsgGetBlockTxn) {
+	chain := sp.server.blockManager.chain
+	height, err := chain.BlockHeightByHash(&msg.BlockHash)
+	if err != nil || chain.BestSnapshot().Height-height >= maxBlockTxnDepth {
+		peerLog.Debugf("Sending block %v requested by getblocktxn from "+
+			"%s in full", msg.BlockHash, sp)
+		sp.server.pushBlockMsg(sp, &msg.BlockHash, nil, nil)
+		return
+	}
+
+	block, err := chain.FetchBlockByHash(&msg.BlockHash)
+	if err != nil {
+		peerLog.Debugf("Unable to fetch block %v requested by "+
+			"getblocktxn from %s: %v", msg.BlockHash, sp, err)
+		return
+	}
+
+	blockTxn, err := blockTxnMsg(block, msg)
+	if err != nil {
+		peerLog.Debugf("Invalid getblocktxn for block %v from %s: %v "+
+			"-- disconnecting", msg.BlockHash, sp, err)
+		sp.Disconnect()
+		return
+	}
+	sp.QueueMessage(blockTxn, nil)
+}
+
+// OnDandelionTx is invoked when a peer receives a dandeliontx wire message.
+// The transaction is relayed further along the stem or diffused to the network.
+// It blocks until the transaction has been fully processed.
+func (sp *serverPeer) OnDandelionTx(p *peer.Peer, msg *wire.MsgDandelionTx) {
+	if sp.server.dandelion == nil || sp.blockRelayOnly {
+		peerLog.Debugf("Ignoring unexpected dandeliontx %v from %s",
+			msg.Tx.TxHash(), sp)
+		return
+	}
+
+	// Add the transaction to the known inventory for the peer so it is not
+	// announced back to it once it is diffused.
+	tx := hcutil.NewTx(msg.Tx)
+	p.AddKnownInventory(wire.NewInvVect(wire.InvTypeTx, tx.Hash()))
+
+	err := sp.server.dandelion.ProcessStemTx(tx, sp, true)
+	if err != nil {
+		peerLog.Debugf("Rejected stem transaction %v from %s: %v",
+			tx.Hash(), sp, err)
+	}
+}
+
 // OnInv is invoked when a peer receives an inv wire message and is used to
 // examine the inventory being advertised by the remote peer and react
 // accordingly.  We pass the message down to blockmanager which will call
 // QueueMessage with any appropriate responses.
 func (sp *serverPeer) OnInv(p *peer.Peer, msg *wire.MsgInv) {
-	if !cfg.BlocksOnly {
+	acceptTxs := !cfg.BlocksOnly || sp.permissions.has(permRelay)
+	if acceptTxs && !sp.blockRelayOnly {
 		if len(msg.InvList) > 0 {
 			sp.server.blockManager.QueueInv(msg, sp)
 		}
@@ -724,7 +1143,10 @@ func (sp *serverPeer) OnGetData(p *peer.Peer, msg *wire.MsgGetData) {
 	// bursts of small requests are not penalized as that would potentially ban
 	// peers performing IBD.
 	// This incremental score decays each minute to half of its value.
-	sp.addBanScore(0, uint32(length)*99/wire.MaxInvPerMsg, "getdata")
+	// Peers with the download permission are exempt.
+	if !sp.permissions.has(permDownload) {
+		sp.addBanScore(0, uint32(length)*99/wire.MaxInvPerMsg, "getdata")
+	}
 
 	// We wait on this wait channel periodically to prevent queuing
 	// far more data than we can send in a reasonable time, wasting memory.
@@ -748,6 +1170,8 @@ func (sp *serverPeer) OnGetData(p *peer.Peer, msg *wire.MsgGetData) {
 			err = sp.server.pushTxMsg(sp, &iv.Hash, c, waitChan)
 		case wire.InvTypeBlock:
 			err = sp.server.pushBlockMsg(sp, &iv.Hash, c, waitChan)
+		case wire.InvTypeCmpctBlock:
+			err = sp.server.pushCmpctBlockMsg(sp, &iv.Hash, c, waitChan)
 		default:
 			peerLog.Warnf("Unknown type %d in inventory request from %s",
 				iv.Type,sp)
@@ -1043,8 +1467,9 @@ func (sp *serverPeer) OnFilterLoad(p *peer.Peer, msg *wire.MsgFilterLoad) {
 	}
 
 	// Transaction relay is no longer disabled once a filterload message is
-	// received regardless of its original state.
-	sp.setDisableRelayTx(false)
+	// received regardless of its original state, unless the peer is a
+	// block-relay-only peer.
+	sp.setDisableRelayTx(sp.blockRelayOnly)
 
 	sp.filter.Reload(msg)
 }
@@ -1060,9 +1485,9 @@ func (sp *serverPeer) OnGetAddr(p *peer.Peer, msg *wire.MsgGetAddr) {
 		return
 	}
 
-	// Do not accept getaddr requests from outbound peers.  This reduces
-	// fingerprinting attacks.
-	if !p.Inbound() {
+	// Do not accept getaddr requests from outbound peers unless they have
+	// the addr permission.  This reduces fingerprinting attacks.
+	if !p.Inbound() && !sp.permissions.has(permAddr) {
 		return
 	}
 
@@ -1076,6 +1501,19 @@ func (sp *serverPeer) OnGetAddr(p *peer.Peer, msg *wire.MsgGetAddr) {
 // OnAddr is invoked when a peer receives an addr wire message and is used to
 // notify the server about advertised addresses.
 func (sp *serverPeer) OnAddr(p *peer.Peer, msg *wire.MsgAddr) {
+	sp.handleAddrList(p, msg.Command(), msg.AddrList)
+}
+
+// OnAddrV2 is invoked when a peer receives an addrv2 wire message and is used
+// to notify the server about advertised addresses, including those which can't
+// be represented by an IP address such as Tor v3 onion addresses.
+func (sp *serverPeer) OnAddrV2(p *peer.Peer, msg *wire.MsgAddrV2) {
+	sp.handleAddrList(p, msg.Command(), msg.AddrList)
+}
+
+// handleAddrList adds the addresses advertised by an addr or addrv2 message to
+// the known addresses of the peer and the address manager.
+func (sp *serverPeer) handleAddrList(p *peer.Peer, cmd string, addrList []*wire.NetAddress) {
 	// Ignore addresses when running on the simulation test network.  This
 	// helps prevent the network from becoming another public test network
 	// since it will not be able to learn about other peers that have not
@@ -1084,16 +1522,23 @@ func (sp *serverPeer) OnAddr(p *peer.Peer, msg *wire.MsgAddr) {
 		return
 	}
 
+	// Addresses are never relayed by block-relay-only peers.
+	if sp.blockRelayOnly {
+		peerLog.Debugf("Ignoring %s from block-relay-only peer %v", cmd,
+			p)
+		return
+	}
+
 	// A message that has no addresses is invalid.
-	if len(msg.AddrList) == 0 {
+	if len(addrList) == 0 {
 		peerLog.Errorf("Command [%s] from %s does not contain any addresses",
-			msg.Command(), p)
+			cmd, p)
 		p.Disconnect()
 		return
 	}
 
 	now := time.Now()
-	for _, na := range msg.AddrList {
+	for _, na := range addrList {
 		// Don't add more address if we're disconnecting.
 		if !p.Connected() {
 			return
@@ -1116,7 +1561,7 @@ func (sp *serverPeer) OnAddr(p *peer.Peer, msg *wire.MsgAddr) {
 	// addresses, and last seen updates.
 	// XXX bitcoind gives a 2 hour time penalty here, do we want to do the
 	// same?
-	sp.server.addrManager.AddAddresses(msg.AddrList, p.NA())
+	sp.server.addrManager.AddAddresses(addrList, p.NA())
 }
 
 // OnRead is invoked when a peer receives a message and it is used to update
@@ -1240,6 +1685,23 @@ func (s *server) pushBlockMsg(sp *serverPeer, hash *chainhash.Hash, doneChan cha
 		return err
 	}
 
+	// Disconnect peers without the download permission which request
+	// historical blocks once the upload target is approached, since they
+	// are most likely syncing and are better served by other nodes.
+	now := time.Now()
+	if isHistoricalBlock(block.MsgBlock().Header.Timestamp, now) &&
+		!sp.permissions.has(permDownload) &&
+		!s.uploadTarget.serveHistoricalBlocks(now) {
+
+		peerLog.Infof("Upload target reached -- disconnecting peer %s "+
+			"requesting historical block %v", sp, hash)
+		sp.Disconnect()
+		if doneChan != nil {
+			doneChan <- struct{}{}
+		}
+		return errors.New("upload target reached")
+	}
+
 	// Once we have fetched data wait for any previous operation to finish.
 	if waitChan != nil {
 		<-waitChan
@@ -1271,6 +1733,42 @@ func (s *server) pushBlockMsg(sp *serverPeer, hash *chainhash.Hash, doneChan cha
 	return nil
 }
 
+// pushCmpctBlockMsg sends a cmpctblock message for the provided block hash to
+// the connected peer.  The block is sent in full when the peer does not support
+// compact blocks or the block is too old for the peer to be expected to have
+// its transactions.  An error is returned if the block hash is not known.
+func (s *server) pushCmpctBlockMsg(sp *serverPeer, hash *chainhash.Hash, doneChan chan<- struct{}, waitChan <-chan struct{}) error {
+	chain := sp.server.blockManager.chain
+	height, err := chain.BlockHeightByHash(hash)
+	if err != nil || !sp.supportsCmpctBlocks() ||
+		chain.BestSnapshot().Height-height >= maxCmpctBlockDepth {
+
+		return s.pushBlockMsg(sp, hash, doneChan, waitChan)
+	}
+
+	block, err := chain.FetchBlockByHash(hash)
+	if err == nil {
+		var msg *wire.MsgCmpctBlock
+		msg, err = newCmpctBlock(block)
+		if err == nil {
+			// Once we have fetched data wait for any previous
+			// operation to finish.
+			if waitChan != nil {
+				<-waitChan
+			}
+			sp.QueueMessage(msg, doneChan)
+			return nil
+		}
+	}
+
+	peerLog.Tracef("Unable to fetch requested compact block hash %v: %v",
+		hash, err)
+	if doneChan != nil {
+		doneChan <- struct{}{}
+	}
+	return err
+}
+
 // handleUpdatePeerHeight updates the heights of all peers who were known to
 // announce a block we recently accepted.
 func (s *server) handleUpdatePeerHeights(state *peerState, umsg updatePeerHeightsMsg) {
@@ -1320,7 +1818,7 @@ func (s *server) handleAddPeerMsg(state *peerState, sp *serverPeer) bool {
 		sp.Disconnect()
 		return false
 	}
-	if banEnd, ok := state.banned[host]; ok {
+	if banEnd, ok := state.banned[host]; ok && !sp.permissions.has(permNoBan) {
 		if time.Now().Before(banEnd) {
 			srvrLog.Debugf("Peer %s is banned for another %v - disconnecting",
 				host, banEnd.Sub(time.Now()))
@@ -1335,8 +1833,13 @@ func (s *server) handleAddPeerMsg(state *peerState, sp *serverPeer) bool {
 	// TODO: Check for max peers from a single IP.
 
 	// Limit max number of total peers.
-	// allow whitelisted inbound peers regardless.
-	if state.Count() >= cfg.MaxPeers && !(sp.Inbound() && sp.isWhitelisted) {
+	// allow inbound peers with the noban permission regardless and evict an
+	// existing inbound peer to make room for other inbound peers when
+	// possible.
+	isNoBanInbound := sp.Inbound() && sp.permissions.has(permNoBan)
+	if state.Count() >= cfg.MaxPeers && !isNoBanInbound &&
+		!(sp.Inbound() && s.evictInboundPeer(state)) {
+
 		srvrLog.Infof("Max peers reached [%d] - disconnecting peer %s",
 			cfg.MaxPeers, sp)
 		sp.Disconnect()
@@ -1350,7 +1853,7 @@ func (s *server) handleAddPeerMsg(state *peerState, sp *serverPeer) bool {
 	if sp.Inbound() {
 		state.inboundPeers[sp.ID()] = sp
 	} else {
-		state.outboundGroups[addrmgr.GroupKey(sp.NA())]++
+		state.outboundGroups[s.addrManager.GroupKey(sp.NA())]++
 		if sp.persistent {
 			state.persistentPeers[sp.ID()] = sp
 		} else {
@@ -1361,6 +1864,30 @@ func (s *server) handleAddPeerMsg(state *peerState, sp *serverPeer) bool {
 	return true
 }
 
+// saveAnchors saves the addresses of the connected block-relay-only peers to the
+// anchors file so they are connected to first on the next start.  It is invoked
+// from the peerHandler goroutine.
+func (s *server) saveAnchors(state *peerState) {
+	if cfg.BlockRelayConns == 0 {
+		return
+	}
+
+	var anchors []string
+	for _, sp := range state.outboundPeers {
+		if sp.blockRelayOnly && sp.Connected() && sp.VerAckReceived() {
+			anchors = append(anchors, sp.Addr())
+		}
+	}
+	if len(anchors) == 0 {
+		return
+	}
+	if err := saveAnchors(anchorsFilePath(), anchors); err != nil {
+		srvrLog.Errorf("Unable to save anchors: %v", err)
+		return
+	}
+	srvrLog.Debugf("Saved %d anchors", len(anchors))
+}
+
 // handleDonePeerMsg deals with peers that have signalled they are done.  It is
 // invoked from the peerHandler goroutine.
 func (s *server) handleDonePeerMsg(state *peerState, sp *serverPeer) {
@@ -1374,7 +1901,7 @@ func (s *server) handleDonePeerMsg(state *peerState, sp *serverPeer) {
 	}
 	if _, ok := list[sp.ID()]; ok {
 		if !sp.Inbound() && sp.VersionKnown() {
-			state.outboundGroups[addrmgr.GroupKey(sp.NA())]--
+			state.outboundGroups[s.addrManager.GroupKey(sp.NA())]--
 		}
 		if !sp.Inbound() && sp.connReq != nil {
 			s.connManager.Disconnect(sp.connReq.ID())
@@ -1388,6 +1915,13 @@ func (s *server) handleDonePeerMsg(state *peerState, sp *serverPeer) {
 		s.connManager.Disconnect(sp.connReq.ID())
 	}
 
+	// Fall back to the unencrypted transport for later connections to an
+	// address when the remote peer rejected the encrypted transport.  Other
+	// failures, such as timeouts, keep it in use.
+	if sp.initiatedEncryption && sp.EncryptionRejected() {
+		s.markUnencrypted(sp.Addr())
+	}
+
 	// Update the address' last seen time if the peer has acknowledged
 	// our version and has sent us its version as well.
 	if sp.VerAckReceived() && sp.VersionKnown() && sp.NA() != nil {
@@ -1415,21 +1949,68 @@ func (s *server) handleBanPeerMsg(state *peerState, sp *serverPeer) {
 // handleRelayInvMsg deals with relaying inventory to peers that are not already
 // known to have it.  It is invoked from the peerHandler goroutine.
 func (s *server) handleRelayInvMsg(state *peerState, msg relayMsg) {
+	// Determine the fee rate of regular transactions so they are not
+	// announced to peers which requested a higher minimum fee rate.  Stake
+	// transactions are not subject to fee filters.
+	var txFeeRate int64
+	var checkFeeFilter bool
+	if msg.invVect.Type == wire.InvTypeTx {
+		txDesc, err := s.txMemPool.FetchTxDesc(&msg.invVect.Hash)
+		if err == nil && txDesc.Type == stake.TxTypeRegular {
+			txSize := int64(txDesc.Tx.MsgTx().SerializeSize())
+			txFeeRate = txDesc.Fee * 1000 / txSize
+			checkFeeFilter = true
+		}
+	}
+
+	// The compact block sent to the peers which want new blocks to be
+	// announced by sending compact blocks directly.  It is only created
+	// when needed.
+	var cmpctBlock *wire.MsgCmpctBlock
+
 	state.forAllPeers(func(sp *serverPeer) {
 		if !sp.Connected() {
 			return
 		}
 
+		// If the inventory is a block and the peer wants compact blocks,
+		// send the compact block directly instead of an inventory
+		// message.
+		if msg.invVect.Type == wire.InvTypeBlock && sp.wantsCmpctBlocks() {
+			if sp.IsKnownInventory(msg.invVect) {
+				return
+			}
+			if cmpctBlock == nil {
+				block, ok := msg.data.(*hcutil.Block)
+				if !ok {
+					peerLog.Warnf("Underlying data for " +
+						"compact block is not a block")
+					return
+				}
+				var err error
+				cmpctBlock, err = newCmpctBlock(block)
+				if err != nil {
+					peerLog.Errorf("Failed to create compact "+
+						"block: %v", err)
+					return
+				}
+			}
+			sp.QueueMessage(cmpctBlock, nil)
+			sp.AddKnownInventory(msg.invVect)
+			return
+		}
+
 		// If the inventory is a block and the peer prefers headers,
 		// generate and send a headers message instead of an inventory
 		// message.
 		if msg.invVect.Type == wire.InvTypeBlock && sp.WantsHeaders() {
-			blockHeader, ok := msg.data.(wire.BlockHeader)
+			block, ok := msg.data.(*hcutil.Block)
 			if !ok {
 				peerLog.Warnf("Underlying data for headers" +
-					" is not a block header")
+					" is not a block")
 				return
 			}
+			blockHeader := block.MsgBlock().Header
 			msgHeaders := wire.NewMsgHeaders()
 			if err := msgHeaders.AddBlockHeader(&blockHeader); err != nil {
 				peerLog.Errorf("Failed to add block"+
@@ -1446,6 +2027,14 @@ func (s *server) handleRelayInvMsg(state *peerState, msg relayMsg) {
 			if sp.relayTxDisabled() {
 				return
 			}
+
+			// Don't relay the transaction if it pays a lower fee
+			// rate than the peer requested.
+			feeFilter := atomic.LoadInt64(&sp.feeFilter)
+			if checkFeeFilter && txFeeRate < feeFilter {
+				return
+			}
+
 			// Don't relay the transaction if there is a bloom
 			// filter loaded and the transaction doesn't match it.
 			if sp.filter.IsLoaded() {
@@ -1577,7 +2166,7 @@ func (s *server) handleQuery(state *peerState, querymsg interface{}) {
 		found := disconnectPeer(state.persistentPeers, msg.cmp, func(sp *serverPeer) {
 			// Keep group counts ok since we remove from
 			// the list now.
-			state.outboundGroups[addrmgr.GroupKey(sp.NA())]--
+			state.outboundGroups[s.addrManager.GroupKey(sp.NA())]--
 		})
 
 		if found {
@@ -1613,7 +2202,7 @@ func (s *server) handleQuery(state *peerState, querymsg interface{}) {
 		found = disconnectPeer(state.outboundPeers, msg.cmp, func(sp *serverPeer) {
 			// Keep group counts ok since we remove from
 			// the list now.
-			state.outboundGroups[addrmgr.GroupKey(sp.NA())]--
+			state.outboundGroups[s.addrManager.GroupKey(sp.NA())]--
 		})
 		if found {
 			// If there are multiple outbound connections to the same
@@ -1621,7 +2210,7 @@ func (s *server) handleQuery(state *peerState, querymsg interface{}) {
 			// peers are found.
 			for found {
 				found = disconnectPeer(state.outboundPeers, msg.cmp, func(sp *serverPeer) {
-					state.outboundGroups[addrmgr.GroupKey(sp.NA())]--
+					state.outboundGroups[s.addrManager.GroupKey(sp.NA())]--
 				})
 			}
 			msg.reply <- nil
@@ -1656,11 +2245,25 @@ func disconnectPeer(peerList map[int32]*serverPeer, compareFunc func(*serverPeer
 	return false
 }
 
-// newPeerConfig returns the configuration for the given serverPeer.
+// newPeerConfig returns the configuration for the given serverPeer.  The
+// permissions of the serverPeer must be set beforehand.
 func newPeerConfig(sp *serverPeer) *peer.Config {
+	// Peers with the download permission are exempt from the send rate
+	// limit.
+	var maxSendRate uint64
+	if !sp.permissions.has(permDownload) {
+		maxSendRate = cfg.MaxPeerSendRate * 1024
+	}
+
+	var captureDir string
+	if cfg.CaptureMessages {
+		captureDir = filepath.Join(cfg.DataDir, msgCaptureDirName)
+	}
+
 	return &peer.Config{
 		Listeners: peer.MessageListeners{
 			OnVersion:        sp.OnVersion,
+			OnVerAck:         sp.OnVerAck,
 			OnMemPool:        sp.OnMemPool,
 			OnGetMiningState: sp.OnGetMiningState,
 			OnMiningState:    sp.OnMiningState,
@@ -1674,8 +2277,15 @@ func newPeerConfig(sp *serverPeer) *peer.Config {
 			OnFilterAdd:      sp.OnFilterAdd,
 			OnFilterClear:    sp.OnFilterClear,
 			OnFilterLoad:     sp.OnFilterLoad,
+			OnFeeFilter:      sp.OnFeeFilter,
+			OnSendCmpct:      sp.OnSendCmpct,
+			OnCmpctBlock:     sp.OnCmpctBlock,
+			OnGetBlockTxn:    sp.OnGetBlockTxn,
+			OnBlockTxn:       sp.OnBlockTxn,
+			OnDandelionTx:    sp.OnDandelionTx,
 			OnGetAddr:        sp.OnGetAddr,
 			OnAddr:           sp.OnAddr,
+			OnAddrV2:         sp.OnAddrV2,
 			OnRead:           sp.OnRead,
 			OnWrite:          sp.OnWrite,
 		},
@@ -1686,8 +2296,11 @@ func newPeerConfig(sp *serverPeer) *peer.Config {
 		UserAgentVersion: userAgentVersion,
 		ChainParams:      sp.server.chainParams,
 		Services:         sp.server.services,
-		DisableRelayTx:   cfg.BlocksOnly,
+		DisableRelayTx:   cfg.BlocksOnly || sp.blockRelayOnly || sp.feeler,
+		AcceptEncryption: sp.server.services&wire.SFNodeEncrypted != 0,
 		ProtocolVersion:  maxProtocolVersion,
+		MaxSendRate:      maxSendRate,
+		CaptureDir:       captureDir,
 	}
 }
 
@@ -1697,7 +2310,7 @@ func newPeerConfig(sp *serverPeer) *peer.Config {
 // for disconnection.
 func (s *server) inboundPeerConnected(conn net.Conn) {
 	sp := newServerPeer(s, false)
-	sp.isWhitelisted = isWhitelisted(conn.RemoteAddr())
+	sp.permissions = connPermissions(conn)
 	sp.Peer = peer.NewInboundPeer(newPeerConfig(sp))
 	sp.AssociateConnection(conn)
 	go s.peerDoneHandler(sp)
@@ -1710,7 +2323,13 @@ func (s *server) inboundPeerConnected(conn net.Conn) {
 // manager of the attempt.
 func (s *server) outboundPeerConnected(c *connmgr.ConnReq, conn net.Conn) {
 	sp := newServerPeer(s, c.Permanent)
-	p, err := peer.NewOutboundPeer(newPeerConfig(sp), c.Addr.String())
+	sp.blockRelayOnly = c.BlockRelayOnly
+	sp.feeler = c.Feeler
+	sp.permissions = whitelistPermissions(conn.RemoteAddr())
+	peerCfg := newPeerConfig(sp)
+	sp.initiatedEncryption = s.shouldEncrypt(c)
+	peerCfg.InitiateEncryption = sp.initiatedEncryption
+	p, err := peer.NewOutboundPeer(peerCfg, c.Addr.String())
 	if err != nil {
 		srvrLog.Debugf("Cannot create outbound peer %s: %v", c.Addr, err)
 		s.connManager.Disconnect(c.ID())
@@ -1718,12 +2337,56 @@ func (s *server) outboundPeerConnected(c *connmgr.ConnReq, conn net.Conn) {
 	}
 	sp.Peer = p
 	sp.connReq = c
-	sp.isWhitelisted = isWhitelisted(conn.RemoteAddr())
 	sp.AssociateConnection(conn)
 	go s.peerDoneHandler(sp)
 	s.addrManager.Attempt(sp.NA())
 }
 
+// shouldEncrypt returns whether or not the encrypted transport should be
+// requested for the passed outbound connection request.  It is requested from
+// peers known to support it and from persistent peers, unless negotiating it
+// with the address failed before.
+func (s *server) shouldEncrypt(c *connmgr.ConnReq) bool {
+	if s.services&wire.SFNodeEncrypted == 0 {
+		return false
+	}
+
+	addr := c.Addr.String()
+	s.unencryptedMtx.Lock()
+	_, unencrypted := s.unencryptedAddrs[addr]
+	s.unencryptedMtx.Unlock()
+	if unencrypted {
+		return false
+	}
+	if c.Permanent {
+		return true
+	}
+
+	na, err := s.addrManager.DeserializeNetAddress(addr)
+	if err != nil {
+		return false
+	}
+	services, ok := s.addrManager.LookupServices(na)
+	return ok && services&wire.SFNodeEncrypted != 0
+}
+
+// markUnencrypted marks the passed address as unable to negotiate the
+// encrypted transport.  An arbitrary address is forgotten when the maximum
+// number of addresses is reached.
+func (s *server) markUnencrypted(addr string) {
+	s.unencryptedMtx.Lock()
+	if len(s.unencryptedAddrs) >= maxUnencryptedAddrs {
+		for a := range s.unencryptedAddrs {
+			delete(s.unencryptedAddrs, a)
+			break
+		}
+	}
+	s.unencryptedAddrs[addr] = struct{}{}
+	s.unencryptedMtx.Unlock()
+
+	srvrLog.Debugf("Falling back to the unencrypted transport for %s", addr)
+}
+
 // peerDoneHandler handles peer disconnects by notifiying the server that it's
 // done.
 func (s *server) peerDoneHandler(sp *serverPeer) {
@@ -1731,7 +2394,8 @@ func (s *server) peerDoneHandler(sp *serverPeer) {
 	s.donePeers <- sp
 
 	// Only tell block manager we are gone if we ever told it we existed.
-	if sp.VersionKnown() {
+	// Feeler connections are never added.
+	if sp.VersionKnown() && !sp.feeler {
 		s.blockManager.DonePeer(sp)
 	}
 	close(sp.quit)
@@ -1772,6 +2436,9 @@ func (s *server) peerHandler() {
 	}
 	go s.connManager.Start()
 
+	feeFilterTicker := time.NewTicker(feeFilterCheckInterval)
+	defer feeFilterTicker.Stop()
+
 out:
 	for {
 		select {
@@ -1803,7 +2470,19 @@ out:
 		case qmsg := <-s.query:
 			s.handleQuery(state, qmsg)
 
+		// Send new fee filters to the peers which are due one.
+		case now := <-feeFilterTicker.C:
+			state.forAllPeers(func(sp *serverPeer) {
+				if sp.Connected() && sp.VerAckReceived() {
+					sp.pushFeeFilterMsg(now)
+				}
+			})
+
 		case <-s.quit:
+			// Save the block-relay-only peers as the anchors of the
+			// next start.
+			s.saveAnchors(state)
+
 			// Disconnect all peers on server shutdown.
 			state.forAllPeers(func(sp *serverPeer) {
 				srvrLog.Tracef("Shutdown peer %s", sp)
@@ -1817,6 +2496,18 @@ out:
 	s.blockManager.Stop()
 	s.addrManager.Stop()
 
+	// Save the transaction memory pool so it can be loaded again on the
+	// next start.  It is not saved when loading it did not finish since
+	// that would lose the transactions which were not loaded yet.
+	if !cfg.NoPersistMempool && atomic.LoadInt32(&s.mempoolLoaded) != 0 {
+		count, err := s.txMemPool.Save(mempoolFilePath())
+		if err != nil {
+			srvrLog.Errorf("Unable to save mempool: %v", err)
+		} else {
+			srvrLog.Infof("Saved %d mempool transactions", count)
+		}
+	}
+
 	// Drain channels before exiting so nothing is left waiting around
 	// to send.
 cleanup:
@@ -1964,6 +2655,7 @@ func (s *server) ConnectNode(addr string, permanent bool) error {
 // for the server.  It is safe for concurrent access.
 func (s *server) AddBytesSent(bytesSent uint64) {
 	atomic.AddUint64(&s.bytesSent, bytesSent)
+	s.uploadTarget.addBytesSent(bytesSent, time.Now())
 }
 
 // AddBytesReceived adds the passed number of bytes to the total bytes received
@@ -1979,6 +2671,14 @@ func (s *server) NetTotals() (uint64, uint64) {
 		atomic.LoadUint64(&s.bytesSent)
 }
 
+// FeelerStats returns the number of feeler connections attempted and the number
+// of them which completed the version handshake.  It is safe for concurrent
+// access.
+func (s *server) FeelerStats() (uint64, uint64) {
+	return atomic.LoadUint64(&s.feelerAttempts),
+		atomic.LoadUint64(&s.feelerSuccesses)
+}
+
 // UpdatePeerHeights updates the heights of all peers who have have announced
 // the latest connected main chain block, or a recognized orphan. These height
 // updates allow us to dynamically refresh peer heights, ensuring sync peer
@@ -2066,11 +2766,34 @@ func (s *server) Start() {
 	s.wg.Add(1)
 	go s.peerHandler()
 
+	// Load the transaction memory pool saved on the last shutdown in the
+	// background.
+	if cfg.NoPersistMempool {
+		atomic.StoreInt32(&s.mempoolLoaded, 1)
+	} else {
+		s.wg.Add(1)
+		go s.mempoolLoadHandler()
+	}
+
+	// Start catching up any optional indexes that are behind the main
+	// chain in the background.
+	if s.indexManager != nil {
+		s.indexManager.Start()
+	}
+
 	if s.nat != nil {
 		s.wg.Add(1)
 		go s.upnpUpdateThread()
 	}
 
+	if s.dandelion != nil {
+		s.wg.Add(1)
+		go func() {
+			s.dandelion.embargoHandler(s.quit)
+			s.wg.Done()
+		}()
+	}
+
 	if !cfg.DisableRPC {
 		s.wg.Add(1)
 
@@ -2085,6 +2808,11 @@ func (s *server) Start() {
 	if cfg.Generate {
 		s.cpuMiner.Start()
 	}
+
+	// Start the Stratum server if it is enabled.
+	if s.stratumServer != nil {
+		s.stratumServer.Start()
+	}
 }
 
 // Stop gracefully shuts down the server by stopping and disconnecting all
@@ -2103,11 +2831,21 @@ func (s *server) Stop() error {
 		s.cpuMiner.Stop()
 	}
 
+	// Shutdown the Stratum server if it's enabled.
+	if s.stratumServer != nil {
+		s.stratumServer.Stop()
+	}
+
 	// Shutdown the RPC server if it's not disabled.
 	if !cfg.DisableRPC && s.rpcServer != nil {
 		s.rpcServer.Stop()
 	}
 
+	// Stop catching up the optional indexes.
+	if s.indexManager != nil {
+		s.indexManager.Stop()
+	}
+
 	// Signal the remaining goroutines to quit.
 	close(s.quit)
 	return nil
@@ -2170,6 +2908,36 @@ func parseListeners(addrs []string) ([]string, []string, bool, error) {
 	return ipv4ListenAddrs, ipv6ListenAddrs, haveWildcard, nil
 }
 
+// mempoolFilePath returns the path of the file the transaction memory pool is
+// saved to.
+func mempoolFilePath() string {
+	return filepath.Join(cfg.DataDir, mempoolFileName)
+}
+
+// mempoolLoadHandler loads the transaction memory pool saved on the last
+// shutdown and marks the mempool as loaded once done.  It must be run as a
+// goroutine.
+func (s *server) mempoolLoadHandler() {
+	defer s.wg.Done()
+
+	txns, skipped, err := s.txMemPool.Load(mempoolFilePath(), s.quit)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		srvrLog.Errorf("Unable to load mempool: %v", err)
+	default:
+		srvrLog.Infof("Loaded %d mempool transactions (%d skipped)",
+			len(txns), skipped)
+	}
+
+	select {
+	case <-s.quit:
+		return
+	default:
+	}
+	atomic.StoreInt32(&s.mempoolLoaded, 1)
+}
+
 func (s *server) upnpUpdateThread() {
 	// Go off immediately to prevent code duplication, thereafter we renew
 	// lease every 15 minutes.
@@ -2246,8 +3014,24 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 	if cfg.NoPeerBloomFilters {
 		services &^= wire.SFNodeBloom
 	}
+	if cfg.NoEncryption {
+		services &^= wire.SFNodeEncrypted
+	}
+	if cfg.Dandelion {
+		services |= wire.SFNodeDandelion
+	}
 
 	amgr := addrmgr.New(cfg.DataDir, hcdLookup)
+	if cfg.ASMap != "" {
+		asmap, err := addrmgr.LoadASMap(cfg.ASMap)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load asmap %s: %v",
+				cfg.ASMap, err)
+		}
+		amgr.SetASMap(asmap)
+		srvrLog.Infof("Using asmap %s with checksum %v", cfg.ASMap,
+			asmap.Checksum())
+	}
 
 	var listeners []net.Listener
 	var nat NAT
@@ -2368,11 +3152,53 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 			}
 		}
 
+		// Peers connecting to whitebind listeners are granted the
+		// permissions of the listener.
+		for _, wb := range cfg.whitebinds {
+			listener, err := net.Listen("tcp", wb.addr)
+			if err != nil {
+				srvrLog.Warnf("Can't listen on %s: %v", wb.addr,
+					err)
+				continue
+			}
+			listeners = append(listeners, &permListener{
+				Listener: listener,
+				perms:    wb.perms,
+			})
+			if discover {
+				if na, err := amgr.DeserializeNetAddress(wb.addr); err == nil {
+					err = amgr.AddLocalAddress(na, addrmgr.BoundPrio)
+					if err != nil {
+						amgrLog.Debugf("Skipping bound address: %v", err)
+					}
+				}
+			}
+		}
+
 		if len(listeners) == 0 {
 			return nil, errors.New("no valid listen address")
 		}
 	}
 
+	evictionSeed, err := wire.RandomUint64()
+	if err != nil {
+		return nil, err
+	}
+
+	// Reserve enough of the upload target to relay a maximum size block
+	// every target block interval during the timeframe.
+	uploadTargetBytes := cfg.MaxUploadTarget * 1024 * 1024
+	blocksPerTimeframe := uint64(uploadTargetTimeframe /
+		chainParams.TargetTimePerBlock)
+	uploadTargetBuffer := blocksPerTimeframe *
+		uint64(chainParams.MaximumBlockSizes[0])
+	if uploadTargetBytes != 0 && uploadTargetBytes < uploadTargetBuffer {
+		srvrLog.Warnf("The upload target of %d MiB is below the %d MiB "+
+			"reserved for relaying new blocks -- historical blocks "+
+			"will not be served", cfg.MaxUploadTarget,
+			uploadTargetBuffer/(1024*1024))
+	}
+
 	s := server{
 		chainParams:          chainParams,
 		addrManager:          amgr,
@@ -2390,6 +3216,10 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 		timeSource:           blockchain.NewMedianTime(),
 		services:             services,
 		sigCache:             txscript.NewSigCache(cfg.SigCacheMaxSize),
+		feeFilterRounder:     newFeeFilterRounder(cfg.minRelayTxFee),
+		unencryptedAddrs:     make(map[string]struct{}),
+		evictionSeed:         evictionSeed,
+		uploadTarget:         newUploadTarget(uploadTargetBytes, uploadTargetBuffer),
 	}
 
 	// Create the transaction and address indexes if needed.
@@ -2427,7 +3257,8 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 	// Create an index manager if any of the optional indexes are enabled.
 	var indexManager blockchain.IndexManager
 	if len(indexes) > 0 {
-		indexManager = indexers.NewManager(db, indexes, chainParams)
+		s.indexManager = indexers.NewManager(db, indexes, chainParams)
+		indexManager = s.indexManager
 	}
 	bm, err := newBlockManager(&s, indexManager)
 	if err != nil {
@@ -2442,9 +3273,14 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 			RelayNonStd:          cfg.RelayNonStd,
 			FreeTxRelayLimit:     cfg.FreeTxRelayLimit,
 			MaxOrphanTxs:         cfg.MaxOrphanTxs,
+			MaxOrphanTxsPerTag:   cfg.MaxOrphanTxsPerPeer,
+			OrphanTTL:            cfg.OrphanTxExpiry,
+			MaxTxAge:             cfg.MempoolExpiry,
 			MaxOrphanTxSize:      defaultMaxOrphanTxSize,
 			MaxSigOpsPerTx:       blockchain.MaxSigOpsPerBlock / 5,
 			MinRelayTxFee:        cfg.minRelayTxFee,
+			AcceptReplacement:    !cfg.RejectReplacement,
+			MaxPoolSize:          cfg.MaxMempool * 1000 * 1000,
 			AllowOldVotes:        cfg.AllowOldVotes,
 			StandardVerifyFlags: func() (txscript.ScriptFlags, error) {
 				return standardScriptVerifyFlags(bm.chain)
@@ -2467,9 +3303,51 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 		PastMedianTime:   func() time.Time { return bm.chain.BestSnapshot().MedianTime },
 		AddrIndex:        s.addrIndex,
 		ExistsAddrIndex:  s.existsAddrIndex,
+		OnTxReplaced: func(replaced, replacement *hcutil.Tx) {
+			if s.rpcServer != nil {
+				s.rpcServer.ntfnMgr.NotifyTxReplaced(replaced,
+					replacement)
+			}
+		},
 	}
 	s.txMemPool = mempool.New(&txC)
 
+	// Keep the transactions in the stem phase of Dandelion++ in a separate
+	// pool which is validated against the memory pool, but does not index
+	// or notify about them.
+	if cfg.Dandelion {
+		stemC := txC
+		stemC.Policy.MaxOrphanTxs = 0
+		stemC.Policy.MaxPoolSize = txC.Policy.MaxPoolSize / 10
+		stemC.FetchUtxoView = func(tx *hcutil.Tx, treeValid bool) (*blockchain.UtxoViewpoint, error) {
+			return s.txMemPool.FetchInputUtxos(tx)
+		}
+		stemC.AddrIndex = nil
+		stemC.ExistsAddrIndex = nil
+		stemC.OnTxReplaced = nil
+		s.dandelion = newDandelion(&dandelionConfig{
+			StemPool:           mempool.New(&stemC),
+			HaveTransaction:    s.txMemPool.HaveTransaction,
+			IsRecentlyRejected: s.txMemPool.IsRecentlyRejected,
+			AcceptToMemPool: func(tx *hcutil.Tx, rateLimit, allowHighFees bool, tag mempool.Tag) error {
+				acceptedTxs, err := s.blockManager.ProcessTransaction(tx,
+					false, rateLimit, allowHighFees, tag)
+				if err != nil {
+					return err
+				}
+				s.AnnounceNewTransactions(acceptedTxs)
+				return nil
+			},
+			RelayCandidates: func() []stemRelay {
+				return stemRelayCandidates(&s)
+			},
+			Rebroadcast: func(tx *hcutil.Tx) {
+				iv := wire.NewInvVect(wire.InvTypeTx, tx.Hash())
+				s.AddRebroadcastInventory(iv, tx)
+			},
+		})
+	}
+
 	// Create the mining policy based on the configuration options.
 	// NOTE: The CPU miner relies on the mempool, so the mempool has to be
 	// created before calling the function to create the CPU miner.
@@ -2487,11 +3365,14 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 	// specified peers and actively avoid advertising and connecting to
 	// discovered peers in order to prevent it from becoming a public test
 	// network.
-	var newAddressFunc func() (net.Addr, error)
+	var newAddressFunc, feelerAddressFunc func() (net.Addr, error)
 	if !cfg.SimNet && len(cfg.ConnectPeers) == 0 {
-		newAddressFunc = func() (net.Addr, error) {
+		// selectAddress returns an address returned by getAddress which
+		// is suitable for a new outbound connection, or nil when none is
+		// found.
+		selectAddress := func(getAddress func() *addrmgr.KnownAddress) *addrmgr.KnownAddress {
 			for tries := 0; tries < 100; tries++ {
-				addr := s.addrManager.GetAddress()
+				addr := getAddress()
 				if addr == nil {
 					break
 				}
@@ -2502,7 +3383,7 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 				// in the same group so that we are not connecting
 				// to the same network segment at the expense of
 				// others.
-				key := addrmgr.GroupKey(addr.NetAddress())
+				key := s.addrManager.GroupKey(addr.NetAddress())
 				if s.OutboundGroupCount(key) != 0 {
 					continue
 				}
@@ -2519,27 +3400,78 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 					continue
 				}
 
-				addrString := addrmgr.NetAddressKey(addr.NetAddress())
-				return addrStringToNetAddr(addrString)
+				return addr
 			}
+			return nil
+		}
+		newAddressFunc = func() (net.Addr, error) {
+			addr := selectAddress(s.addrManager.GetAddress)
+			if addr == nil {
+				return nil, errors.New("no valid connect address")
+			}
+			addrString := addrmgr.NetAddressKey(addr.NetAddress())
+			return addrStringToNetAddr(addrString)
+		}
 
-			return nil, errors.New("no valid connect address")
+		// Feeler connections test addresses of the new table.  The
+		// attempt is recorded before connecting, so addresses which
+		// are unreachable are eventually considered bad.
+		feelerAddressFunc = func() (net.Addr, error) {
+			addr := selectAddress(s.addrManager.GetNewTableAddress)
+			if addr == nil {
+				return nil, errors.New("no valid feeler address")
+			}
+			s.addrManager.Attempt(addr.NetAddress())
+			atomic.AddUint64(&s.feelerAttempts, 1)
+			addrString := addrmgr.NetAddressKey(addr.NetAddress())
+			return addrStringToNetAddr(addrString)
 		}
 	}
 
-	// Create a connection manager.
+	// Load the anchors of the previous run which are connected to first
+	// by the block-relay-only connections.  Anchors are only used when
+	// addresses to connect to are chosen automatically.
+	var anchors []net.Addr
+	if newAddressFunc != nil && cfg.BlockRelayConns > 0 {
+		anchorAddrs, err := loadAnchors(anchorsFilePath())
+		if err != nil {
+			srvrLog.Warnf("Unable to load anchors: %v", err)
+		}
+		for _, addr := range anchorAddrs {
+			netAddr, err := addrStringToNetAddr(addr)
+			if err != nil {
+				srvrLog.Debugf("Ignoring anchor %s: %v", addr, err)
+				continue
+			}
+			anchors = append(anchors, netAddr)
+		}
+		if len(anchors) > 0 {
+			srvrLog.Infof("Loaded %d anchors", len(anchors))
+		}
+	}
+
+	// Create a connection manager.  The block-relay-only connections are
+	// made in addition to the full relay outbound connections.
 	targetOutbound := defaultTargetOutbound
 	if cfg.MaxPeers < targetOutbound {
 		targetOutbound = cfg.MaxPeers
 	}
+	targetBlockRelayOnly := cfg.BlockRelayConns
+	if cfg.MaxPeers-targetOutbound < targetBlockRelayOnly {
+		targetBlockRelayOnly = cfg.MaxPeers - targetOutbound
+	}
 	cmgr, err := connmgr.New(&connmgr.Config{
-		Listeners:      listeners,
-		OnAccept:       s.inboundPeerConnected,
-		RetryDuration:  connectionRetryInterval,
-		TargetOutbound: uint32(targetOutbound),
-		Dial:           hcdDial,
-		OnConnection:   s.outboundPeerConnected,
-		GetNewAddress:  newAddressFunc,
+		Listeners:            listeners,
+		OnAccept:             s.inboundPeerConnected,
+		RetryDuration:        connectionRetryInterval,
+		TargetOutbound:       uint32(targetOutbound),
+		TargetBlockRelayOnly: uint32(targetBlockRelayOnly),
+		Anchors:              anchors,
+		Dial:                 hcdDial,
+		OnConnection:         s.outboundPeerConnected,
+		GetNewAddress:        newAddressFunc,
+		FeelerInterval:       feelerInterval,
+		GetFeelerAddress:     feelerAddressFunc,
 	})
 	if err != nil {
 		return nil, err
@@ -2576,18 +3508,57 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 		}()
 	}
 
+	if len(cfg.StratumListeners) > 0 {
+		s.stratumServer, err = newStratumServer(cfg.StratumListeners,
+			&policy, &s)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &s, nil
 }
 
+// torV3HostLen is the length of the host name of a Tor v3 onion address, which
+// is made up of 56 base32 characters and the ".onion" suffix.
+const torV3HostLen = 56 + len(".onion")
+
+// onionAddr implements the net.Addr interface and represents a Tor v3 onion
+// address.  Unlike Tor v2 onion addresses, they can't be encoded as an IP
+// address, so they are passed to the onion proxy as is.
+type onionAddr struct {
+	addr string
+}
+
+// String returns the onion address in the form of 'host:port'.
+//
+// This is part of the net.Addr interface.
+func (oa *onionAddr) String() string {
+	return oa.addr
+}
+
+// Network returns "tcp" since onion addresses are only reachable over TCP.
+//
+// This is part of the net.Addr interface.
+func (oa *onionAddr) Network() string {
+	return "tcp"
+}
+
 // addrStringToNetAddr takes an address in the form of 'host:port' and returns
 // a net.Addr which maps to the original address with any host names resolved
-// to IP addresses.
+// to IP addresses.  Tor v3 onion addresses are not resolved.
 func addrStringToNetAddr(addr string) (net.Addr, error) {
 	host, strPort, err := net.SplitHostPort(addr)
 	if err != nil {
 		return nil, err
 	}
 
+	// Tor v3 onion addresses can't be resolved to an IP address, so they
+	// are dialed through the onion proxy by name.
+	if len(host) == torV3HostLen && strings.HasSuffix(host, ".onion") {
+		return &onionAddr{addr: addr}, nil
+	}
+
 	// Attempt to look up an IP address associated with the parsed host.
 	// The hcdLookup function will transparently handle performing the
 	// lookup over Tor if necessary.
@@ -2611,28 +3582,29 @@ func addrStringToNetAddr(addr string) (net.Addr, error) {
 }
 
 
-// isWhitelisted returns whether the IP address is included in the whitelisted
-// networks and IPs.
-func isWhitelisted(addr net.Addr) bool {
+// whitelistPermissions returns the permissions granted to the passed address by
+// the whitelisted networks and IPs which include it.
+func whitelistPermissions(addr net.Addr) permissionFlags {
 	if len(cfg.whitelists) == 0 {
-		return false
+		return 0
 	}
 
 	host, _, err := net.SplitHostPort(addr.String())
 	if err != nil {
 		srvrLog.Warnf("Unable to SplitHostPort on '%s': %v", addr, err)
-		return false
+		return 0
 	}
 	ip := net.ParseIP(host)
 	if ip == nil {
 		srvrLog.Warnf("Unable to parse IP '%s'", addr)
-		return false
+		return 0
 	}
 
-	for _, ipnet := range cfg.whitelists {
-		if ipnet.Contains(ip) {
-			return true
+	var perms permissionFlags
+	for _, wl := range cfg.whitelists {
+		if wl.ipnet.Contains(ip) {
+			perms |= wl.perms
 		}
 	}
-	return false
+	return perms
 }
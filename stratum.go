@@ -0,0 +1,898 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nbit99/hcd/blockchain"
+	"github.com/nbit99/hcd/chaincfg/chainhash"
+	"github.com/nbit99/hcd/hcutil"
+	"github.com/nbit99/hcd/mining"
+	"github.com/nbit99/hcd/wire"
+)
+
+// The Stratum server hands out work to miners as serialized block headers
+// split into the parts described below.  Miners fill in the timestamp, the
+// nonce and the 2-byte extra nonce 2 they search over while the server
+// assigns each connection a distinct 2-byte extra nonce 1 so no two miners
+// ever perform the same work.  Both extra nonces are stored in bytes 8 to 12
+// of the extra data of the header, which are the only ones the consensus rules
+// leave free.  The first four bytes are restricted to a small set of values
+// and all others must be zero, so they are left as in the block template:
+//
+//	header[0:4]     version (mining.notify)
+//	header[4:36]    previous block hash (mining.notify)
+//	header[36:152]  generation part 1, including the bits, timestamp, nonce
+//	                and extra data 0 to 8 (mining.notify)
+//	header[136:140] timestamp (mining.submit)
+//	header[140:144] nonce (mining.submit)
+//	header[152:154] extra nonce 1 (mining.subscribe)
+//	header[154:156] extra nonce 2 (mining.submit)
+//	header[156:180] generation part 2, including the zero extra data 12 to
+//	                32 (mining.notify)
+//
+// All values are hex encoded in the byte order of the serialized header.
+const (
+	stratumVersionOffset     = 0
+	stratumPrevBlockOffset   = 4
+	stratumGenTx1Offset      = 36
+	stratumBitsOffset        = 116
+	stratumTimestampOffset   = 136
+	stratumNonceOffset       = 140
+	stratumExtraNonce1Offset = 152
+	stratumExtraNonce2Offset = 154
+	stratumGenTx2Offset      = 156
+
+	// stratumExtraNonce1Size and stratumExtraNonce2Size are the sizes of the
+	// extra nonce assigned to a connection and the one searched by the
+	// miner.
+	stratumExtraNonce1Size = 2
+	stratumExtraNonce2Size = 2
+
+	// stratumShareKeySize is the size of the extra nonces, timestamp and
+	// nonce which identify a share.
+	stratumShareKeySize = stratumExtraNonce1Size + stratumExtraNonce2Size + 8
+)
+
+const (
+	// stratumWorkCheckInterval is the interval at which the best block and
+	// the votes for it are checked for whether new work has to be pushed
+	// to the miners.
+	stratumWorkCheckInterval = time.Second
+
+	// stratumWorkRefreshInterval is the minimum interval between two jobs
+	// for the same block which are pushed to include new transactions from
+	// the memory pool.
+	stratumWorkRefreshInterval = time.Second * 30
+
+	// stratumMaxJobs is the maximum number of jobs building on the same
+	// block for which shares are accepted.
+	stratumMaxJobs = 8
+
+	// stratumMaxClients is the maximum number of simultaneous Stratum
+	// connections.
+	stratumMaxClients = 128
+
+	// stratumMaxMessageSize is the maximum size of a message from a miner.
+	stratumMaxMessageSize = 4096
+
+	// stratumIdleTimeout is the duration after which miners which did not
+	// send any message are disconnected.
+	stratumIdleTimeout = time.Minute * 15
+
+	// stratumWriteTimeout is the maximum duration of sending a message to a
+	// miner.
+	stratumWriteTimeout = time.Second * 10
+
+	// stratumShareInterval is the average interval between shares from a
+	// worker the difficulty of its shares is adjusted to.
+	stratumShareInterval = time.Second * 10
+
+	// stratumRetargetInterval is the minimum interval between two
+	// adjustments of the share difficulty of a worker.
+	stratumRetargetInterval = time.Minute * 2
+
+	// stratumMaxRetargetFactor is the maximum factor the share difficulty
+	// of a worker is changed by in a single adjustment.
+	stratumMaxRetargetFactor = 4.0
+
+	// stratumMinDifficulty is the lowest share difficulty.  It corresponds
+	// to the proof of work limit of the network.
+	stratumMinDifficulty = 1.0
+)
+
+// Stratum error codes.
+const (
+	stratumErrOther          = 20
+	stratumErrJobNotFound    = 21
+	stratumErrDuplicateShare = 22
+	stratumErrLowDifficulty  = 23
+	stratumErrUnauthorized   = 24
+	stratumErrNotSubscribed  = 25
+)
+
+// stratumRequest is a request or notification sent by a miner.
+type stratumRequest struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// stratumResponse is the response to a request of a miner.
+type stratumResponse struct {
+	ID     json.RawMessage `json:"id"`
+	Result interface{}     `json:"result"`
+	Error  *stratumError   `json:"error"`
+}
+
+// stratumNotification is a notification sent to a miner.
+type stratumNotification struct {
+	ID     interface{}   `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+// stratumError is an error returned to a miner.  It is encoded as an array of
+// the error code, the error message and a traceback which is always null.
+type stratumError struct {
+	Code    int
+	Message string
+}
+
+// MarshalJSON encodes the error in the format expected by miners.
+func (e *stratumError) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.Code, e.Message, nil})
+}
+
+// stratumJob is a unit of work handed out to the miners.
+type stratumJob struct {
+	id       string
+	msgBlock *wire.MsgBlock
+	header   [wire.MaxBlockHeaderPayload]byte
+	notify   []interface{}
+
+	// shares holds the extra nonces, timestamps and nonces of the shares
+	// submitted for the job so duplicate shares can be rejected.
+	shares map[[stratumShareKeySize]byte]struct{}
+}
+
+// newStratumJob returns a new job for the passed block template.
+func newStratumJob(id string, msgBlock *wire.MsgBlock) (*stratumJob, error) {
+	job := &stratumJob{
+		id:       id,
+		msgBlock: msgBlock,
+		shares:   make(map[[stratumShareKeySize]byte]struct{}),
+	}
+	buf := bytes.NewBuffer(job.header[:0])
+	if err := msgBlock.Header.Serialize(buf); err != nil {
+		return nil, err
+	}
+
+	h := job.header[:]
+	job.notify = []interface{}{
+		id,
+		hex.EncodeToString(h[stratumPrevBlockOffset:stratumGenTx1Offset]),
+		hex.EncodeToString(h[stratumGenTx1Offset:stratumExtraNonce1Offset]),
+		hex.EncodeToString(h[stratumGenTx2Offset:]),
+		[]string{},
+		hex.EncodeToString(h[stratumVersionOffset:stratumPrevBlockOffset]),
+		hex.EncodeToString(h[stratumBitsOffset : stratumBitsOffset+4]),
+		hex.EncodeToString(h[stratumTimestampOffset:stratumNonceOffset]),
+	}
+	return job, nil
+}
+
+// solvedHeader returns the block header of the job solved with the passed
+// extra nonces, timestamp and nonce.
+func (j *stratumJob) solvedHeader(extraNonce1, extraNonce2, timestamp, nonce []byte) (*wire.BlockHeader, error) {
+	header := j.header
+	copy(header[stratumTimestampOffset:], timestamp)
+	copy(header[stratumNonceOffset:], nonce)
+	copy(header[stratumExtraNonce1Offset:], extraNonce1)
+	copy(header[stratumExtraNonce2Offset:], extraNonce2)
+
+	var solved wire.BlockHeader
+	if err := solved.Deserialize(bytes.NewReader(header[:])); err != nil {
+		return nil, err
+	}
+	return &solved, nil
+}
+
+// stratumShareTarget returns the target the hash of a share with the passed
+// difficulty must not exceed.  A difficulty of one corresponds to the proof of
+// work limit of the network and the target is never lower than the target of
+// the network.
+func stratumShareTarget(difficulty float64, powLimit, netTarget *big.Int) *big.Int {
+	target := new(big.Rat).SetInt(powLimit)
+	target.Quo(target, new(big.Rat).SetFloat64(difficulty))
+	shareTarget := new(big.Int).Quo(target.Num(), target.Denom())
+	if shareTarget.Cmp(netTarget) < 0 {
+		shareTarget.Set(netTarget)
+	}
+	if shareTarget.Cmp(powLimit) > 0 {
+		shareTarget.Set(powLimit)
+	}
+	return shareTarget
+}
+
+// stratumRetargetDifficulty returns the share difficulty for a worker which
+// submitted the passed number of shares at the passed difficulty over the
+// passed duration.  The difficulty is only changed when the worker submits
+// shares at less than half or more than twice the desired rate in order to
+// avoid needless changes.
+func stratumRetargetDifficulty(difficulty float64, shares int, elapsed time.Duration) float64 {
+	factor := float64(shares) * float64(stratumShareInterval) /
+		float64(elapsed)
+	if factor > 0.5 && factor < 2 {
+		return difficulty
+	}
+	if factor < 1/stratumMaxRetargetFactor {
+		factor = 1 / stratumMaxRetargetFactor
+	}
+	if factor > stratumMaxRetargetFactor {
+		factor = stratumMaxRetargetFactor
+	}
+
+	difficulty *= factor
+	if difficulty < stratumMinDifficulty {
+		difficulty = stratumMinDifficulty
+	}
+	return difficulty
+}
+
+// stratumClient houses the state of a miner connected to the Stratum server.
+type stratumClient struct {
+	server      *stratumServer
+	conn        net.Conn
+	addr        string
+	extraNonce1 [stratumExtraNonce1Size]byte
+
+	writeMtx sync.Mutex
+
+	// The following fields are protected by the mutex.
+	mtx            sync.Mutex
+	subscribed     bool
+	authorized     bool
+	worker         string
+	difficulty     float64
+	sentDifficulty float64
+	jobDifficulty  map[string]float64
+	windowStart    time.Time
+	windowShares   int
+}
+
+// send writes the passed message to the miner.  The connection is closed when
+// the message can't be written.
+func (c *stratumClient) send(msg interface{}) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		minrLog.Errorf("Failed to marshal Stratum message: %v", err)
+		return
+	}
+	b = append(b, '\n')
+
+	c.writeMtx.Lock()
+	defer c.writeMtx.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(stratumWriteTimeout))
+	if _, err := c.conn.Write(b); err != nil {
+		minrLog.Debugf("Failed to write to Stratum client %s: %v",
+			c.addr, err)
+		c.conn.Close()
+	}
+}
+
+// notifyJob sends the passed job to the miner once it subscribed and a worker
+// was authorized.  The share difficulty of the worker is adjusted and sent
+// beforehand when needed.
+func (c *stratumClient) notifyJob(job *stratumJob, cleanJobs bool) {
+	c.mtx.Lock()
+	if !c.subscribed || !c.authorized {
+		c.mtx.Unlock()
+		return
+	}
+
+	now := time.Now()
+	if elapsed := now.Sub(c.windowStart); elapsed >= stratumRetargetInterval {
+		difficulty := stratumRetargetDifficulty(c.difficulty,
+			c.windowShares, elapsed)
+		if difficulty != c.difficulty {
+			minrLog.Debugf("Adjusting share difficulty of Stratum "+
+				"worker %s (%s) from %g to %g", c.worker, c.addr,
+				c.difficulty, difficulty)
+			c.difficulty = difficulty
+		}
+		c.windowStart = now
+		c.windowShares = 0
+	}
+	if cleanJobs {
+		c.jobDifficulty = make(map[string]float64)
+	}
+	c.jobDifficulty[job.id] = c.difficulty
+	difficulty := c.difficulty
+	sendDifficulty := c.difficulty != c.sentDifficulty
+	c.sentDifficulty = c.difficulty
+	c.mtx.Unlock()
+
+	if sendDifficulty {
+		c.send(&stratumNotification{
+			Method: "mining.set_difficulty",
+			Params: []interface{}{difficulty},
+		})
+	}
+	params := make([]interface{}, 0, len(job.notify)+1)
+	params = append(params, job.notify...)
+	params = append(params, cleanJobs)
+	c.send(&stratumNotification{
+		Method: "mining.notify",
+		Params: params,
+	})
+}
+
+// handleSubscribe handles the mining.subscribe request.
+func (c *stratumClient) handleSubscribe(params []json.RawMessage) (interface{}, *stratumError) {
+	c.mtx.Lock()
+	c.subscribed = true
+	c.mtx.Unlock()
+
+	subscriptionID := hex.EncodeToString(c.extraNonce1[:])
+	subscriptions := [][]string{
+		{"mining.set_difficulty", subscriptionID},
+		{"mining.notify", subscriptionID},
+	}
+	return []interface{}{subscriptions, subscriptionID,
+		stratumExtraNonce2Size}, nil
+}
+
+// handleAuthorize handles the mining.authorize request.  Any worker is
+// authorized.
+func (c *stratumClient) handleAuthorize(params []json.RawMessage) (interface{}, *stratumError) {
+	var worker string
+	if len(params) < 1 || json.Unmarshal(params[0], &worker) != nil {
+		return nil, &stratumError{stratumErrOther, "invalid worker name"}
+	}
+
+	c.mtx.Lock()
+	if !c.subscribed {
+		c.mtx.Unlock()
+		return nil, &stratumError{stratumErrNotSubscribed,
+			"not subscribed"}
+	}
+	c.authorized = true
+	c.worker = worker
+	c.windowStart = time.Now()
+	c.mtx.Unlock()
+
+	minrLog.Infof("Stratum worker %s authorized from %s", worker, c.addr)
+	return true, nil
+}
+
+// handleSuggestDifficulty handles the mining.suggest_difficulty notification.
+// The suggested difficulty applies to the next job.
+func (c *stratumClient) handleSuggestDifficulty(params []json.RawMessage) (interface{}, *stratumError) {
+	var difficulty float64
+	if len(params) < 1 || json.Unmarshal(params[0], &difficulty) != nil {
+		return nil, &stratumError{stratumErrOther, "invalid difficulty"}
+	}
+	if difficulty < stratumMinDifficulty {
+		difficulty = stratumMinDifficulty
+	}
+
+	c.mtx.Lock()
+	c.difficulty = difficulty
+	c.windowStart = time.Now()
+	c.windowShares = 0
+	c.mtx.Unlock()
+	return true, nil
+}
+
+// handleSubmit handles the mining.submit request.  Shares which meet the
+// target of the network are submitted as blocks.
+func (c *stratumClient) handleSubmit(params []json.RawMessage) (interface{}, *stratumError) {
+	var args [5]string
+	if len(params) < len(args) {
+		return nil, &stratumError{stratumErrOther, "missing parameters"}
+	}
+	for i := range args {
+		if err := json.Unmarshal(params[i], &args[i]); err != nil {
+			return nil, &stratumError{stratumErrOther,
+				"invalid parameters"}
+		}
+	}
+	jobID := args[1]
+	var values [3][]byte
+	sizes := [3]int{stratumExtraNonce2Size, 4, 4}
+	for i, s := range args[2:] {
+		b, err := hex.DecodeString(s)
+		if err != nil || len(b) != sizes[i] {
+			return nil, &stratumError{stratumErrOther,
+				"invalid parameters"}
+		}
+		values[i] = b
+	}
+	extraNonce2, timestamp, nonce := values[0], values[1], values[2]
+
+	c.mtx.Lock()
+	authorized := c.authorized
+	worker := c.worker
+	difficulty, ok := c.jobDifficulty[jobID]
+	c.mtx.Unlock()
+	if !authorized {
+		return nil, &stratumError{stratumErrUnauthorized,
+			"unauthorized worker"}
+	}
+	job := c.server.job(jobID)
+	if !ok || job == nil {
+		return nil, &stratumError{stratumErrJobNotFound, "job not found"}
+	}
+
+	header, err := job.solvedHeader(c.extraNonce1[:], extraNonce2,
+		timestamp, nonce)
+	if err != nil {
+		return nil, &stratumError{stratumErrOther,
+			"invalid solution"}
+	}
+	if !blockchain.CheckExtraDataBuf(header.ExtraData[:]) {
+		return nil, &stratumError{stratumErrOther,
+			"invalid extra nonce"}
+	}
+	maxTimestamp := time.Now().Add(blockchain.MaxTimeOffsetSeconds *
+		time.Second)
+	if header.Timestamp.Before(job.msgBlock.Header.Timestamp) ||
+		header.Timestamp.After(maxTimestamp) {
+
+		return nil, &stratumError{stratumErrOther,
+			"timestamp out of range"}
+	}
+
+	hash := header.BlockHash()
+	hashNum := blockchain.HashToBig(&hash)
+	netTarget := blockchain.CompactToBig(header.Bits)
+	shareTarget := stratumShareTarget(difficulty, activeNetParams.PowLimit,
+		netTarget)
+	if hashNum.Cmp(shareTarget) > 0 {
+		return nil, &stratumError{stratumErrLowDifficulty,
+			"low difficulty share"}
+	}
+
+	// Reject shares which were already submitted.  Only shares which meet
+	// the target are recorded so a client which does no work can not grow
+	// the recorded shares of the job.
+	var key [stratumShareKeySize]byte
+	copy(key[:], c.extraNonce1[:])
+	copy(key[stratumExtraNonce1Size:], extraNonce2)
+	copy(key[stratumExtraNonce1Size+stratumExtraNonce2Size:], timestamp)
+	copy(key[stratumShareKeySize-4:], nonce)
+	if !c.server.addShare(job, key) {
+		return nil, &stratumError{stratumErrDuplicateShare,
+			"duplicate share"}
+	}
+
+	c.mtx.Lock()
+	c.windowShares++
+	c.mtx.Unlock()
+	minrLog.Tracef("Accepted share %v from Stratum worker %s (%s)", hash,
+		worker, c.addr)
+
+	if hashNum.Cmp(netTarget) <= 0 {
+		c.server.submitBlock(job, header, worker)
+	}
+	return true, nil
+}
+
+// handleRequest handles the passed request from the miner and sends the
+// response.
+func (c *stratumClient) handleRequest(req *stratumRequest) {
+	var result interface{}
+	var rerr *stratumError
+	switch req.Method {
+	case "mining.subscribe":
+		result, rerr = c.handleSubscribe(req.Params)
+	case "mining.authorize":
+		result, rerr = c.handleAuthorize(req.Params)
+	case "mining.suggest_difficulty":
+		result, rerr = c.handleSuggestDifficulty(req.Params)
+	case "mining.submit":
+		result, rerr = c.handleSubmit(req.Params)
+	default:
+		rerr = &stratumError{stratumErrOther, "unknown method"}
+	}
+	if rerr != nil {
+		minrLog.Debugf("Stratum request %s from %s failed: %s",
+			req.Method, c.addr, rerr.Message)
+	}
+
+	// Requests without an id are notifications which are not responded
+	// to.
+	if len(req.ID) == 0 || bytes.Equal(req.ID, []byte("null")) {
+		return
+	}
+	c.send(&stratumResponse{ID: req.ID, Result: result, Error: rerr})
+
+	// Hand out work right away once a worker was authorized.
+	if req.Method == "mining.authorize" && rerr == nil {
+		if job := c.server.currentJob(); job != nil {
+			c.notifyJob(job, true)
+		}
+	}
+}
+
+// stratumServer provides a Stratum mining server which hands out work built
+// from block templates to miners, pushes new work whenever the best block or
+// the votes for it change and submits the blocks solved by the miners to the
+// network.
+type stratumServer struct {
+	started  int32
+	shutdown int32
+
+	policy    *mining.Policy
+	server    *server
+	listeners []net.Listener
+
+	// The following fields are protected by the mutex.
+	mtx             sync.Mutex
+	clients         map[*stratumClient]struct{}
+	jobs            map[string]*stratumJob
+	jobOrder        []string
+	nextJobID       uint64
+	nextExtraNonce1 uint16
+
+	// The following fields are only accessed by the work handler.
+	bestHash     chainhash.Hash
+	numVotes     int
+	lastTxUpdate time.Time
+	lastJob      time.Time
+
+	wg   sync.WaitGroup
+	quit chan struct{}
+}
+
+// job returns the job with the passed id or nil if it is unknown or stale.
+//
+// This function is safe for concurrent access.
+func (s *stratumServer) job(id string) *stratumJob {
+	s.mtx.Lock()
+	job := s.jobs[id]
+	s.mtx.Unlock()
+	return job
+}
+
+// currentJob returns the most recent job or nil if no work was generated yet.
+//
+// This function is safe for concurrent access.
+func (s *stratumServer) currentJob() *stratumJob {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if len(s.jobOrder) == 0 {
+		return nil
+	}
+	return s.jobs[s.jobOrder[len(s.jobOrder)-1]]
+}
+
+// addShare records the passed share of the job and returns whether or not it
+// was not already submitted.
+//
+// This function is safe for concurrent access.
+func (s *stratumServer) addShare(job *stratumJob, key [stratumShareKeySize]byte) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if _, ok := job.shares[key]; ok {
+		return false
+	}
+	job.shares[key] = struct{}{}
+	return true
+}
+
+// submitBlock submits the block of the passed job solved by the passed header
+// to the network.
+func (s *stratumServer) submitBlock(job *stratumJob, header *wire.BlockHeader, worker string) {
+	msgBlock := *job.msgBlock
+	msgBlock.Header = *header
+	block := hcutil.NewBlockDeepCopyCoinbase(&msgBlock)
+
+	// Process this block using the same rules as blocks coming from other
+	// nodes.  This will in turn relay it to the network like normal.
+	isOrphan, err := s.server.blockManager.ProcessBlock(block,
+		blockchain.BFNone)
+	if err != nil {
+		if _, ok := err.(blockchain.RuleError); !ok {
+			minrLog.Errorf("Unexpected error while processing block "+
+				"submitted by Stratum worker %s: %v", worker, err)
+			return
+		}
+		minrLog.Infof("Block submitted by Stratum worker %s rejected: %v",
+			worker, err)
+		return
+	}
+	if isOrphan {
+		minrLog.Infof("Block submitted by Stratum worker %s is an orphan",
+			worker)
+		return
+	}
+
+	minrLog.Infof("Block submitted by Stratum worker %s accepted: %v "+
+		"(height %d)", worker, block.Hash(), header.Height)
+}
+
+// canGenerateWork returns whether or not work can currently be handed out to
+// miners.  There is no point in mining before the chain is synced or without
+// peers to relay the solved blocks to.  However, the latter is allowed when
+// running on the simulation test network.
+func (s *stratumServer) canGenerateWork() bool {
+	if !cfg.SimNet && s.server.ConnectedCount() == 0 {
+		return false
+	}
+	_, height := s.server.blockManager.chainState.Best()
+	return height == 0 || s.server.blockManager.IsCurrent()
+}
+
+// updateWork generates a new job and pushes it to all miners when the best
+// block or the number of votes for it changed, or when the memory pool was
+// updated and the last job is old enough.
+func (s *stratumServer) updateWork() {
+	if !s.canGenerateWork() {
+		return
+	}
+
+	bestHash, _ := s.server.blockManager.chainState.Best()
+	numVotes := len(s.server.txMemPool.VoteHashesForBlock(*bestHash))
+	lastTxUpdate := s.server.txMemPool.LastUpdated()
+	newBlock := *bestHash != s.bestHash || numVotes != s.numVotes
+	if !newBlock && (lastTxUpdate == s.lastTxUpdate ||
+		time.Since(s.lastJob) < stratumWorkRefreshInterval) {
+
+		return
+	}
+
+	payToAddr := cfg.miningAddrs[rand.Intn(len(cfg.miningAddrs))]
+	template, err := NewBlockTemplate(s.policy, s.server, payToAddr)
+	if err != nil {
+		minrLog.Errorf("Failed to create new block template for Stratum "+
+			"work: %v", err)
+		return
+	}
+	if template == nil {
+		// This happens if there are not enough voters on the best block
+		// and there is no suitable parent template to build from.
+		minrLog.Debugf("Not enough voters to create Stratum work")
+		return
+	}
+	msgBlock := deepCopyBlockTemplate(template).Block
+
+	s.mtx.Lock()
+	s.nextJobID++
+	job, err := newStratumJob(strconv.FormatUint(s.nextJobID, 16), msgBlock)
+	if err != nil {
+		s.mtx.Unlock()
+		minrLog.Errorf("Failed to create Stratum job: %v", err)
+		return
+	}
+
+	// Shares for jobs which build on a different block are stale, so
+	// forget all jobs when the template builds on a new block.
+	cleanJobs := true
+	if len(s.jobOrder) != 0 {
+		lastJob := s.jobs[s.jobOrder[len(s.jobOrder)-1]]
+		cleanJobs = lastJob.msgBlock.Header.PrevBlock !=
+			msgBlock.Header.PrevBlock
+	}
+	if cleanJobs {
+		s.jobs = make(map[string]*stratumJob)
+		s.jobOrder = s.jobOrder[:0]
+	}
+	if len(s.jobOrder) == stratumMaxJobs {
+		delete(s.jobs, s.jobOrder[0])
+		s.jobOrder = append(s.jobOrder[:0], s.jobOrder[1:]...)
+	}
+	s.jobs[job.id] = job
+	s.jobOrder = append(s.jobOrder, job.id)
+	clients := make([]*stratumClient, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mtx.Unlock()
+
+	s.bestHash = *bestHash
+	s.numVotes = numVotes
+	s.lastTxUpdate = lastTxUpdate
+	s.lastJob = time.Now()
+
+	minrLog.Debugf("New Stratum job %s (height %d, %d votes, %d "+
+		"transactions, clean %v)", job.id, msgBlock.Header.Height,
+		msgBlock.Header.Voters, len(msgBlock.Transactions), cleanJobs)
+	for _, c := range clients {
+		c.notifyJob(job, cleanJobs)
+	}
+}
+
+// workHandler periodically checks whether new work has to be pushed to the
+// miners.  It must be run as a goroutine.
+func (s *stratumServer) workHandler() {
+	ticker := time.NewTicker(stratumWorkCheckInterval)
+	defer ticker.Stop()
+
+out:
+	for {
+		select {
+		case <-ticker.C:
+			s.updateWork()
+
+		case <-s.quit:
+			break out
+		}
+	}
+
+	s.wg.Done()
+	minrLog.Tracef("Stratum work handler done")
+}
+
+// handleClient reads and handles the requests of a newly connected miner
+// until it disconnects.  It must be run as a goroutine.
+func (s *stratumServer) handleClient(conn net.Conn) {
+	defer s.wg.Done()
+	defer conn.Close()
+
+	c := &stratumClient{
+		server:        s,
+		conn:          conn,
+		addr:          conn.RemoteAddr().String(),
+		difficulty:    cfg.StratumDifficulty,
+		jobDifficulty: make(map[string]float64),
+	}
+	s.mtx.Lock()
+	if len(s.clients) >= stratumMaxClients {
+		s.mtx.Unlock()
+		minrLog.Warnf("Max Stratum clients exceeded [%d] - "+
+			"disconnecting client %s", stratumMaxClients, c.addr)
+		return
+	}
+	binary.LittleEndian.PutUint16(c.extraNonce1[:], s.nextExtraNonce1)
+	s.nextExtraNonce1++
+	s.clients[c] = struct{}{}
+	s.mtx.Unlock()
+	minrLog.Debugf("New Stratum client %s", c.addr)
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 512), stratumMaxMessageSize)
+	for {
+		conn.SetReadDeadline(time.Now().Add(stratumIdleTimeout))
+		if !scanner.Scan() {
+			break
+		}
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var req stratumRequest
+		if err := json.Unmarshal(line, &req); err != nil {
+			minrLog.Debugf("Malformed message from Stratum client "+
+				"%s: %v", c.addr, err)
+			break
+		}
+		c.handleRequest(&req)
+	}
+
+	s.mtx.Lock()
+	delete(s.clients, c)
+	s.mtx.Unlock()
+	minrLog.Debugf("Stratum client %s disconnected", c.addr)
+}
+
+// listenHandler accepts connections from miners on the passed listener.  It
+// must be run as a goroutine.
+func (s *stratumServer) listenHandler(listener net.Listener) {
+	minrLog.Infof("Stratum server listening on %s", listener.Addr())
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			// Only log the error if not forcibly shutting down.
+			if atomic.LoadInt32(&s.shutdown) == 0 {
+				minrLog.Errorf("Can't accept Stratum connection: "+
+					"%v", err)
+			}
+			break
+		}
+		s.wg.Add(1)
+		go s.handleClient(conn)
+	}
+	s.wg.Done()
+	minrLog.Tracef("Stratum listener done for %s", listener.Addr())
+}
+
+// Start begins accepting connections from miners and handing out work.
+func (s *stratumServer) Start() {
+	if atomic.AddInt32(&s.started, 1) != 1 {
+		return
+	}
+
+	minrLog.Trace("Starting Stratum server")
+	for _, listener := range s.listeners {
+		s.wg.Add(1)
+		go s.listenHandler(listener)
+	}
+	s.wg.Add(1)
+	go s.workHandler()
+}
+
+// Stop disconnects all miners and shuts down the Stratum server.
+func (s *stratumServer) Stop() error {
+	if atomic.AddInt32(&s.shutdown, 1) != 1 {
+		minrLog.Infof("Stratum server is already in the process of " +
+			"shutting down")
+		return nil
+	}
+
+	minrLog.Warnf("Stratum server shutting down")
+	var closeErr error
+	for _, listener := range s.listeners {
+		if err := listener.Close(); err != nil {
+			minrLog.Errorf("Problem shutting down Stratum server: %v",
+				err)
+			closeErr = err
+		}
+	}
+	s.mtx.Lock()
+	for c := range s.clients {
+		c.conn.Close()
+	}
+	s.mtx.Unlock()
+	close(s.quit)
+	s.wg.Wait()
+	minrLog.Infof("Stratum server shutdown complete")
+	return closeErr
+}
+
+// newStratumServer returns a new Stratum server listening on the passed
+// addresses.
+func newStratumServer(listenAddrs []string, policy *mining.Policy, s *server) (*stratumServer, error) {
+	ipv4ListenAddrs, ipv6ListenAddrs, _, err := parseListeners(listenAddrs)
+	if err != nil {
+		return nil, err
+	}
+	listeners := make([]net.Listener, 0,
+		len(ipv6ListenAddrs)+len(ipv4ListenAddrs))
+	for _, addr := range ipv4ListenAddrs {
+		listener, err := net.Listen("tcp4", addr)
+		if err != nil {
+			minrLog.Warnf("Can't listen on %s: %v", addr, err)
+			continue
+		}
+		listeners = append(listeners, listener)
+	}
+	for _, addr := range ipv6ListenAddrs {
+		listener, err := net.Listen("tcp6", addr)
+		if err != nil {
+			minrLog.Warnf("Can't listen on %s: %v", addr, err)
+			continue
+		}
+		listeners = append(listeners, listener)
+	}
+	if len(listeners) == 0 {
+		return nil, errors.New("no valid Stratum listen address")
+	}
+
+	return &stratumServer{
+		policy:          policy,
+		server:          s,
+		listeners:       listeners,
+		clients:         make(map[*stratumClient]struct{}),
+		jobs:            make(map[string]*stratumJob),
+		nextExtraNonce1: uint16(rand.Uint32()),
+		quit:            make(chan struct{}),
+	}, nil
+}
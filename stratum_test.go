@@ -0,0 +1,204 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/nbit99/hcd/blockchain"
+	"github.com/nbit99/hcd/chaincfg/chainhash"
+	"github.com/nbit99/hcd/wire"
+)
+
+// TestStratumJob ensures the header parts sent to miners in job notifications
+// together with the values submitted by them assemble to the solved header.
+func TestStratumJob(t *testing.T) {
+	msgBlock := &wire.MsgBlock{
+		Header: wire.BlockHeader{
+			Version:      5,
+			PrevBlock:    chainhash.Hash{0x01, 0x02},
+			MerkleRoot:   chainhash.Hash{0x03, 0x04},
+			StakeRoot:    chainhash.Hash{0x05, 0x06},
+			VoteBits:     1,
+			Voters:       5,
+			FreshStake:   2,
+			PoolSize:     40960,
+			Bits:         0x1b01ffff,
+			SBits:        2e8,
+			Height:       123456,
+			Size:         12345,
+			Timestamp:    time.Unix(1500000000, 0),
+			StakeVersion: 4,
+		},
+	}
+	job, err := newStratumJob("1f", msgBlock)
+	if err != nil {
+		t.Fatalf("newStratumJob: unexpected error: %v", err)
+	}
+	if len(job.notify) != 8 || job.notify[0] != "1f" {
+		t.Fatalf("newStratumJob: unexpected notify params %v", job.notify)
+	}
+
+	// Every extra nonce 2 a miner can search over must result in a header
+	// with valid extra data.
+	extraNonce1 := []byte{0x11, 0x12}
+	timestamp := []byte{0x01, 0x2f, 0x68, 0x59}
+	nonce := []byte{0x21, 0x22, 0x23, 0x24}
+	want := msgBlock.Header
+	want.Timestamp = time.Unix(0x59682f01, 0)
+	want.Nonce = 0x24232221
+	copy(want.ExtraData[8:], extraNonce1)
+	extraNonce2 := make([]byte, stratumExtraNonce2Size)
+	for i := 0; i <= 0xffff; i++ {
+		binary.LittleEndian.PutUint16(extraNonce2, uint16(i))
+		solved, err := job.solvedHeader(extraNonce1, extraNonce2,
+			timestamp, nonce)
+		if err != nil {
+			t.Fatalf("solvedHeader: unexpected error: %v", err)
+		}
+		copy(want.ExtraData[10:], extraNonce2)
+		if *solved != want {
+			t.Fatalf("solvedHeader: got %+v, want %+v", solved, want)
+		}
+		if !blockchain.CheckExtraDataBuf(solved.ExtraData[:]) {
+			t.Fatalf("solvedHeader: invalid extra data %x for extra "+
+				"nonce 2 %x", solved.ExtraData, extraNonce2)
+		}
+	}
+
+	// Assemble the header the way miners do.
+	part := func(i int) []byte {
+		b, err := hex.DecodeString(job.notify[i].(string))
+		if err != nil {
+			t.Fatalf("notify param %d: %v", i, err)
+		}
+		return b
+	}
+	var assembled []byte
+	assembled = append(assembled, part(5)...)
+	assembled = append(assembled, part(1)...)
+	assembled = append(assembled, part(2)...)
+	copy(assembled[stratumTimestampOffset:], timestamp)
+	copy(assembled[stratumNonceOffset:], nonce)
+	assembled = append(assembled, extraNonce1...)
+	assembled = append(assembled, extraNonce2...)
+	assembled = append(assembled, part(3)...)
+
+	var buf bytes.Buffer
+	if err := want.Serialize(&buf); err != nil {
+		t.Fatalf("Serialize: unexpected error: %v", err)
+	}
+	if !bytes.Equal(assembled, buf.Bytes()) {
+		t.Fatalf("assembled header %x, want %x", assembled, buf.Bytes())
+	}
+	if !bytes.Equal(part(6), buf.Bytes()[stratumBitsOffset:][:4]) {
+		t.Fatalf("notify bits %x do not match the header", part(6))
+	}
+}
+
+// TestStratumShareTarget ensures share targets are derived from the proof of
+// work limit and stay between the target of the network and the limit.
+func TestStratumShareTarget(t *testing.T) {
+	powLimit := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 224),
+		big.NewInt(1))
+	netTarget := new(big.Int).Lsh(big.NewInt(1), 200)
+
+	tests := []struct {
+		difficulty float64
+		want       *big.Int
+	}{
+		{1, powLimit},
+		{2, new(big.Int).Rsh(powLimit, 1)},
+		{1 << 20, new(big.Int).Rsh(powLimit, 20)},
+		{1 << 30, netTarget},
+	}
+	for _, test := range tests {
+		got := stratumShareTarget(test.difficulty, powLimit, netTarget)
+		if got.Cmp(test.want) != 0 {
+			t.Errorf("stratumShareTarget(%v): got %064x, want %064x",
+				test.difficulty, got, test.want)
+		}
+	}
+}
+
+// TestStratumRetargetDifficulty ensures the share difficulty of workers is
+// adjusted towards the desired share interval within the allowed bounds.
+func TestStratumRetargetDifficulty(t *testing.T) {
+	elapsed := stratumShareInterval * 100
+	tests := []struct {
+		difficulty float64
+		shares     int
+		want       float64
+	}{
+		{8, 100, 8},
+		{8, 60, 8},
+		{8, 190, 8},
+		{8, 300, 24},
+		{8, 10000, 32},
+		{8, 25, 2},
+		{8, 0, 2},
+		{2, 0, stratumMinDifficulty},
+	}
+	for _, test := range tests {
+		got := stratumRetargetDifficulty(test.difficulty, test.shares,
+			elapsed)
+		if got != test.want {
+			t.Errorf("stratumRetargetDifficulty(%v, %d): got %v, "+
+				"want %v", test.difficulty, test.shares, got,
+				test.want)
+		}
+	}
+}
+
+// TestStratumSubmitLowDifficulty ensures shares which do not meet the share
+// target are rejected without being recorded, so a miner can not grow the
+// recorded shares of a job without doing the work.
+func TestStratumSubmitLowDifficulty(t *testing.T) {
+	msgBlock := &wire.MsgBlock{
+		Header: wire.BlockHeader{
+			Version:   5,
+			Bits:      0x1b01ffff,
+			Height:    123456,
+			Timestamp: time.Unix(1500000000, 0),
+		},
+	}
+	job, err := newStratumJob("1f", msgBlock)
+	if err != nil {
+		t.Fatalf("newStratumJob: unexpected error: %v", err)
+	}
+	s := &stratumServer{jobs: map[string]*stratumJob{"1f": job}}
+	c := &stratumClient{
+		server:        s,
+		extraNonce1:   [stratumExtraNonce1Size]byte{0x11, 0x12},
+		authorized:    true,
+		jobDifficulty: map[string]float64{"1f": 1e12},
+	}
+
+	for i := 0; i < 100; i++ {
+		var nonce [4]byte
+		binary.LittleEndian.PutUint32(nonce[:], uint32(i))
+		var params []json.RawMessage
+		for _, arg := range []string{"worker", "1f", "0000", "012f6859",
+			hex.EncodeToString(nonce[:])} {
+
+			param, _ := json.Marshal(arg)
+			params = append(params, param)
+		}
+		_, rerr := c.handleSubmit(params)
+		if rerr == nil || rerr.Code != stratumErrLowDifficulty {
+			t.Fatalf("handleSubmit: got error %v, want low "+
+				"difficulty share", rerr)
+		}
+	}
+	if len(job.shares) != 0 {
+		t.Fatalf("got %d recorded shares, want none", len(job.shares))
+	}
+}
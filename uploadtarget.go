@@ -0,0 +1,153 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// uploadTargetTimeframe is the duration of the cycles the upload target
+	// applies to.
+	uploadTargetTimeframe = 24 * time.Hour
+
+	// historicalBlockAge is the age of the blocks which are considered
+	// historical.  Historical blocks are no longer served to peers without
+	// the download permission once the upload target is reached.
+	historicalBlockAge = 7 * 24 * time.Hour
+)
+
+// uploadTarget keeps track of the bytes sent to peers during the current cycle
+// in order to stop serving historical blocks once the bytes sent approach the
+// target.  The target is only a soft limit since new blocks and transactions
+// are still relayed once it is reached.
+type uploadTarget struct {
+	mtx sync.Mutex
+
+	// target is the number of bytes which may be sent per cycle.  The
+	// upload target is disabled when it is zero.
+	target uint64
+
+	// buffer is the number of bytes of the target which are reserved for
+	// relaying new blocks.  Historical blocks are no longer served once
+	// fewer bytes are left in the cycle.
+	buffer uint64
+
+	cycleStart time.Time
+	cycleBytes uint64
+}
+
+// newUploadTarget returns a new upload target which allows the passed number of
+// bytes to be sent per cycle while reserving the passed buffer for relaying new
+// blocks.
+func newUploadTarget(target, buffer uint64) *uploadTarget {
+	return &uploadTarget{
+		target: target,
+		buffer: buffer,
+	}
+}
+
+// maybeStartCycle starts a new cycle when the current one ended.
+//
+// This function MUST be called with the mutex held (for writes).
+func (u *uploadTarget) maybeStartCycle(now time.Time) {
+	if now.Sub(u.cycleStart) >= uploadTargetTimeframe {
+		u.cycleStart = now
+		u.cycleBytes = 0
+	}
+}
+
+// addBytesSent adds the passed number of bytes sent to the current cycle.
+//
+// This function is safe for concurrent access.
+func (u *uploadTarget) addBytesSent(n uint64, now time.Time) {
+	if u.target == 0 {
+		return
+	}
+
+	u.mtx.Lock()
+	u.maybeStartCycle(now)
+	u.cycleBytes += n
+	u.mtx.Unlock()
+}
+
+// bytesLeft returns the number of bytes left in the current cycle.
+//
+// This function MUST be called with the mutex held (for writes).
+func (u *uploadTarget) bytesLeft(now time.Time) uint64 {
+	u.maybeStartCycle(now)
+	if u.cycleBytes >= u.target {
+		return 0
+	}
+	return u.target - u.cycleBytes
+}
+
+// reached returns whether or not the bytes sent during the current cycle
+// reached the target.
+//
+// This function is safe for concurrent access.
+func (u *uploadTarget) reached(now time.Time) bool {
+	if u.target == 0 {
+		return false
+	}
+
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	return u.bytesLeft(now) == 0
+}
+
+// serveHistoricalBlocks returns whether or not historical blocks are served to
+// peers without the download permission, which is the case until the bytes
+// left in the current cycle fall below the buffer reserved for relaying new
+// blocks.
+//
+// This function is safe for concurrent access.
+func (u *uploadTarget) serveHistoricalBlocks(now time.Time) bool {
+	if u.target == 0 {
+		return true
+	}
+
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	return u.bytesLeft(now) > u.buffer
+}
+
+// isHistoricalBlock returns whether or not a block with the passed timestamp is
+// considered historical.
+func isHistoricalBlock(timestamp, now time.Time) bool {
+	return now.Sub(timestamp) > historicalBlockAge
+}
+
+// uploadTargetState houses the state of the upload target reported by the RPC
+// server.
+type uploadTargetState struct {
+	target                uint64
+	reached               bool
+	serveHistoricalBlocks bool
+	bytesLeftInCycle      uint64
+	timeLeftInCycle       time.Duration
+}
+
+// state returns the state of the upload target.  The bytes and time left in the
+// cycle are zero when the upload target is disabled.
+//
+// This function is safe for concurrent access.
+func (u *uploadTarget) state(now time.Time) uploadTargetState {
+	if u.target == 0 {
+		return uploadTargetState{serveHistoricalBlocks: true}
+	}
+
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	bytesLeft := u.bytesLeft(now)
+	return uploadTargetState{
+		target:                u.target,
+		reached:               bytesLeft == 0,
+		serveHistoricalBlocks: bytesLeft > u.buffer,
+		bytesLeftInCycle:      bytesLeft,
+		timeLeftInCycle:       u.cycleStart.Add(uploadTargetTimeframe).Sub(now),
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestUploadTarget ensures the upload target keeps track of the bytes sent
+// during each cycle and stops serving historical blocks once the bytes left in
+// the cycle fall below the buffer.
+func TestUploadTarget(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+
+	// A disabled upload target never stops serving historical blocks.
+	u := newUploadTarget(0, 0)
+	u.addBytesSent(1<<40, now)
+	if u.reached(now) || !u.serveHistoricalBlocks(now) {
+		t.Fatalf("disabled upload target was reached")
+	}
+	if state := u.state(now); state.bytesLeftInCycle != 0 ||
+		!state.serveHistoricalBlocks {
+
+		t.Fatalf("disabled upload target: unexpected state %+v", state)
+	}
+
+	u = newUploadTarget(1000, 200)
+	u.addBytesSent(700, now)
+	if u.reached(now) || !u.serveHistoricalBlocks(now) {
+		t.Fatalf("upload target reached after 700 of 1000 bytes")
+	}
+
+	// Historical blocks are no longer served once the buffer is reached,
+	// and the target is reached once all bytes are sent.
+	now = now.Add(time.Hour)
+	u.addBytesSent(200, now)
+	if u.reached(now) || u.serveHistoricalBlocks(now) {
+		t.Fatalf("historical blocks served with 100 bytes left")
+	}
+	u.addBytesSent(200, now)
+	state := u.state(now)
+	if !state.reached || state.serveHistoricalBlocks ||
+		state.bytesLeftInCycle != 0 ||
+		state.timeLeftInCycle != uploadTargetTimeframe-time.Hour {
+
+		t.Fatalf("upload target: unexpected state %+v", state)
+	}
+
+	// A new cycle starts once the timeframe passed.
+	now = now.Add(uploadTargetTimeframe)
+	if u.reached(now) || !u.serveHistoricalBlocks(now) {
+		t.Fatalf("upload target still reached in a new cycle")
+	}
+	if state := u.state(now); state.bytesLeftInCycle != 1000 ||
+		state.timeLeftInCycle != uploadTargetTimeframe {
+
+		t.Fatalf("new cycle: unexpected state %+v", state)
+	}
+}
+
+// TestIsHistoricalBlock ensures blocks are considered historical once they are
+// older than a week.
+func TestIsHistoricalBlock(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	if isHistoricalBlock(now.Add(-historicalBlockAge), now) {
+		t.Errorf("block of exactly historicalBlockAge is historical")
+	}
+	if !isHistoricalBlock(now.Add(-historicalBlockAge-time.Second), now) {
+		t.Errorf("block older than historicalBlockAge is not historical")
+	}
+}
@@ -55,6 +55,12 @@ const (
 	CmdReject         = "reject"
 	CmdSendHeaders    = "sendheaders"
 	CmdFeeFilter      = "feefilter"
+	CmdSendCmpct      = "sendcmpct"
+	CmdCmpctBlock     = "cmpctblock"
+	CmdGetBlockTxn    = "getblocktxn"
+	CmdBlockTxn       = "blocktxn"
+	CmdAddrV2         = "addrv2"
+	CmdDandelionTx    = "dandeliontx"
 )
 
 // Message is an interface that describes a HC message.  A type that
@@ -145,6 +151,24 @@ func makeEmptyMessage(command string) (Message, error) {
 	case CmdFeeFilter:
 		msg = &MsgFeeFilter{}
 
+	case CmdSendCmpct:
+		msg = &MsgSendCmpct{}
+
+	case CmdCmpctBlock:
+		msg = &MsgCmpctBlock{}
+
+	case CmdGetBlockTxn:
+		msg = &MsgGetBlockTxn{}
+
+	case CmdBlockTxn:
+		msg = &MsgBlockTxn{}
+
+	case CmdAddrV2:
+		msg = &MsgAddrV2{}
+
+	case CmdDandelionTx:
+		msg = &MsgDandelionTx{}
+
 	default:
 		return nil, fmt.Errorf("unhandled command [%s]", command)
 	}
@@ -376,3 +400,27 @@ func ReadMessage(r io.Reader, pver uint32, hcnet CurrencyNet) (Message, []byte,
 	_, msg, buf, err := ReadMessageN(r, pver, hcnet)
 	return msg, buf, err
 }
+
+// DecodeMessagePayload parses the passed raw payload of a message with the
+// passed command for the provided protocol version.  It allows payloads which
+// were recorded without their message header to be decoded.
+func DecodeMessagePayload(command string, payload []byte, pver uint32) (Message, error) {
+	msg, err := makeEmptyMessage(command)
+	if err != nil {
+		return nil, messageError("DecodeMessagePayload", err.Error())
+	}
+	if uint32(len(payload)) > msg.MaxPayloadLength(pver) {
+		str := fmt.Sprintf("payload of %d bytes exceeds max length of "+
+			"%d for messages of type [%v]", len(payload),
+			msg.MaxPayloadLength(pver), command)
+		return nil, messageError("DecodeMessagePayload", str)
+	}
+
+	// NOTE: This must be a *bytes.Buffer since the MsgVersion BtcDecode
+	// function requires it.
+	err = msg.BtcDecode(bytes.NewBuffer(payload), pver)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+)
+
+// TestAddrV2 tests the MsgAddrV2 API against the latest protocol version and
+// the protocol version prior to AddrV2Version.
+func TestAddrV2(t *testing.T) {
+	pver := ProtocolVersion
+
+	msg := NewMsgAddrV2()
+
+	// Ensure the command is expected value.
+	wantCmd := "addrv2"
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgAddrV2: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value.
+	// Num addresses (varInt) + max allowed addresses.
+	wantPayload := uint32(9 + 1000*(4+9+1+9+512+2))
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	timestamp := time.Unix(0x495fab29, 0)
+	torV3 := bytes.Repeat([]byte{0xab}, 32)
+	addrs := []*NetAddress{
+		NewNetAddressTimestamp(timestamp, SFNodeNetwork,
+			net.ParseIP("127.0.0.1"), 8333),
+		NewNetAddressTimestamp(timestamp, SFNodeNetwork,
+			net.ParseIP("2001:db8::1"), 8333),
+		NewNetAddressTimestamp(timestamp, 0,
+			net.ParseIP("fd87:d87e:eb43:102:304:506:708:90a"), 9108),
+		NewNetAddressNetwork(timestamp, SFNodeNetwork|SFNodeBloom,
+			AddrNetworkTorV3, torV3, 9108),
+	}
+	err := msg.AddAddresses(addrs...)
+	if err != nil {
+		t.Fatalf("AddAddresses: unexpected error: %v", err)
+	}
+
+	// Ensure the Tor v3 address is encoded as expected.
+	var buf bytes.Buffer
+	err = writeNetAddressV2(&buf, pver, addrs[3])
+	if err != nil {
+		t.Fatalf("writeNetAddressV2: unexpected error: %v", err)
+	}
+	want := []byte{
+		0x29, 0xab, 0x5f, 0x49, // Timestamp
+		0x03, // Services
+		0x04, // Network
+		0x20, // Address length
+	}
+	want = append(want, torV3...)
+	want = append(want, 0x23, 0x94) // Port 9108 in big-endian
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("writeNetAddressV2 got: %s want: %s",
+			spew.Sdump(buf.Bytes()), spew.Sdump(want))
+	}
+
+	// Test encode and decode with latest protocol version.
+	buf.Reset()
+	err = msg.BtcEncode(&buf, pver)
+	if err != nil {
+		t.Fatalf("encode of MsgAddrV2 failed %v err <%v>", msg, err)
+	}
+	encoded := buf.Bytes()
+	var readmsg MsgAddrV2
+	err = readmsg.BtcDecode(bytes.NewReader(encoded), pver)
+	if err != nil {
+		t.Fatalf("decode of MsgAddrV2 failed [%v] err <%v>", buf, err)
+	}
+	for i := range addrs {
+		addrs[i].IP = addrs[i].IP.To16()
+	}
+	if !reflect.DeepEqual(readmsg.AddrList, addrs) {
+		t.Errorf("BtcDecode got: %s want: %s",
+			spew.Sdump(readmsg.AddrList), spew.Sdump(addrs))
+	}
+
+	// Addresses of unknown networks are skipped.
+	unknown := []byte{
+		0x02,                   // Varint for number of addresses
+		0x29, 0xab, 0x5f, 0x49, // Timestamp
+		0x01,             // Services
+		0xfe,             // Unknown network
+		0x03,             // Address length
+		0x01, 0x02, 0x03, // Address
+		0x20, 0x8d, // Port
+	}
+	unknown = append(unknown, want...)
+	err = readmsg.BtcDecode(bytes.NewReader(unknown), pver)
+	if err != nil {
+		t.Fatalf("decode of MsgAddrV2 with unknown network failed: %v",
+			err)
+	}
+	if !reflect.DeepEqual(readmsg.AddrList, addrs[3:]) {
+		t.Errorf("BtcDecode got: %s want: %s",
+			spew.Sdump(readmsg.AddrList), spew.Sdump(addrs[3:]))
+	}
+
+	// Addresses of known networks must have the size of the network.
+	badSize := append([]byte{0x01}, want...)
+	badSize[7] = 0x1f
+	err = readmsg.BtcDecode(bytes.NewReader(badSize), pver)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("decode of MsgAddrV2 with wrong address size: got "+
+			"error %v, want MessageError", err)
+	}
+	buf.Reset()
+	badAddr := NewNetAddressNetwork(timestamp, 0, AddrNetworkTorV3,
+		torV3[:16], 9108)
+	err = writeNetAddressV2(&buf, pver, badAddr)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("writeNetAddressV2 with wrong address size: got "+
+			"error %v, want MessageError", err)
+	}
+
+	// Older protocol versions should fail encode and decode since the
+	// message didn't exist yet.
+	oldPver := AddrV2Version - 1
+	err = msg.BtcEncode(&buf, oldPver)
+	if err == nil {
+		t.Errorf("encode of MsgAddrV2 passed for old protocol "+
+			"version %v", oldPver)
+	}
+	err = readmsg.BtcDecode(bytes.NewReader(encoded), oldPver)
+	if err == nil {
+		t.Errorf("decode of MsgAddrV2 passed for old protocol "+
+			"version %v", oldPver)
+	}
+
+	// Ensure messages with too many addresses are rejected.
+	for i := len(msg.AddrList); i < MaxAddrPerMsg; i++ {
+		msg.AddAddress(addrs[0])
+	}
+	if err := msg.AddAddress(addrs[0]); err == nil {
+		t.Errorf("AddAddress: expected error on too many addresses")
+	}
+	msg.AddrList = append(msg.AddrList, addrs[0])
+	if err := msg.BtcEncode(&buf, pver); err == nil {
+		t.Errorf("BtcEncode: expected error on too many addresses")
+	}
+
+	msg.ClearAddresses()
+	if len(msg.AddrList) != 0 {
+		t.Errorf("ClearAddresses: address list is not empty")
+	}
+}
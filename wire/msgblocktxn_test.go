@@ -0,0 +1,67 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+)
+
+// TestBlockTxn tests the MsgBlockTxn API.
+func TestBlockTxn(t *testing.T) {
+	pver := ProtocolVersion
+
+	hash := testBlock.Header.BlockHash()
+	msg := NewMsgBlockTxn(&hash)
+	msg.AddTransaction(testBlock.Transactions[0])
+	msg.AddSTransaction(testBlock.STransactions[0])
+
+	// Ensure the command is expected value.
+	wantCmd := "blocktxn"
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgBlockTxn: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Test encode and decode with latest protocol version.
+	var buf bytes.Buffer
+	err := msg.BtcEncode(&buf, pver)
+	if err != nil {
+		t.Fatalf("encode of MsgBlockTxn failed %v err <%v>", msg, err)
+	}
+	encoded := append([]byte{}, buf.Bytes()...)
+	var readmsg MsgBlockTxn
+	err = readmsg.BtcDecode(&buf, pver)
+	if err != nil {
+		t.Fatalf("decode of MsgBlockTxn failed err <%v>", err)
+	}
+	if !reflect.DeepEqual(&readmsg, msg) {
+		t.Errorf("BtcDecode got: %s want: %s", spew.Sdump(&readmsg),
+			spew.Sdump(msg))
+	}
+
+	// Decoding fails for short data and older protocol versions.
+	for i := 0; i < len(encoded); i += 11 {
+		err = readmsg.BtcDecode(bytes.NewReader(encoded[:i]), pver)
+		if err == nil {
+			t.Errorf("BtcDecode: no error for %d of %d bytes", i,
+				len(encoded))
+		}
+	}
+	oldPver := CompactBlocksVersion - 1
+	err = readmsg.BtcDecode(bytes.NewReader(encoded), oldPver)
+	if err == nil {
+		t.Errorf("decode of MsgBlockTxn passed for old protocol "+
+			"version %v", oldPver)
+	}
+	err = msg.BtcEncode(&buf, oldPver)
+	if err == nil {
+		t.Errorf("encode of MsgBlockTxn passed for old protocol "+
+			"version %v", oldPver)
+	}
+}
@@ -0,0 +1,347 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+
+	"github.com/nbit99/hcd/chaincfg/chainhash"
+)
+
+// ShortTxIDSize is the size of a short transaction id in a compact block.
+const ShortTxIDSize = 6
+
+// shortTxIDMask masks the bits of a short transaction id.
+const shortTxIDMask = 1<<(ShortTxIDSize*8) - 1
+
+// PrefilledTx houses a transaction which is included in full in a compact
+// block along with its index in the transaction tree of the block.
+type PrefilledTx struct {
+	Index uint32
+	Tx    *MsgTx
+}
+
+// MsgCmpctBlock implements the Message interface and represents a cmpctblock
+// message.  It is used to relay a block by only including short ids of the
+// transactions of both transaction trees which the receiving peer is expected
+// to already have in its memory pool.  The transactions which it is not
+// expected to have, such as the coinbase and the votes, are prefilled.
+//
+// The transaction trees of the block consist of the prefilled transactions at
+// their indexes with the transactions of the short ids filling the remaining
+// indexes in order.
+//
+// This message was not added until protocol versions starting with
+// CompactBlocksVersion.
+type MsgCmpctBlock struct {
+	Header        BlockHeader
+	Nonce         uint64
+	ShortIDs      []uint64
+	PrefilledTxs  []PrefilledTx
+	SShortIDs     []uint64
+	SPrefilledTxs []PrefilledTx
+}
+
+// ShortIDKey houses the key used to calculate the short transaction ids of a
+// compact block.  It is derived from the block header and the nonce of the
+// compact block so short id collisions are not shared between blocks or
+// peers.
+type ShortIDKey struct {
+	k0, k1 uint64
+}
+
+// ShortIDKey returns the key used to calculate the short transaction ids of
+// the compact block.
+func (msg *MsgCmpctBlock) ShortIDKey() ShortIDKey {
+	// The key is made up of the first 16 bytes of the hash of the
+	// serialized header followed by the nonce.  Writing to a buffer never
+	// fails.
+	buf := bytes.NewBuffer(make([]byte, 0, blockHeaderLen+8))
+	_ = writeBlockHeader(buf, 0, &msg.Header)
+	_ = writeElement(buf, msg.Nonce)
+	hash := chainhash.HashH(buf.Bytes())
+	return ShortIDKey{
+		k0: binary.LittleEndian.Uint64(hash[0:8]),
+		k1: binary.LittleEndian.Uint64(hash[8:16]),
+	}
+}
+
+// ShortID returns the short id of the transaction with the passed hash.
+func (k ShortIDKey) ShortID(txHash *chainhash.Hash) uint64 {
+	return sipHash24(k.k0, k.k1, txHash[:]) & shortTxIDMask
+}
+
+// TxCount returns the number of transactions in the regular transaction tree
+// of the block.
+func (msg *MsgCmpctBlock) TxCount() int {
+	return len(msg.ShortIDs) + len(msg.PrefilledTxs)
+}
+
+// STxCount returns the number of transactions in the stake transaction tree
+// of the block.
+func (msg *MsgCmpctBlock) STxCount() int {
+	return len(msg.SShortIDs) + len(msg.SPrefilledTxs)
+}
+
+// BtcDecode decodes r using the protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgCmpctBlock) BtcDecode(r io.Reader, pver uint32) error {
+	if pver < CompactBlocksVersion {
+		str := fmt.Sprintf("cmpctblock message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgCmpctBlock.BtcDecode", str)
+	}
+
+	err := readBlockHeader(r, pver, &msg.Header)
+	if err != nil {
+		return err
+	}
+	err = readElement(r, &msg.Nonce)
+	if err != nil {
+		return err
+	}
+
+	msg.ShortIDs, err = readShortIDs(r, pver)
+	if err != nil {
+		return err
+	}
+	msg.PrefilledTxs, err = readPrefilledTxs(r, pver)
+	if err != nil {
+		return err
+	}
+	msg.SShortIDs, err = readShortIDs(r, pver)
+	if err != nil {
+		return err
+	}
+	msg.SPrefilledTxs, err = readPrefilledTxs(r, pver)
+	return err
+}
+
+// BtcEncode encodes the receiver to w using the protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgCmpctBlock) BtcEncode(w io.Writer, pver uint32) error {
+	if pver < CompactBlocksVersion {
+		str := fmt.Sprintf("cmpctblock message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgCmpctBlock.BtcEncode", str)
+	}
+
+	err := writeBlockHeader(w, pver, &msg.Header)
+	if err != nil {
+		return err
+	}
+	err = writeElement(w, msg.Nonce)
+	if err != nil {
+		return err
+	}
+
+	err = writeShortIDs(w, pver, msg.ShortIDs)
+	if err != nil {
+		return err
+	}
+	err = writePrefilledTxs(w, pver, msg.PrefilledTxs)
+	if err != nil {
+		return err
+	}
+	err = writeShortIDs(w, pver, msg.SShortIDs)
+	if err != nil {
+		return err
+	}
+	return writePrefilledTxs(w, pver, msg.SPrefilledTxs)
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgCmpctBlock) Command() string {
+	return CmdCmpctBlock
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgCmpctBlock) MaxPayloadLength(pver uint32) uint32 {
+	// A compact block is never larger than the block it represents.
+	return MaxBlockPayload
+}
+
+// NewMsgCmpctBlock returns a new cmpctblock message that conforms to the
+// Message interface using the passed header and nonce.  The transactions are
+// added by the caller.  See MsgCmpctBlock for details.
+func NewMsgCmpctBlock(header *BlockHeader, nonce uint64) *MsgCmpctBlock {
+	return &MsgCmpctBlock{
+		Header: *header,
+		Nonce:  nonce,
+	}
+}
+
+// readShortIDs reads a count followed by that many short transaction ids.
+func readShortIDs(r io.Reader, pver uint32) ([]uint64, error) {
+	count, err := ReadVarInt(r, pver)
+	if err != nil {
+		return nil, err
+	}
+
+	// Prevent more short ids than could possibly fit into a transaction
+	// tree.  It would be possible to cause memory exhaustion and panics
+	// without a sane upper bound on this count.
+	maxTxPerTree := MaxTxPerTxTree(pver)
+	if count > maxTxPerTree {
+		str := fmt.Sprintf("too many short ids to fit into a block "+
+			"[count %d, max %d]", count, maxTxPerTree)
+		return nil, messageError("readShortIDs", str)
+	}
+
+	ids := make([]uint64, count)
+	var b [8]byte
+	for i := range ids {
+		if _, err := io.ReadFull(r, b[:ShortTxIDSize]); err != nil {
+			return nil, err
+		}
+		ids[i] = binary.LittleEndian.Uint64(b[:])
+	}
+	return ids, nil
+}
+
+// writeShortIDs writes the number of passed short transaction ids followed by
+// the ids.
+func writeShortIDs(w io.Writer, pver uint32, ids []uint64) error {
+	err := WriteVarInt(w, pver, uint64(len(ids)))
+	if err != nil {
+		return err
+	}
+
+	var b [8]byte
+	for _, id := range ids {
+		binary.LittleEndian.PutUint64(b[:], id)
+		if _, err := w.Write(b[:ShortTxIDSize]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readPrefilledTxs reads a count followed by that many prefilled transactions.
+// The indexes of the transactions are differentially encoded.
+func readPrefilledTxs(r io.Reader, pver uint32) ([]PrefilledTx, error) {
+	count, err := ReadVarInt(r, pver)
+	if err != nil {
+		return nil, err
+	}
+	maxTxPerTree := MaxTxPerTxTree(pver)
+	if count > maxTxPerTree {
+		str := fmt.Sprintf("too many prefilled transactions to fit "+
+			"into a block [count %d, max %d]", count, maxTxPerTree)
+		return nil, messageError("readPrefilledTxs", str)
+	}
+
+	txns := make([]PrefilledTx, 0, count)
+	var next uint64
+	for i := uint64(0); i < count; i++ {
+		diff, err := ReadVarInt(r, pver)
+		if err != nil {
+			return nil, err
+		}
+		index := next + diff
+		if diff > maxTxPerTree || index >= maxTxPerTree {
+			str := fmt.Sprintf("prefilled transaction index %d "+
+				"exceeds the maximum of %d", index,
+				maxTxPerTree-1)
+			return nil, messageError("readPrefilledTxs", str)
+		}
+
+		var tx MsgTx
+		if err := tx.BtcDecode(r, pver); err != nil {
+			return nil, err
+		}
+		txns = append(txns, PrefilledTx{Index: uint32(index), Tx: &tx})
+		next = index + 1
+	}
+	return txns, nil
+}
+
+// writePrefilledTxs writes the number of passed prefilled transactions followed
+// by the transactions with their differentially encoded indexes.  The indexes
+// must be strictly increasing.
+func writePrefilledTxs(w io.Writer, pver uint32, txns []PrefilledTx) error {
+	err := WriteVarInt(w, pver, uint64(len(txns)))
+	if err != nil {
+		return err
+	}
+
+	var next uint32
+	for i := range txns {
+		if i > 0 && txns[i].Index < next {
+			str := fmt.Sprintf("prefilled transaction index %d is "+
+				"not greater than the previous index",
+				txns[i].Index)
+			return messageError("writePrefilledTxs", str)
+		}
+		err := WriteVarInt(w, pver, uint64(txns[i].Index-next))
+		if err != nil {
+			return err
+		}
+		if err := txns[i].Tx.BtcEncode(w, pver); err != nil {
+			return err
+		}
+		next = txns[i].Index + 1
+	}
+	return nil
+}
+
+// sipRound performs a single SipHash round on the passed state.
+func sipRound(v0, v1, v2, v3 *uint64) {
+	*v0 += *v1
+	*v1 = bits.RotateLeft64(*v1, 13)
+	*v1 ^= *v0
+	*v0 = bits.RotateLeft64(*v0, 32)
+	*v2 += *v3
+	*v3 = bits.RotateLeft64(*v3, 16)
+	*v3 ^= *v2
+	*v0 += *v3
+	*v3 = bits.RotateLeft64(*v3, 21)
+	*v3 ^= *v0
+	*v2 += *v1
+	*v1 = bits.RotateLeft64(*v1, 17)
+	*v1 ^= *v2
+	*v2 = bits.RotateLeft64(*v2, 32)
+}
+
+// sipHash24 returns the SipHash-2-4 of the passed data with the 128-bit key
+// made up of k0 and k1.
+func sipHash24(k0, k1 uint64, b []byte) uint64 {
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+
+	n := len(b)
+	for ; len(b) >= 8; b = b[8:] {
+		m := binary.LittleEndian.Uint64(b)
+		v3 ^= m
+		sipRound(&v0, &v1, &v2, &v3)
+		sipRound(&v0, &v1, &v2, &v3)
+		v0 ^= m
+	}
+
+	// The final message word holds the remaining bytes and the length of
+	// the data in its most significant byte.
+	m := uint64(n) << 56
+	for i := range b {
+		m |= uint64(b[i]) << (8 * uint(i))
+	}
+	v3 ^= m
+	sipRound(&v0, &v1, &v2, &v3)
+	sipRound(&v0, &v1, &v2, &v3)
+	v0 ^= m
+
+	v2 ^= 0xff
+	for i := 0; i < 4; i++ {
+		sipRound(&v0, &v1, &v2, &v3)
+	}
+	return v0 ^ v1 ^ v2 ^ v3
+}
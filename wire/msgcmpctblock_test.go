@@ -0,0 +1,165 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/nbit99/hcd/chaincfg/chainhash"
+)
+
+// TestSipHash24 ensures SipHash-2-4 produces the expected results for the
+// test vectors of the reference implementation.
+func TestSipHash24(t *testing.T) {
+	// The key of the test vectors is the bytes 0 through 15.
+	k0, k1 := uint64(0x0706050403020100), uint64(0x0f0e0d0c0b0a0908)
+	tests := []struct {
+		n    int
+		want uint64
+	}{
+		{0, 0x726fdb47dd0e0e31},
+		{1, 0x74f839c593dc67fd},
+		{7, 0xab0200f58b01d137},
+		{8, 0x93f5f5799a932462},
+		{15, 0xa129ca6149be45e5},
+	}
+
+	msg := make([]byte, 16)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	for _, test := range tests {
+		got := sipHash24(k0, k1, msg[:test.n])
+		if got != test.want {
+			t.Errorf("sipHash24 (length %d): got %x, want %x",
+				test.n, got, test.want)
+		}
+	}
+}
+
+// TestCmpctBlock tests the MsgCmpctBlock API.
+func TestCmpctBlock(t *testing.T) {
+	pver := ProtocolVersion
+
+	msg := NewMsgCmpctBlock(&testBlock.Header, 0x0123456789abcdef)
+
+	// Ensure the command is expected value.
+	wantCmd := "cmpctblock"
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgCmpctBlock: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure short ids are limited to their size and depend on the nonce.
+	key := msg.ShortIDKey()
+	txHash := testBlock.Transactions[0].TxHash()
+	shortID := key.ShortID(&txHash)
+	if shortID>>(ShortTxIDSize*8) != 0 {
+		t.Errorf("ShortID: %x exceeds %d bytes", shortID, ShortTxIDSize)
+	}
+	otherMsg := NewMsgCmpctBlock(&testBlock.Header, 0)
+	otherKey := otherMsg.ShortIDKey()
+	if otherKey.ShortID(&txHash) == shortID {
+		t.Errorf("ShortID: same short id for different nonces")
+	}
+
+	msg.ShortIDs = []uint64{shortID, 0xffffffffffff}
+	msg.PrefilledTxs = []PrefilledTx{
+		{Index: 0, Tx: testBlock.Transactions[0]},
+		{Index: 3, Tx: testBlock.Transactions[0]},
+	}
+	msg.SPrefilledTxs = []PrefilledTx{
+		{Index: 1, Tx: testBlock.STransactions[0]},
+	}
+	msg.SShortIDs = []uint64{1}
+	if msg.TxCount() != 4 || msg.STxCount() != 2 {
+		t.Errorf("TxCount: got %d and %d, want 4 and 2", msg.TxCount(),
+			msg.STxCount())
+	}
+
+	// Test encode and decode with latest protocol version.
+	var buf bytes.Buffer
+	err := msg.BtcEncode(&buf, pver)
+	if err != nil {
+		t.Fatalf("encode of MsgCmpctBlock failed %v err <%v>", msg, err)
+	}
+	encoded := append([]byte{}, buf.Bytes()...)
+	var readmsg MsgCmpctBlock
+	err = readmsg.BtcDecode(&buf, pver)
+	if err != nil {
+		t.Fatalf("decode of MsgCmpctBlock failed err <%v>", err)
+	}
+	if !reflect.DeepEqual(&readmsg, msg) {
+		t.Errorf("BtcDecode got: %s want: %s", spew.Sdump(&readmsg),
+			spew.Sdump(msg))
+	}
+
+	// Indexes of prefilled transactions must be increasing.
+	msg.PrefilledTxs[1].Index = 0
+	err = msg.BtcEncode(&buf, pver)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("BtcEncode: unexpected error for decreasing index %v",
+			err)
+	}
+
+	// Decoding fails for short data and older protocol versions.
+	for i := 0; i < len(encoded); i += 37 {
+		err = readmsg.BtcDecode(bytes.NewReader(encoded[:i]), pver)
+		if err == nil {
+			t.Errorf("BtcDecode: no error for %d of %d bytes", i,
+				len(encoded))
+		}
+	}
+	oldPver := CompactBlocksVersion - 1
+	err = readmsg.BtcDecode(bytes.NewReader(encoded), oldPver)
+	if err == nil {
+		t.Errorf("decode of MsgCmpctBlock passed for old protocol "+
+			"version %v", oldPver)
+	}
+	err = msg.BtcEncode(&buf, oldPver)
+	if err == nil {
+		t.Errorf("encode of MsgCmpctBlock passed for old protocol "+
+			"version %v", oldPver)
+	}
+
+	// Ensure an excessive number of short ids is rejected.
+	var tooMany bytes.Buffer
+	writeBlockHeader(&tooMany, pver, &testBlock.Header)
+	writeElement(&tooMany, uint64(0))
+	WriteVarInt(&tooMany, pver, MaxTxPerTxTree(pver)+1)
+	err = readmsg.BtcDecode(&tooMany, pver)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("BtcDecode: unexpected error for too many short ids %v",
+			err)
+	}
+}
+
+// TestCmpctBlockShortIDKey ensures the short id key is derived from the hash
+// of the header followed by the nonce.
+func TestCmpctBlockShortIDKey(t *testing.T) {
+	msg := NewMsgCmpctBlock(&testBlock.Header, 1)
+	var buf bytes.Buffer
+	testBlock.Header.Serialize(&buf)
+	buf.Write([]byte{1, 0, 0, 0, 0, 0, 0, 0})
+	hash := chainhash.HashH(buf.Bytes())
+	want := sipHash24(
+		uint64(hash[0])|uint64(hash[1])<<8|uint64(hash[2])<<16|
+			uint64(hash[3])<<24|uint64(hash[4])<<32|
+			uint64(hash[5])<<40|uint64(hash[6])<<48|
+			uint64(hash[7])<<56,
+		uint64(hash[8])|uint64(hash[9])<<8|uint64(hash[10])<<16|
+			uint64(hash[11])<<24|uint64(hash[12])<<32|
+			uint64(hash[13])<<40|uint64(hash[14])<<48|
+			uint64(hash[15])<<56,
+		hash[:]) & shortTxIDMask
+
+	key := msg.ShortIDKey()
+	if got := key.ShortID(&hash); got != want {
+		t.Errorf("ShortID: got %x, want %x", got, want)
+	}
+}
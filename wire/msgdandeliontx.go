@@ -0,0 +1,65 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"fmt"
+	"io"
+)
+
+// MsgDandelionTx implements the Message interface and represents a dandeliontx
+// message.  It is used to relay a transaction in the stem phase of Dandelion++
+// to a single peer, which either relays it further along the stem or diffuses
+// it to the network as usual.  The encoding of the transaction is the same as
+// in tx messages.
+//
+// This message was not added until protocol versions starting with
+// DandelionVersion.
+type MsgDandelionTx struct {
+	Tx *MsgTx
+}
+
+// BtcDecode decodes r using the protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgDandelionTx) BtcDecode(r io.Reader, pver uint32) error {
+	if pver < DandelionVersion {
+		str := fmt.Sprintf("dandeliontx message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgDandelionTx.BtcDecode", str)
+	}
+
+	msg.Tx = new(MsgTx)
+	return msg.Tx.BtcDecode(r, pver)
+}
+
+// BtcEncode encodes the receiver to w using the protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgDandelionTx) BtcEncode(w io.Writer, pver uint32) error {
+	if pver < DandelionVersion {
+		str := fmt.Sprintf("dandeliontx message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgDandelionTx.BtcEncode", str)
+	}
+
+	return msg.Tx.BtcEncode(w, pver)
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgDandelionTx) Command() string {
+	return CmdDandelionTx
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgDandelionTx) MaxPayloadLength(pver uint32) uint32 {
+	return MaxBlockPayload
+}
+
+// NewMsgDandelionTx returns a new dandeliontx message that conforms to the
+// Message interface.  See MsgDandelionTx for details.
+func NewMsgDandelionTx(tx *MsgTx) *MsgDandelionTx {
+	return &MsgDandelionTx{Tx: tx}
+}
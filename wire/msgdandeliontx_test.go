@@ -0,0 +1,71 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+)
+
+// TestDandelionTx tests the MsgDandelionTx API against the latest protocol
+// version and the protocol version prior to DandelionVersion.
+func TestDandelionTx(t *testing.T) {
+	pver := ProtocolVersion
+
+	msg := NewMsgDandelionTx(multiTx)
+
+	// Ensure the command is expected value.
+	wantCmd := "dandeliontx"
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgDandelionTx: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value.
+	wantPayload := uint32(MaxBlockPayload)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// The transaction is encoded the same way as in tx messages.
+	var buf bytes.Buffer
+	err := msg.BtcEncode(&buf, pver)
+	if err != nil {
+		t.Fatalf("encode of MsgDandelionTx failed %v err <%v>", msg, err)
+	}
+	if !bytes.Equal(buf.Bytes(), multiTxEncoded) {
+		t.Errorf("BtcEncode got: %s want: %s",
+			spew.Sdump(buf.Bytes()), spew.Sdump(multiTxEncoded))
+	}
+	var readmsg MsgDandelionTx
+	err = readmsg.BtcDecode(&buf, pver)
+	if err != nil {
+		t.Fatalf("decode of MsgDandelionTx failed [%v] err <%v>", buf, err)
+	}
+	if !reflect.DeepEqual(readmsg.Tx, multiTx) {
+		t.Errorf("BtcDecode got: %s want: %s", spew.Sdump(readmsg.Tx),
+			spew.Sdump(multiTx))
+	}
+
+	// Older protocol versions should fail encode and decode since the
+	// message didn't exist yet.
+	oldPver := DandelionVersion - 1
+	err = msg.BtcEncode(&buf, oldPver)
+	if err == nil {
+		t.Errorf("encode of MsgDandelionTx passed for old protocol "+
+			"version %v", oldPver)
+	}
+	err = readmsg.BtcDecode(bytes.NewReader(multiTxEncoded), oldPver)
+	if err == nil {
+		t.Errorf("decode of MsgDandelionTx passed for old protocol "+
+			"version %v", oldPver)
+	}
+}
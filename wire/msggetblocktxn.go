@@ -0,0 +1,153 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/nbit99/hcd/chaincfg/chainhash"
+)
+
+// MsgGetBlockTxn implements the Message interface and represents a getblocktxn
+// message.  It is used to request the transactions of a compact block which
+// the requesting peer could not find in its memory pool by their indexes in
+// the regular and stake transaction trees of the block.
+//
+// This message was not added until protocol versions starting with
+// CompactBlocksVersion.
+type MsgGetBlockTxn struct {
+	BlockHash chainhash.Hash
+	Indexes   []uint32
+	SIndexes  []uint32
+}
+
+// BtcDecode decodes r using the protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgGetBlockTxn) BtcDecode(r io.Reader, pver uint32) error {
+	if pver < CompactBlocksVersion {
+		str := fmt.Sprintf("getblocktxn message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgGetBlockTxn.BtcDecode", str)
+	}
+
+	err := readElement(r, &msg.BlockHash)
+	if err != nil {
+		return err
+	}
+	msg.Indexes, err = readTxIndexes(r, pver)
+	if err != nil {
+		return err
+	}
+	msg.SIndexes, err = readTxIndexes(r, pver)
+	return err
+}
+
+// BtcEncode encodes the receiver to w using the protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgGetBlockTxn) BtcEncode(w io.Writer, pver uint32) error {
+	if pver < CompactBlocksVersion {
+		str := fmt.Sprintf("getblocktxn message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgGetBlockTxn.BtcEncode", str)
+	}
+
+	err := writeElement(w, &msg.BlockHash)
+	if err != nil {
+		return err
+	}
+	err = writeTxIndexes(w, pver, msg.Indexes)
+	if err != nil {
+		return err
+	}
+	return writeTxIndexes(w, pver, msg.SIndexes)
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgGetBlockTxn) Command() string {
+	return CmdGetBlockTxn
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgGetBlockTxn) MaxPayloadLength(pver uint32) uint32 {
+	// Block hash + two trees of num indexes (varInt) + max allowed
+	// indexes (varInt each).
+	maxIndexes := uint32(MaxTxPerTxTree(pver))
+	return chainhash.HashSize + 2*(MaxVarIntPayload+
+		maxIndexes*MaxVarIntPayload)
+}
+
+// NewMsgGetBlockTxn returns a new getblocktxn message that conforms to the
+// Message interface using the passed block hash.  See MsgGetBlockTxn for
+// details.
+func NewMsgGetBlockTxn(blockHash *chainhash.Hash) *MsgGetBlockTxn {
+	return &MsgGetBlockTxn{
+		BlockHash: *blockHash,
+	}
+}
+
+// readTxIndexes reads a count followed by that many differentially encoded
+// transaction indexes.
+func readTxIndexes(r io.Reader, pver uint32) ([]uint32, error) {
+	count, err := ReadVarInt(r, pver)
+	if err != nil {
+		return nil, err
+	}
+
+	// Prevent more indexes than could possibly fit into a transaction
+	// tree.  It would be possible to cause memory exhaustion and panics
+	// without a sane upper bound on this count.
+	maxTxPerTree := MaxTxPerTxTree(pver)
+	if count > maxTxPerTree {
+		str := fmt.Sprintf("too many transaction indexes to fit into "+
+			"a block [count %d, max %d]", count, maxTxPerTree)
+		return nil, messageError("readTxIndexes", str)
+	}
+
+	indexes := make([]uint32, 0, count)
+	var next uint64
+	for i := uint64(0); i < count; i++ {
+		diff, err := ReadVarInt(r, pver)
+		if err != nil {
+			return nil, err
+		}
+		index := next + diff
+		if diff > maxTxPerTree || index >= maxTxPerTree {
+			str := fmt.Sprintf("transaction index %d exceeds the "+
+				"maximum of %d", index, maxTxPerTree-1)
+			return nil, messageError("readTxIndexes", str)
+		}
+		indexes = append(indexes, uint32(index))
+		next = index + 1
+	}
+	return indexes, nil
+}
+
+// writeTxIndexes writes the number of passed transaction indexes followed by
+// the differentially encoded indexes.  The indexes must be strictly
+// increasing.
+func writeTxIndexes(w io.Writer, pver uint32, indexes []uint32) error {
+	err := WriteVarInt(w, pver, uint64(len(indexes)))
+	if err != nil {
+		return err
+	}
+
+	var next uint32
+	for i, index := range indexes {
+		if i > 0 && index < next {
+			str := fmt.Sprintf("transaction index %d is not greater "+
+				"than the previous index", index)
+			return messageError("writeTxIndexes", str)
+		}
+		err := WriteVarInt(w, pver, uint64(index-next))
+		if err != nil {
+			return err
+		}
+		next = index + 1
+	}
+	return nil
+}
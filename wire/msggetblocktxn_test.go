@@ -0,0 +1,91 @@
+// Copyright (c) 2018-2020 The Hc developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/nbit99/hcd/chaincfg/chainhash"
+)
+
+// TestGetBlockTxn tests the MsgGetBlockTxn API.
+func TestGetBlockTxn(t *testing.T) {
+	pver := ProtocolVersion
+
+	hash := testBlock.Header.BlockHash()
+	msg := NewMsgGetBlockTxn(&hash)
+	msg.Indexes = []uint32{1, 2, 5, 300}
+	msg.SIndexes = []uint32{0}
+
+	// Ensure the command is expected value.
+	wantCmd := "getblocktxn"
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgGetBlockTxn: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Test encode with latest protocol version.  The indexes are
+	// differentially encoded.
+	var buf bytes.Buffer
+	err := msg.BtcEncode(&buf, pver)
+	if err != nil {
+		t.Fatalf("encode of MsgGetBlockTxn failed %v err <%v>", msg, err)
+	}
+	want := append(append([]byte{}, hash[:]...),
+		0x04, 0x01, 0x00, 0x02, 0xfd, 0x26, 0x01, 0x01, 0x00)
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("BtcEncode got: %s want: %s",
+			spew.Sdump(buf.Bytes()), spew.Sdump(want))
+	}
+
+	// Test decode with latest protocol version.
+	var readmsg MsgGetBlockTxn
+	err = readmsg.BtcDecode(bytes.NewReader(want), pver)
+	if err != nil {
+		t.Fatalf("decode of MsgGetBlockTxn failed err <%v>", err)
+	}
+	if !reflect.DeepEqual(&readmsg, msg) {
+		t.Errorf("BtcDecode got: %s want: %s", spew.Sdump(&readmsg),
+			spew.Sdump(msg))
+	}
+	if uint32(buf.Len()) > msg.MaxPayloadLength(pver) {
+		t.Errorf("MaxPayloadLength: %d is less than %d",
+			msg.MaxPayloadLength(pver), buf.Len())
+	}
+
+	// Indexes must be increasing and within the bounds of a block.
+	msg.Indexes = []uint32{2, 1}
+	err = msg.BtcEncode(&buf, pver)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("BtcEncode: unexpected error for decreasing index %v",
+			err)
+	}
+	var tooLarge bytes.Buffer
+	tooLarge.Write(hash[:])
+	WriteVarInt(&tooLarge, pver, 1)
+	WriteVarInt(&tooLarge, pver, MaxTxPerTxTree(pver))
+	err = readmsg.BtcDecode(&tooLarge, pver)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("BtcDecode: unexpected error for too large index %v",
+			err)
+	}
+
+	// Older protocol versions should fail encode and decode since the
+	// message didn't exist yet.
+	oldPver := CompactBlocksVersion - 1
+	err = NewMsgGetBlockTxn(&chainhash.Hash{}).BtcEncode(&buf, oldPver)
+	if err == nil {
+		t.Errorf("encode of MsgGetBlockTxn passed for old protocol "+
+			"version %v", oldPver)
+	}
+	err = readmsg.BtcDecode(bytes.NewReader(want), oldPver)
+	if err == nil {
+		t.Errorf("decode of MsgGetBlockTxn passed for old protocol "+
+			"version %v", oldPver)
+	}
+}
@@ -7,8 +7,10 @@
 package wire
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"time"
@@ -18,6 +20,58 @@ import (
 // a TCP address as required.
 var ErrInvalidNetAddr = errors.New("provided net.Addr is not a net.TCPAddr")
 
+// AddrNetwork identifies the network of an address as encoded in addrv2
+// messages.
+type AddrNetwork uint8
+
+// These constants define the networks of addresses which are encoded in addrv2
+// messages.
+const (
+	AddrNetworkIPv4  AddrNetwork = 1
+	AddrNetworkIPv6  AddrNetwork = 2
+	AddrNetworkTorV2 AddrNetwork = 3
+	AddrNetworkTorV3 AddrNetwork = 4
+	AddrNetworkI2P   AddrNetwork = 5
+	AddrNetworkCJDNS AddrNetwork = 6
+)
+
+// addrNetworkSizes maps the known networks of addresses to the size of their
+// addresses.
+var addrNetworkSizes = map[AddrNetwork]int{
+	AddrNetworkIPv4:  4,
+	AddrNetworkIPv6:  16,
+	AddrNetworkTorV2: 10,
+	AddrNetworkTorV3: 32,
+	AddrNetworkI2P:   32,
+	AddrNetworkCJDNS: 16,
+}
+
+// Map of address networks back to their constant names for pretty printing.
+var addrNetworkStrings = map[AddrNetwork]string{
+	AddrNetworkIPv4:  "IPv4",
+	AddrNetworkIPv6:  "IPv6",
+	AddrNetworkTorV2: "TorV2",
+	AddrNetworkTorV3: "TorV3",
+	AddrNetworkI2P:   "I2P",
+	AddrNetworkCJDNS: "CJDNS",
+}
+
+// String returns the AddrNetwork in human-readable form.
+func (n AddrNetwork) String() string {
+	if s, ok := addrNetworkStrings[n]; ok {
+		return s
+	}
+	return fmt.Sprintf("Unknown AddrNetwork (%d)", uint8(n))
+}
+
+// maxAddrV2Size is the maximum size of an address in an addrv2 message.
+// Addresses of unknown networks up to this size are skipped.
+const maxAddrV2Size = 512
+
+// onionCatPrefix is the prefix of the IPv6 range Tor v2 onion addresses are
+// mapped into in order to represent them as IP addresses.
+var onionCatPrefix = []byte{0xfd, 0x87, 0xd8, 0x7e, 0xeb, 0x43}
+
 // maxNetAddressPayload returns the max payload size for a hcd NetAddress
 // based on the protocol version.
 func maxNetAddressPayload(pver uint32) uint32 {
@@ -30,8 +84,20 @@ func maxNetAddressPayload(pver uint32) uint32 {
 	return plen
 }
 
+// maxNetAddressV2Payload returns the max payload size for a NetAddress in an
+// addrv2 message.
+func maxNetAddressV2Payload() uint32 {
+	// Timestamp 4 bytes + services varint + network 1 byte + address
+	// length varint + address + port 2 bytes.
+	return 4 + MaxVarIntPayload + 1 + MaxVarIntPayload + maxAddrV2Size + 2
+}
+
 // NetAddress defines information about a peer on the network including the time
 // it was last seen, the services it supports, its IP address, and port.
+//
+// Addresses which can't be represented by an IP address, such as Tor v3 onion
+// addresses, are identified by Network and Addr instead of IP.  They can only
+// be relayed with addrv2 messages.
 type NetAddress struct {
 	// Last time the address was seen.  This is, unfortunately, encoded as a
 	// uint32 on the wire and therefore is limited to 2106.  This field is
@@ -48,6 +114,14 @@ type NetAddress struct {
 	// Port the peer is using.  This is encoded in big endian on the wire
 	// which differs from most everything else.
 	Port uint16
+
+	// Network identifies the network of Addr.  It is zero for IP
+	// addresses.
+	Network AddrNetwork
+
+	// Addr is the address of the peer for addresses which can't be
+	// represented by an IP address.  It is only set along with Network.
+	Addr []byte
 }
 
 // HasService returns whether the specified service is supported by the address.
@@ -83,6 +157,22 @@ func NewNetAddressTimestamp(
 	return &na
 }
 
+// NewNetAddressNetwork returns a new NetAddress using the provided timestamp,
+// network, address, port, and supported services for addresses which can't be
+// represented by an IP address.  The timestamp is rounded to single second
+// precision.
+func NewNetAddressNetwork(timestamp time.Time, services ServiceFlag,
+	network AddrNetwork, addr []byte, port uint16) *NetAddress {
+
+	return &NetAddress{
+		Timestamp: time.Unix(timestamp.Unix(), 0),
+		Services:  services,
+		Port:      port,
+		Network:   network,
+		Addr:      addr,
+	}
+}
+
 // NewNetAddress returns a new NetAddress using the provided TCP address and
 // supported services with defaults for the remaining fields.
 //
@@ -160,3 +250,103 @@ func writeNetAddress(w io.Writer, pver uint32, na *NetAddress, ts bool) error {
 	// Sigh.  Hcd protocol mixes little and big endian.
 	return binary.Write(w, bigEndian, na.Port)
 }
+
+// readNetAddressV2 reads a NetAddress encoded for an addrv2 message from r.  It
+// returns false when the address belongs to an unknown network, in which case
+// it must be skipped.
+func readNetAddressV2(r io.Reader, pver uint32, na *NetAddress) (bool, error) {
+	var timestamp time.Time
+	err := readElement(r, (*uint32Time)(&timestamp))
+	if err != nil {
+		return false, err
+	}
+	services, err := ReadVarInt(r, pver)
+	if err != nil {
+		return false, err
+	}
+	network, err := binarySerializer.Uint8(r)
+	if err != nil {
+		return false, err
+	}
+	addr, err := ReadVarBytes(r, pver, maxAddrV2Size, "address")
+	if err != nil {
+		return false, err
+	}
+	// Sigh.  Hcd protocol mixes little and big endian.
+	port, err := binarySerializer.Uint16(r, bigEndian)
+	if err != nil {
+		return false, err
+	}
+
+	size, ok := addrNetworkSizes[AddrNetwork(network)]
+	if !ok {
+		return false, nil
+	}
+	if len(addr) != size {
+		str := fmt.Sprintf("%v address has %d bytes instead of %d",
+			AddrNetwork(network), len(addr), size)
+		return false, messageError("readNetAddressV2", str)
+	}
+
+	*na = NetAddress{
+		Timestamp: timestamp,
+		Services:  ServiceFlag(services),
+		Port:      port,
+	}
+	switch AddrNetwork(network) {
+	case AddrNetworkIPv4, AddrNetworkIPv6:
+		na.IP = net.IP(addr).To16()
+	case AddrNetworkTorV2:
+		na.IP = net.IP(append(append([]byte{}, onionCatPrefix...),
+			addr...))
+	default:
+		na.Network = AddrNetwork(network)
+		na.Addr = addr
+	}
+	return true, nil
+}
+
+// writeNetAddressV2 serializes a NetAddress for an addrv2 message to w.
+func writeNetAddressV2(w io.Writer, pver uint32, na *NetAddress) error {
+	network, addr := na.Network, na.Addr
+	if network == 0 {
+		// Ensure to always write an address even if the ip is nil.
+		ip := make(net.IP, net.IPv6len)
+		if na.IP != nil {
+			copy(ip, na.IP.To16())
+		}
+		switch {
+		case ip.To4() != nil:
+			network, addr = AddrNetworkIPv4, ip.To4()
+		case bytes.HasPrefix(ip, onionCatPrefix):
+			network, addr = AddrNetworkTorV2, ip[len(onionCatPrefix):]
+		default:
+			network, addr = AddrNetworkIPv6, ip
+		}
+	}
+	if size, ok := addrNetworkSizes[network]; !ok || len(addr) != size {
+		str := fmt.Sprintf("invalid %v address of %d bytes", network,
+			len(addr))
+		return messageError("writeNetAddressV2", str)
+	}
+
+	err := writeElement(w, uint32(na.Timestamp.Unix()))
+	if err != nil {
+		return err
+	}
+	err = WriteVarInt(w, pver, uint64(na.Services))
+	if err != nil {
+		return err
+	}
+	err = binarySerializer.PutUint8(w, uint8(network))
+	if err != nil {
+		return err
+	}
+	err = WriteVarBytes(w, pver, addr)
+	if err != nil {
+		return err
+	}
+
+	// Sigh.  Hcd protocol mixes little and big endian.
+	return binary.Write(w, bigEndian, na.Port)
+}
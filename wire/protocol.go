@@ -18,7 +18,7 @@ const (
 	InitialProcotolVersion uint32 = 1
 
 	// ProtocolVersion is the latest protocol version this package supports.
-	ProtocolVersion uint32 = 5
+	ProtocolVersion uint32 = 8
 
 	// BIP0111Version is the protocol version which added the SFNodeBloom
 	// service flag.
@@ -35,6 +35,20 @@ const (
 	// FeeFilterVersion is the protocol version which added a new
 	// feefilter message.
 	FeeFilterVersion uint32 = 5
+
+	// CompactBlocksVersion is the protocol version which added the
+	// sendcmpct, cmpctblock, getblocktxn and blocktxn messages for compact
+	// block relay.
+	CompactBlocksVersion uint32 = 6
+
+	// AddrV2Version is the protocol version which added the addrv2 message
+	// for relaying addresses of variable length such as Tor v3 onion
+	// addresses.
+	AddrV2Version uint32 = 7
+
+	// DandelionVersion is the protocol version which added the dandeliontx
+	// message for relaying transactions in the stem phase of Dandelion++.
+	DandelionVersion uint32 = 8
 )
 
 // ServiceFlag identifies services supported by a hcd peer.
@@ -47,12 +61,22 @@ const (
 	// SFNodeBloom is a flag used to indiciate a peer supports bloom
 	// filtering.
 	SFNodeBloom
+
+	// SFNodeEncrypted is a flag used to indicate a peer accepts the
+	// encrypted transport on inbound connections.
+	SFNodeEncrypted
+
+	// SFNodeDandelion is a flag used to indicate a peer relays transactions
+	// in the stem phase of Dandelion++.
+	SFNodeDandelion
 )
 
 // Map of service flags back to their constant names for pretty printing.
 var sfStrings = map[ServiceFlag]string{
-	SFNodeNetwork: "SFNodeNetwork",
-	SFNodeBloom:   "SFNodeBloom",
+	SFNodeNetwork:   "SFNodeNetwork",
+	SFNodeBloom:     "SFNodeBloom",
+	SFNodeEncrypted: "SFNodeEncrypted",
+	SFNodeDandelion: "SFNodeDandelion",
 }
 
 // orderedSFStrings is an ordered list of service flags from highest to
@@ -60,6 +84,8 @@ var sfStrings = map[ServiceFlag]string{
 var orderedSFStrings = []ServiceFlag{
 	SFNodeNetwork,
 	SFNodeBloom,
+	SFNodeEncrypted,
+	SFNodeDandelion,
 }
 
 // String returns the ServiceFlag in human-readable form.
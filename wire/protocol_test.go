@@ -17,7 +17,9 @@ func TestServiceFlagStringer(t *testing.T) {
 		{0, "0x0"},
 		{SFNodeNetwork, "SFNodeNetwork"},
 		{SFNodeBloom, "SFNodeBloom"},
-		{0xffffffff, "SFNodeNetwork|SFNodeBloom|0xfffffffc"},
+		{SFNodeEncrypted, "SFNodeEncrypted"},
+		{SFNodeDandelion, "SFNodeDandelion"},
+		{0xffffffff, "SFNodeNetwork|SFNodeBloom|SFNodeEncrypted|SFNodeDandelion|0xfffffff0"},
 	}
 
 	t.Logf("Running %d tests", len(tests))